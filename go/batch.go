@@ -1,51 +1,84 @@
 package smt
 
 import (
+	"errors"
 	"fmt"
 	"math/big"
-	
-	"github.com/ethereum/go-ethereum/crypto"
+	"sort"
 )
 
-// BatchInsert inserts multiple leaves efficiently
+// BatchInsert inserts multiple leaves in a single batch commit. A leaf whose
+// index is out of range or already holds a leaf is skipped and gets a nil proof;
+// the other proofs are those inserting the leaves one at a time would return.
 func (smt *SparseMerkleTree) BatchInsert(indices []*big.Int, leaves []Bytes32) ([]*UpdateProof, error) {
 	if len(indices) != len(leaves) {// coverage-ignore
 		return nil, fmt.Errorf("indices and leaves must have same length")
 	}
 	
-	proofs := make([]*UpdateProof, len(indices))
-	
-	for i := range indices {
-		proof, err := smt.Insert(indices[i], leaves[i])
-		if err != nil {
-			// Continue with other insertions
-			proofs[i] = nil
-			continue
-		}
-		proofs[i] = proof
-	}
-	
-	return proofs, nil
+	return smt.batchUpsert(indices, leaves, false)
 }
 
-// BatchUpdate updates multiple leaves efficiently
+// BatchUpdate updates multiple leaves in a single batch commit. A leaf whose
+// index does not hold a leaf is skipped and gets a nil proof; the other proofs
+// are those updating the leaves one at a time would return.
 func (smt *SparseMerkleTree) BatchUpdate(indices []*big.Int, newLeaves []Bytes32) ([]*UpdateProof, error) {
 	if len(indices) != len(newLeaves) {// coverage-ignore
 		return nil, fmt.Errorf("indices and newLeaves must have same length")
 	}
 	
+	return smt.batchUpsert(indices, newLeaves, true)
+}
+
+// batchUpsert commits the inserts, or updates when existing is set, that would
+// succeed if applied in order, and returns nil proofs for the rest
+func (smt *SparseMerkleTree) batchUpsert(indices []*big.Int, leaves []Bytes32, existing bool) ([]*UpdateProof, error) {
+	smt.mu.Lock()
+	defer smt.mu.Unlock()
+	
 	proofs := make([]*UpdateProof, len(indices))
+	writes := make([]LeafWrite, 0, len(indices))
+	positions := make([]int, 0, len(indices))
+	written := make(map[string]bool)
 	
-	for i := range indices {
-		proof, err := smt.Update(indices[i], newLeaves[i])
-		if err != nil {
-			// Continue with other updates
-			proofs[i] = nil
+	for i, index := range indices {
+		exists := written[index.String()]
+		if !exists {
+			var err error
+			exists, err = smt.exists(index)
+			if err != nil {
+				// Skip the leaf as a failed Insert or Update would be
+				continue
+			}
+		}
+		if exists != existing {
 			continue
 		}
-		proofs[i] = proof
+		
+		written[index.String()] = true
+		writes = append(writes, LeafWrite{Index: index, Value: leaves[i]})
+		positions = append(positions, i)
+	}
+	
+	if len(writes) == 0 {
+		return proofs, nil
 	}
 	
+	smt.beginWrite()
+	applied, err := smt.applyWrites(writes, true)
+	if err == nil {
+		err = smt.recordVersion()
+	}
+	if err != nil {
+		smt.discardWrite()
+		return nil, err
+	}
+	if err := smt.commitWrite(); err != nil { // coverage-ignore
+		return nil, err
+	}
+	
+	for i, proof := range applied {
+		proofs[positions[i]] = proof
+	}
 	return proofs, nil
 }
 
@@ -79,30 +112,56 @@ func (smt *SparseMerkleTree) BatchExists(indices []*big.Int) ([]bool, error) {
 	return results, nil
 }
 
-// BatchInsertKV inserts multiple key-value pairs
+// BatchInsertKV inserts multiple key-value pairs in a single batch commit,
+// returning their proofs in key order. A pair whose index already holds a leaf
+// is skipped and gets a nil proof. Any other failure leaves the tree and KV
+// store unchanged.
 func (smt *SparseMerkleTree) BatchInsertKV(kvPairs map[string]Bytes32) ([]*UpdateProof, error) {
-	indices := make([]*big.Int, 0, len(kvPairs))
-	values := make([]Bytes32, 0, len(kvPairs))
+	keys := make([]string, 0, len(kvPairs))
+	for key := range kvPairs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
 	
-	for key, value := range kvPairs {
-		// Compute index from key and truncate to tree depth (same logic as InsertKV)
-		hash := crypto.Keccak256([]byte(key))
-		index := new(big.Int).SetBytes(hash)
-		
-		// Truncate index to fit within tree depth
-		if smt.depth < SMT_DEPTH {
-			maxIndex := new(big.Int).Lsh(ONE, uint(smt.depth))
-			index.Mod(index, maxIndex)
+	smt.mu.Lock()
+	defer smt.mu.Unlock()
+	
+	smt.beginWrite()
+	
+	proofs := make([]*UpdateProof, len(keys))
+	inserted := 0
+	for i, key := range keys {
+		// Each pair is staged together with its KV entry
+		proof, err := smt.insertInternal(smt.indexForKey(key), kvPairs[key])
+		var exists *KeyExistsError
+		if errors.As(err, &exists) {
+			// Skip the pair as a failed InsertKV would be
+			continue
 		}
-		
-		indices = append(indices, index)
-		values = append(values, value)
-		
-		// Store in KV store
-		smt.kvStore.Set(key, value)
+		if err == nil {
+			err = smt.kvStore.Store(key, kvPairs[key])
+		}
+		if err != nil { // coverage-ignore
+			smt.discardWrite()
+			return nil, err
+		}
+		proofs[i] = proof
+		inserted++
+	}
+	
+	if inserted == 0 {
+		smt.discardWrite()
+		return proofs, nil
+	}
+	if err := smt.recordVersion(); err != nil { // coverage-ignore
+		smt.discardWrite()
+		return nil, err
+	}
+	if err := smt.commitWrite(); err != nil { // coverage-ignore
+		return nil, err
 	}
 	
-	return smt.BatchInsert(indices, values)
+	return proofs, nil
 }
 
 // BatchGetKV retrieves multiple values by keys
@@ -131,15 +190,28 @@ type BatchOperation struct {
 	Value   Bytes32   // For KV operations
 }
 
-// ExecuteBatch executes a batch of operations atomically
+// ExecuteBatch executes a batch of operations atomically.
+// All writes are staged and committed as a single database batch. If any
+// operation fails, the database, root and KV store are left exactly as they
+// were and a *BatchError identifies the failing operation.
+//
+// ExecuteBatchTransition executes a batch the same way and also returns a
+// TransitionProof for it.
 func (smt *SparseMerkleTree) ExecuteBatch(operations []BatchOperation) ([]*UpdateProof, error) {
 	smt.mu.Lock()
 	defer smt.mu.Unlock()
 	
-	// Save current root for rollback
-	oldRoot := smt.root
+	proofs, _, err := smt.executeBatch(operations)
+	return proofs, err
+}
+
+// executeBatch applies operations as one staged write, returning each
+// operation's proof and the root it left
+func (smt *SparseMerkleTree) executeBatch(operations []BatchOperation) ([]*UpdateProof, []Bytes32, error) {
+	smt.beginWrite()
 	
 	proofs := make([]*UpdateProof, len(operations))
+	roots := make([]Bytes32, len(operations))
 	
 	for i, op := range operations {
 		var proof *UpdateProof
@@ -149,19 +221,12 @@ func (smt *SparseMerkleTree) ExecuteBatch(operations []BatchOperation) ([]*Updat
 		case "insert":
 			if op.Key != "" { // coverage-ignore
 				// KV insert - compute index and use internal method
-				hash := crypto.Keccak256([]byte(op.Key))
-				index := new(big.Int).SetBytes(hash)
+				index := smt.indexForKey(op.Key)
 				
-				// Truncate index to fit within tree depth
-				if smt.depth < SMT_DEPTH {
-					maxIndex := new(big.Int).Lsh(ONE, uint(smt.depth))
-					index.Mod(index, maxIndex)
-				}
-				
-				leafHash := ComputeLeafHash(index, op.Value)
+				leafHash := smt.hasher.HashLeaf(index, op.Value)
 				proof, err = smt.insertInternal(index, leafHash)
 				if err == nil {
-					smt.kvStore.Set(op.Key, op.Value)
+					err = smt.kvStore.Store(op.Key, op.Value)
 				}
 			} else { // coverage-ignore
 				// Direct insert - use internal method to avoid deadlock
@@ -171,19 +236,12 @@ func (smt *SparseMerkleTree) ExecuteBatch(operations []BatchOperation) ([]*Updat
 		case "update": // coverage-ignore
 			if op.Key != "" {
 				// KV update
-				hash := crypto.Keccak256([]byte(op.Key))
-				index := new(big.Int).SetBytes(hash)
-				
-				// Truncate index to fit within tree depth
-				if smt.depth < SMT_DEPTH {
-					maxIndex := new(big.Int).Lsh(ONE, uint(smt.depth))
-					index.Mod(index, maxIndex)
-				}
+				index := smt.indexForKey(op.Key)
 				
-				leafHash := ComputeLeafHash(index, op.Value)
+				leafHash := smt.hasher.HashLeaf(index, op.Value)
 				proof, err = smt.updateInternal(index, leafHash)
 				if err == nil {
-					smt.kvStore.Set(op.Key, op.Value)
+					err = smt.kvStore.Store(op.Key, op.Value)
 				}
 			} else {
 				// Direct update - use internal method to avoid deadlock
@@ -193,24 +251,18 @@ func (smt *SparseMerkleTree) ExecuteBatch(operations []BatchOperation) ([]*Updat
 		case "delete": // coverage-ignore
 			if op.Key != "" {
 				// KV delete - use internal method to avoid deadlock
-				hash := crypto.Keccak256([]byte(op.Key))
-				index := new(big.Int).SetBytes(hash)
-				
-				// Truncate index to fit within tree depth
-				if smt.depth < SMT_DEPTH {
-					maxIndex := new(big.Int).Lsh(ONE, uint(smt.depth))
-					index.Mod(index, maxIndex)
-				}
+				index := smt.indexForKey(op.Key)
 
 				// Check if key exists in KV store
-				if !smt.kvStore.Has(op.Key) {
+				var exists bool
+				if _, exists, err = smt.kvStore.Load(op.Key); err == nil && !exists {
 					err = &KeyNotFoundError{Index: index}
-				} else {
+				} else if err == nil {
 					// Delete from tree using internal method
 					proof, err = smt.deleteInternal(index)
 					if err == nil {
 						// Remove from KV store
-						smt.kvStore.Delete(op.Key)
+						err = smt.kvStore.Remove(op.Key)
 					}
 				}
 			} else {
@@ -223,14 +275,24 @@ func (smt *SparseMerkleTree) ExecuteBatch(operations []BatchOperation) ([]*Updat
 		}
 		
 		if err != nil {
-			// Rollback on error
-			smt.root = oldRoot
-			return nil, fmt.Errorf("batch operation %d failed: %w", i, err)
+			// Rollback on error: drop every staged write and KV change
+			smt.discardWrite()
+			return nil, nil, &BatchError{Index: i, Op: op, Err: err}
 		}
 		
 		proofs[i] = proof
+		roots[i] = smt.root
 	}
 	
-	return proofs, nil
+	if err := smt.recordVersion(); err != nil { // coverage-ignore
+		smt.discardWrite()
+		return nil, nil, err
+	}
+	
+	if err := smt.commitWrite(); err != nil { // coverage-ignore
+		return nil, nil, err
+	}
+	
+	return proofs, roots, nil
 }
 
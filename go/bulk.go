@@ -0,0 +1,201 @@
+package smt
+
+import (
+	"errors"
+	"io"
+	"math/big"
+)
+
+// bulkLoadBatchSize is the number of records BulkLoad writes per database batch
+const bulkLoadBatchSize = 4096
+
+// LeafSource yields leaves in strictly ascending index order. Next returns
+// io.EOF once the source is exhausted.
+type LeafSource interface {
+	Next() (LeafData, error)
+}
+
+// SliceLeafSource returns a LeafSource over leaves, which must already be sorted by index
+func SliceLeafSource(leaves []LeafData) LeafSource {
+	return &sliceLeafSource{leaves: leaves}
+}
+
+// sliceLeafSource yields the leaves of a slice in order
+type sliceLeafSource struct {
+	leaves []LeafData
+	pos    int
+}
+
+// Next returns the next leaf of the slice
+func (s *sliceLeafSource) Next() (LeafData, error) {
+	if s.pos >= len(s.leaves) {
+		return LeafData{}, io.EOF
+	}
+	leaf := s.leaves[s.pos]
+	s.pos++
+	return leaf, nil
+}
+
+// BulkLoad builds a tree of the given depth in db from a sorted stream of
+// leaves. The tree is built bottom-up in a single pass that writes every node
+// exactly once, keeping only one root-to-leaf path in memory, and yields the
+// same root and records as inserting the leaves one by one. The database must
+// not already hold a non-empty tree.
+//
+// Records are written in batches as the build progresses and the tree metadata
+// last, so a failed load leaves no tree behind; its partial records are
+// unreachable and the database should be discarded.
+func BulkLoad(db Database, depth uint16, source LeafSource) (*SparseMerkleTree, error) {
+	return BulkLoadWithOptions(db, &TreeOptions{Depth: depth}, source)
+}
+
+// BulkLoadWithOptions bulk loads a tree configured by opts, as BulkLoad does
+func BulkLoadWithOptions(db Database, opts *TreeOptions, source LeafSource) (*SparseMerkleTree, error) {
+	tree, err := NewSparseMerkleTreeWithOptions(db, opts)
+	if err != nil {
+		return nil, err
+	}
+	if !tree.root.IsZero() {
+		return nil, ErrTreeNotEmpty
+	}
+
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+
+	b := &bulkLoader{smt: tree, source: source}
+	tree.beginWrite()
+	root, err := b.load()
+	if err == nil {
+		err = tree.replaceRoot(root)
+	}
+	if err == nil {
+		err = tree.recordVersion()
+	}
+	if err != nil {
+		// A failed batch flush has already dropped the staged writes
+		if tree.pending != nil {
+			tree.discardWrite()
+		}
+		return nil, err
+	}
+	if err := tree.commitWrite(); err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+// bulkLoader builds a tree from a LeafSource with one leaf of lookahead
+type bulkLoader struct {
+	smt    *SparseMerkleTree
+	source LeafSource
+	next   *LeafData // Next leaf to place, nil once the source is exhausted
+}
+
+// load builds the whole tree and returns its root
+func (b *bulkLoader) load() (Bytes32, error) {
+	if err := b.advance(); err != nil {
+		return Bytes32{}, err
+	}
+
+	root, _, err := b.build(b.smt.depth, big.NewInt(0))
+	if err != nil {
+		return Bytes32{}, err
+	}
+
+	// Sorted leaves left over after the build lie beyond the last index
+	if b.next != nil {
+		return Bytes32{}, &OutOfRangeError{Index: b.next.Index, TreeDepth: b.smt.depth}
+	}
+	return root, nil
+}
+
+// advance reads the next leaf from the source, checking the order
+func (b *bulkLoader) advance() error {
+	leaf, err := b.source.Next()
+	if errors.Is(err, io.EOF) {
+		b.next = nil
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := b.smt.validateIndex(leaf.Index); err != nil {
+		return err
+	}
+	if b.next != nil && leaf.Index.Cmp(b.next.Index) <= 0 {
+		return ErrUnsortedLeaves
+	}
+
+	b.next = &LeafData{Index: new(big.Int).Set(leaf.Index), Value: leaf.Value}
+	return nil
+}
+
+// build returns the hash of the subtree of the given height whose path from
+// the root spells prefix, consuming and storing the leaves it contains. It
+// also reports whether the subtree is a single leaf, which a compact tree
+// places at the top of the subtree instead of storing nodes above it.
+func (b *bulkLoader) build(height uint16, prefix *big.Int) (Bytes32, bool, error) {
+	if b.next == nil || new(big.Int).Rsh(b.next.Index, uint(height)).Cmp(prefix) != 0 {
+		return Bytes32{}, false, nil
+	}
+
+	if height == 0 {
+		leaf := b.next
+		hash := b.smt.hasher.HashLeaf(leaf.Index, leaf.Value)
+		if err := b.smt.setLeaf(hash, leaf); err != nil { // coverage-ignore
+			return Bytes32{}, false, err
+		}
+		if err := b.advance(); err != nil {
+			return Bytes32{}, false, err
+		}
+		return hash, true, b.flushIfFull()
+	}
+
+	leftPrefix := new(big.Int).Lsh(prefix, 1)
+	left, leftLeaf, err := b.build(height-1, leftPrefix)
+	if err != nil {
+		return Bytes32{}, false, err
+	}
+	right, rightLeaf, err := b.build(height-1, new(big.Int).Or(leftPrefix, ONE))
+	if err != nil {
+		return Bytes32{}, false, err
+	}
+
+	if b.smt.options.Compact {
+		if leftLeaf && right.IsZero() {
+			return left, true, nil
+		}
+		if rightLeaf && left.IsZero() {
+			return right, true, nil
+		}
+	}
+
+	// Leaf hashes commit to their index, so every record built here is new and
+	// referenced by exactly one parent
+	hash := b.smt.hasher.HashNode(left, right)
+	if err := b.smt.setNode(hash, &Node{Left: left, Right: right}); err != nil { // coverage-ignore
+		return Bytes32{}, false, err
+	}
+	for _, child := range []Bytes32{left, right} {
+		if child.IsZero() {
+			continue
+		}
+		if err := b.smt.setRefCount(child, 1); err != nil { // coverage-ignore
+			return Bytes32{}, false, err
+		}
+	}
+	return hash, false, b.flushIfFull()
+}
+
+// flushIfFull writes the staged records once a batch has accumulated. The
+// metadata is not touched until the final commit.
+func (b *bulkLoader) flushIfFull() error {
+	if len(b.smt.pending.writes) < bulkLoadBatchSize {
+		return nil
+	}
+	if err := b.smt.flushWrite(); err != nil {
+		return err
+	}
+	b.smt.beginWrite()
+	return nil
+}
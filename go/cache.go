@@ -0,0 +1,449 @@
+package smt
+
+import (
+	"container/list"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// CacheOptions configures a CachedDatabase
+type CacheOptions struct {
+	// Size is the maximum number of node and leaf records kept in the LRU
+	Size int
+	// PinnedLevels keeps the nodes of the top levels of the current tree in
+	// memory outside the LRU; the pinned set is refreshed on every root change
+	PinnedLevels uint16
+}
+
+// DefaultCacheOptions returns the options used when none are given
+func DefaultCacheOptions() *CacheOptions {
+	return &CacheOptions{
+		Size:         1 << 16,
+		PinnedLevels: 0,
+	}
+}
+
+// CacheStats reports cache effectiveness
+type CacheStats struct {
+	Hits    uint64
+	Misses  uint64
+	Entries int
+	Pinned  int
+}
+
+// CachedDatabase is a read-through Database wrapper that keeps recently used
+// node and leaf records in a bounded LRU. Writes go to the wrapped database
+// first and then update the cache, so it never serves stale records.
+//
+// Pinning walks the top levels of the tree whose metadata is written through
+// the wrapper, costing up to 2^PinnedLevels node lookups per committed operation.
+//
+// Keys are classified after any namespace prefix added by a NamespacedDatabase,
+// so trees opened with TreeOptions.Namespace on top of the cache are cached and
+// pinned too, each namespace keeping its own pinned set. Only a tree outside any
+// namespace is pinned when the cache is created; a namespaced tree is pinned
+// from its next committed write.
+type CachedDatabase struct {
+	db   Database
+	opts CacheOptions
+
+	lru    *list.List
+	items  map[string]*list.Element
+	pinned map[string]map[string][]byte // namespace prefix -> key -> node record
+
+	// version is bumped on every write so reads that raced with a write do not
+	// populate the cache with the value they saw before it
+	version uint64
+	hits    uint64
+	misses  uint64
+	mu      sync.Mutex
+}
+
+// cacheEntry is an LRU element
+type cacheEntry struct {
+	key   string
+	value []byte
+}
+
+var (
+	_ BatchDatabase    = (*CachedDatabase)(nil)
+	_ IterableDatabase = (*CachedDatabase)(nil)
+)
+
+// NewCachedDatabase wraps db with a node and leaf cache
+func NewCachedDatabase(db Database, opts *CacheOptions) (*CachedDatabase, error) {
+	if db == nil {
+		return nil, ErrNilDatabase
+	}
+	if opts == nil {
+		opts = DefaultCacheOptions()
+	}
+	if opts.Size <= 0 {
+		return nil, ErrInvalidCacheSize
+	}
+
+	cdb := &CachedDatabase{
+		db:     db,
+		opts:   *opts,
+		lru:    list.New(),
+		items:  make(map[string]*list.Element),
+		pinned: make(map[string]map[string][]byte),
+	}
+
+	if opts.PinnedLevels > 0 {
+		meta, err := ReadTreeMetadata(db)
+		if err != nil {
+			return nil, err
+		}
+		if meta != nil {
+			if err := cdb.repin("", meta); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return cdb, nil
+}
+
+// Unwrap returns the wrapped database
+func (db *CachedDatabase) Unwrap() Database {
+	return db.db
+}
+
+// Get retrieves a value by key, serving node and leaf records from the cache
+func (db *CachedDatabase) Get(key []byte) ([]byte, error) {
+	k := string(key)
+	if !cacheable(k) {
+		return db.db.Get(key)
+	}
+
+	db.mu.Lock()
+	if value, ok := db.lookupLocked(k); ok {
+		db.hits++
+		db.mu.Unlock()
+		return copyBytes(value), nil
+	}
+	db.misses++
+	version := db.version
+	db.mu.Unlock()
+
+	value, err := db.db.Get(key)
+	if err != nil || value == nil {
+		return value, err
+	}
+
+	db.mu.Lock()
+	if db.version == version {
+		db.addLocked(k, copyBytes(value))
+	}
+	db.mu.Unlock()
+	return value, nil
+}
+
+// Set stores a key-value pair
+func (db *CachedDatabase) Set(key []byte, value []byte) error {
+	err := db.db.Set(key, value)
+	db.applyWrites(map[string][]byte{string(key): copyBytes(value)}, err)
+	if err != nil {
+		return err
+	}
+	return db.repinOnRootChange(map[string][]byte{string(key): value})
+}
+
+// Delete removes a key-value pair
+func (db *CachedDatabase) Delete(key []byte) error {
+	err := db.db.Delete(key)
+	db.applyWrites(map[string][]byte{string(key): nil}, err)
+	return err
+}
+
+// Has checks if a key exists
+func (db *CachedDatabase) Has(key []byte) (bool, error) {
+	k := string(key)
+	if cacheable(k) {
+		db.mu.Lock()
+		_, ok := db.lookupLocked(k)
+		db.mu.Unlock()
+		if ok {
+			return true, nil
+		}
+	}
+	return db.db.Has(key)
+}
+
+// IteratePrefix delegates to the wrapped database, returning ErrNotIterable if
+// it does not implement IterableDatabase
+func (db *CachedDatabase) IteratePrefix(prefix []byte, fn func(key, value []byte) bool) error {
+	idb, ok := db.db.(IterableDatabase)
+	if !ok {
+		return ErrNotIterable
+	}
+	return idb.IteratePrefix(prefix, fn)
+}
+
+// NewBatch creates a write batch. It is applied through a batch of the wrapped
+// database when supported and one write at a time otherwise.
+func (db *CachedDatabase) NewBatch() Batch {
+	return &cachedBatch{db: db, writes: make(map[string][]byte)}
+}
+
+// Stats returns the cache hit/miss counters and sizes
+func (db *CachedDatabase) Stats() CacheStats {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return CacheStats{
+		Hits:    db.hits,
+		Misses:  db.misses,
+		Entries: db.lru.Len(),
+		Pinned:  db.pinnedLenLocked(),
+	}
+}
+
+// pinnedLenLocked returns the number of pinned records in every namespace
+func (db *CachedDatabase) pinnedLenLocked() int {
+	n := 0
+	for _, pinned := range db.pinned {
+		n += len(pinned)
+	}
+	return n
+}
+
+// Purge drops every cached and pinned record and resets the statistics
+func (db *CachedDatabase) Purge() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.lru.Init()
+	db.items = make(map[string]*list.Element)
+	db.pinned = make(map[string]map[string][]byte)
+	db.version++
+	db.hits = 0
+	db.misses = 0
+}
+
+// splitNamespace splits key into the namespace prefix added by any
+// NamespacedDatabase, separators included, and the key the tree stored
+func splitNamespace(key string) (string, string) {
+	i := strings.LastIndex(key, NamespaceSeparator)
+	return key[:i+1], key[i+1:]
+}
+
+// cacheable reports whether key holds a node or leaf record
+func cacheable(key string) bool {
+	_, k := splitNamespace(key)
+	return strings.HasPrefix(k, NodePrefix) || strings.HasPrefix(k, LeafPrefix)
+}
+
+// copyBytes returns a copy of b, preserving nil
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	c := make([]byte, len(b))
+	copy(c, b)
+	return c
+}
+
+// lookupLocked returns a cached value, marking it as recently used
+func (db *CachedDatabase) lookupLocked(key string) ([]byte, bool) {
+	ns, _ := splitNamespace(key)
+	if value, ok := db.pinned[ns][key]; ok {
+		return value, true
+	}
+	if elem, ok := db.items[key]; ok {
+		db.lru.MoveToFront(elem)
+		return elem.Value.(*cacheEntry).value, true
+	}
+	return nil, false
+}
+
+// peek returns a cached value without touching the statistics or the LRU order
+func (db *CachedDatabase) peek(key string) ([]byte, bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	ns, _ := splitNamespace(key)
+	if value, ok := db.pinned[ns][key]; ok {
+		return value, true
+	}
+	if elem, ok := db.items[key]; ok {
+		return elem.Value.(*cacheEntry).value, true
+	}
+	return nil, false
+}
+
+// addLocked inserts or refreshes an LRU entry, evicting the least recently used one
+func (db *CachedDatabase) addLocked(key string, value []byte) {
+	ns, _ := splitNamespace(key)
+	if _, ok := db.pinned[ns][key]; ok {
+		db.pinned[ns][key] = value
+		return
+	}
+	if elem, ok := db.items[key]; ok {
+		elem.Value.(*cacheEntry).value = value
+		db.lru.MoveToFront(elem)
+		return
+	}
+
+	db.items[key] = db.lru.PushFront(&cacheEntry{key: key, value: value})
+	for db.lru.Len() > db.opts.Size {
+		oldest := db.lru.Back()
+		db.lru.Remove(oldest)
+		delete(db.items, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// removeLocked drops a key from the LRU and the pinned set
+func (db *CachedDatabase) removeLocked(key string) {
+	if elem, ok := db.items[key]; ok {
+		db.lru.Remove(elem)
+		delete(db.items, key)
+	}
+	ns, _ := splitNamespace(key)
+	delete(db.pinned[ns], key)
+}
+
+// applyWrites updates the cache after writes to the wrapped database. If the
+// write failed its outcome is unknown, so the affected keys are dropped instead.
+func (db *CachedDatabase) applyWrites(writes map[string][]byte, err error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.version++
+	for key, value := range writes {
+		if !cacheable(key) {
+			continue
+		}
+		if err != nil || value == nil {
+			db.removeLocked(key)
+		} else {
+			db.addLocked(key, value)
+		}
+	}
+}
+
+// repinOnRootChange refreshes the pinned set of every namespace whose tree
+// metadata is among writes
+func (db *CachedDatabase) repinOnRootChange(writes map[string][]byte) error {
+	if db.opts.PinnedLevels == 0 {
+		return nil
+	}
+	for key, data := range writes {
+		ns, k := splitNamespace(key)
+		if k != MetadataKey || data == nil {
+			continue
+		}
+		meta, err := decodeMetadata(data)
+		if err != nil { // coverage-ignore
+			return err
+		}
+		if err := db.repin(ns, meta); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// repin replaces the pinned set of namespace ns with the nodes of the top
+// levels below meta.Root
+func (db *CachedDatabase) repin(ns string, meta *TreeMetadata) error {
+	db.mu.Lock()
+	version := db.version
+	db.mu.Unlock()
+
+	pinned := make(map[string][]byte)
+	frontier := []Bytes32{meta.Root}
+	for level := uint16(0); level < db.opts.PinnedLevels && level < meta.Depth && len(frontier) > 0; level++ {
+		next := make([]Bytes32, 0, 2*len(frontier))
+		for _, hash := range frontier {
+			key := ns + NodePrefix + hex.EncodeToString(hash[:])
+
+			data, ok := db.peek(key)
+			if !ok {
+				var err error
+				if data, err = db.db.Get([]byte(key)); err != nil {
+					return err
+				}
+			}
+			if len(data) != 64 {
+				continue
+			}
+			pinned[key] = data
+
+			var left, right Bytes32
+			copy(left[:], data[0:32])
+			copy(right[:], data[32:64])
+			if !left.IsZero() {
+				next = append(next, left)
+			}
+			if !right.IsZero() {
+				next = append(next, right)
+			}
+		}
+		frontier = next
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// A write that raced with the walk may have removed a node we read; the
+	// next root change will pin again
+	if db.version != version {
+		return nil
+	}
+	for key := range pinned {
+		if elem, ok := db.items[key]; ok {
+			db.lru.Remove(elem)
+			delete(db.items, key)
+		}
+	}
+	db.pinned[ns] = pinned
+	return nil
+}
+
+// cachedBatch buffers writes for a CachedDatabase
+type cachedBatch struct {
+	db     *CachedDatabase
+	writes map[string][]byte
+}
+
+// Put buffers a key-value write
+func (b *cachedBatch) Put(key []byte, value []byte) error {
+	stored := make([]byte, len(value))
+	copy(stored, value)
+	b.writes[string(key)] = stored
+	return nil
+}
+
+// Delete buffers a key deletion
+func (b *cachedBatch) Delete(key []byte) error {
+	b.writes[string(key)] = nil
+	return nil
+}
+
+// Write applies the buffered writes to the wrapped database and the cache
+func (b *cachedBatch) Write() error {
+	keys := make([]string, 0, len(b.writes))
+	for key := range b.writes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var err error
+	if bdb, ok := b.db.db.(BatchDatabase); ok {
+		err = writeBatch(bdb.NewBatch(), keys, b.writes)
+	} else {
+		err = writeSequential(b.db.db, keys, b.writes)
+	}
+	b.db.applyWrites(b.writes, err)
+
+	writes := b.writes
+	b.writes = make(map[string][]byte)
+	if err != nil {
+		return err
+	}
+	return b.db.repinOnRootChange(writes)
+}
@@ -0,0 +1,424 @@
+package smt
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+// IssueKind classifies a problem found by Check
+type IssueKind string
+
+const (
+	// IssueCorruptRecord is a record that cannot be decoded
+	IssueCorruptRecord IssueKind = "corrupt-record"
+	// IssueDanglingReference is a root or node child whose record is missing
+	IssueDanglingReference IssueKind = "dangling-reference"
+	// IssueNodeHashMismatch is a node whose children do not hash to its key
+	IssueNodeHashMismatch IssueKind = "node-hash-mismatch"
+	// IssueLeafHashMismatch is a leaf whose index and value do not hash to its key
+	IssueLeafHashMismatch IssueKind = "leaf-hash-mismatch"
+	// IssueMisplacedLeaf is a leaf whose index bits do not match its path from the root
+	IssueMisplacedLeaf IssueKind = "misplaced-leaf"
+	// IssueIndexMismatch is an i: mapping that disagrees with the leaves of the current root
+	IssueIndexMismatch IssueKind = "index-mismatch"
+	// IssueRefCountMismatch is a stored reference count that differs from the references found
+	IssueRefCountMismatch IssueKind = "refcount-mismatch"
+	// IssueOrphan is a node or leaf record unreachable from every retained root
+	IssueOrphan IssueKind = "orphan"
+)
+
+// CheckIssue is a single problem found by Check
+type CheckIssue struct {
+	Kind    IssueKind
+	Key     string // Storage key of the offending record
+	Path    string // Bits taken from the root to reach the record, "" for the root itself
+	Message string
+}
+
+func (i CheckIssue) String() string {
+	if i.Path == "" {
+		return fmt.Sprintf("%s %s: %s", i.Kind, i.Key, i.Message)
+	}
+	return fmt.Sprintf("%s %s at path %s: %s", i.Kind, i.Key, i.Path, i.Message)
+}
+
+// CheckReport summarizes a tree integrity check
+type CheckReport struct {
+	Root    Bytes32
+	Depth   uint16
+	Roots   int  // Number of roots walked: the current root and every retained version
+	Nodes   int  // Distinct internal nodes visited
+	Leaves  int  // Distinct leaves visited
+	Scanned bool // Whether the database was scanned for orphaned and stale records
+	Issues  []CheckIssue
+}
+
+// OK reports whether the check found no issues
+func (r *CheckReport) OK() bool {
+	return len(r.Issues) == 0
+}
+
+// Check walks the tree from its current root and every retained version root,
+// recomputing every node and leaf hash, checking leaf paths, index mappings and
+// reference counts. If the database implements IterableDatabase it is also
+// scanned for orphaned records. Problems are returned in the report; the error
+// is reserved for database failures.
+func (smt *SparseMerkleTree) Check() (*CheckReport, error) {
+	smt.mu.RLock()
+	defer smt.mu.RUnlock()
+
+	// Staged records are invisible to the scan of the database
+	if smt.speculative {
+		return nil, ErrUncommittedChanges
+	}
+
+	return smt.check(true)
+}
+
+// CheckDatabase checks the tree stored in db without opening it, so trees
+// written by older format versions are checked as they are rather than upgraded.
+// The tree must use a built-in hash suite and key derivation; a tree stored in
+// a namespace is checked through a NamespacedDatabase.
+func CheckDatabase(db Database) (*CheckReport, error) {
+	meta, err := ReadTreeMetadata(db)
+	if err != nil {
+		return nil, err
+	}
+	if meta == nil {
+		return nil, ErrTreeNotFound
+	}
+	if meta.Depth == 0 || meta.Depth > SMT_DEPTH {
+		return nil, &InvalidTreeDepthError{Depth: meta.Depth}
+	}
+	if meta.Version < minTreeFormatVersion || meta.Version > TreeFormatVersion {
+		return nil, &FormatVersionError{Version: meta.Version}
+	}
+
+	opts, err := ReadTreeOptions(db)
+	if err != nil {
+		return nil, err
+	}
+	resolved, err := opts.resolve()
+	if err != nil { // coverage-ignore
+		return nil, err
+	}
+
+	tree := &SparseMerkleTree{
+		db:            db,
+		options:       resolved,
+		hasher:        resolved.EffectiveHashSuite(),
+		root:          meta.Root,
+		depth:         meta.Depth,
+		version:       meta.LatestVersion,
+		oldestVersion: meta.OldestVersion,
+	}
+	return tree.check(meta.Version >= 2)
+}
+
+// checker holds the state of one integrity check
+type checker struct {
+	smt     *SparseMerkleTree
+	report  *CheckReport
+	visited map[Bytes32]bool
+	refs    map[Bytes32]uint64 // References found: parent nodes plus the current root and version records
+	current map[string]Bytes32 // Index mapping key -> leaf hash for leaves of the current root
+}
+
+// check runs the integrity check; refCounted enables reference count checks
+func (smt *SparseMerkleTree) check(refCounted bool) (*CheckReport, error) {
+	c := &checker{
+		smt:     smt,
+		report:  &CheckReport{Root: smt.root, Depth: smt.depth},
+		visited: make(map[Bytes32]bool),
+		refs:    make(map[Bytes32]uint64),
+		current: make(map[string]Bytes32),
+	}
+
+	// The current root is walked first so its leaves are checked against the index mappings
+	if err := c.walk(smt.root, 0, nil, MetadataKey, true, false); err != nil {
+		return nil, err
+	}
+	c.report.Roots++
+
+	if smt.oldestVersion != 0 {
+		for version := smt.oldestVersion; version <= smt.version; version++ {
+			record, err := smt.getVersion(version)
+			if err != nil {
+				return nil, err
+			}
+			if record == nil {
+				c.issue(IssueDanglingReference, string(versionKey(version)), "", "retained version record is missing")
+				continue
+			}
+			if err := c.walk(record.root, 0, nil, string(versionKey(version)), false, false); err != nil {
+				return nil, err
+			}
+			c.report.Roots++
+		}
+	}
+
+	if err := c.checkIndexMappings(); err != nil {
+		return nil, err
+	}
+
+	// Wrappers implement IterableDatabase even when the database they wrap does not
+	scanned := false
+	if idb, ok := smt.db.(IterableDatabase); ok {
+		err := c.scan(idb, refCounted)
+		if err != nil && !errors.Is(err, ErrNotIterable) {
+			return nil, err
+		}
+		scanned = err == nil
+	}
+	c.report.Scanned = scanned
+	if !scanned && refCounted {
+		if err := c.checkRefCounts(); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(c.report.Issues, func(i, j int) bool {
+		return c.report.Issues[i].Key < c.report.Issues[j].Key
+	})
+	return c.report, nil
+}
+
+// issue records a problem
+func (c *checker) issue(kind IssueKind, key, path, format string, args ...interface{}) {
+	c.report.Issues = append(c.report.Issues, CheckIssue{
+		Kind:    kind,
+		Key:     key,
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// read loads a record, returning nil if it does not exist
+func (c *checker) read(key string) ([]byte, error) {
+	data, _, err := getIfPresent(treeDatabase{smt: c.smt}, []byte(key))
+	return data, err
+}
+
+// walk checks the subtree at hash, reached from the root through path and
+// referenced by the record at from; lone reports that its sibling is empty
+func (c *checker) walk(hash Bytes32, level uint16, path []byte, from string, current, lone bool) error {
+	if hash.IsZero() {
+		return nil
+	}
+
+	c.refs[hash]++
+	if c.visited[hash] {
+		return nil
+	}
+	c.visited[hash] = true
+
+	if level == c.smt.depth {
+		return c.checkLeaf(hash, path, from, current, lone)
+	}
+
+	key := NodePrefix + hex.EncodeToString(hash[:])
+	data, err := c.read(key)
+	if err != nil {
+		return err
+	}
+	// Compact trees place leaves above full depth
+	if data == nil && c.smt.options.Compact {
+		return c.checkLeaf(hash, path, from, current, lone)
+	}
+	if data == nil {
+		c.issue(IssueDanglingReference, key, string(path), "node referenced by %s is missing", from)
+		return nil
+	}
+	if len(data) != 64 {
+		c.issue(IssueCorruptRecord, key, string(path), "node record is %d bytes, expected 64", len(data))
+		return nil
+	}
+	c.report.Nodes++
+
+	var left, right Bytes32
+	copy(left[:], data[0:32])
+	copy(right[:], data[32:64])
+	if computed := c.smt.hasher.HashNode(left, right); computed != hash {
+		c.issue(IssueNodeHashMismatch, key, string(path), "children hash to %s", computed.Hex())
+	}
+	if left.IsZero() && right.IsZero() {
+		c.issue(IssueCorruptRecord, key, string(path), "node has no children")
+	}
+
+	if err := c.walk(left, level+1, append(path, '0'), key, current, right.IsZero()); err != nil {
+		return err
+	}
+	return c.walk(right, level+1, append(path, '1'), key, current, left.IsZero())
+}
+
+// checkLeaf checks a leaf record found at the bottom of the tree or, in a
+// compact tree, wherever its subtree holds no other leaf
+func (c *checker) checkLeaf(hash Bytes32, path []byte, from string, current, lone bool) error {
+	key := LeafPrefix + hex.EncodeToString(hash[:])
+	data, err := c.read(key)
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		c.issue(IssueDanglingReference, key, string(path), "leaf referenced by %s is missing", from)
+		return nil
+	}
+	if len(data) < 32 {
+		c.issue(IssueCorruptRecord, key, string(path), "leaf record is %d bytes, expected at least 32", len(data))
+		return nil
+	}
+	c.report.Leaves++
+
+	var value Bytes32
+	copy(value[:], data[0:32])
+	index := new(big.Int).SetBytes(data[32:])
+
+	if computed := c.smt.hasher.HashLeaf(index, value); computed != hash {
+		c.issue(IssueLeafHashMismatch, key, string(path), "index %s and value %s hash to %s", index, value.Hex(), computed.Hex())
+	}
+	if expected := indexPath(index, c.smt.depth); c.smt.options.Compact {
+		if !strings.HasPrefix(expected, string(path)) {
+			c.issue(IssueMisplacedLeaf, key, string(path), "index %s belongs at path %s", index, expected)
+		} else if lone {
+			c.issue(IssueMisplacedLeaf, key, string(path), "leaf without a sibling belongs above path %s", path)
+		}
+	} else if expected != string(path) {
+		c.issue(IssueMisplacedLeaf, key, string(path), "index %s belongs at path %s", index, expected)
+	}
+
+	if current {
+		c.current[LeafIndexPrefix+hex.EncodeToString(index.Bytes())] = hash
+	}
+	return nil
+}
+
+// indexPath returns the bits of index from the root down, or a description if
+// the index does not fit the tree
+func indexPath(index *big.Int, depth uint16) string {
+	if index.BitLen() > int(depth) {
+		return fmt.Sprintf("(none: index exceeds %d bits)", depth)
+	}
+
+	var b strings.Builder
+	for i := int(depth) - 1; i >= 0; i-- {
+		if index.Bit(i) == 0 {
+			b.WriteByte('0')
+		} else {
+			b.WriteByte('1')
+		}
+	}
+	return b.String()
+}
+
+// checkIndexMappings verifies that every leaf of the current root has a matching i: mapping
+func (c *checker) checkIndexMappings() error {
+	keys := make([]string, 0, len(c.current))
+	for key := range c.current {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		hash := c.current[key]
+		data, err := c.read(key)
+		if err != nil {
+			return err
+		}
+		switch {
+		case data == nil:
+			c.issue(IssueIndexMismatch, key, "", "index mapping for leaf %s is missing", hash.Hex())
+		case len(data) != 32:
+			c.issue(IssueCorruptRecord, key, "", "index mapping is %d bytes, expected 32", len(data))
+		case Bytes32(data) != hash:
+			c.issue(IssueIndexMismatch, key, "", "maps to %s, current leaf is %s", Bytes32(data).Hex(), hash.Hex())
+		}
+	}
+	return nil
+}
+
+// checkRefCounts compares the stored reference count of every visited record
+func (c *checker) checkRefCounts() error {
+	hashes := make([]Bytes32, 0, len(c.visited))
+	for hash := range c.visited {
+		hashes = append(hashes, hash)
+	}
+	sort.Slice(hashes, func(i, j int) bool {
+		return string(hashes[i][:]) < string(hashes[j][:])
+	})
+
+	for _, hash := range hashes {
+		key := RefCountPrefix + hex.EncodeToString(hash[:])
+		data, err := c.read(key)
+		if err != nil {
+			return err
+		}
+
+		var stored uint64
+		if len(data) == 8 {
+			stored = binary.BigEndian.Uint64(data)
+		} else if data != nil {
+			c.issue(IssueCorruptRecord, key, "", "reference count is %d bytes, expected 8", len(data))
+			continue
+		}
+
+		if expected := c.refs[hash]; stored != expected {
+			c.issue(IssueRefCountMismatch, key, "", "stored %d references, found %d", stored, expected)
+		}
+	}
+	return nil
+}
+
+// scan looks for records the walk did not reach: orphaned nodes and leaves,
+// stale index mappings and, when refCounted, reference counts without a record
+func (c *checker) scan(idb IterableDatabase, refCounted bool) error {
+	for _, prefix := range []string{NodePrefix, LeafPrefix} {
+		err := idb.IteratePrefix([]byte(prefix), func(key, value []byte) bool {
+			hash, ok := decodeHashKey(string(key), prefix)
+			if !ok || !c.visited[hash] {
+				c.issue(IssueOrphan, string(key), "", "record is not reachable from any retained root")
+			}
+			return true
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	err := idb.IteratePrefix([]byte(LeafIndexPrefix), func(key, value []byte) bool {
+		if _, ok := c.current[string(key)]; !ok {
+			c.issue(IssueIndexMismatch, string(key), "", "index mapping has no leaf in the current root")
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	if !refCounted {
+		return nil
+	}
+	if err := c.checkRefCounts(); err != nil {
+		return err
+	}
+	return idb.IteratePrefix([]byte(RefCountPrefix), func(key, value []byte) bool {
+		hash, ok := decodeHashKey(string(key), RefCountPrefix)
+		if !ok || !c.visited[hash] {
+			c.issue(IssueOrphan, string(key), "", "reference count for a record that is not reachable")
+		}
+		return true
+	})
+}
+
+// decodeHashKey extracts the hash from a prefixed hex key
+func decodeHashKey(key, prefix string) (Bytes32, bool) {
+	raw, err := hex.DecodeString(strings.TrimPrefix(key, prefix))
+	if err != nil || len(raw) != 32 {
+		return Bytes32{}, false
+	}
+	return Bytes32(raw), true
+}
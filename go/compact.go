@@ -0,0 +1,348 @@
+package smt
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// In a compact tree a leaf sits at the highest position whose subtree holds no
+// other leaf, rather than at the bottom of a chain of single-child nodes.
+// Inserting a leaf writes nodes only down to the first bit its index does not
+// share with its neighbours, roughly log2(n) of them for n random leaves.
+// Leaf hashes commit to the full index, so a leaf can stand in for its subtree.
+//
+// Proofs keep the full-depth shape. The sibling of a leaf is never empty, so
+// the height a leaf sits at is the lowest set bit of Enables, or the depth when
+// the leaf is the only one in the tree. A non-membership proof whose path ends
+// at another leaf carries that leaf as its Neighbor, and so does the proof of a
+// delete whose leaf sibling moves up to replace the parent. Compact proofs are
+// verified with VerifyCompactProof and are not accepted by the Solidity
+// verifier.
+
+// Names of the stored tree layouts
+const (
+	FullLayoutName    = "full"
+	CompactLayoutName = "compact"
+)
+
+// layoutName returns the name of the layout a tree with these options uses
+func (o *TreeOptions) layoutName() string {
+	if o.Compact {
+		return CompactLayoutName
+	}
+	return FullLayoutName
+}
+
+// compactUpsert places the leaf for index in a compact tree and moves the root
+func (smt *SparseMerkleTree) compactUpsert(index *big.Int, leafHash Bytes32) error {
+	root, err := smt.compactInsert(smt.root, smt.depth, index, leafHash)
+	if err != nil {
+		return err
+	}
+	return smt.replaceRoot(root)
+}
+
+// compactInsert returns the root of the subtree of the given height at hash
+// with leafHash placed for index, storing the nodes it builds
+func (smt *SparseMerkleTree) compactInsert(hash Bytes32, height uint16, index *big.Int, leafHash Bytes32) (Bytes32, error) {
+	if hash.IsZero() {
+		return leafHash, nil
+	}
+
+	isNode, err := smt.hasNode(hash)
+	if err != nil { // coverage-ignore
+		return Bytes32{}, err
+	}
+	if !isNode {
+		leaf, err := smt.getLeaf(hash)
+		if err != nil { // coverage-ignore
+			return Bytes32{}, err
+		}
+		if leaf == nil { // coverage-ignore
+			return Bytes32{}, fmt.Errorf("leaf %s is missing", hash.Hex())
+		}
+		if leaf.Index.Cmp(index) == 0 {
+			return leafHash, nil
+		}
+		return smt.compactSplit(height, index, leafHash, leaf.Index, hash)
+	}
+
+	node, err := smt.getNode(hash)
+	if err != nil { // coverage-ignore
+		return Bytes32{}, err
+	}
+	left, right := node.Left, node.Right
+	if GetBit(index, uint(height-1)) == 0 {
+		left, err = smt.compactInsert(left, height-1, index, leafHash)
+	} else {
+		right, err = smt.compactInsert(right, height-1, index, leafHash)
+	}
+	if err != nil { // coverage-ignore
+		return Bytes32{}, err
+	}
+	return smt.compactNode(left, right)
+}
+
+// compactSplit returns the subtree of the given height holding two leaves that
+// share its path, storing the nodes it builds
+func (smt *SparseMerkleTree) compactSplit(height uint16, index *big.Int, leafHash Bytes32, otherIndex *big.Int, otherHash Bytes32) (Bytes32, error) {
+	return splitLeaves(height, index, leafHash, otherIndex, otherHash, smt.compactNode)
+}
+
+// splitLeaves builds, with node, the subtree of the given height holding two
+// leaves that share its path: a node where their indices first differ, below a
+// chain of nodes with one empty child
+func splitLeaves(height uint16, index *big.Int, leafHash Bytes32, otherIndex *big.Int, otherHash Bytes32, node func(left, right Bytes32) (Bytes32, error)) (Bytes32, error) {
+	bit := uint(height - 1)
+	if GetBit(index, bit) == GetBit(otherIndex, bit) {
+		child, err := splitLeaves(height-1, index, leafHash, otherIndex, otherHash, node)
+		if err != nil { // coverage-ignore
+			return Bytes32{}, err
+		}
+		if GetBit(index, bit) == 0 {
+			return node(child, Bytes32{})
+		}
+		return node(Bytes32{}, child)
+	}
+
+	if GetBit(index, bit) == 0 {
+		return node(leafHash, otherHash)
+	}
+	return node(otherHash, leafHash)
+}
+
+// compactDelete returns the root of the subtree at hash with the leaf for
+// index removed. The leaf must exist. A leaf left without a sibling moves up
+// to take the place of its parent.
+func (smt *SparseMerkleTree) compactDelete(hash Bytes32, height uint16, index *big.Int) (Bytes32, error) {
+	isNode, err := smt.hasNode(hash)
+	if err != nil { // coverage-ignore
+		return Bytes32{}, err
+	}
+	if !isNode {
+		return Bytes32{}, nil
+	}
+
+	node, err := smt.getNode(hash)
+	if err != nil { // coverage-ignore
+		return Bytes32{}, err
+	}
+	left, right := node.Left, node.Right
+	if GetBit(index, uint(height-1)) == 0 {
+		left, err = smt.compactDelete(left, height-1, index)
+	} else {
+		right, err = smt.compactDelete(right, height-1, index)
+	}
+	if err != nil { // coverage-ignore
+		return Bytes32{}, err
+	}
+
+	if left.IsZero() != right.IsZero() {
+		child := left
+		if child.IsZero() {
+			child = right
+		}
+		isNode, err := smt.hasNode(child)
+		if err != nil { // coverage-ignore
+			return Bytes32{}, err
+		}
+		if !isNode {
+			return child, nil
+		}
+	}
+	if left.IsZero() && right.IsZero() { // coverage-ignore
+		return Bytes32{}, nil
+	}
+	return smt.compactNode(left, right)
+}
+
+// compactCollapsingSibling returns the leaf that replaces the parent of the
+// leaf a membership proof ends at once that leaf is deleted, or nil if its
+// sibling is a node or the leaf is the only one in the tree
+func (smt *SparseMerkleTree) compactCollapsingSibling(proof *Proof) (*LeafData, error) {
+	if len(proof.Siblings) == 0 {
+		return nil, nil
+	}
+	sibling := proof.Siblings[0]
+	isNode, err := smt.hasNode(sibling)
+	if err != nil || isNode { // coverage-ignore
+		return nil, err
+	}
+	leaf, err := smt.getLeaf(sibling)
+	if err != nil { // coverage-ignore
+		return nil, err
+	}
+	if leaf == nil { // coverage-ignore
+		return nil, fmt.Errorf("leaf %s is missing", sibling.Hex())
+	}
+	return &LeafData{Index: new(big.Int).Set(leaf.Index), Value: leaf.Value}, nil
+}
+
+// compactNode stores the node with the given children and returns its hash
+func (smt *SparseMerkleTree) compactNode(left, right Bytes32) (Bytes32, error) {
+	hash := smt.hasher.HashNode(left, right)
+	if err := smt.putNode(hash, &Node{Left: left, Right: right}); err != nil { // coverage-ignore
+		return Bytes32{}, err
+	}
+	return hash, nil
+}
+
+// compactHeight returns the height of the leaf a compact proof ends at
+func compactHeight(depth uint16, enables *big.Int) uint {
+	if enables == nil || enables.Sign() == 0 {
+		return uint(depth)
+	}
+	return enables.TrailingZeroBits()
+}
+
+// compactRoot hashes current, the subtree of the given height on the path to
+// index, up to the root
+func compactRoot(depth uint16, height uint, current Bytes32, index, enables *big.Int, siblings []Bytes32, hasher *HashSuite) Bytes32 {
+	siblingIndex := 0
+	for i := uint(0); i < uint(depth); i++ {
+		var sibling Bytes32
+		if enables != nil && GetBit(enables, i) == 1 {
+			if siblingIndex < len(siblings) {
+				sibling = siblings[siblingIndex]
+			}
+			siblingIndex++
+		}
+		if i < height || (current.IsZero() && sibling.IsZero()) {
+			continue
+		}
+
+		if GetBit(index, i) == 1 {
+			current = hasher.HashNode(sibling, current)
+		} else {
+			current = hasher.HashNode(current, sibling)
+		}
+	}
+	return current
+}
+
+// compactPathEnd returns the subtree a compact proof's path ends at and its
+// height, or false if the proof's neighbour does not lie on the path
+func compactPathEnd(depth uint16, proof *Proof, hasher *HashSuite) (Bytes32, uint, bool) {
+	height := compactHeight(depth, proof.Enables)
+	switch {
+	case proof.Exists:
+		return hasher.HashLeaf(proof.Index, proof.Value), height, true
+	case proof.Neighbor != nil:
+		neighbor := proof.Neighbor
+		if neighbor.Index == nil || neighbor.Index.Cmp(proof.Index) == 0 {
+			return Bytes32{}, 0, false
+		}
+		if new(big.Int).Rsh(neighbor.Index, height).Cmp(new(big.Int).Rsh(proof.Index, height)) != 0 {
+			return Bytes32{}, 0, false
+		}
+		return hasher.HashLeaf(neighbor.Index, neighbor.Value), height, true
+	}
+	// The path ends at an empty subtree, which hashes to zero at any height
+	return Bytes32{}, 0, true
+}
+
+// ComputeRootFromCompactProof computes the root hash of a compact tree from a
+// proof using the optional hash suite, Keccak256 by default. It returns zero
+// if the proof's neighbour does not lie on the proof's path.
+func ComputeRootFromCompactProof(depth uint16, proof *Proof, suite ...*HashSuite) Bytes32 {
+	if proof == nil || proof.Index == nil {
+		return Bytes32{}
+	}
+	hasher := resolveHashSuite(suite)
+
+	current, height, ok := compactPathEnd(depth, proof, hasher)
+	if !ok {
+		return Bytes32{}
+	}
+	return compactRoot(depth, height, current, proof.Index, proof.Enables, proof.Siblings, hasher)
+}
+
+// VerifyCompactProof verifies a proof from a compact tree against root
+func VerifyCompactProof(root Bytes32, depth uint16, proof *Proof, suite ...*HashSuite) bool {
+	if proof == nil || proof.Index == nil {
+		return false
+	}
+	hasher := resolveHashSuite(suite)
+
+	current, height, ok := compactPathEnd(depth, proof, hasher)
+	if !ok {
+		return false
+	}
+	return compactRoot(depth, height, current, proof.Index, proof.Enables, proof.Siblings, hasher) == root
+}
+
+// VerifyCompactUpdateProof verifies that an insert, update or delete in a
+// compact tree moved oldRoot to newRoot. The proof of a delete whose sibling is
+// a leaf carries that leaf as its Neighbor, since it moves up in place of the
+// parent.
+func VerifyCompactUpdateProof(oldRoot, newRoot Bytes32, depth uint16, updateProof *UpdateProof, suite ...*HashSuite) bool {
+	if updateProof == nil {
+		return false
+	}
+	op, ok := updateProof.operation()
+	if !ok || (op == OpUpdate && updateProof.Neighbor != nil) {
+		return false
+	}
+	hasher := resolveHashSuite(suite)
+
+	oldProof := &Proof{
+		Exists:   updateProof.Exists,
+		Leaf:     updateProof.Leaf,
+		Value:    updateProof.Value,
+		Index:    updateProof.Index,
+		Enables:  updateProof.Enables,
+		Siblings: updateProof.Siblings,
+	}
+	if op == OpInsert {
+		oldProof.Neighbor = updateProof.Neighbor
+	}
+	if !VerifyCompactProof(oldRoot, depth, oldProof, hasher) {
+		return false
+	}
+	if op == OpDelete {
+		computed, ok := compactDeleteRoot(depth, updateProof, hasher)
+		return ok && computed == newRoot
+	}
+
+	// The new leaf takes the place the path ended at, sharing it with the
+	// neighbour if there is one
+	height := compactHeight(depth, updateProof.Enables)
+	current := updateProof.NewLeaf
+	if !updateProof.Exists && updateProof.Neighbor != nil {
+		neighbor := updateProof.Neighbor
+		current, _ = splitLeaves(uint16(height), updateProof.Index, current,
+			neighbor.Index, hasher.HashLeaf(neighbor.Index, neighbor.Value), func(left, right Bytes32) (Bytes32, error) {
+				return hasher.HashNode(left, right), nil
+			})
+	}
+	return compactRoot(depth, height, current, updateProof.Index, updateProof.Enables, updateProof.Siblings, hasher) == newRoot
+}
+
+// compactDeleteRoot returns the root left by the delete a verified proof
+// records, or false if its neighbour is not the leaf's sibling. Without a
+// neighbour the leaf's place becomes empty. Otherwise the neighbour takes the
+// parent's place and rises past every empty sibling above it.
+func compactDeleteRoot(depth uint16, updateProof *UpdateProof, hasher *HashSuite) (Bytes32, bool) {
+	height := compactHeight(depth, updateProof.Enables)
+	neighbor := updateProof.Neighbor
+	if neighbor == nil {
+		return compactRoot(depth, height, Bytes32{}, updateProof.Index, updateProof.Enables, updateProof.Siblings, hasher), true
+	}
+
+	if len(updateProof.Siblings) == 0 || neighbor.Index == nil {
+		return Bytes32{}, false
+	}
+	current := hasher.HashLeaf(neighbor.Index, neighbor.Value)
+	if current != updateProof.Siblings[0] {
+		return Bytes32{}, false
+	}
+	// The sibling's subtree holds the indices that share the path above height
+	// and take the other branch at it
+	if GetBit(neighbor.Index, height) == GetBit(updateProof.Index, height) ||
+		new(big.Int).Rsh(neighbor.Index, height+1).Cmp(new(big.Int).Rsh(updateProof.Index, height+1)) != 0 {
+		return Bytes32{}, false
+	}
+
+	enables := new(big.Int).SetBit(updateProof.Enables, int(height), 0)
+	return compactRoot(depth, compactHeight(depth, enables), current, updateProof.Index, enables, updateProof.Siblings[1:], hasher), true
+}
@@ -1,9 +1,12 @@
 package smt
 
 import (
+	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 	"math/big"
+	"sort"
+	"strings"
 	"sync"
 )
 
@@ -18,12 +21,355 @@ const (
 	NodePrefix = "n:"
 	LeafPrefix = "l:"
 	LeafIndexPrefix = "i:"
+	KVPrefix = "k:"
+	RefCountPrefix = "r:"
+	VersionPrefix = "v:"
+	MetadataKey = "m:tree"
+	HashSuiteKey = "m:hash"
+	DomainTagKey = "m:domain"
+	KeyDerivationKey = "m:keys"
+	LayoutKey = "m:layout"
+	RetentionKey = "m:retention"
 )
 
+// TreeFormatVersion is the on-disk format version written to the metadata record.
+// Version 2 added reference counts and version 3 versioned roots; older trees
+// are upgraded when opened.
+const TreeFormatVersion uint8 = 3
+
+// minTreeFormatVersion is the oldest format version that can still be opened
+const minTreeFormatVersion uint8 = 1
+
+// TreeMetadata describes a tree persisted in a Database
+type TreeMetadata struct {
+	Version       uint8
+	Depth         uint16
+	Root          Bytes32
+	LatestVersion uint64 // Latest committed tree version
+	OldestVersion uint64 // Oldest tree version still retained
+}
+
+// encodeMetadata serializes metadata as
+// version(1) || depth(2) || root(32) || latestVersion(8) || oldestVersion(8)
+func encodeMetadata(meta *TreeMetadata) []byte {
+	data := make([]byte, 51)
+	data[0] = meta.Version
+	binary.BigEndian.PutUint16(data[1:3], meta.Depth)
+	copy(data[3:35], meta.Root[:])
+	binary.BigEndian.PutUint64(data[35:43], meta.LatestVersion)
+	binary.BigEndian.PutUint64(data[43:51], meta.OldestVersion)
+	return data
+}
+
+// decodeMetadata parses a metadata record written by encodeMetadata. Records
+// written before format version 3 end after the root.
+func decodeMetadata(data []byte) (*TreeMetadata, error) {
+	if len(data) != 35 && len(data) != 51 {
+		return nil, fmt.Errorf("invalid metadata length: expected 35 or 51, got %d", len(data))
+	}
+
+	meta := &TreeMetadata{
+		Version: data[0],
+		Depth:   binary.BigEndian.Uint16(data[1:3]),
+	}
+	copy(meta.Root[:], data[3:35])
+	if len(data) == 51 {
+		meta.LatestVersion = binary.BigEndian.Uint64(data[35:43])
+		meta.OldestVersion = binary.BigEndian.Uint64(data[43:51])
+	}
+	return meta, nil
+}
+
+// ReadTreeMetadata loads the tree metadata stored in db, returning nil if none exists
+func ReadTreeMetadata(db Database) (*TreeMetadata, error) {
+	if db == nil {
+		return nil, ErrNilDatabase
+	}
+
+	data, exists, err := getIfPresent(db, []byte(MetadataKey))
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	return decodeMetadata(data)
+}
+
+// getIfPresent loads the record at key, reporting whether it exists
+func getIfPresent(db Database, key []byte) ([]byte, bool, error) {
+	// Check presence first: some databases report missing keys as errors
+	exists, err := db.Has(key)
+	if err != nil || !exists {
+		return nil, false, err
+	}
+
+	data, err := db.Get(key)
+	if err != nil { // coverage-ignore
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+// setRoot updates the root and persists it together with the tree metadata
+func (smt *SparseMerkleTree) setRoot(root Bytes32) error {
+	smt.root = root
+
+	if err := smt.writeOptionRecords(); err != nil { // coverage-ignore
+		return err
+	}
+
+	return smt.dbSet([]byte(MetadataKey), encodeMetadata(&TreeMetadata{
+		Version:       TreeFormatVersion,
+		Depth:         smt.depth,
+		Root:          root,
+		LatestVersion: smt.version,
+		OldestVersion: smt.oldestVersion,
+	}))
+}
+
+// writeBuffer stages writes so they can be committed to the database
+// atomically. It normally lives for one logical operation; while snapshots are
+// open it spans every operation until Commit.
+type writeBuffer struct {
+	base    stateMark         // state when staging started
+	op      stateMark         // state when the current operation started
+	writes  map[string][]byte // nil value marks a deletion
+	journal []stagedWrite     // previous staged state of every write, for reverts
+}
+
+// stagedWrite records the staged state of a key before it was overwritten
+type stagedWrite struct {
+	key    string
+	value  []byte
+	staged bool
+}
+
+// stateMark captures the in-memory tree state and journal positions so the
+// tree can be rolled back to it
+type stateMark struct {
+	root          Bytes32
+	version       uint64
+	oldestVersion uint64
+	retention     RetentionPolicy
+	journal       int
+	kvJournal     int
+}
+
+// beginWrite starts staging writes for a logical operation
+func (smt *SparseMerkleTree) beginWrite() {
+	smt.startStaging()
+	smt.pending.op = smt.markState()
+}
+
+// startStaging creates the write buffer if none is active
+func (smt *SparseMerkleTree) startStaging() {
+	if smt.pending != nil {
+		return
+	}
+	smt.pending = &writeBuffer{
+		writes: make(map[string][]byte),
+	}
+	smt.kvStore.beginJournal()
+	smt.pending.base = smt.markState()
+}
+
+// markState records the current state; staging must be active
+func (smt *SparseMerkleTree) markState() stateMark {
+	return stateMark{
+		root:          smt.root,
+		version:       smt.version,
+		oldestVersion: smt.oldestVersion,
+		retention:     smt.retention,
+		journal:       len(smt.pending.journal),
+		kvJournal:     smt.kvStore.journalLen(),
+	}
+}
+
+// revertTo undoes every staged write and KV change made after mark
+func (smt *SparseMerkleTree) revertTo(mark stateMark) {
+	buf := smt.pending
+	for i := len(buf.journal) - 1; i >= mark.journal; i-- {
+		entry := buf.journal[i]
+		if entry.staged {
+			buf.writes[entry.key] = entry.value
+		} else {
+			delete(buf.writes, entry.key)
+		}
+	}
+	buf.journal = buf.journal[:mark.journal]
+
+	smt.restoreState(mark)
+	smt.kvStore.revertJournalTo(mark.kvJournal)
+}
+
+// commitWrite ends the current operation. Its writes are flushed to the
+// database unless snapshots are open, in which case they wait for Commit.
+func (smt *SparseMerkleTree) commitWrite() error {
+	if smt.speculative {
+		return nil
+	}
+	return smt.flushWrite()
+}
+
+// flushWrite writes everything staged, using a single batch when the database
+// supports it. On failure the in-memory root and KV cache are restored.
+func (smt *SparseMerkleTree) flushWrite() error {
+	buf := smt.pending
+	smt.pending = nil
+
+	keys := make([]string, 0, len(buf.writes))
+	for key := range buf.writes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var err error
+	if bdb, ok := smt.db.(BatchDatabase); ok {
+		err = writeBatch(bdb.NewBatch(), keys, buf.writes)
+	} else {
+		err = writeSequential(smt.db, keys, buf.writes)
+	}
+
+	if err != nil {
+		smt.restoreState(buf.base)
+		smt.kvStore.revertJournal()
+		return err
+	}
+
+	smt.kvStore.commitJournal()
+	return nil
+}
+
+// discardWrite drops the writes of the current operation and restores the
+// in-memory root and KV cache
+func (smt *SparseMerkleTree) discardWrite() {
+	smt.revertTo(smt.pending.op)
+	if !smt.speculative {
+		smt.pending = nil
+		smt.kvStore.commitJournal()
+	}
+}
+
+// restoreState resets the in-memory root and version counters to mark
+func (smt *SparseMerkleTree) restoreState(mark stateMark) {
+	smt.root = mark.root
+	smt.version = mark.version
+	smt.oldestVersion = mark.oldestVersion
+	smt.retention = mark.retention
+}
+
+// writeBatch applies staged writes through a database batch
+func writeBatch(batch Batch, keys []string, writes map[string][]byte) error {
+	for _, key := range keys {
+		var err error
+		if value := writes[key]; value == nil {
+			err = batch.Delete([]byte(key))
+		} else {
+			err = batch.Put([]byte(key), value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return batch.Write()
+}
+
+// writeSequential applies staged writes one by one for databases without batch support
+func writeSequential(db Database, keys []string, writes map[string][]byte) error {
+	for _, key := range keys {
+		var err error
+		if value := writes[key]; value == nil {
+			err = db.Delete([]byte(key))
+		} else {
+			err = db.Set([]byte(key), value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dbGet reads a key, observing writes staged by the current operation
+func (smt *SparseMerkleTree) dbGet(key []byte) ([]byte, error) {
+	if smt.pending != nil {
+		if value, staged := smt.pending.writes[string(key)]; staged {
+			return value, nil
+		}
+	}
+	return smt.db.Get(key)
+}
+
+// dbHas checks a key, observing writes staged by the current operation
+func (smt *SparseMerkleTree) dbHas(key []byte) (bool, error) {
+	if smt.pending != nil {
+		if value, staged := smt.pending.writes[string(key)]; staged {
+			return value != nil, nil
+		}
+	}
+	return smt.db.Has(key)
+}
+
+// dbSet stages a write, or writes through when no operation is in progress
+func (smt *SparseMerkleTree) dbSet(key []byte, value []byte) error {
+	if smt.pending != nil {
+		stored := make([]byte, len(value))
+		copy(stored, value)
+		smt.stage(string(key), stored)
+		return nil
+	}
+	return smt.db.Set(key, value)
+}
+
+// dbDelete stages a deletion, or deletes through when no operation is in progress
+func (smt *SparseMerkleTree) dbDelete(key []byte) error {
+	if smt.pending != nil {
+		smt.stage(string(key), nil)
+		return nil
+	}
+	return smt.db.Delete(key)
+}
+
+// stage records a write in the buffer, journaling what it replaces
+func (smt *SparseMerkleTree) stage(key string, value []byte) {
+	prev, staged := smt.pending.writes[key]
+	smt.pending.journal = append(smt.pending.journal, stagedWrite{key: key, value: prev, staged: staged})
+	smt.pending.writes[key] = value
+}
+
+// treeDatabase exposes the tree's staged view of its database so that
+// KVStore writes join the same batch as the tree writes
+type treeDatabase struct {
+	smt *SparseMerkleTree
+}
+
+func (t treeDatabase) Get(key []byte) ([]byte, error)     { return t.smt.dbGet(key) }
+func (t treeDatabase) Set(key []byte, value []byte) error { return t.smt.dbSet(key, value) }
+func (t treeDatabase) Delete(key []byte) error            { return t.smt.dbDelete(key) }
+func (t treeDatabase) Has(key []byte) (bool, error)       { return t.smt.dbHas(key) }
+
+// IteratePrefix scans the tree's database, observing writes staged by the
+// current operation
+func (t treeDatabase) IteratePrefix(prefix []byte, fn func(key, value []byte) bool) error {
+	idb, ok := t.smt.db.(IterableDatabase)
+	if !ok {
+		return ErrNotIterable
+	}
+
+	staged := make(map[string][]byte)
+	if t.smt.pending != nil {
+		for key, value := range t.smt.pending.writes {
+			if strings.HasPrefix(key, string(prefix)) {
+				staged[key] = value
+			}
+		}
+	}
+	return iterateMerged(idb, prefix, staged, fn)
+}
+
 // getNode retrieves a node from the database
 func (smt *SparseMerkleTree) getNode(hash Bytes32) (*Node, error) {
 	key := []byte(NodePrefix + hex.EncodeToString(hash[:]))
-	data, err := smt.db.Get(key)
+	data, err := smt.dbGet(key)
 	if err != nil {// coverage-ignore
 		return nil, err
 	}
@@ -47,19 +393,19 @@ func (smt *SparseMerkleTree) getNode(hash Bytes32) (*Node, error) {
 func (smt *SparseMerkleTree) setNode(hash Bytes32, node *Node) error {
 	key := []byte(NodePrefix + hex.EncodeToString(hash[:]))
 	data := append(node.Left[:], node.Right[:]...)
-	return smt.db.Set(key, data)
+	return smt.dbSet(key, data)
 }
 
 // deleteNode removes a node from the database
 func (smt *SparseMerkleTree) deleteNode(hash Bytes32) error {
 	key := []byte(NodePrefix + hex.EncodeToString(hash[:]))
-	return smt.db.Delete(key)
+	return smt.dbDelete(key)
 }
 
 // getLeaf retrieves a leaf from the database
 func (smt *SparseMerkleTree) getLeaf(hash Bytes32) (*LeafData, error) {
 	key := []byte(LeafPrefix + hex.EncodeToString(hash[:]))
-	data, err := smt.db.Get(key)
+	data, err := smt.dbGet(key)
 	if err != nil {// coverage-ignore
 		return nil, err
 	}
@@ -92,35 +438,20 @@ func (smt *SparseMerkleTree) setLeaf(hash Bytes32, leaf *LeafData) error {
 	indexBytes := leaf.Index.Bytes()
 	data := append(leaf.Value[:], indexBytes...)
 	
-	if err := smt.db.Set(key, data); err != nil { // coverage-ignore
+	if err := smt.dbSet(key, data); err != nil { // coverage-ignore
 		return err
 	}
 	
 	// Store index mapping
 	indexKey := []byte(LeafIndexPrefix + hex.EncodeToString(indexBytes))
-	return smt.db.Set(indexKey, hash[:])
+	return smt.dbSet(indexKey, hash[:])
 }
 
-// deleteLeaf removes a leaf from the database
-func (smt *SparseMerkleTree) deleteLeaf(hash Bytes32) error {
-	// Get leaf data to find index
-	leaf, err := smt.getLeaf(hash)
-	if err != nil {// coverage-ignore
-		return err
-	}
-	
-	if leaf != nil {
-		// Delete index mapping
-		indexBytes := leaf.Index.Bytes()
-		indexKey := []byte(LeafIndexPrefix + hex.EncodeToString(indexBytes))
-		if err := smt.db.Delete(indexKey); err != nil { // coverage-ignore
-			return err
-		}
-	}
-	
-	// Delete leaf data
-	key := []byte(LeafPrefix + hex.EncodeToString(hash[:]))
-	return smt.db.Delete(key)
+// deleteLeafIndex removes the index mapping of a leaf. The leaf record itself
+// is reference counted and deleted once no node refers to it.
+func (smt *SparseMerkleTree) deleteLeafIndex(index *big.Int) error {
+	indexKey := []byte(LeafIndexPrefix + hex.EncodeToString(index.Bytes()))
+	return smt.dbDelete(indexKey)
 }
 
 // getLeafByIndex retrieves a leaf hash by its index
@@ -128,7 +459,7 @@ func (smt *SparseMerkleTree) getLeafByIndex(index *big.Int) (Bytes32, error) {
 	indexBytes := index.Bytes()
 	indexKey := []byte(LeafIndexPrefix + hex.EncodeToString(indexBytes))
 	
-	data, err := smt.db.Get(indexKey)
+	data, err := smt.dbGet(indexKey)
 	if err != nil { // coverage-ignore
 		return Bytes32{}, err
 	}
@@ -199,4 +530,76 @@ func (db *InMemoryDatabase) Has(key []byte) (bool, error) {
 	
 	_, exists := db.data[string(key)]
 	return exists, nil
-}
\ No newline at end of file
+}
+
+// IteratePrefix calls fn for every key with the given prefix in ascending key order.
+// It iterates over a snapshot, so fn may modify the database.
+func (db *InMemoryDatabase) IteratePrefix(prefix []byte, fn func(key, value []byte) bool) error {
+	db.mu.RLock()
+	keys := make([]string, 0)
+	values := make(map[string][]byte)
+	for key, value := range db.data {
+		if strings.HasPrefix(key, string(prefix)) {
+			keys = append(keys, key)
+			values[key] = value
+		}
+	}
+	db.mu.RUnlock()
+
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := make([]byte, len(values[key]))
+		copy(value, values[key])
+		if !fn([]byte(key), value) {
+			break
+		}
+	}
+	return nil
+}
+
+// NewBatch creates a write batch applied atomically under the database lock
+func (db *InMemoryDatabase) NewBatch() Batch {
+	return &inMemoryBatch{db: db}
+}
+
+// inMemoryBatch buffers writes for an InMemoryDatabase
+type inMemoryBatch struct {
+	db  *InMemoryDatabase
+	ops []batchOp
+}
+
+// batchOp is a single buffered write; a nil value marks a deletion
+type batchOp struct {
+	key   string
+	value []byte
+}
+
+// Put buffers a key-value write
+func (b *inMemoryBatch) Put(key []byte, value []byte) error {
+	storedValue := make([]byte, len(value))
+	copy(storedValue, value)
+	b.ops = append(b.ops, batchOp{key: string(key), value: storedValue})
+	return nil
+}
+
+// Delete buffers a key deletion
+func (b *inMemoryBatch) Delete(key []byte) error {
+	b.ops = append(b.ops, batchOp{key: string(key)})
+	return nil
+}
+
+// Write applies all buffered writes atomically
+func (b *inMemoryBatch) Write() error {
+	b.db.mu.Lock()
+	defer b.db.mu.Unlock()
+
+	for _, op := range b.ops {
+		if op.value == nil {
+			delete(b.db.data, op.key)
+		} else {
+			b.db.data[op.key] = op.value
+		}
+	}
+	b.ops = nil
+	return nil
+}
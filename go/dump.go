@@ -0,0 +1,305 @@
+package smt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// A dump is a portable stream of the leaves of a tree:
+//
+//	header:  magic "SMTD" || format version(1) || depth(2) || root(32)
+//	record:  kind(1) || index(32) || value(32) [|| keyLen(uvarint) || kvKey]
+//	trailer: dumpEnd(1) || record count(8) || checksum(32)
+//
+// Records are written in ascending index order. The checksum is the Keccak256
+// of every byte before it. Integers are big-endian.
+
+// DumpFormatVersion is the version of the dump stream written by Export
+const DumpFormatVersion uint8 = 1
+
+// dumpMagic identifies a dump stream
+const dumpMagic = "SMTD"
+
+// Record kinds
+const (
+	dumpEnd    byte = 0
+	dumpLeaf   byte = 1
+	dumpKVLeaf byte = 2
+)
+
+// maxDumpKeyLength bounds KV keys read from a dump
+const maxDumpKeyLength = 1 << 16
+
+// Export writes every leaf of the current tree, with the KV key that produced
+// it where known, to w as a dump stream. KV keys are found by scanning the
+// database when it implements IterableDatabase, and from the KV cache otherwise.
+func (smt *SparseMerkleTree) Export(w io.Writer) error {
+	smt.mu.RLock()
+	defer smt.mu.RUnlock()
+
+	// The KV scan reads the database directly
+	if smt.speculative {
+		return ErrUncommittedChanges
+	}
+
+	keys, err := smt.kvKeysByIndex()
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	dw := &dumpWriter{w: bw, h: crypto.NewKeccakState()}
+
+	header := make([]byte, 0, 39)
+	header = append(header, dumpMagic...)
+	header = append(header, DumpFormatVersion)
+	header = binary.BigEndian.AppendUint16(header, smt.depth)
+	header = append(header, smt.root[:]...)
+	dw.write(header)
+
+	var count uint64
+	_, err = smt.walkLeaves(smt.root, 0, big.NewInt(0), nil, nil, func(index *big.Int, value Bytes32) bool {
+		record := make([]byte, 65)
+		record[0] = dumpLeaf
+		index.FillBytes(record[1:33])
+		copy(record[33:65], value[:])
+
+		if key, ok := keys[index.String()]; ok && key.value == value {
+			record[0] = dumpKVLeaf
+			record = binary.AppendUvarint(record, uint64(len(key.key)))
+			record = append(record, key.key...)
+		}
+
+		dw.write(record)
+		count++
+		return dw.err == nil
+	})
+	if err != nil { // coverage-ignore
+		return err
+	}
+
+	trailer := []byte{dumpEnd}
+	trailer = binary.BigEndian.AppendUint64(trailer, count)
+	dw.write(trailer)
+	if dw.err != nil {
+		return dw.err
+	}
+
+	if _, err := bw.Write(dw.h.Sum(nil)); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// dumpKVKey is a KV key and the value it was stored with
+type dumpKVKey struct {
+	key   string
+	value Bytes32
+}
+
+// kvKeysByIndex maps leaf indices, in decimal, to the KV keys that map to them
+func (smt *SparseMerkleTree) kvKeysByIndex() (map[string]dumpKVKey, error) {
+	keys := make(map[string]dumpKVKey)
+
+	idb, ok := smt.db.(IterableDatabase)
+	if !ok {
+		return smt.cachedKVKeys(keys), nil
+	}
+
+	err := idb.IteratePrefix([]byte(KVPrefix), func(k, v []byte) bool {
+		raw, err := hex.DecodeString(strings.TrimPrefix(string(k), KVPrefix))
+		if err != nil || len(v) != 32 {
+			return true
+		}
+		key := string(raw)
+		keys[smt.indexForKey(key).String()] = dumpKVKey{key: key, value: Bytes32(v)}
+		return true
+	})
+	// Wrappers implement IterableDatabase even when the database they wrap does not
+	if errors.Is(err, ErrNotIterable) {
+		return smt.cachedKVKeys(keys), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// cachedKVKeys adds the KV keys held by the KV cache to keys
+func (smt *SparseMerkleTree) cachedKVKeys(keys map[string]dumpKVKey) map[string]dumpKVKey {
+	for key, value := range smt.kvStore.All() {
+		keys[smt.indexForKey(key).String()] = dumpKVKey{key: key, value: value}
+	}
+	return keys
+}
+
+// dumpWriter writes to a stream while hashing, keeping the first error
+type dumpWriter struct {
+	w   io.Writer
+	h   hash.Hash
+	err error
+}
+
+func (dw *dumpWriter) write(p []byte) {
+	if dw.err != nil {
+		return
+	}
+	dw.h.Write(p)
+	_, dw.err = dw.w.Write(p)
+}
+
+// Import rebuilds a tree from a dump stream written by Export. The tree must be
+// empty and of the dump's depth. Nothing is committed unless the checksum
+// verifies and the rebuilt root matches the root in the header; the whole
+// import is committed as a single version.
+func (smt *SparseMerkleTree) Import(r io.Reader) error {
+	smt.mu.Lock()
+	defer smt.mu.Unlock()
+
+	if !smt.root.IsZero() {
+		return ErrTreeNotEmpty
+	}
+
+	dr := &dumpReader{r: bufio.NewReader(r), h: crypto.NewKeccakState()}
+
+	header, err := dr.read(39)
+	if err != nil {
+		return err
+	}
+	if string(header[0:4]) != dumpMagic {
+		return fmt.Errorf("%w: bad magic", ErrInvalidDump)
+	}
+	if header[4] != DumpFormatVersion {
+		return &DumpVersionError{Version: header[4]}
+	}
+	if depth := binary.BigEndian.Uint16(header[5:7]); depth != smt.depth {
+		return &DepthMismatchError{Stored: depth, Requested: smt.depth}
+	}
+	var expected Bytes32
+	copy(expected[:], header[7:39])
+
+	smt.beginWrite()
+	if err := smt.importRecords(dr, expected); err != nil {
+		smt.discardWrite()
+		return err
+	}
+
+	if err := smt.recordVersion(); err != nil { // coverage-ignore
+		smt.discardWrite()
+		return err
+	}
+	return smt.commitWrite()
+}
+
+// importRecords inserts the records of a dump and verifies its trailer and root
+func (smt *SparseMerkleTree) importRecords(dr *dumpReader, expected Bytes32) error {
+	var count uint64
+	var previous *big.Int
+	for {
+		kind, err := dr.read(1)
+		if err != nil {
+			return err
+		}
+		if kind[0] == dumpEnd {
+			break
+		}
+		if kind[0] != dumpLeaf && kind[0] != dumpKVLeaf {
+			return fmt.Errorf("%w: unknown record kind %d", ErrInvalidDump, kind[0])
+		}
+
+		record, err := dr.read(64)
+		if err != nil {
+			return err
+		}
+		index := new(big.Int).SetBytes(record[0:32])
+		var value Bytes32
+		copy(value[:], record[32:64])
+
+		if previous != nil && index.Cmp(previous) <= 0 {
+			return fmt.Errorf("%w: record %d at index %s is out of order", ErrInvalidDump, count, index)
+		}
+		previous = index
+
+		if _, err := smt.insertInternal(index, value); err != nil {
+			return err
+		}
+
+		if kind[0] == dumpKVLeaf {
+			key, err := dr.readKey()
+			if err != nil {
+				return err
+			}
+			if smt.indexForKey(key).Cmp(index) != 0 {
+				return fmt.Errorf("%w: KV key %q does not map to index %s", ErrInvalidDump, key, index)
+			}
+			if err := smt.kvStore.Store(key, value); err != nil { // coverage-ignore
+				return err
+			}
+		}
+		count++
+	}
+
+	trailer, err := dr.read(8)
+	if err != nil {
+		return err
+	}
+	if binary.BigEndian.Uint64(trailer) != count {
+		return fmt.Errorf("%w: trailer records %d leaves, read %d", ErrInvalidDump, binary.BigEndian.Uint64(trailer), count)
+	}
+
+	sum := dr.h.Sum(nil)
+	checksum := make([]byte, 32)
+	if _, err := io.ReadFull(dr.r, checksum); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidDump, err)
+	}
+	if string(checksum) != string(sum) {
+		return ErrDumpChecksum
+	}
+
+	if smt.root != expected {
+		return &DumpRootMismatchError{Expected: expected, Computed: smt.root}
+	}
+	return nil
+}
+
+// dumpReader reads from a stream while hashing what it reads
+type dumpReader struct {
+	r *bufio.Reader
+	h hash.Hash
+}
+
+func (dr *dumpReader) read(n int) ([]byte, error) {
+	p := make([]byte, n)
+	if _, err := io.ReadFull(dr.r, p); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidDump, err)
+	}
+	dr.h.Write(p)
+	return p, nil
+}
+
+// readKey reads a length-prefixed KV key
+func (dr *dumpReader) readKey() (string, error) {
+	length, err := binary.ReadUvarint(dr.r)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidDump, err)
+	}
+	if length > maxDumpKeyLength {
+		return "", fmt.Errorf("%w: KV key of %d bytes", ErrInvalidDump, length)
+	}
+	dr.h.Write(binary.AppendUvarint(nil, length))
+
+	key, err := dr.read(int(length))
+	if err != nil {
+		return "", err
+	}
+	return string(key), nil
+}
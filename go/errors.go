@@ -24,6 +24,64 @@ var (
 
 	// ErrNilDatabase is returned when database is nil
 	ErrNilDatabase = fmt.Errorf("database cannot be nil")
+
+	// ErrTreeNotFound is returned when opening a database that holds no tree metadata
+	ErrTreeNotFound = fmt.Errorf("no tree metadata found in database")
+
+	// ErrInvalidCacheSize is returned when a cache is configured without capacity
+	ErrInvalidCacheSize = fmt.Errorf("cache size must be positive")
+
+	// ErrNotIterable is returned when iterating a database that does not support it
+	ErrNotIterable = fmt.Errorf("database does not support iteration")
+
+	// ErrSnapshotNotFound is returned when reverting to a snapshot that was never taken,
+	// has already been reverted or was committed
+	ErrSnapshotNotFound = fmt.Errorf("snapshot not found")
+
+	// ErrUncommittedChanges is returned by operations that need every change written to the database
+	ErrUncommittedChanges = fmt.Errorf("tree has uncommitted snapshot changes")
+
+	// ErrTreeNotEmpty is returned when importing into a tree that already holds leaves
+	ErrTreeNotEmpty = fmt.Errorf("tree is not empty")
+
+	// ErrInvalidDump is returned when a dump stream is malformed or truncated
+	ErrInvalidDump = fmt.Errorf("invalid tree dump")
+
+	// ErrDumpChecksum is returned when a dump stream fails its checksum
+	ErrDumpChecksum = fmt.Errorf("tree dump checksum mismatch")
+
+	// ErrInvalidHashSuite is returned when a hash suite lacks a name or a hash function
+	ErrInvalidHashSuite = fmt.Errorf("hash suite must have a name, a node hash and a leaf hash")
+
+	// ErrInvalidKeyDerivation is returned when a key derivation scheme lacks a name or a function
+	ErrInvalidKeyDerivation = fmt.Errorf("key derivation must have a name and a derive function")
+
+	// ErrInvalidNamespace is returned when namespacing a database with an empty namespace
+	ErrInvalidNamespace = fmt.Errorf("namespace cannot be empty")
+
+	// ErrNilOptions is returned when creating a tree without options
+	ErrNilOptions = fmt.Errorf("tree options cannot be nil")
+
+	// ErrUnsortedLeaves is returned when a bulk load source is not in strictly ascending index order
+	ErrUnsortedLeaves = fmt.Errorf("leaves must be in strictly ascending index order")
+
+	// ErrNoIndices is returned when a multi-index proof is requested for no indices
+	ErrNoIndices = fmt.Errorf("at least one index is required")
+
+	// ErrCompactLayout is returned by operations the compact layout does not support
+	ErrCompactLayout = fmt.Errorf("not supported by compact trees")
+
+	// ErrInvalidPrefix is returned when a prefix does not name a subtree of the tree
+	ErrInvalidPrefix = fmt.Errorf("prefix does not name a subtree of the tree")
+
+	// ErrInvalidRange is returned for an index range that is empty or exceeds the tree
+	ErrInvalidRange = fmt.Errorf("range must satisfy 0 <= start < end <= 2^depth")
+
+	// ErrWitnessMismatch is returned when an update proof was not made against the root a witness verifies against
+	ErrWitnessMismatch = fmt.Errorf("update proof does not share the witness's root")
+
+	// ErrWitnessIndex is returned when an update proof writes the witness's own index
+	ErrWitnessIndex = fmt.Errorf("update proof writes a new value to the witness's index")
 )
 
 // InvalidTreeDepthError represents an error for invalid tree depth
@@ -35,6 +93,77 @@ func (e InvalidTreeDepthError) Error() string {
 	return fmt.Sprintf("invalid tree depth: %d (must be between 1 and 256)", e.Depth)
 }
 
+// DepthMismatchError represents an error when the stored tree depth differs from the requested one
+type DepthMismatchError struct {
+	Stored    uint16
+	Requested uint16
+}
+
+func (e DepthMismatchError) Error() string {
+	return fmt.Sprintf("tree depth mismatch: database holds depth %d, requested %d", e.Stored, e.Requested)
+}
+
+// FormatVersionError represents an error for an unsupported metadata format version
+type FormatVersionError struct {
+	Version uint8
+}
+
+func (e FormatVersionError) Error() string {
+	return fmt.Sprintf("unsupported tree format version: %d (expected %d)", e.Version, TreeFormatVersion)
+}
+
+// HashSuiteMismatchError represents an error when a stored tree was built with a different hash suite
+type HashSuiteMismatchError struct {
+	Stored    string
+	Requested string // Empty when the stored suite is not a built-in one
+}
+
+func (e HashSuiteMismatchError) Error() string {
+	if e.Requested == "" {
+		return fmt.Sprintf("tree uses hash suite %q, which is not built in", e.Stored)
+	}
+	return fmt.Sprintf("hash suite mismatch: database holds %q, requested %q", e.Stored, e.Requested)
+}
+
+// OptionMismatchError represents an error when a stored tree was built with a different option
+type OptionMismatchError struct {
+	Option    string
+	Stored    string
+	Requested string
+}
+
+func (e OptionMismatchError) Error() string {
+	return fmt.Sprintf("%s mismatch: database holds %q, requested %q", e.Option, e.Stored, e.Requested)
+}
+
+// VersionNotFoundError represents an error for a version that was never committed or has been pruned
+type VersionNotFoundError struct {
+	Version uint64
+}
+
+func (e VersionNotFoundError) Error() string {
+	return fmt.Sprintf("tree version %d not found or pruned", e.Version)
+}
+
+// DumpVersionError represents an error for an unsupported dump format version
+type DumpVersionError struct {
+	Version uint8
+}
+
+func (e DumpVersionError) Error() string {
+	return fmt.Sprintf("unsupported tree dump version: %d (expected %d)", e.Version, DumpFormatVersion)
+}
+
+// DumpRootMismatchError represents an error when an imported tree does not rebuild the dumped root
+type DumpRootMismatchError struct {
+	Expected Bytes32
+	Computed Bytes32
+}
+
+func (e DumpRootMismatchError) Error() string {
+	return fmt.Sprintf("tree dump root mismatch: header has %s, rebuilt %s", e.Expected.Hex(), e.Computed.Hex())
+}
+
 // OutOfRangeError represents an error for out of range index
 type OutOfRangeError struct {
 	Index     *big.Int
@@ -47,6 +176,67 @@ func (e OutOfRangeError) Error() string {
 		e.Index.String(), e.TreeDepth, maxIndex.String())
 }
 
+// EnableBitError reports a proof with an enable bit at or beyond the tree depth
+type EnableBitError struct {
+	Bit   int
+	Depth uint16
+}
+
+func (e EnableBitError) Error() string {
+	return fmt.Sprintf("invalid proof: enable bit %d is at or beyond depth %d", e.Bit, e.Depth)
+}
+
+// Unwrap lets errors.Is match ErrInvalidProof
+func (e EnableBitError) Unwrap() error {
+	return ErrInvalidProof
+}
+
+// SiblingCountError reports a proof whose sibling count differs from the number of enable bits set
+type SiblingCountError struct {
+	Siblings int
+	Enabled  int
+}
+
+func (e SiblingCountError) Error() string {
+	return fmt.Sprintf("invalid proof: %d siblings for %d enable bits", e.Siblings, e.Enabled)
+}
+
+// Unwrap lets errors.Is match ErrInvalidProof
+func (e SiblingCountError) Unwrap() error {
+	return ErrInvalidProof
+}
+
+// LeafHashMismatchError reports a proof whose leaf hash is not the hash of its
+// index and value, or is not zero in a non-membership proof
+type LeafHashMismatchError struct {
+	Leaf     Bytes32
+	Computed Bytes32
+}
+
+func (e LeafHashMismatchError) Error() string {
+	return fmt.Sprintf("invalid proof: leaf hash %s, expected %s", e.Leaf.Hex(), e.Computed.Hex())
+}
+
+// Unwrap lets errors.Is match ErrInvalidProof
+func (e LeafHashMismatchError) Unwrap() error {
+	return ErrInvalidProof
+}
+
+// RootMismatchError reports a well-formed proof that computes a different root
+type RootMismatchError struct {
+	Expected Bytes32
+	Computed Bytes32
+}
+
+func (e RootMismatchError) Error() string {
+	return fmt.Sprintf("invalid proof: computes root %s, expected %s", e.Computed.Hex(), e.Expected.Hex())
+}
+
+// Unwrap lets errors.Is match ErrInvalidProof
+func (e RootMismatchError) Unwrap() error {
+	return ErrInvalidProof
+}
+
 // KeyNotFoundError represents an error when key is not found
 type KeyNotFoundError struct {
 	Index *big.Int
@@ -70,3 +260,20 @@ func IsKeyExistsError(err error) bool {
 	_, ok := err.(*KeyExistsError)
 	return ok
 }
+
+// BatchError reports the operation that caused ExecuteBatch or a batch commit
+// to roll back
+type BatchError struct {
+	Index int            // Position of the failed operation in the batch
+	Op    BatchOperation // The failed operation
+	Err   error          // Underlying cause
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("batch operation %d (%s) failed: %v", e.Index, e.Op.Type, e.Err)
+}
+
+// Unwrap returns the underlying cause
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
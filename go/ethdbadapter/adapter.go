@@ -0,0 +1,110 @@
+// Package ethdbadapter exposes a go-ethereum ethdb.KeyValueStore as an smt.Database,
+// so trees can live in LevelDB, Pebble or memorydb stores used by node tooling.
+//
+// Keys are written unprefixed; wrap the store with rawdb.NewTable to give the
+// tree its own namespace inside a shared database.
+package ethdbadapter
+
+import (
+	"github.com/ethereum/go-ethereum/ethdb"
+
+	smt "github.com/0xanonymeow/smt/go"
+)
+
+// Database adapts an ethdb.KeyValueStore to the smt.Database interface
+type Database struct {
+	kv ethdb.KeyValueStore
+}
+
+var (
+	_ smt.BatchDatabase    = (*Database)(nil)
+	_ smt.IterableDatabase = (*Database)(nil)
+)
+
+// NewDatabase wraps an ethdb.KeyValueStore
+func NewDatabase(kv ethdb.KeyValueStore) *Database {
+	return &Database{kv: kv}
+}
+
+// Store returns the wrapped key-value store
+func (db *Database) Store() ethdb.KeyValueStore {
+	return db.kv
+}
+
+// Get retrieves a value by key, returning nil if it does not exist.
+// ethdb backends report missing keys with backend-specific errors, so a
+// failed Get is confirmed with Has before being treated as a miss.
+func (db *Database) Get(key []byte) ([]byte, error) {
+	value, err := db.kv.Get(key)
+	if err == nil {
+		return value, nil
+	}
+
+	exists, herr := db.kv.Has(key)
+	if herr != nil {
+		return nil, herr
+	}
+	if !exists {
+		return nil, nil
+	}
+	return nil, err
+}
+
+// Set stores a key-value pair
+func (db *Database) Set(key []byte, value []byte) error {
+	return db.kv.Put(key, value)
+}
+
+// Delete removes a key-value pair
+func (db *Database) Delete(key []byte) error {
+	return db.kv.Delete(key)
+}
+
+// Has checks if a key exists
+func (db *Database) Has(key []byte) (bool, error) {
+	return db.kv.Has(key)
+}
+
+// IteratePrefix calls fn for every key with the given prefix in ascending key order
+func (db *Database) IteratePrefix(prefix []byte, fn func(key, value []byte) bool) error {
+	it := db.kv.NewIterator(prefix, nil)
+	defer it.Release()
+
+	for it.Next() {
+		key := append([]byte(nil), it.Key()...)
+		value := append([]byte(nil), it.Value()...)
+		if !fn(key, value) {
+			break
+		}
+	}
+	return it.Error()
+}
+
+// NewBatch creates a write batch backed by an ethdb.Batch
+func (db *Database) NewBatch() smt.Batch {
+	return &batch{b: db.kv.NewBatch()}
+}
+
+// batch adapts an ethdb.Batch to the smt.Batch interface
+type batch struct {
+	b ethdb.Batch
+}
+
+// Put buffers a key-value write
+func (b *batch) Put(key []byte, value []byte) error {
+	return b.b.Put(key, value)
+}
+
+// Delete buffers a key deletion
+func (b *batch) Delete(key []byte) error {
+	return b.b.Delete(key)
+}
+
+// Write commits the buffered writes atomically
+func (b *batch) Write() error {
+	if err := b.b.Write(); err != nil {
+		return err
+	}
+	b.b.Reset()
+	return nil
+}
@@ -264,26 +264,25 @@ func customHashFunctionExample() {
 	fmt.Println("4. Custom Hash Functions")
 	fmt.Println("------------------------")
 
-	// Custom database with domain-separated hash function
-	type CustomDB struct {
-		smt.Database
-		domain string
+	// Two applications share one database; each tree lives in its own
+	// namespace and mixes its own domain tag into every leaf hash
+	sharedDB := smt.NewInMemoryDatabase()
+	customOpts := &smt.TreeOptions{
+		Depth:     256,
+		DomainTag: "MyApplication",
+		Namespace: "app-a",
 	}
-
-	// Create a custom database wrapper that adds domain separation
-	customDB := &CustomDB{
-		Database: smt.NewInMemoryDatabase(),
-		domain: "MyApplication",
+	standardOpts := &smt.TreeOptions{
+		Depth:     256,
+		Namespace: "app-b",
 	}
 
-	// Create SMT with standard configuration
-	customTree, err := smt.NewSparseMerkleTree(customDB, 256)
+	customTree, err := smt.NewSparseMerkleTreeWithOptions(sharedDB, customOpts)
 	if err != nil {
 		log.Fatal("Failed to create custom tree:", err)
 	}
 
-	standardDB := smt.NewInMemoryDatabase()
-	standardTree, err := smt.NewSparseMerkleTree(standardDB, 256)
+	standardTree, err := smt.NewSparseMerkleTreeWithOptions(sharedDB, standardOpts)
 	if err != nil {
 		log.Fatal("Failed to create standard tree:", err)
 	}
@@ -302,22 +301,21 @@ func customHashFunctionExample() {
 	fmt.Printf("  Custom tree root:   %s\n", customTree.Root())
 	fmt.Printf("  Standard tree root: %s\n", standardTree.Root())
 	
-	// Note: Roots will be the same unless the underlying hash function is different
-	// The SMT package uses a standard hash function internally
+	// The domain tag changes every leaf hash, so the roots differ
 
-	// Verify proofs work with respective trees
+	// Verify proofs with the options of their trees
 	customProof, _ := customTree.Get(index)
 	standardProof, _ := standardTree.Get(index)
 
-	customValid := smt.VerifyProof(customTree.Root(), customTree.Depth(), customProof)
-	standardValid := smt.VerifyProof(standardTree.Root(), standardTree.Depth(), standardProof)
+	customValid := smt.VerifyProofWithOptions(customTree.Root(), customProof, customOpts)
+	standardValid := smt.VerifyProofWithOptions(standardTree.Root(), standardProof, standardOpts)
 
 	fmt.Printf("  Custom proof valid:   %v\n", customValid)
 	fmt.Printf("  Standard proof valid: %v\n", standardValid)
 
-	// Cross-verification should work since hash functions are the same
-	crossValid := smt.VerifyProof(customTree.Root(), customTree.Depth(), standardProof)
-	fmt.Printf("  Cross-verification:   %v (should be true with same hash)\n", crossValid)
+	// Cross-verification fails: without the domain tag the leaf hashes differ
+	crossValid := smt.VerifyProofWithOptions(customTree.Root(), customProof, standardOpts)
+	fmt.Printf("  Cross-verification:   %v (should be false with different tags)\n", crossValid)
 
 	fmt.Println()
 }
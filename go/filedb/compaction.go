@@ -0,0 +1,215 @@
+package filedb
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// compactionRecordSize bounds the payload of the records written by compaction
+const compactionRecordSize = 1 << 20
+
+// GarbageRatio returns the fraction of sealed segment bytes taken by
+// overwritten values and tombstones
+func (db *FileDatabase) GarbageRatio() float64 {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var total, dead int64
+	for id, size := range db.sizes {
+		if id == db.active {
+			continue
+		}
+		total += size
+		dead += db.dead[id]
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(dead) / float64(total)
+}
+
+// Compact rewrites the live records of all sealed segments into a single base
+// segment and removes the segments it replaces. Reads and writes continue while
+// the records are copied; the index is switched over at the end.
+//
+// The output replaces the newest sealed segment through an atomic rename and is
+// flagged as a base segment, so a crash at any point leaves a log that replays
+// to the same state.
+func (db *FileDatabase) Compact() error {
+	db.compactMu.Lock()
+	defer db.compactMu.Unlock()
+
+	db.mu.Lock()
+	if db.closed {
+		db.mu.Unlock()
+		return ErrClosed
+	}
+	if db.opts.ReadOnly {
+		db.mu.Unlock()
+		return ErrReadOnly
+	}
+	// Seal the active segment so every record to compact is immutable
+	if db.activeSize > int64(segmentHeaderSize) {
+		if err := db.rotateLocked(); err != nil {
+			db.mu.Unlock()
+			return err
+		}
+	}
+
+	sealed := make([]uint32, 0, len(db.sizes))
+	for id := range db.sizes {
+		if id != db.active {
+			sealed = append(sealed, id)
+		}
+	}
+	if len(sealed) == 0 {
+		db.mu.Unlock()
+		return nil
+	}
+	sort.Slice(sealed, func(i, j int) bool { return sealed[i] < sealed[j] })
+	target := sealed[len(sealed)-1]
+
+	snapshot := make(map[string]location)
+	for key, loc := range db.index {
+		if loc.segment <= target {
+			snapshot[key] = loc
+		}
+	}
+	files := make(map[uint32]*os.File, len(sealed))
+	for _, id := range sealed {
+		files[id] = db.files[id]
+	}
+	db.mu.Unlock()
+
+	tmpPath := filepath.Join(db.dir, compactTmpName)
+	moved, size, err := writeCompacted(tmpPath, snapshot, files, target)
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := os.Rename(tmpPath, segmentPath(db.dir, target)); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := syncDir(db.dir); err != nil {
+		return err
+	}
+
+	compacted, err := os.Open(segmentPath(db.dir, target))
+	if err != nil {
+		return err
+	}
+
+	for _, id := range sealed {
+		db.files[id].Close()
+		delete(db.files, id)
+		delete(db.sizes, id)
+		delete(db.live, id)
+		delete(db.dead, id)
+	}
+	db.files[target] = compacted
+	db.sizes[target] = size
+
+	// Keys written or deleted since the snapshot keep their newer location
+	for key, loc := range moved {
+		if db.index[key] == snapshot[key] {
+			db.index[key] = loc
+			db.live[target] += int64(len(key)) + int64(loc.length)
+		}
+	}
+
+	// Older segments are superseded by the base segment; removing them is best effort
+	for _, id := range sealed[:len(sealed)-1] {
+		os.Remove(segmentPath(db.dir, id))
+	}
+	return nil
+}
+
+// writeCompacted copies the snapshot values into a new base segment at path
+func writeCompacted(path string, snapshot map[string]location, files map[uint32]*os.File, target uint32) (map[string]location, int64, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(encodeHeader(flagBase)); err != nil {
+		return nil, 0, err
+	}
+	off := int64(segmentHeaderSize)
+
+	keys := make([]string, 0, len(snapshot))
+	for key := range snapshot {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	moved := make(map[string]location, len(keys))
+	pending := make([]op, 0)
+	pendingSize := 0
+
+	flush := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		record, valueOffsets := encodeRecord(pending)
+		if _, err := f.WriteAt(record, off); err != nil {
+			return err
+		}
+		for i, o := range pending {
+			moved[o.key] = location{segment: target, offset: off + valueOffsets[i], length: uint32(len(o.value))}
+		}
+		off += int64(len(record))
+		pending = pending[:0]
+		pendingSize = 0
+		return nil
+	}
+
+	for _, key := range keys {
+		loc := snapshot[key]
+		value := make([]byte, loc.length)
+		if _, err := files[loc.segment].ReadAt(value, loc.offset); err != nil {
+			return nil, 0, err
+		}
+		pending = append(pending, op{kind: opPut, key: key, value: value})
+		pendingSize += len(key) + len(value)
+		if pendingSize >= compactionRecordSize {
+			if err := flush(); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return nil, 0, err
+	}
+
+	if err := f.Sync(); err != nil {
+		return nil, 0, err
+	}
+	return moved, off, nil
+}
+
+// compactionLoop periodically compacts when the garbage ratio exceeds the configured threshold
+func (db *FileDatabase) compactionLoop() {
+	defer db.wg.Done()
+
+	ticker := time.NewTicker(db.opts.CompactionInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-db.stop:
+			return
+		case <-ticker.C:
+			if db.GarbageRatio() >= db.opts.CompactionRatio {
+				db.Compact()
+			}
+		}
+	}
+}
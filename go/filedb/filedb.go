@@ -0,0 +1,666 @@
+// Package filedb provides a durable, dependency-free smt.Database backed by an
+// append-only segment log with an in-memory index.
+package filedb
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	smt "github.com/0xanonymeow/smt/go"
+)
+
+// SyncPolicy controls when writes are flushed to stable storage
+type SyncPolicy int
+
+const (
+	// SyncAlways fsyncs the log after every write or batch
+	SyncAlways SyncPolicy = iota
+	// SyncInterval fsyncs the log periodically in the background
+	SyncInterval
+	// SyncNever leaves flushing to the operating system
+	SyncNever
+)
+
+// Default option values
+const (
+	DefaultSyncInterval    = time.Second
+	DefaultMaxSegmentSize  = 64 << 20
+	DefaultCompactionRatio = 0.5
+)
+
+// ErrClosed is returned when using a database after Close
+var ErrClosed = errors.New("filedb: database is closed")
+
+// ErrReadOnly is returned when writing to a database opened with Options.ReadOnly
+var ErrReadOnly = errors.New("filedb: database is read-only")
+
+// Options configures a FileDatabase
+type Options struct {
+	SyncPolicy         SyncPolicy
+	SyncInterval       time.Duration // Flush period for SyncInterval
+	MaxSegmentSize     int64         // Size at which the active segment is sealed
+	CompactionInterval time.Duration // Background compaction check period; 0 disables it
+	CompactionRatio    float64       // Garbage ratio of sealed segments that triggers compaction
+
+	// ReadOnly opens an existing database without modifying it: nothing is
+	// truncated, deleted or created, writes return ErrReadOnly, and damaged
+	// records are reported by Damage instead of being repaired
+	ReadOnly bool
+}
+
+// DefaultOptions returns options that fsync every write and never compact in the background
+func DefaultOptions() *Options {
+	return &Options{
+		SyncPolicy:      SyncAlways,
+		SyncInterval:    DefaultSyncInterval,
+		MaxSegmentSize:  DefaultMaxSegmentSize,
+		CompactionRatio: DefaultCompactionRatio,
+	}
+}
+
+// Stats describes the on-disk state of a FileDatabase
+type Stats struct {
+	Keys       int   // Number of live keys
+	Segments   int   // Number of segment files
+	TotalBytes int64 // Size of all segment files
+	LiveBytes  int64 // Bytes of live keys and values
+}
+
+// Damage is a damaged part of the log found by a read-only open. Replay of the
+// segment stops at the damage, so the records after it are not loaded.
+type Damage struct {
+	Segment uint32 // Segment id
+	File    string // Segment file name within the database directory
+	Offset  int64  // Offset of the damaged header or record
+	Reason  string
+}
+
+func (d Damage) String() string {
+	return fmt.Sprintf("%s at offset %d: %s", d.File, d.Offset, d.Reason)
+}
+
+// FileDatabase is an append-only log database implementing smt.BatchDatabase
+type FileDatabase struct {
+	dir  string
+	opts Options
+
+	mu         sync.RWMutex
+	index      map[string]location
+	files      map[uint32]*os.File
+	sizes      map[uint32]int64 // total bytes per segment
+	live       map[uint32]int64 // live key and value bytes per segment
+	dead       map[uint32]int64 // bytes of overwritten values and tombstones per segment
+	active     uint32
+	activeSize int64
+	dirty      bool
+	closed     bool
+	damage     []Damage
+
+	compactMu sync.Mutex
+	stop      chan struct{}
+	wg        sync.WaitGroup
+}
+
+var _ smt.BatchDatabase = (*FileDatabase)(nil)
+
+// Open opens or creates a database in dir, replaying the segment log.
+// A torn record at the end of the newest segment is truncated, unless the
+// database is opened read-only.
+func Open(dir string, opts *Options) (*FileDatabase, error) {
+	if opts == nil {
+		opts = DefaultOptions()
+	}
+
+	db := &FileDatabase{
+		dir:   dir,
+		opts:  *opts,
+		index: make(map[string]location),
+		files: make(map[uint32]*os.File),
+		sizes: make(map[uint32]int64),
+		live:  make(map[uint32]int64),
+		dead:  make(map[uint32]int64),
+		stop:  make(chan struct{}),
+	}
+	if db.opts.SyncInterval <= 0 {
+		db.opts.SyncInterval = DefaultSyncInterval
+	}
+	if db.opts.MaxSegmentSize <= 0 {
+		db.opts.MaxSegmentSize = DefaultMaxSegmentSize
+	}
+	if db.opts.CompactionRatio <= 0 {
+		db.opts.CompactionRatio = DefaultCompactionRatio
+	}
+
+	if db.opts.ReadOnly {
+		if err := db.recover(); err != nil {
+			db.closeFiles()
+			return nil, err
+		}
+		return db, nil
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	// A leftover temporary file means compaction was interrupted before it took effect
+	if err := os.Remove(filepath.Join(dir, compactTmpName)); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if err := db.recover(); err != nil {
+		db.closeFiles()
+		return nil, err
+	}
+
+	if db.opts.SyncPolicy == SyncInterval {
+		db.wg.Add(1)
+		go db.syncLoop()
+	}
+	if db.opts.CompactionInterval > 0 {
+		db.wg.Add(1)
+		go db.compactionLoop()
+	}
+
+	return db, nil
+}
+
+// recover replays the segment log into the in-memory index
+func (db *FileDatabase) recover() error {
+	ids, err := listSegments(db.dir)
+	if err != nil {
+		return err
+	}
+
+	// Start from the newest base segment; everything before it is superseded
+	start := 0
+	for i, id := range ids {
+		f, err := os.Open(segmentPath(db.dir, id))
+		if err != nil {
+			return err
+		}
+		flags, err := readHeader(f)
+		f.Close()
+		if err != nil && i < len(ids)-1 && !db.opts.ReadOnly {
+			return fmt.Errorf("filedb: segment %d: %w", id, err)
+		}
+		if err == nil && flags&flagBase != 0 {
+			start = i
+		}
+	}
+	if !db.opts.ReadOnly {
+		for _, id := range ids[:start] {
+			if err := os.Remove(segmentPath(db.dir, id)); err != nil {
+				return err
+			}
+		}
+	}
+	ids = ids[start:]
+
+	for i, id := range ids {
+		last := i == len(ids)-1
+		if err := db.replaySegment(id, last); err != nil {
+			return err
+		}
+	}
+
+	if len(ids) == 0 {
+		if db.opts.ReadOnly {
+			return nil
+		}
+		return db.createSegment(1)
+	}
+
+	db.active = ids[len(ids)-1]
+	db.activeSize = db.sizes[db.active]
+	if db.opts.ReadOnly {
+		return nil
+	}
+	f, err := os.OpenFile(segmentPath(db.dir, db.active), os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	db.files[db.active].Close()
+	db.files[db.active] = f
+	return nil
+}
+
+// replaySegment applies the records of one segment to the index. Torn records
+// are truncated from the last segment and reported as corruption elsewhere; a
+// read-only database records them as damage instead.
+func (db *FileDatabase) replaySegment(id uint32, last bool) error {
+	path := segmentPath(db.dir, id)
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	db.files[id] = f
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+
+	if _, err := readHeader(f); err != nil {
+		if db.opts.ReadOnly {
+			return db.damaged(id, 0, fmt.Sprintf("invalid segment header: %v", err))
+		}
+		if !last {
+			return fmt.Errorf("filedb: segment %d: %w", id, err)
+		}
+		// The newest segment was created but its header never reached disk
+		return db.truncate(id, 0, true)
+	}
+
+	off := int64(segmentHeaderSize)
+	for {
+		ops, n, err := readRecord(f, off, size)
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, errTornRecord) {
+			if db.opts.ReadOnly {
+				return db.damaged(id, off, err.Error())
+			}
+			if !last {
+				return fmt.Errorf("filedb: corrupted record in segment %d at offset %d", id, off)
+			}
+			return db.truncate(id, off, false)
+		}
+		if err != nil {
+			return err
+		}
+		for _, o := range ops {
+			db.applyOp(o.kind, o.key, id, location{segment: id, offset: o.valueOffset, length: o.valueLength})
+		}
+		off += n
+	}
+
+	db.sizes[id] = size
+	return nil
+}
+
+// damaged records damage found while replaying a read-only segment, whose
+// replay stops at off
+func (db *FileDatabase) damaged(id uint32, off int64, reason string) error {
+	db.damage = append(db.damage, Damage{
+		Segment: id,
+		File:    filepath.Base(segmentPath(db.dir, id)),
+		Offset:  off,
+		Reason:  reason,
+	})
+	db.sizes[id] = off
+	return nil
+}
+
+// Damage returns the damaged records found when the database was opened
+// read-only. A read-write open repairs a torn tail and fails on other damage,
+// so it always returns nil.
+func (db *FileDatabase) Damage() []Damage {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return append([]Damage(nil), db.damage...)
+}
+
+// truncate cuts a segment back to off, rewriting the header if requested
+func (db *FileDatabase) truncate(id uint32, off int64, rewriteHeader bool) error {
+	f, err := os.OpenFile(segmentPath(db.dir, id), os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if rewriteHeader {
+		if err := f.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := f.WriteAt(encodeHeader(0), 0); err != nil {
+			return err
+		}
+		off = int64(segmentHeaderSize)
+	} else if err := f.Truncate(off); err != nil {
+		return err
+	}
+
+	db.sizes[id] = off
+	return f.Sync()
+}
+
+// applyOp updates the index and byte accounting for one operation in segment
+func (db *FileDatabase) applyOp(kind byte, key string, segment uint32, loc location) {
+	if old, exists := db.index[key]; exists {
+		db.live[old.segment] -= int64(len(key)) + int64(old.length)
+		db.dead[old.segment] += opSize(key, int64(old.length))
+		delete(db.index, key)
+	}
+	if kind == opPut {
+		db.index[key] = loc
+		db.live[loc.segment] += int64(len(key)) + int64(loc.length)
+	} else {
+		// Tombstones only matter until the segments before them are compacted
+		db.dead[segment] += opSize(key, -1)
+	}
+}
+
+// opSize returns the encoded size of an op; valueLen is negative for deletions
+func opSize(key string, valueLen int64) int64 {
+	size := 1 + int64(uvarintLen(uint64(len(key)))+len(key))
+	if valueLen >= 0 {
+		size += int64(uvarintLen(uint64(valueLen))) + valueLen
+	}
+	return size
+}
+
+// createSegment creates a new empty segment and makes it active
+func (db *FileDatabase) createSegment(id uint32) error {
+	f, err := os.OpenFile(segmentPath(db.dir, id), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(encodeHeader(0)); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := syncDir(db.dir); err != nil {
+		f.Close()
+		return err
+	}
+
+	db.files[id] = f
+	db.sizes[id] = int64(segmentHeaderSize)
+	db.active = id
+	db.activeSize = int64(segmentHeaderSize)
+	return nil
+}
+
+// rotateLocked seals the active segment and starts a new one; the caller must hold db.mu
+func (db *FileDatabase) rotateLocked() error {
+	if db.dirty {
+		if err := db.files[db.active].Sync(); err != nil {
+			return err
+		}
+		db.dirty = false
+	}
+	return db.createSegment(db.active + 1)
+}
+
+// write appends ops as a single record and applies them to the index
+func (db *FileDatabase) write(ops []op) error {
+	if len(ops) == 0 {
+		return nil
+	}
+
+	record, valueOffsets := encodeRecord(ops)
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+	if db.opts.ReadOnly {
+		return ErrReadOnly
+	}
+
+	if db.activeSize > int64(segmentHeaderSize) && db.activeSize+int64(len(record)) > db.opts.MaxSegmentSize {
+		if err := db.rotateLocked(); err != nil {
+			return err
+		}
+	}
+
+	f := db.files[db.active]
+	off := db.activeSize
+	if _, err := f.WriteAt(record, off); err != nil {
+		// Drop any partial record so the log stays well formed
+		f.Truncate(off)
+		return err
+	}
+	db.activeSize += int64(len(record))
+	db.sizes[db.active] = db.activeSize
+
+	if db.opts.SyncPolicy == SyncAlways {
+		if err := f.Sync(); err != nil {
+			return err
+		}
+	} else {
+		db.dirty = true
+	}
+
+	for i, o := range ops {
+		db.applyOp(o.kind, o.key, db.active, location{
+			segment: db.active,
+			offset:  off + valueOffsets[i],
+			length:  uint32(len(o.value)),
+		})
+	}
+	return nil
+}
+
+// Get retrieves a value by key, returning nil if it does not exist
+func (db *FileDatabase) Get(key []byte) ([]byte, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed {
+		return nil, ErrClosed
+	}
+
+	loc, exists := db.index[string(key)]
+	if !exists {
+		return nil, nil
+	}
+
+	value := make([]byte, loc.length)
+	if _, err := db.files[loc.segment].ReadAt(value, loc.offset); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// IteratePrefix calls fn for every key with the given prefix in ascending key order.
+// Matching values are read up front, so fn may modify the database.
+func (db *FileDatabase) IteratePrefix(prefix []byte, fn func(key, value []byte) bool) error {
+	db.mu.RLock()
+	if db.closed {
+		db.mu.RUnlock()
+		return ErrClosed
+	}
+
+	keys := make([]string, 0)
+	for key := range db.index {
+		if strings.HasPrefix(key, string(prefix)) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		loc := db.index[key]
+		values[i] = make([]byte, loc.length)
+		if _, err := db.files[loc.segment].ReadAt(values[i], loc.offset); err != nil {
+			db.mu.RUnlock()
+			return err
+		}
+	}
+	db.mu.RUnlock()
+
+	for i, key := range keys {
+		if !fn([]byte(key), values[i]) {
+			break
+		}
+	}
+	return nil
+}
+
+// Set stores a key-value pair
+func (db *FileDatabase) Set(key []byte, value []byte) error {
+	return db.write([]op{{kind: opPut, key: string(key), value: value}})
+}
+
+// Delete removes a key-value pair
+func (db *FileDatabase) Delete(key []byte) error {
+	db.mu.RLock()
+	_, exists := db.index[string(key)]
+	db.mu.RUnlock()
+
+	if !exists {
+		return nil
+	}
+	return db.write([]op{{kind: opDelete, key: string(key)}})
+}
+
+// Has checks if a key exists
+func (db *FileDatabase) Has(key []byte) (bool, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed {
+		return false, ErrClosed
+	}
+
+	_, exists := db.index[string(key)]
+	return exists, nil
+}
+
+// NewBatch creates a write batch that is appended to the log as a single record
+func (db *FileDatabase) NewBatch() smt.Batch {
+	return &fileBatch{db: db}
+}
+
+// fileBatch buffers writes for a FileDatabase
+type fileBatch struct {
+	db  *FileDatabase
+	ops []op
+}
+
+// Put buffers a key-value write
+func (b *fileBatch) Put(key []byte, value []byte) error {
+	stored := make([]byte, len(value))
+	copy(stored, value)
+	b.ops = append(b.ops, op{kind: opPut, key: string(key), value: stored})
+	return nil
+}
+
+// Delete buffers a key deletion
+func (b *fileBatch) Delete(key []byte) error {
+	b.ops = append(b.ops, op{kind: opDelete, key: string(key)})
+	return nil
+}
+
+// Write appends all buffered writes atomically
+func (b *fileBatch) Write() error {
+	err := b.db.write(b.ops)
+	b.ops = nil
+	return err
+}
+
+// Sync flushes the active segment to stable storage
+func (db *FileDatabase) Sync() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+	return db.syncLocked()
+}
+
+// syncLocked fsyncs the active segment if it has unsynced writes
+func (db *FileDatabase) syncLocked() error {
+	if !db.dirty {
+		return nil
+	}
+	if err := db.files[db.active].Sync(); err != nil {
+		return err
+	}
+	db.dirty = false
+	return nil
+}
+
+// Stats returns the current size and key counts
+func (db *FileDatabase) Stats() Stats {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	stats := Stats{Keys: len(db.index), Segments: len(db.sizes)}
+	for id, size := range db.sizes {
+		stats.TotalBytes += size
+		stats.LiveBytes += db.live[id]
+	}
+	return stats
+}
+
+// Close stops background work, flushes pending writes and closes all segments
+func (db *FileDatabase) Close() error {
+	db.mu.Lock()
+	if db.closed {
+		db.mu.Unlock()
+		return nil
+	}
+	db.closed = true
+	err := db.syncLocked()
+	db.mu.Unlock()
+
+	close(db.stop)
+	db.wg.Wait()
+
+	// Wait for an in-flight compaction before releasing its files
+	db.compactMu.Lock()
+	defer db.compactMu.Unlock()
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.closeFiles()
+	return err
+}
+
+// closeFiles closes every open segment file
+func (db *FileDatabase) closeFiles() {
+	for id, f := range db.files {
+		f.Close()
+		delete(db.files, id)
+	}
+}
+
+// syncLoop periodically flushes writes under the SyncInterval policy
+func (db *FileDatabase) syncLoop() {
+	defer db.wg.Done()
+
+	ticker := time.NewTicker(db.opts.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-db.stop:
+			return
+		case <-ticker.C:
+			db.mu.Lock()
+			if !db.closed {
+				db.syncLocked()
+			}
+			db.mu.Unlock()
+		}
+	}
+}
+
+// syncDir fsyncs a directory so that file creations and renames are durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,252 @@
+package filedb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Segment file layout:
+//
+//	header:  magic(8) || flags(1)
+//	records: crc32c(4) || payloadLen(4) || payload
+//	payload: opCount(uvarint) || ops...
+//	op:      kind(1) || keyLen(uvarint) || key [|| valueLen(uvarint) || value]
+//
+// Every record is written with a single write call and carries a checksum, so
+// a record, and therefore a whole batch, is either replayed completely or not at all.
+const (
+	segmentMagic      = "SMTLOG01"
+	segmentHeaderSize = len(segmentMagic) + 1
+	recordHeaderSize  = 8
+	segmentExt        = ".log"
+	compactTmpName    = "compact.tmp"
+
+	// flagBase marks a segment produced by compaction: it holds the complete
+	// live state of every lower-numbered segment, which are ignored on replay
+	flagBase byte = 1 << 0
+)
+
+// Operation kinds stored in a record
+const (
+	opPut    byte = 1
+	opDelete byte = 2
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// errTornRecord reports an incomplete or corrupted record at the end of a segment
+var errTornRecord = errors.New("torn record")
+
+// op is a single write inside a record
+type op struct {
+	kind  byte
+	key   string
+	value []byte
+}
+
+// location identifies where a value is stored on disk
+type location struct {
+	segment uint32
+	offset  int64
+	length  uint32
+}
+
+// segmentPath returns the file name of a segment
+func segmentPath(dir string, id uint32) string {
+	return filepath.Join(dir, fmt.Sprintf("%08d%s", id, segmentExt))
+}
+
+// listSegments returns the ids of the segment files in dir in ascending order
+func listSegments(dir string) ([]uint32, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint32, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 32)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, uint32(id))
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+// uvarintLen returns the encoded length of v as a uvarint
+func uvarintLen(v uint64) int {
+	n := 1
+	for v >= 0x80 {
+		v >>= 7
+		n++
+	}
+	return n
+}
+
+// encodeHeader returns a segment header with the given flags
+func encodeHeader(flags byte) []byte {
+	header := make([]byte, segmentHeaderSize)
+	copy(header, segmentMagic)
+	header[len(segmentMagic)] = flags
+	return header
+}
+
+// readHeader validates a segment header and returns its flags
+func readHeader(f *os.File) (byte, error) {
+	header := make([]byte, segmentHeaderSize)
+	if _, err := f.ReadAt(header, 0); err != nil {
+		return 0, err
+	}
+	if string(header[:len(segmentMagic)]) != segmentMagic {
+		return 0, fmt.Errorf("invalid segment magic in %s", f.Name())
+	}
+	return header[len(segmentMagic)], nil
+}
+
+// encodeRecord serializes ops into a record. It also returns, for every put,
+// the offset of its value relative to the start of the record.
+func encodeRecord(ops []op) ([]byte, []int64) {
+	size := binary.MaxVarintLen64
+	for _, o := range ops {
+		size += 1 + 2*binary.MaxVarintLen64 + len(o.key) + len(o.value)
+	}
+
+	payload := make([]byte, 0, size)
+	payload = binary.AppendUvarint(payload, uint64(len(ops)))
+
+	valueOffsets := make([]int64, len(ops))
+	for i, o := range ops {
+		payload = append(payload, o.kind)
+		payload = binary.AppendUvarint(payload, uint64(len(o.key)))
+		payload = append(payload, o.key...)
+		if o.kind == opPut {
+			payload = binary.AppendUvarint(payload, uint64(len(o.value)))
+			valueOffsets[i] = int64(recordHeaderSize + len(payload))
+			payload = append(payload, o.value...)
+		}
+	}
+
+	record := make([]byte, recordHeaderSize, recordHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(record[0:4], crc32.Checksum(payload, crcTable))
+	binary.LittleEndian.PutUint32(record[4:8], uint32(len(payload)))
+	return append(record, payload...), valueOffsets
+}
+
+// decodedOp is an op read back from disk together with its value location
+type decodedOp struct {
+	kind        byte
+	key         string
+	valueOffset int64
+	valueLength uint32
+}
+
+// readRecord reads the record at off. It returns errTornRecord if the record
+// is incomplete or fails its checksum, and io.EOF at a clean end of segment.
+func readRecord(f *os.File, off int64, fileSize int64) ([]decodedOp, int64, error) {
+	if off == fileSize {
+		return nil, 0, io.EOF
+	}
+	if fileSize-off < recordHeaderSize {
+		return nil, 0, fmt.Errorf("%w: record extends past the end of the segment", errTornRecord)
+	}
+
+	header := make([]byte, recordHeaderSize)
+	if _, err := f.ReadAt(header, off); err != nil {
+		return nil, 0, err
+	}
+	checksum := binary.LittleEndian.Uint32(header[0:4])
+	length := int64(binary.LittleEndian.Uint32(header[4:8]))
+	if fileSize-off-recordHeaderSize < length {
+		return nil, 0, fmt.Errorf("%w: record extends past the end of the segment", errTornRecord)
+	}
+
+	payload := make([]byte, length)
+	if _, err := f.ReadAt(payload, off+recordHeaderSize); err != nil {
+		return nil, 0, err
+	}
+	if crc32.Checksum(payload, crcTable) != checksum {
+		return nil, 0, fmt.Errorf("%w: checksum mismatch", errTornRecord)
+	}
+
+	ops, err := decodePayload(payload, off)
+	if errors.Is(err, errTornRecord) {
+		return nil, 0, fmt.Errorf("%w: malformed payload", errTornRecord)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return ops, recordHeaderSize + length, nil
+}
+
+// decodePayload parses the ops of a record that starts at recordOff
+func decodePayload(payload []byte, recordOff int64) ([]decodedOp, error) {
+	pos := 0
+	readUvarint := func() (uint64, error) {
+		v, n := binary.Uvarint(payload[pos:])
+		if n <= 0 {
+			return 0, errTornRecord
+		}
+		pos += n
+		return v, nil
+	}
+
+	count, err := readUvarint()
+	if err != nil {
+		return nil, err
+	}
+
+	ops := make([]decodedOp, 0, count)
+	for i := uint64(0); i < count; i++ {
+		if pos >= len(payload) {
+			return nil, errTornRecord
+		}
+		kind := payload[pos]
+		pos++
+
+		keyLen, err := readUvarint()
+		if err != nil {
+			return nil, err
+		}
+		if uint64(len(payload)-pos) < keyLen {
+			return nil, errTornRecord
+		}
+		d := decodedOp{kind: kind, key: string(payload[pos : pos+int(keyLen)])}
+		pos += int(keyLen)
+
+		switch kind {
+		case opPut:
+			valueLen, err := readUvarint()
+			if err != nil {
+				return nil, err
+			}
+			if uint64(len(payload)-pos) < valueLen {
+				return nil, errTornRecord
+			}
+			d.valueOffset = recordOff + recordHeaderSize + int64(pos)
+			d.valueLength = uint32(valueLen)
+			pos += int(valueLen)
+		case opDelete:
+		default:
+			return nil, fmt.Errorf("unknown record op kind: %d", kind)
+		}
+
+		ops = append(ops, d)
+	}
+
+	return ops, nil
+}
@@ -1,6 +1,7 @@
 package smt
 
 import (
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"math/big"
@@ -8,6 +9,86 @@ import (
 	"github.com/ethereum/go-ethereum/crypto"
 )
 
+// Names of the built-in hash suites
+const (
+	Keccak256SuiteName = "keccak256"
+	SHA256SuiteName    = "sha256"
+)
+
+// LeafHashFunction computes the hash of a leaf from its index and value
+type LeafHashFunction func(index *big.Int, value Bytes32) Bytes32
+
+// HashSuite is the pair of hash functions a tree is built with. The name is
+// stored with trees built with a non-default suite so they are reopened with it.
+type HashSuite struct {
+	Name string
+	Node HashFunction     // Hashes the two 32-byte children of a node
+	Leaf LeafHashFunction // Hashes a leaf's index and value
+}
+
+// Keccak256HashSuite returns the default suite, matching the Solidity contracts
+func Keccak256HashSuite() *HashSuite {
+	return &HashSuite{
+		Name: Keccak256SuiteName,
+		Node: Hash,
+		Leaf: ComputeLeafHash,
+	}
+}
+
+// SHA256HashSuite returns a suite using SHA-256 for nodes and leaves, with the
+// same encodings as the Keccak256 suite
+func SHA256HashSuite() *HashSuite {
+	return &HashSuite{
+		Name: SHA256SuiteName,
+		Node: func(left, right []byte) []byte {
+			sum := sha256.Sum256(append(append([]byte{}, left...), right...))
+			return sum[:]
+		},
+		Leaf: func(index *big.Int, value Bytes32) Bytes32 {
+			return Bytes32(sha256.Sum256(leafPreimage(index, value)))
+		},
+	}
+}
+
+// HashSuiteByName returns the built-in suite with the given name, or nil
+func HashSuiteByName(name string) *HashSuite {
+	switch name {
+	case Keccak256SuiteName:
+		return Keccak256HashSuite()
+	case SHA256SuiteName:
+		return SHA256HashSuite()
+	}
+	return nil
+}
+
+// resolveHashSuite returns the suite to use for an optional suite argument
+func resolveHashSuite(suites []*HashSuite) *HashSuite {
+	if len(suites) == 0 || suites[0] == nil {
+		return Keccak256HashSuite()
+	}
+	return suites[0]
+}
+
+// validate checks that every function of the suite is set
+func (s *HashSuite) validate() error {
+	if s.Name == "" || s.Node == nil || s.Leaf == nil {
+		return ErrInvalidHashSuite
+	}
+	return nil
+}
+
+// HashNode hashes the children of a node
+func (s *HashSuite) HashNode(left, right Bytes32) Bytes32 {
+	var b32 Bytes32
+	copy(b32[:], s.Node(left[:], right[:]))
+	return b32
+}
+
+// HashLeaf hashes a leaf's index and value
+func (s *HashSuite) HashLeaf(index *big.Int, value Bytes32) Bytes32 {
+	return s.Leaf(index, value)
+}
+
 // Hash computes Keccak256 hash of two 32-byte values
 func Hash(left, right []byte) []byte {
 	data := append(left, right...)
@@ -22,8 +103,16 @@ func HashBytes32(left, right Bytes32) Bytes32 {
 	return b32
 }
 
-// ComputeLeafHash computes the hash for a leaf node
+// ComputeLeafHash computes the Keccak256 hash for a leaf node
 func ComputeLeafHash(index *big.Int, value Bytes32) Bytes32 {
+	result := crypto.Keccak256(leafPreimage(index, value))
+	var b32 Bytes32
+	copy(b32[:], result)
+	return b32
+}
+
+// leafPreimage encodes a leaf for hashing as index(32) || value(32) || 1
+func leafPreimage(index *big.Int, value Bytes32) []byte {
 	indexBytes := index.Bytes()
 	if len(indexBytes) == 0 {
 		indexBytes = []byte{0}
@@ -38,11 +127,7 @@ func ComputeLeafHash(index *big.Int, value Bytes32) Bytes32 {
 	copy(data[0:32], paddedIndex)
 	copy(data[32:64], value[:])
 	data[64] = 1
-	
-	result := crypto.Keccak256(data)
-	var b32 Bytes32
-	copy(b32[:], result)
-	return b32
+	return data
 }
 
 // GetBit extracts a bit at given position from a big.Int
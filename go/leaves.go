@@ -0,0 +1,84 @@
+package smt
+
+import (
+	"math/big"
+)
+
+// ForEachLeaf calls fn for every leaf in ascending index order, stopping early
+// when fn returns false. fn runs under the tree's read lock and must not modify the tree.
+func (smt *SparseMerkleTree) ForEachLeaf(fn func(index *big.Int, value Bytes32) bool) error {
+	smt.mu.RLock()
+	defer smt.mu.RUnlock()
+
+	_, err := smt.walkLeaves(smt.root, 0, big.NewInt(0), nil, nil, fn)
+	return err
+}
+
+// Leaves returns the leaves with start <= index < end in ascending index order.
+// A nil start or end leaves that side of the range unbounded.
+func (smt *SparseMerkleTree) Leaves(start, end *big.Int) ([]LeafData, error) {
+	smt.mu.RLock()
+	defer smt.mu.RUnlock()
+
+	leaves := make([]LeafData, 0)
+	_, err := smt.walkLeaves(smt.root, 0, big.NewInt(0), start, end, func(index *big.Int, value Bytes32) bool {
+		leaves = append(leaves, LeafData{Index: index, Value: value})
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return leaves, nil
+}
+
+// walkLeaves visits the leaves below current, the node at the given level whose
+// path from the root spells prefix. Subtrees outside [start, end) are skipped.
+// It returns false once fn has asked to stop.
+func (smt *SparseMerkleTree) walkLeaves(current Bytes32, level uint16, prefix *big.Int, start, end *big.Int, fn func(*big.Int, Bytes32) bool) (bool, error) {
+	if current.IsZero() {
+		return true, nil
+	}
+
+	// Indices covered by this subtree: [first, first + 2^(depth-level))
+	height := uint(smt.depth - level)
+	first := new(big.Int).Lsh(prefix, height)
+	if end != nil && first.Cmp(end) >= 0 {
+		return true, nil
+	}
+	if start != nil {
+		last := new(big.Int).Add(first, new(big.Int).Lsh(big.NewInt(1), height))
+		if last.Cmp(start) <= 0 {
+			return true, nil
+		}
+	}
+
+	if level < smt.depth {
+		node, err := smt.getNode(current)
+		if err != nil { // coverage-ignore
+			return false, err
+		}
+		if !node.IsEmpty() {
+			left := new(big.Int).Lsh(prefix, 1)
+			right := new(big.Int).Add(left, big.NewInt(1))
+			if ok, err := smt.walkLeaves(node.Left, level+1, left, start, end, fn); !ok || err != nil {
+				return ok, err
+			}
+			return smt.walkLeaves(node.Right, level+1, right, start, end, fn)
+		}
+	}
+
+	leaf, err := smt.getLeaf(current)
+	if err != nil { // coverage-ignore
+		return false, err
+	}
+	if leaf == nil { // coverage-ignore
+		return true, nil
+	}
+	if start != nil && leaf.Index.Cmp(start) < 0 {
+		return true, nil
+	}
+	if end != nil && leaf.Index.Cmp(end) >= 0 {
+		return true, nil
+	}
+	return fn(new(big.Int).Set(leaf.Index), leaf.Value), nil
+}
@@ -0,0 +1,251 @@
+package smt
+
+import (
+	"math/big"
+	"sort"
+)
+
+// GetMultiProof returns one proof for the leaves at indices. Duplicate indices
+// are proven once and the proof lists them in ascending order. Compact trees
+// return ErrCompactLayout.
+func (smt *SparseMerkleTree) GetMultiProof(indices []*big.Int) (*MultiProof, error) {
+	smt.mu.RLock()
+	defer smt.mu.RUnlock()
+
+	return smt.multiProofAt(smt.root, indices)
+}
+
+// multiProofAt builds the multi-proof for indices against an arbitrary stored root
+func (smt *SparseMerkleTree) multiProofAt(root Bytes32, indices []*big.Int) (*MultiProof, error) {
+	if smt.options.Compact {
+		return nil, ErrCompactLayout
+	}
+	if len(indices) == 0 {
+		return nil, ErrNoIndices
+	}
+	for _, index := range indices {
+		if err := smt.validateIndex(index); err != nil {
+			return nil, err
+		}
+	}
+
+	sorted := make([]*big.Int, len(indices))
+	copy(sorted, indices)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Cmp(sorted[j]) < 0
+	})
+	distinct := sorted[:1]
+	for _, index := range sorted[1:] {
+		if index.Cmp(distinct[len(distinct)-1]) != 0 {
+			distinct = append(distinct, index)
+		}
+	}
+
+	mp := &MultiProof{
+		Indices:  distinct,
+		Exists:   make([]bool, len(distinct)),
+		Values:   make([]Bytes32, len(distinct)),
+		Enables:  big.NewInt(0),
+		Siblings: make([]Bytes32, 0),
+	}
+	b := &multiProofBuilder{smt: smt, mp: mp, slots: &siblingSlots{enables: mp.Enables, siblings: mp.Siblings}}
+	if err := b.subtree(root, smt.depth, 0, len(distinct)); err != nil {
+		return nil, err
+	}
+	mp.Siblings = b.slots.siblings
+	return mp, nil
+}
+
+// multiProofBuilder collects the leaves and siblings of a multi-proof
+type multiProofBuilder struct {
+	smt   *SparseMerkleTree
+	mp    *MultiProof
+	slots *siblingSlots
+}
+
+// subtree adds the subtree of the given height at hash, holding the paths of
+// mp.Indices[lo:hi], to the proof
+func (b *multiProofBuilder) subtree(hash Bytes32, height uint16, lo, hi int) error {
+	if height == 0 {
+		if hash.IsZero() {
+			return nil
+		}
+		leaf, err := b.smt.getLeaf(hash)
+		if err != nil { // coverage-ignore
+			return err
+		}
+		if leaf != nil && leaf.Index.Cmp(b.mp.Indices[lo]) == 0 {
+			b.mp.Exists[lo] = true
+			b.mp.Values[lo] = leaf.Value
+		}
+		return nil
+	}
+
+	var node Node
+	if !hash.IsZero() {
+		stored, err := b.smt.getNode(hash)
+		if err != nil { // coverage-ignore
+			return err
+		}
+		node = *stored
+	}
+
+	split := splitIndices(b.mp.Indices, height, lo, hi)
+	switch {
+	case split == hi:
+		if err := b.subtree(node.Left, height-1, lo, hi); err != nil {
+			return err
+		}
+		b.slots.write(node.Right)
+	case split == lo:
+		if err := b.subtree(node.Right, height-1, lo, hi); err != nil {
+			return err
+		}
+		b.slots.write(node.Left)
+	default:
+		if err := b.subtree(node.Left, height-1, lo, split); err != nil {
+			return err
+		}
+		return b.subtree(node.Right, height-1, split, hi)
+	}
+	return nil
+}
+
+// splitIndices returns the first of the ascending indices[lo:hi], which share
+// the path to a node of the given height, that lies under its right child
+func splitIndices(indices []*big.Int, height uint16, lo, hi int) int {
+	bit := uint(height - 1)
+	return lo + sort.Search(hi-lo, func(i int) bool {
+		return GetBit(indices[lo+i], bit) == 1
+	})
+}
+
+// ComputeRootFromMultiProof computes the root hash from a multi-proof using
+// the optional hash suite, Keccak256 by default. It returns zero if the proof
+// is malformed.
+func ComputeRootFromMultiProof(depth uint16, mp *MultiProof, suite ...*HashSuite) Bytes32 {
+	root, _ := computeMultiProofRoot(depth, mp, resolveHashSuite(suite))
+	return root
+}
+
+// VerifyMultiProof verifies a multi-proof against root
+func VerifyMultiProof(root Bytes32, depth uint16, mp *MultiProof, suite ...*HashSuite) bool {
+	computed, ok := computeMultiProofRoot(depth, mp, resolveHashSuite(suite))
+	return ok && computed == root
+}
+
+// VerifyMultiProof verifies a multi-proof against the tree's current root
+func (smt *SparseMerkleTree) VerifyMultiProof(mp *MultiProof) bool {
+	smt.mu.RLock()
+	defer smt.mu.RUnlock()
+
+	return !smt.options.Compact && VerifyMultiProof(smt.root, smt.depth, mp, smt.hasher)
+}
+
+// computeMultiProofRoot computes the root a multi-proof implies, or false if
+// the proof is malformed: indices out of order or range, mismatched lengths,
+// or siblings left over or missing
+func computeMultiProofRoot(depth uint16, mp *MultiProof, hasher *HashSuite) (Bytes32, bool) {
+	if mp == nil || len(mp.Indices) == 0 || len(mp.Exists) != len(mp.Indices) || len(mp.Values) != len(mp.Indices) {
+		return Bytes32{}, false
+	}
+	limit := new(big.Int).Lsh(ONE, uint(depth))
+	for i, index := range mp.Indices {
+		if index == nil || index.Sign() < 0 || index.Cmp(limit) >= 0 {
+			return Bytes32{}, false
+		}
+		if i > 0 && index.Cmp(mp.Indices[i-1]) <= 0 {
+			return Bytes32{}, false
+		}
+	}
+
+	r := &multiProofReader{mp: mp, hasher: hasher, slots: &siblingSlots{enables: mp.Enables, siblings: mp.Siblings}}
+	root := r.subtree(depth, 0, len(mp.Indices))
+	if !r.slots.done() {
+		return Bytes32{}, false
+	}
+	return root, true
+}
+
+// multiProofReader recomputes the root of a multi-proof
+type multiProofReader struct {
+	mp     *MultiProof
+	hasher *HashSuite
+	slots  *siblingSlots
+}
+
+// subtree returns the hash of the subtree of the given height holding the
+// paths of mp.Indices[lo:hi]
+func (r *multiProofReader) subtree(height uint16, lo, hi int) Bytes32 {
+	if height == 0 {
+		if !r.mp.Exists[lo] {
+			return Bytes32{}
+		}
+		return r.hasher.HashLeaf(r.mp.Indices[lo], r.mp.Values[lo])
+	}
+
+	split := splitIndices(r.mp.Indices, height, lo, hi)
+	switch {
+	case split == hi:
+		left := r.subtree(height-1, lo, hi)
+		return hashChildren(r.hasher, left, r.slots.read())
+	case split == lo:
+		right := r.subtree(height-1, lo, hi)
+		return hashChildren(r.hasher, r.slots.read(), right)
+	default:
+		left := r.subtree(height-1, lo, split)
+		return hashChildren(r.hasher, left, r.subtree(height-1, split, hi))
+	}
+}
+
+// siblingSlots is the sequence of sibling slots of a multi-index proof: bit i
+// of enables is set when slot i holds a non-zero sibling, and siblings lists
+// those in slot order
+type siblingSlots struct {
+	enables  *big.Int
+	siblings []Bytes32
+	slot     int  // Slots visited so far
+	next     int  // Siblings read so far
+	failed   bool // A slot was enabled past the last sibling
+}
+
+// write fills the next slot
+func (s *siblingSlots) write(hash Bytes32) {
+	if !hash.IsZero() {
+		s.siblings = append(s.siblings, hash)
+		s.enables.SetBit(s.enables, s.slot, 1)
+	}
+	s.slot++
+}
+
+// read returns the sibling in the next slot
+func (s *siblingSlots) read() Bytes32 {
+	slot := s.slot
+	s.slot++
+	if s.enables == nil || s.enables.Bit(slot) == 0 {
+		return Bytes32{}
+	}
+	if s.next >= len(s.siblings) {
+		s.failed = true
+		return Bytes32{}
+	}
+	sibling := s.siblings[s.next]
+	s.next++
+	return sibling
+}
+
+// done reports whether every sibling and enabled slot was read
+func (s *siblingSlots) done() bool {
+	if s.failed || s.next != len(s.siblings) {
+		return false
+	}
+	return s.enables == nil || s.enables.BitLen() <= s.slot
+}
+
+// hashChildren hashes a node's children; a node with two empty children is empty
+func hashChildren(hasher *HashSuite, left, right Bytes32) Bytes32 {
+	if left.IsZero() && right.IsZero() {
+		return Bytes32{}
+	}
+	return hasher.HashNode(left, right)
+}
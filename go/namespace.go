@@ -0,0 +1,136 @@
+package smt
+
+import (
+	"bytes"
+)
+
+// NamespaceSeparator ends the prefix a NamespacedDatabase adds to every key
+const NamespaceSeparator = "/"
+
+// NamespacedDatabase prefixes every key with a namespace so that several trees
+// can share one database without seeing each other's records. Node and leaf
+// records are content-addressed and reference counted, so trees must never
+// share them; a namespace keeps their counts apart.
+type NamespacedDatabase struct {
+	db     Database
+	prefix []byte
+}
+
+var (
+	_ BatchDatabase    = (*NamespacedDatabase)(nil)
+	_ IterableDatabase = (*NamespacedDatabase)(nil)
+)
+
+// NewNamespacedDatabase wraps db, storing every key under namespace
+func NewNamespacedDatabase(db Database, namespace string) (*NamespacedDatabase, error) {
+	if db == nil {
+		return nil, ErrNilDatabase
+	}
+	if namespace == "" {
+		return nil, ErrInvalidNamespace
+	}
+	return &NamespacedDatabase{
+		db:     db,
+		prefix: []byte(namespace + NamespaceSeparator),
+	}, nil
+}
+
+// Unwrap returns the shared database
+func (db *NamespacedDatabase) Unwrap() Database {
+	return db.db
+}
+
+// key returns the shared database key for a namespaced key
+func (db *NamespacedDatabase) key(key []byte) []byte {
+	k := make([]byte, 0, len(db.prefix)+len(key))
+	k = append(k, db.prefix...)
+	return append(k, key...)
+}
+
+// Get retrieves a value by key
+func (db *NamespacedDatabase) Get(key []byte) ([]byte, error) {
+	return db.db.Get(db.key(key))
+}
+
+// Set stores a key-value pair
+func (db *NamespacedDatabase) Set(key []byte, value []byte) error {
+	return db.db.Set(db.key(key), value)
+}
+
+// Delete removes a key-value pair
+func (db *NamespacedDatabase) Delete(key []byte) error {
+	return db.db.Delete(db.key(key))
+}
+
+// Has checks if a key exists
+func (db *NamespacedDatabase) Has(key []byte) (bool, error) {
+	return db.db.Has(db.key(key))
+}
+
+// IteratePrefix scans the namespace, passing keys without the namespace. The
+// shared database must implement IterableDatabase.
+func (db *NamespacedDatabase) IteratePrefix(prefix []byte, fn func(key, value []byte) bool) error {
+	idb, ok := db.db.(IterableDatabase)
+	if !ok {
+		return ErrNotIterable
+	}
+	return idb.IteratePrefix(db.key(prefix), func(key, value []byte) bool {
+		return fn(bytes.TrimPrefix(key, db.prefix), value)
+	})
+}
+
+// NewBatch creates a write batch. It uses a batch of the shared database when
+// supported and applies writes one at a time otherwise.
+func (db *NamespacedDatabase) NewBatch() Batch {
+	if bdb, ok := db.db.(BatchDatabase); ok {
+		return &namespacedBatch{db: db, batch: bdb.NewBatch()}
+	}
+	return &namespacedBatch{db: db}
+}
+
+// namespacedBatch prefixes the keys of a batch
+type namespacedBatch struct {
+	db    *NamespacedDatabase
+	batch Batch     // nil when the shared database has no batch support
+	ops   []batchOp // buffered writes when batch is nil
+}
+
+// Put buffers a key-value write
+func (b *namespacedBatch) Put(key []byte, value []byte) error {
+	if b.batch != nil {
+		return b.batch.Put(b.db.key(key), value)
+	}
+	b.ops = append(b.ops, batchOp{key: string(key), value: append([]byte{}, value...)})
+	return nil
+}
+
+// Delete buffers a key deletion
+func (b *namespacedBatch) Delete(key []byte) error {
+	if b.batch != nil {
+		return b.batch.Delete(b.db.key(key))
+	}
+	b.ops = append(b.ops, batchOp{key: string(key), value: nil})
+	return nil
+}
+
+// Write applies the buffered writes
+func (b *namespacedBatch) Write() error {
+	if b.batch != nil {
+		return b.batch.Write()
+	}
+
+	ops := b.ops
+	b.ops = nil
+	for _, op := range ops {
+		var err error
+		if op.value == nil {
+			err = b.db.Delete([]byte(op.key))
+		} else {
+			err = b.db.Set([]byte(op.key), op.value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
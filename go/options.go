@@ -0,0 +1,267 @@
+package smt
+
+import (
+	"crypto/sha256"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Names of the built-in key derivation schemes
+const (
+	Keccak256KeyDerivationName = "keccak256"
+	SHA256KeyDerivationName    = "sha256"
+)
+
+// KeyDerivation maps the keys of the KV API to tree indices. The derived bytes
+// are read as a big-endian integer and reduced modulo 2^depth.
+type KeyDerivation struct {
+	Name   string
+	Derive func(key []byte) []byte
+}
+
+// Keccak256KeyDerivation returns the default scheme, indexing a key by its Keccak256 hash
+func Keccak256KeyDerivation() *KeyDerivation {
+	return &KeyDerivation{
+		Name: Keccak256KeyDerivationName,
+		Derive: func(key []byte) []byte {
+			return crypto.Keccak256(key)
+		},
+	}
+}
+
+// SHA256KeyDerivation returns a scheme indexing a key by its SHA-256 hash
+func SHA256KeyDerivation() *KeyDerivation {
+	return &KeyDerivation{
+		Name: SHA256KeyDerivationName,
+		Derive: func(key []byte) []byte {
+			sum := sha256.Sum256(key)
+			return sum[:]
+		},
+	}
+}
+
+// KeyDerivationByName returns the built-in scheme with the given name, or nil
+func KeyDerivationByName(name string) *KeyDerivation {
+	switch name {
+	case Keccak256KeyDerivationName:
+		return Keccak256KeyDerivation()
+	case SHA256KeyDerivationName:
+		return SHA256KeyDerivation()
+	}
+	return nil
+}
+
+// TreeOptions configures a tree. Options other than the depth and namespace
+// that differ from their defaults are stored with the tree, and reopening it
+// with different ones fails.
+type TreeOptions struct {
+	Depth uint16
+	// HashSuite hashes nodes and leaves; nil selects Keccak256
+	HashSuite *HashSuite
+	// DomainTag is mixed into every leaf hash so proofs from trees with another
+	// tag do not verify; empty disables domain separation
+	DomainTag string
+	// KeyDerivation maps KV keys to indices; nil selects Keccak256
+	KeyDerivation *KeyDerivation
+	// Namespace prefixes every key the tree stores, including its metadata, so
+	// several trees can share one database; empty stores keys unprefixed
+	Namespace string
+	// Compact places each leaf at the highest position whose subtree holds no
+	// other leaf instead of at full depth, so far fewer nodes are written.
+	// Proofs of compact trees verify with VerifyCompactProof, not in Solidity.
+	Compact bool
+}
+
+// resolve returns a copy of the options with defaults filled in
+func (o *TreeOptions) resolve() (*TreeOptions, error) {
+	resolved := *o
+	if resolved.Depth == 0 || resolved.Depth > SMT_DEPTH {
+		return nil, &InvalidTreeDepthError{Depth: resolved.Depth}
+	}
+	if resolved.HashSuite == nil {
+		resolved.HashSuite = Keccak256HashSuite()
+	}
+	if err := resolved.HashSuite.validate(); err != nil {
+		return nil, err
+	}
+	if resolved.KeyDerivation == nil {
+		resolved.KeyDerivation = Keccak256KeyDerivation()
+	}
+	if resolved.KeyDerivation.Name == "" || resolved.KeyDerivation.Derive == nil {
+		return nil, ErrInvalidKeyDerivation
+	}
+	return &resolved, nil
+}
+
+// EffectiveHashSuite returns the suite proofs of a tree with these options are
+// verified with: the hash suite, Keccak256 by default, with the domain tag
+// mixed into leaf hashes
+func (o *TreeOptions) EffectiveHashSuite() *HashSuite {
+	suite := o.HashSuite
+	if suite == nil {
+		suite = Keccak256HashSuite()
+	}
+	if o.DomainTag == "" {
+		return suite
+	}
+	return DomainSeparatedHashSuite(suite, o.DomainTag)
+}
+
+// DomainSeparatedHashSuite returns a suite whose leaf hash is
+// Node(Node(tag, ""), Leaf(index, value)), binding every leaf to tag. Node
+// hashes are unchanged. The returned suite keeps the name of suite.
+func DomainSeparatedHashSuite(suite *HashSuite, tag string) *HashSuite {
+	var domain Bytes32
+	copy(domain[:], suite.Node([]byte(tag), nil))
+
+	return &HashSuite{
+		Name: suite.Name,
+		Node: suite.Node,
+		Leaf: func(index *big.Int, value Bytes32) Bytes32 {
+			return suite.HashNode(domain, suite.Leaf(index, value))
+		},
+	}
+}
+
+// VerifyProofWithOptions verifies a proof against root for a tree built with opts
+func VerifyProofWithOptions(root Bytes32, proof *Proof, opts *TreeOptions) bool {
+	if opts.Compact {
+		return VerifyCompactProof(root, opts.Depth, proof, opts.EffectiveHashSuite())
+	}
+	return VerifyProof(root, opts.Depth, proof, opts.EffectiveHashSuite())
+}
+
+// ComputeRootWithOptions computes the root a proof implies for a tree built with opts
+func ComputeRootWithOptions(proof *Proof, opts *TreeOptions) Bytes32 {
+	if opts.Compact {
+		return ComputeRootFromCompactProof(opts.Depth, proof, opts.EffectiveHashSuite())
+	}
+	return ComputeRootFromProof(opts.Depth, proof, opts.EffectiveHashSuite())
+}
+
+// ReadTreeOptions returns the options of the tree stored in db, or nil if db
+// holds no tree. The hash suite and key derivation must be built in. db must
+// already be scoped to the tree's namespace, so Namespace is left empty.
+func ReadTreeOptions(db Database) (*TreeOptions, error) {
+	meta, err := ReadTreeMetadata(db)
+	if err != nil || meta == nil {
+		return nil, err
+	}
+
+	stored, err := readOptionRecords(db)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &TreeOptions{
+		Depth:         meta.Depth,
+		HashSuite:     HashSuiteByName(stored.suite),
+		DomainTag:     stored.domainTag,
+		KeyDerivation: KeyDerivationByName(stored.keys),
+		Compact:       stored.layout == CompactLayoutName,
+	}
+	if opts.HashSuite == nil {
+		return nil, &HashSuiteMismatchError{Stored: stored.suite}
+	}
+	if opts.KeyDerivation == nil {
+		return nil, &OptionMismatchError{Option: "key derivation", Stored: stored.keys}
+	}
+	if stored.layout != FullLayoutName && stored.layout != CompactLayoutName {
+		return nil, &OptionMismatchError{Option: "layout", Stored: stored.layout}
+	}
+	return opts, nil
+}
+
+// ReadHashSuiteName returns the name of the hash suite of the tree stored in
+// db. Trees without a HashSuiteKey record use the default Keccak256 suite.
+func ReadHashSuiteName(db Database) (string, error) {
+	return readOptionRecord(db, HashSuiteKey, Keccak256SuiteName)
+}
+
+// optionRecords holds the stored option records of a tree
+type optionRecords struct {
+	suite     string
+	domainTag string
+	keys      string
+	layout    string
+}
+
+// readOptionRecords loads the stored option records, defaulting missing ones
+func readOptionRecords(db Database) (*optionRecords, error) {
+	var stored optionRecords
+	var err error
+	if stored.suite, err = readOptionRecord(db, HashSuiteKey, Keccak256SuiteName); err != nil {
+		return nil, err
+	}
+	if stored.domainTag, err = readOptionRecord(db, DomainTagKey, ""); err != nil { // coverage-ignore
+		return nil, err
+	}
+	if stored.keys, err = readOptionRecord(db, KeyDerivationKey, Keccak256KeyDerivationName); err != nil { // coverage-ignore
+		return nil, err
+	}
+	if stored.layout, err = readOptionRecord(db, LayoutKey, FullLayoutName); err != nil { // coverage-ignore
+		return nil, err
+	}
+	return &stored, nil
+}
+
+// readOptionRecord loads one option record, returning def if it is absent
+func readOptionRecord(db Database, key, def string) (string, error) {
+	data, exists, err := getIfPresent(db, []byte(key))
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return def, nil
+	}
+	return string(data), nil
+}
+
+// checkOptionRecords verifies that a stored tree was built with opts
+func checkOptionRecords(db Database, opts *TreeOptions) error {
+	stored, err := readOptionRecords(db)
+	if err != nil {
+		return err
+	}
+	if stored.suite != opts.HashSuite.Name {
+		return &HashSuiteMismatchError{Stored: stored.suite, Requested: opts.HashSuite.Name}
+	}
+	if stored.domainTag != opts.DomainTag {
+		return &OptionMismatchError{Option: "domain tag", Stored: stored.domainTag, Requested: opts.DomainTag}
+	}
+	if stored.keys != opts.KeyDerivation.Name {
+		return &OptionMismatchError{Option: "key derivation", Stored: stored.keys, Requested: opts.KeyDerivation.Name}
+	}
+	if stored.layout != opts.layoutName() {
+		return &OptionMismatchError{Option: "layout", Stored: stored.layout, Requested: opts.layoutName()}
+	}
+	return nil
+}
+
+// writeOptionRecords stages the records of options that differ from their
+// defaults; default trees stay readable by releases that predate options
+func (smt *SparseMerkleTree) writeOptionRecords() error {
+	records := []struct {
+		key, value, def string
+	}{
+		{HashSuiteKey, smt.options.HashSuite.Name, Keccak256SuiteName},
+		{DomainTagKey, smt.options.DomainTag, ""},
+		{KeyDerivationKey, smt.options.KeyDerivation.Name, Keccak256KeyDerivationName},
+		{LayoutKey, smt.options.layoutName(), FullLayoutName},
+	}
+	for _, record := range records {
+		if record.value == record.def {
+			continue
+		}
+		if err := smt.dbSet([]byte(record.key), []byte(record.value)); err != nil { // coverage-ignore
+			return err
+		}
+	}
+	return nil
+}
+
+// Options returns a copy of the options the tree was created with, with defaults filled in
+func (smt *SparseMerkleTree) Options() TreeOptions {
+	return *smt.options
+}
@@ -0,0 +1,208 @@
+package smt
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// OverlayDatabase layers writes over a parent Database that it never modifies
+// until Flush. Deletes are recorded as tombstones that hide the parent's value.
+//
+// Opening a tree on an overlay forks it cheaply: the fork shares every record
+// with the parent, and a candidate set of updates can be applied, inspected and
+// either merged with Flush or dropped with Discard.
+type OverlayDatabase struct {
+	parent Database
+	writes map[string][]byte // nil value marks a tombstone
+	mu     sync.RWMutex
+}
+
+var (
+	_ BatchDatabase    = (*OverlayDatabase)(nil)
+	_ IterableDatabase = (*OverlayDatabase)(nil)
+)
+
+// NewOverlayDatabase creates an empty overlay on top of parent
+func NewOverlayDatabase(parent Database) (*OverlayDatabase, error) {
+	if parent == nil {
+		return nil, ErrNilDatabase
+	}
+	return &OverlayDatabase{
+		parent: parent,
+		writes: make(map[string][]byte),
+	}, nil
+}
+
+// Parent returns the database the overlay is layered on
+func (db *OverlayDatabase) Parent() Database {
+	return db.parent
+}
+
+// Get retrieves a value by key, preferring the overlay's own writes
+func (db *OverlayDatabase) Get(key []byte) ([]byte, error) {
+	db.mu.RLock()
+	value, written := db.writes[string(key)]
+	db.mu.RUnlock()
+
+	if written {
+		return copyBytes(value), nil
+	}
+	return db.parent.Get(key)
+}
+
+// Set stores a key-value pair in the overlay
+func (db *OverlayDatabase) Set(key []byte, value []byte) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.writes[string(key)] = append([]byte{}, value...)
+	return nil
+}
+
+// Delete hides a key with a tombstone
+func (db *OverlayDatabase) Delete(key []byte) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.writes[string(key)] = nil
+	return nil
+}
+
+// Has checks if a key exists in the overlay or, unless deleted, in the parent
+func (db *OverlayDatabase) Has(key []byte) (bool, error) {
+	db.mu.RLock()
+	value, written := db.writes[string(key)]
+	db.mu.RUnlock()
+
+	if written {
+		return value != nil, nil
+	}
+	return db.parent.Has(key)
+}
+
+// IteratePrefix merges the overlay's writes with the parent's keys in ascending
+// key order. The parent must implement IterableDatabase.
+func (db *OverlayDatabase) IteratePrefix(prefix []byte, fn func(key, value []byte) bool) error {
+	idb, ok := db.parent.(IterableDatabase)
+	if !ok {
+		return ErrNotIterable
+	}
+
+	db.mu.RLock()
+	merged := make(map[string][]byte)
+	for key, value := range db.writes {
+		if strings.HasPrefix(key, string(prefix)) {
+			merged[key] = value
+		}
+	}
+	db.mu.RUnlock()
+
+	return iterateMerged(idb, prefix, merged, fn)
+}
+
+// iterateMerged calls fn for every key with the given prefix in ascending key
+// order, taking values from writes, where nil marks a deletion, over those of
+// idb. Every key in writes must have the prefix; writes is modified.
+func iterateMerged(idb IterableDatabase, prefix []byte, writes map[string][]byte, fn func(key, value []byte) bool) error {
+	err := idb.IteratePrefix(prefix, func(key, value []byte) bool {
+		if _, written := writes[string(key)]; !written {
+			writes[string(key)] = value
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(writes))
+	for key, value := range writes {
+		if value != nil {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if !fn([]byte(key), copyBytes(writes[key])) {
+			break
+		}
+	}
+	return nil
+}
+
+// NewBatch creates a write batch applied to the overlay atomically
+func (db *OverlayDatabase) NewBatch() Batch {
+	return &overlayBatch{db: db}
+}
+
+// Len returns the number of keys written or deleted in the overlay
+func (db *OverlayDatabase) Len() int {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return len(db.writes)
+}
+
+// Flush merges the overlay into the parent, in a single batch when the parent
+// supports it, and empties the overlay. On failure the overlay keeps its writes.
+func (db *OverlayDatabase) Flush() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	keys := make([]string, 0, len(db.writes))
+	for key := range db.writes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var err error
+	if bdb, ok := db.parent.(BatchDatabase); ok {
+		err = writeBatch(bdb.NewBatch(), keys, db.writes)
+	} else {
+		err = writeSequential(db.parent, keys, db.writes)
+	}
+	if err != nil {
+		return err
+	}
+
+	db.writes = make(map[string][]byte)
+	return nil
+}
+
+// Discard drops every write, exposing the parent unchanged
+func (db *OverlayDatabase) Discard() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.writes = make(map[string][]byte)
+}
+
+// overlayBatch buffers writes for an OverlayDatabase
+type overlayBatch struct {
+	db  *OverlayDatabase
+	ops []batchOp
+}
+
+// Put buffers a key-value write
+func (b *overlayBatch) Put(key []byte, value []byte) error {
+	b.ops = append(b.ops, batchOp{key: string(key), value: append([]byte{}, value...)})
+	return nil
+}
+
+// Delete buffers a key deletion
+func (b *overlayBatch) Delete(key []byte) error {
+	b.ops = append(b.ops, batchOp{key: string(key), value: nil})
+	return nil
+}
+
+// Write applies the buffered writes to the overlay under its lock
+func (b *overlayBatch) Write() error {
+	b.db.mu.Lock()
+	defer b.db.mu.Unlock()
+
+	for _, op := range b.ops {
+		b.db.writes[op.key] = op.value
+	}
+	b.ops = nil
+	return nil
+}
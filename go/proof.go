@@ -4,40 +4,49 @@ import (
 	"math/big"
 )
 
-// VerifyProof verifies a proof against a given root
-func VerifyProof(root Bytes32, depth uint16, proof *Proof) bool {
-	computedRoot := ComputeRootFromProof(depth, proof)
+// VerifyProof verifies a proof against a given root. The optional suite must
+// be the one the tree was built with; the default is Keccak256.
+func VerifyProof(root Bytes32, depth uint16, proof *Proof, suite ...*HashSuite) bool {
+	computedRoot := ComputeRootFromProof(depth, proof, suite...)
 	return computedRoot == root
 }
 
-// ComputeRootFromProof computes the root hash from a proof
-func ComputeRootFromProof(depth uint16, proof *Proof) Bytes32 {
+// ComputeRootFromProof computes the root hash from a proof using the optional
+// hash suite, Keccak256 by default
+func ComputeRootFromProof(depth uint16, proof *Proof, suite ...*HashSuite) Bytes32 {
 	if proof == nil {
 		return Bytes32{}
 	}
+	hasher := resolveHashSuite(suite)
 	
 	// For non-existence proofs, we start with zero and build up the path
 	// For existence proofs, we start with the computed leaf hash
 	var current Bytes32
 	if proof.Exists {
-		current = ComputeLeafHash(proof.Index, proof.Value)
+		current = hasher.HashLeaf(proof.Index, proof.Value)
 	} else {
 		// For non-existence proofs, we don't compute a leaf hash
 		// We start with zero (empty subtree)
 		current = Bytes32{}
 	}
+	return pathRoot(depth, current, proof.Index, proof.Enables, proof.Siblings, hasher)
+}
+
+// pathRoot hashes current, the leaf hash at index or zero, up to the root
+// with the siblings the enable bits call for
+func pathRoot(depth uint16, current Bytes32, index, enables *big.Int, siblings []Bytes32, hasher *HashSuite) Bytes32 {
 	siblingIndex := 0
 	
 	// Rebuild root from leaf->root (LSB->MSB)
 	// Only process levels where we have siblings or non-zero current
 	for i := uint(0); i < uint(depth); i++ {
-		bit := GetBit(proof.Index, i)
+		bit := GetBit(index, i)
 		var sibling Bytes32
 		
 		// Check if sibling is enabled (non-zero)
-		if GetBit(proof.Enables, i) == 1 {
-			if siblingIndex < len(proof.Siblings) {
-				sibling = proof.Siblings[siblingIndex]
+		if GetBit(enables, i) == 1 {
+			if siblingIndex < len(siblings) {
+				sibling = siblings[siblingIndex]
 				siblingIndex++
 			}
 		}
@@ -51,10 +60,10 @@ func ComputeRootFromProof(depth uint16, proof *Proof) Bytes32 {
 		// Compute parent hash
 		if bit == 1 {
 			// Current is right child
-			current = HashBytes32(sibling, current)
+			current = hasher.HashNode(sibling, current)
 		} else {
 			// Current is left child
-			current = HashBytes32(current, sibling)
+			current = hasher.HashNode(current, sibling)
 		}
 	}
 	
@@ -62,7 +71,7 @@ func ComputeRootFromProof(depth uint16, proof *Proof) Bytes32 {
 }
 
 // VerifyProofWithLeaf verifies a proof for a specific leaf value
-func VerifyProofWithLeaf(root Bytes32, depth uint16, leaf Bytes32, index *big.Int, enables *big.Int, siblings []Bytes32) bool {
+func VerifyProofWithLeaf(root Bytes32, depth uint16, leaf Bytes32, index *big.Int, enables *big.Int, siblings []Bytes32, suite ...*HashSuite) bool {
 	proof := &Proof{
 		Exists:   !leaf.IsZero(),
 		Leaf:     leaf,  // This is already a computed leaf hash
@@ -71,11 +80,11 @@ func VerifyProofWithLeaf(root Bytes32, depth uint16, leaf Bytes32, index *big.In
 		Enables:  enables,
 		Siblings: siblings,
 	}
-	return VerifyProof(root, depth, proof)
+	return VerifyProof(root, depth, proof, suite...)
 }
 
 // ComputeRootWithLeaf computes root from leaf and proof components
-func ComputeRootWithLeaf(depth uint16, leaf Bytes32, index *big.Int, enables *big.Int, siblings []Bytes32) Bytes32 {
+func ComputeRootWithLeaf(depth uint16, leaf Bytes32, index *big.Int, enables *big.Int, siblings []Bytes32, suite ...*HashSuite) Bytes32 {
 	proof := &Proof{
 		Exists:   !leaf.IsZero(),
 		Leaf:     leaf,  // This is already a computed leaf hash
@@ -84,11 +93,22 @@ func ComputeRootWithLeaf(depth uint16, leaf Bytes32, index *big.Int, enables *bi
 		Enables:  enables,
 		Siblings: siblings,
 	}
-	return ComputeRootFromProof(depth, proof)
+	return ComputeRootFromProof(depth, proof, suite...)
 }
 
-// VerifyUpdateProof verifies an update proof
-func VerifyUpdateProof(oldRoot, newRoot Bytes32, depth uint16, updateProof *UpdateProof) bool {
+// VerifyUpdateProof verifies that an insert, update or delete moved a
+// full-depth tree from oldRoot to newRoot. The old side must verify against
+// oldRoot; the new root puts NewLeaf, a leaf hash, on the same path, or
+// nothing for a delete.
+func VerifyUpdateProof(oldRoot, newRoot Bytes32, depth uint16, updateProof *UpdateProof, suite ...*HashSuite) bool {
+	if updateProof == nil {
+		return false
+	}
+	if _, ok := updateProof.operation(); !ok {
+		return false
+	}
+	hasher := resolveHashSuite(suite)
+
 	// Verify old proof
 	oldProof := &Proof{
 		Exists:   updateProof.Exists,
@@ -99,38 +119,55 @@ func VerifyUpdateProof(oldRoot, newRoot Bytes32, depth uint16, updateProof *Upda
 		Siblings: updateProof.Siblings,
 	}
 	
-	if !VerifyProof(oldRoot, depth, oldProof) {
+	if !VerifyProof(oldRoot, depth, oldProof, hasher) {
 		return false
 	}
 	
-	// Compute new root with new leaf
-	newProof := &Proof{
-		Exists:   true,
-		Leaf:     updateProof.NewLeaf,  // This is already a computed leaf hash
-		Value:    updateProof.NewLeaf,  // In update proofs, NewLeaf is the hash
-		Index:    updateProof.Index,
-		Enables:  updateProof.Enables,
-		Siblings: updateProof.Siblings,
-	}
-	
-	computedNewRoot := ComputeRootFromProof(depth, newProof)
+	// Compute new root with new leaf, which is zero for a delete
+	computedNewRoot := pathRoot(depth, updateProof.NewLeaf, updateProof.Index, updateProof.Enables, updateProof.Siblings, hasher)
 	return computedNewRoot == newRoot
 }
 
+// operation returns the proof's operation, inferred from Exists and NewLeaf
+// when Op is empty, and false if it contradicts them
+func (p *UpdateProof) operation() (UpdateOp, bool) {
+	op := p.Op
+	if op == "" {
+		switch {
+		case p.NewLeaf.IsZero():
+			op = OpDelete
+		case p.Exists:
+			op = OpUpdate
+		default:
+			op = OpInsert
+		}
+	}
+
+	switch op {
+	case OpInsert:
+		return op, !p.Exists && !p.NewLeaf.IsZero()
+	case OpUpdate:
+		return op, p.Exists && !p.NewLeaf.IsZero()
+	case OpDelete:
+		return op, p.Exists && p.NewLeaf.IsZero()
+	}
+	return op, false
+}
+
 // BatchVerifyProof verifies multiple proofs efficiently
-func BatchVerifyProof(root Bytes32, depth uint16, proofs []*Proof) []bool {
+func BatchVerifyProof(root Bytes32, depth uint16, proofs []*Proof, suite ...*HashSuite) []bool {
 	results := make([]bool, len(proofs))
 	for i, proof := range proofs {
-		results[i] = VerifyProof(root, depth, proof)
+		results[i] = VerifyProof(root, depth, proof, suite...)
 	}
 	return results
 }
 
 // BatchComputeRoot computes roots for multiple proofs
-func BatchComputeRoot(depth uint16, proofs []*Proof) []Bytes32 {
+func BatchComputeRoot(depth uint16, proofs []*Proof, suite ...*HashSuite) []Bytes32 {
 	roots := make([]Bytes32, len(proofs))
 	for i, proof := range proofs {
-		roots[i] = ComputeRootFromProof(depth, proof)
+		roots[i] = ComputeRootFromProof(depth, proof, suite...)
 	}
 	return roots
 }
@@ -164,7 +201,7 @@ func GenerateProofPath(smt *SparseMerkleTree, index *big.Int) (*ProofPath, error
 			return nil, err
 		}
 		
-		if node.IsEmpty() { // coverage-ignore
+		if node.IsEmpty() {
 			break
 		}
 		
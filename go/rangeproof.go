@@ -0,0 +1,292 @@
+package smt
+
+import (
+	"math/big"
+	"sort"
+)
+
+// Subtree and range proofs prove completeness: the verifier rebuilds the
+// hashes of the covered subtrees from the listed leaves, so a leaf left out of
+// the list or added to it changes the root.
+
+// Ways a subtree can overlap an index range
+const (
+	rangeOutside = iota
+	rangeInside
+	rangePartial
+)
+
+// GetSubtreeProof returns every leaf of the subtree holding the indices whose
+// top prefixLen bits spell prefix, with the subtree's hash and the siblings on
+// its path to the root. Compact trees return ErrCompactLayout.
+func (smt *SparseMerkleTree) GetSubtreeProof(prefix *big.Int, prefixLen uint16) (*SubtreeProof, error) {
+	smt.mu.RLock()
+	defer smt.mu.RUnlock()
+
+	if smt.options.Compact {
+		return nil, ErrCompactLayout
+	}
+	if !validPrefix(smt.depth, prefix, prefixLen) {
+		return nil, ErrInvalidPrefix
+	}
+
+	enables := big.NewInt(0)
+	siblings := make([]Bytes32, 0, prefixLen)
+	current := smt.root
+
+	for level := uint16(0); level < prefixLen && !current.IsZero(); level++ {
+		node, err := smt.getNode(current)
+		if err != nil { // coverage-ignore
+			return nil, err
+		}
+
+		var sibling Bytes32
+		if GetBit(prefix, uint(prefixLen-1-level)) == 0 {
+			sibling, current = node.Right, node.Left
+		} else {
+			sibling, current = node.Left, node.Right
+		}
+
+		// Prepend so siblings run from the subtree up, as in a Proof
+		if !sibling.IsZero() {
+			siblings = append([]Bytes32{sibling}, siblings...)
+			enables.SetBit(enables, int(smt.depth-level-1), 1)
+		}
+	}
+
+	leaves := make([]LeafData, 0)
+	_, err := smt.walkLeaves(current, prefixLen, prefix, nil, nil, func(index *big.Int, value Bytes32) bool {
+		leaves = append(leaves, LeafData{Index: index, Value: value})
+		return true
+	})
+	if err != nil { // coverage-ignore
+		return nil, err
+	}
+
+	return &SubtreeProof{
+		Prefix:    new(big.Int).Set(prefix),
+		PrefixLen: prefixLen,
+		Root:      current,
+		Leaves:    leaves,
+		Enables:   enables,
+		Siblings:  siblings,
+	}, nil
+}
+
+// VerifySubtreeProof verifies that a subtree proof's leaves are exactly the
+// contents of its subtree in the tree with the given root
+func VerifySubtreeProof(root Bytes32, depth uint16, sp *SubtreeProof, suite ...*HashSuite) bool {
+	if sp == nil || !validPrefix(depth, sp.Prefix, sp.PrefixLen) {
+		return false
+	}
+	hasher := resolveHashSuite(suite)
+	height := uint(depth - sp.PrefixLen)
+
+	first := new(big.Int).Lsh(sp.Prefix, height)
+	end := new(big.Int).Lsh(new(big.Int).Add(sp.Prefix, ONE), height)
+	if !leavesWithin(sp.Leaves, first, end) || leavesRoot(hasher, height, sp.Leaves) != sp.Root {
+		return false
+	}
+
+	// Only the heights above the subtree have siblings
+	enables := sp.Enables
+	if enables == nil {
+		enables = big.NewInt(0)
+	}
+	if enables.BitLen() > int(depth) || (enables.Sign() != 0 && enables.TrailingZeroBits() < height) {
+		return false
+	}
+
+	current := sp.Root
+	siblingIndex := 0
+	for i := height; i < uint(depth); i++ {
+		var sibling Bytes32
+		if enables.Bit(int(i)) == 1 {
+			if siblingIndex >= len(sp.Siblings) {
+				return false
+			}
+			sibling = sp.Siblings[siblingIndex]
+			siblingIndex++
+		}
+
+		if GetBit(sp.Prefix, i-height) == 1 {
+			current = hashChildren(hasher, sibling, current)
+		} else {
+			current = hashChildren(hasher, current, sibling)
+		}
+	}
+	return siblingIndex == len(sp.Siblings) && current == root
+}
+
+// GetRangeProof returns every leaf with start <= index < end, with the hashes
+// of the subtrees beside the range, proving nothing else lies in the range.
+// Compact trees return ErrCompactLayout.
+func (smt *SparseMerkleTree) GetRangeProof(start, end *big.Int) (*RangeProof, error) {
+	smt.mu.RLock()
+	defer smt.mu.RUnlock()
+
+	if smt.options.Compact {
+		return nil, ErrCompactLayout
+	}
+	if !validRange(smt.depth, start, end) {
+		return nil, ErrInvalidRange
+	}
+
+	rp := &RangeProof{
+		Start:    new(big.Int).Set(start),
+		End:      new(big.Int).Set(end),
+		Leaves:   make([]LeafData, 0),
+		Enables:  big.NewInt(0),
+		Siblings: make([]Bytes32, 0),
+	}
+	b := &rangeProofBuilder{smt: smt, rp: rp, slots: &siblingSlots{enables: rp.Enables, siblings: rp.Siblings}}
+	if err := b.subtree(smt.root, smt.depth, big.NewInt(0)); err != nil {
+		return nil, err
+	}
+	rp.Siblings = b.slots.siblings
+	return rp, nil
+}
+
+// rangeProofBuilder collects the leaves and siblings of a range proof
+type rangeProofBuilder struct {
+	smt   *SparseMerkleTree
+	rp    *RangeProof
+	slots *siblingSlots
+}
+
+// subtree adds the subtree of the given height at hash, whose path from the
+// root spells prefix, to the proof
+func (b *rangeProofBuilder) subtree(hash Bytes32, height uint16, prefix *big.Int) error {
+	switch rangeOverlap(b.rp.Start, b.rp.End, height, prefix) {
+	case rangeOutside:
+		b.slots.write(hash)
+		return nil
+	case rangeInside:
+		_, err := b.smt.walkLeaves(hash, b.smt.depth-height, prefix, nil, nil, func(index *big.Int, value Bytes32) bool {
+			b.rp.Leaves = append(b.rp.Leaves, LeafData{Index: index, Value: value})
+			return true
+		})
+		return err
+	}
+
+	var node Node
+	if !hash.IsZero() {
+		stored, err := b.smt.getNode(hash)
+		if err != nil { // coverage-ignore
+			return err
+		}
+		node = *stored
+	}
+
+	left := new(big.Int).Lsh(prefix, 1)
+	if err := b.subtree(node.Left, height-1, left); err != nil {
+		return err
+	}
+	return b.subtree(node.Right, height-1, new(big.Int).Add(left, ONE))
+}
+
+// VerifyRangeProof verifies that a range proof's leaves are exactly the
+// contents of its range in the tree with the given root
+func VerifyRangeProof(root Bytes32, depth uint16, rp *RangeProof, suite ...*HashSuite) bool {
+	if rp == nil || !validRange(depth, rp.Start, rp.End) || !leavesWithin(rp.Leaves, rp.Start, rp.End) {
+		return false
+	}
+
+	r := &rangeProofReader{
+		rp:     rp,
+		hasher: resolveHashSuite(suite),
+		slots:  &siblingSlots{enables: rp.Enables, siblings: rp.Siblings},
+	}
+	computed := r.subtree(depth, big.NewInt(0))
+	return r.slots.done() && r.next == len(rp.Leaves) && computed == root
+}
+
+// rangeProofReader recomputes the root of a range proof
+type rangeProofReader struct {
+	rp     *RangeProof
+	hasher *HashSuite
+	slots  *siblingSlots
+	next   int // Leaves consumed so far
+}
+
+// subtree returns the hash of the subtree of the given height whose path from
+// the root spells prefix
+func (r *rangeProofReader) subtree(height uint16, prefix *big.Int) Bytes32 {
+	switch rangeOverlap(r.rp.Start, r.rp.End, height, prefix) {
+	case rangeOutside:
+		return r.slots.read()
+	case rangeInside:
+		// Covered subtrees are visited in index order and tile the range
+		end := new(big.Int).Lsh(new(big.Int).Add(prefix, ONE), uint(height))
+		leaves := r.rp.Leaves[r.next:]
+		count := sort.Search(len(leaves), func(i int) bool {
+			return leaves[i].Index.Cmp(end) >= 0
+		})
+		r.next += count
+		return leavesRoot(r.hasher, uint(height), leaves[:count])
+	}
+
+	left := new(big.Int).Lsh(prefix, 1)
+	leftHash := r.subtree(height-1, left)
+	return hashChildren(r.hasher, leftHash, r.subtree(height-1, new(big.Int).Add(left, ONE)))
+}
+
+// leavesRoot returns the hash of the subtree of the given height holding
+// exactly leaves, which are in strictly ascending order and share its path
+func leavesRoot(hasher *HashSuite, height uint, leaves []LeafData) Bytes32 {
+	if len(leaves) == 0 {
+		return Bytes32{}
+	}
+	if height == 0 {
+		return hasher.HashLeaf(leaves[0].Index, leaves[0].Value)
+	}
+
+	split := sort.Search(len(leaves), func(i int) bool {
+		return GetBit(leaves[i].Index, height-1) == 1
+	})
+	return hashChildren(hasher, leavesRoot(hasher, height-1, leaves[:split]), leavesRoot(hasher, height-1, leaves[split:]))
+}
+
+// leavesWithin reports whether leaves are in strictly ascending index order
+// with first <= index < end
+func leavesWithin(leaves []LeafData, first, end *big.Int) bool {
+	for i, leaf := range leaves {
+		if leaf.Index == nil || leaf.Index.Cmp(first) < 0 || leaf.Index.Cmp(end) >= 0 {
+			return false
+		}
+		if i > 0 && leaf.Index.Cmp(leaves[i-1].Index) <= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// rangeOverlap classifies the subtree of the given height whose path from the
+// root spells prefix against the range [start, end)
+func rangeOverlap(start, end *big.Int, height uint16, prefix *big.Int) int {
+	first := new(big.Int).Lsh(prefix, uint(height))
+	last := new(big.Int).Add(first, new(big.Int).Lsh(ONE, uint(height)))
+	switch {
+	case last.Cmp(start) <= 0 || first.Cmp(end) >= 0:
+		return rangeOutside
+	case first.Cmp(start) >= 0 && last.Cmp(end) <= 0:
+		return rangeInside
+	}
+	return rangePartial
+}
+
+// validPrefix reports whether prefix names a subtree of a tree of the given depth
+func validPrefix(depth uint16, prefix *big.Int, prefixLen uint16) bool {
+	if prefix == nil || prefixLen > depth || prefix.Sign() < 0 {
+		return false
+	}
+	return prefix.BitLen() <= int(prefixLen)
+}
+
+// validRange reports whether [start, end) is a non-empty range of a tree of the given depth
+func validRange(depth uint16, start, end *big.Int) bool {
+	if start == nil || end == nil || start.Sign() < 0 || start.Cmp(end) >= 0 {
+		return false
+	}
+	return end.Cmp(new(big.Int).Lsh(ONE, uint(depth))) <= 0
+}
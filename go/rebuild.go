@@ -0,0 +1,437 @@
+package smt
+
+import (
+	"fmt"
+	"math/big"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// A batch commit applies many writes in one pass instead of walking the path
+// of each key in turn. The writes are sorted by index and the tree is rebuilt
+// top-down: each node on a touched path is read once, its writes are split by
+// the node's branching bit, and the two halves are rebuilt independently.
+// Halves holding enough writes are hashed on their own goroutines; database
+// reads are serialized and the rebuilt nodes are stored once hashing is done.
+//
+// Per-key update proofs are chained: the proof for a write is taken against
+// the tree with every earlier write of the batch applied, exactly as if the
+// writes were applied one at a time. To build them each subtree reports its
+// hash after each write under it, in batch order, so the sibling a write sees
+// at a node is the other half's hash as of the writes before it.
+
+// parallelRebuildWrites is the number of writes below which a subtree is
+// rebuilt on the calling goroutine
+const parallelRebuildWrites = 64
+
+// LeafWrite sets the leaf at Index to Value, or removes it when Delete is set
+type LeafWrite struct {
+	Index  *big.Int
+	Value  Bytes32
+	Delete bool
+}
+
+// operation describes the write as a batch operation, for BatchError
+func (w LeafWrite) operation() BatchOperation {
+	if w.Delete {
+		return BatchOperation{Type: "delete", Index: w.Index}
+	}
+	return BatchOperation{Type: "set", Index: w.Index, Leaf: w.Value}
+}
+
+// CommitBatch applies writes to the tree as a single operation recorded as one
+// version. Each touched subtree is rebuilt once, with disjoint subtrees hashed
+// concurrently, so the hash suite must be safe for concurrent use. Writes are
+// applied in order: a later write to an index overrides an earlier one, and
+// deleting an index that does not hold a leaf at that point fails. If any
+// write fails nothing is committed and a *BatchError is returned.
+func (smt *SparseMerkleTree) CommitBatch(writes []LeafWrite) error {
+	_, err := smt.commitBatch(writes, false)
+	return err
+}
+
+// CommitBatchWithProofs is CommitBatch that also returns, for each write, the
+// UpdateProof applying the writes one at a time in order would have returned
+func (smt *SparseMerkleTree) CommitBatchWithProofs(writes []LeafWrite) ([]*UpdateProof, error) {
+	return smt.commitBatch(writes, true)
+}
+
+// commitBatch applies writes as one committed operation
+func (smt *SparseMerkleTree) commitBatch(writes []LeafWrite, withProofs bool) ([]*UpdateProof, error) {
+	smt.mu.Lock()
+	defer smt.mu.Unlock()
+
+	if len(writes) == 0 {
+		return []*UpdateProof{}, nil
+	}
+
+	smt.beginWrite()
+	proofs, err := smt.applyWrites(writes, withProofs)
+	if err == nil {
+		err = smt.recordVersion()
+	}
+	if err != nil {
+		smt.discardWrite()
+		return nil, err
+	}
+
+	if err := smt.commitWrite(); err != nil { // coverage-ignore
+		return nil, err
+	}
+	return proofs, nil
+}
+
+// applyWrites stages writes without locking. Proofs are returned in the order
+// of writes when withProofs is set.
+func (smt *SparseMerkleTree) applyWrites(writes []LeafWrite, withProofs bool) ([]*UpdateProof, error) {
+	for i, w := range writes {
+		if err := smt.validateIndex(w.Index); err != nil {
+			return nil, &BatchError{Index: i, Op: w.operation(), Err: err}
+		}
+	}
+
+	// Compact leaves move as their neighbours change, so compact trees apply
+	// the writes one at a time
+	if smt.options.Compact {
+		return smt.applyWritesInOrder(writes, withProofs)
+	}
+
+	pending := make([]*batchWrite, len(writes))
+	for i, w := range writes {
+		pending[i] = &batchWrite{LeafWrite: w, order: i}
+	}
+	sorted := make([]*batchWrite, len(pending))
+	copy(sorted, pending)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Index.Cmp(sorted[j].Index) < 0
+	})
+
+	b := &batchRebuild{
+		smt:        smt,
+		withProofs: withProofs,
+		tokens:     make(chan struct{}, runtime.GOMAXPROCS(0)-1),
+	}
+	var built []rebuiltNode
+	result, err := b.subtree(smt.root, smt.depth, sorted, &built)
+	if err != nil {
+		return nil, err
+	}
+	b.nodes = append(b.nodes, built...)
+
+	// Only the final state of each index and the final nodes are stored
+	for _, w := range sorted {
+		if !w.final {
+			continue
+		}
+		if w.leaf.IsZero() {
+			err = smt.deleteLeafIndex(w.Index)
+		} else {
+			err = smt.setLeaf(w.leaf, &LeafData{Index: w.Index, Value: w.Value})
+		}
+		if err != nil { // coverage-ignore
+			return nil, err
+		}
+	}
+	for _, n := range b.nodes {
+		if err := smt.putNode(n.hash, &Node{Left: n.left, Right: n.right}); err != nil { // coverage-ignore
+			return nil, err
+		}
+	}
+	if err := smt.replaceRoot(result.final()); err != nil { // coverage-ignore
+		return nil, err
+	}
+
+	if !withProofs {
+		return nil, nil
+	}
+	proofs := make([]*UpdateProof, len(pending))
+	for i, w := range pending {
+		proofs[i] = w.proof
+	}
+	return proofs, nil
+}
+
+// applyWritesInOrder stages writes one at a time without locking
+func (smt *SparseMerkleTree) applyWritesInOrder(writes []LeafWrite, withProofs bool) ([]*UpdateProof, error) {
+	var proofs []*UpdateProof
+	if withProofs {
+		proofs = make([]*UpdateProof, len(writes))
+	}
+
+	for i, w := range writes {
+		var proof *UpdateProof
+		var err error
+		if w.Delete {
+			proof, err = smt.deleteInternal(w.Index)
+		} else {
+			proof, err = smt.upsert(w.Index, w.Value)
+		}
+		if err != nil {
+			return nil, &BatchError{Index: i, Op: w.operation(), Err: err}
+		}
+		if withProofs {
+			proofs[i] = proof
+		}
+	}
+	return proofs, nil
+}
+
+// batchWrite is a write of a batch commit and the state built up for it
+type batchWrite struct {
+	LeafWrite
+	order int          // Position in the batch
+	leaf  Bytes32      // Leaf hash after the write, zero for a delete
+	final bool         // Last write to its index
+	proof *UpdateProof // Chained proof, when proofs are collected
+}
+
+// rebuiltNode is a node built by a batch commit, stored once hashing is done
+type rebuiltNode struct {
+	hash, left, right Bytes32
+}
+
+// rebuiltSubtree is the result of rebuilding a subtree
+type rebuiltSubtree struct {
+	writes []*batchWrite // Writes under the subtree in batch order, when proofs are collected
+	hashes []Bytes32     // Subtree hash after each write, or only the final hash
+}
+
+// final returns the hash of the rebuilt subtree
+func (r *rebuiltSubtree) final() Bytes32 {
+	return r.hashes[len(r.hashes)-1]
+}
+
+// batchRebuild holds the state shared by the goroutines of a batch commit
+type batchRebuild struct {
+	smt        *SparseMerkleTree
+	withProofs bool
+	tokens     chan struct{} // One per goroutine that may run besides the caller
+
+	mu    sync.Mutex // Serializes database reads and collecting nodes
+	nodes []rebuiltNode
+}
+
+// subtree rebuilds the subtree of the given height at hash with writes, which
+// are sorted by index and all lie under it, applied. Nodes built on this
+// goroutine are appended to nodes.
+func (b *batchRebuild) subtree(hash Bytes32, height uint16, writes []*batchWrite, nodes *[]rebuiltNode) (*rebuiltSubtree, error) {
+	if height == 0 {
+		return b.leaf(hash, writes)
+	}
+
+	var left, right Bytes32
+	if !hash.IsZero() {
+		node, err := b.node(hash)
+		if err != nil { // coverage-ignore
+			return nil, err
+		}
+		left, right = node.Left, node.Right
+	}
+
+	bit := uint(height - 1)
+	split := sort.Search(len(writes), func(i int) bool {
+		return GetBit(writes[i].Index, bit) == 1
+	})
+	leftWrites, rightWrites := writes[:split], writes[split:]
+
+	var l, r *rebuiltSubtree
+	var leftErr, rightErr error
+	if len(leftWrites) > 0 && len(rightWrites) > 0 && len(writes) >= parallelRebuildWrites && b.acquire() {
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer b.release()
+			var built []rebuiltNode
+			l, leftErr = b.subtree(left, height-1, leftWrites, &built)
+			b.mu.Lock()
+			b.nodes = append(b.nodes, built...)
+			b.mu.Unlock()
+		}()
+		r, rightErr = b.subtree(right, height-1, rightWrites, nodes)
+		wg.Wait()
+	} else {
+		if len(leftWrites) > 0 {
+			l, leftErr = b.subtree(left, height-1, leftWrites, nodes)
+		}
+		if len(rightWrites) > 0 {
+			r, rightErr = b.subtree(right, height-1, rightWrites, nodes)
+		}
+	}
+	if err := earliestError(leftErr, rightErr); err != nil {
+		return nil, err
+	}
+
+	return b.merge(bit, left, right, l, r, nodes), nil
+}
+
+// merge combines the rebuilt halves of a node whose branching bit is bit.
+// left and right are the children before the batch; a half without writes is nil.
+func (b *batchRebuild) merge(bit uint, left, right Bytes32, l, r *rebuiltSubtree, nodes *[]rebuiltNode) *rebuiltSubtree {
+	result := &rebuiltSubtree{}
+	if !b.withProofs {
+		if l != nil {
+			left = l.final()
+		}
+		if r != nil {
+			right = r.final()
+		}
+		result.hashes = []Bytes32{b.hashNode(left, right)}
+	} else {
+		if l == nil {
+			l = &rebuiltSubtree{}
+		}
+		if r == nil {
+			r = &rebuiltSubtree{}
+		}
+
+		// Replay the writes of both halves in batch order; each sees the
+		// other half as it stood before it
+		i, j := 0, 0
+		for i < len(l.writes) || j < len(r.writes) {
+			var w *batchWrite
+			if j == len(r.writes) || (i < len(l.writes) && l.writes[i].order < r.writes[j].order) {
+				w = l.writes[i]
+				addSibling(w.proof, bit, right)
+				left = l.hashes[i]
+				i++
+			} else {
+				w = r.writes[j]
+				addSibling(w.proof, bit, left)
+				right = r.hashes[j]
+				j++
+			}
+			result.writes = append(result.writes, w)
+			result.hashes = append(result.hashes, b.hashNode(left, right))
+		}
+	}
+
+	if hash := result.final(); !hash.IsZero() {
+		*nodes = append(*nodes, rebuiltNode{hash: hash, left: left, right: right})
+	}
+	return result
+}
+
+// leaf applies writes, all to the same index and in batch order, to the leaf
+// position holding hash
+func (b *batchRebuild) leaf(hash Bytes32, writes []*batchWrite) (*rebuiltSubtree, error) {
+	var value Bytes32
+	if !hash.IsZero() {
+		b.mu.Lock()
+		leaf, err := b.smt.getLeaf(hash)
+		b.mu.Unlock()
+		if err != nil { // coverage-ignore
+			return nil, err
+		}
+		if leaf == nil { // coverage-ignore
+			return nil, fmt.Errorf("leaf %s is missing", hash.Hex())
+		}
+		value = leaf.Value
+	}
+
+	result := &rebuiltSubtree{}
+	current := hash
+	for _, w := range writes {
+		if b.withProofs {
+			w.proof = &UpdateProof{
+				Exists:   !current.IsZero(),
+				Leaf:     current,
+				Value:    value,
+				Index:    w.Index,
+				Enables:  big.NewInt(0),
+				Siblings: make([]Bytes32, 0),
+				Op:       upsertOp(!current.IsZero()),
+			}
+			if w.Delete {
+				w.proof.Op = OpDelete
+			}
+		}
+
+		if w.Delete {
+			if current.IsZero() {
+				return nil, &BatchError{Index: w.order, Op: w.operation(), Err: &KeyNotFoundError{Index: w.Index}}
+			}
+			current, value = Bytes32{}, Bytes32{}
+		} else {
+			current, value = b.smt.hasher.HashLeaf(w.Index, w.Value), w.Value
+		}
+		w.leaf = current
+
+		if b.withProofs {
+			w.proof.NewLeaf = current
+			result.writes = append(result.writes, w)
+			result.hashes = append(result.hashes, current)
+		}
+	}
+	writes[len(writes)-1].final = true
+
+	if !b.withProofs {
+		result.hashes = []Bytes32{current}
+	}
+	return result, nil
+}
+
+// node reads the node stored under hash
+func (b *batchRebuild) node(hash Bytes32) (*Node, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	node, err := b.smt.getNode(hash)
+	if err != nil { // coverage-ignore
+		return nil, err
+	}
+	if node.IsEmpty() { // coverage-ignore
+		return nil, fmt.Errorf("node %s is missing", hash.Hex())
+	}
+	return node, nil
+}
+
+// hashNode hashes a node's children; a node with two empty children is empty
+func (b *batchRebuild) hashNode(left, right Bytes32) Bytes32 {
+	if left.IsZero() && right.IsZero() {
+		return Bytes32{}
+	}
+	return b.smt.hasher.HashNode(left, right)
+}
+
+// acquire reports whether another goroutine may be started
+func (b *batchRebuild) acquire() bool {
+	select {
+	case b.tokens <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// release returns a goroutine token
+func (b *batchRebuild) release() {
+	<-b.tokens
+}
+
+// addSibling records a sibling, from the bottom of the path up, in a proof
+func addSibling(proof *UpdateProof, bit uint, sibling Bytes32) {
+	if sibling.IsZero() {
+		return
+	}
+	proof.Siblings = append(proof.Siblings, sibling)
+	proof.Enables.SetBit(proof.Enables, int(bit), 1)
+}
+
+// earliestError returns the error of the write that comes first in the batch,
+// so concurrent rebuilds report the same failure as applying writes in order
+func earliestError(a, b error) error {
+	if a == nil || b == nil {
+		if a != nil {
+			return a
+		}
+		return b
+	}
+	ba, okA := a.(*BatchError)
+	bb, okB := b.(*BatchError)
+	if okA && okB && bb.Index < ba.Index {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,224 @@
+package smt
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+)
+
+// Nodes and leaves are content-addressed, so the same record can be reachable
+// from several parents or roots. Every node and leaf record carries a reference
+// count under RefCountPrefix: one reference per stored parent node pointing at
+// it, plus one per root held by the tree. Records are deleted when their count
+// drops to zero, releasing their children in turn.
+
+// getRefCount returns the reference count of a node or leaf record
+func (smt *SparseMerkleTree) getRefCount(hash Bytes32) (uint64, error) {
+	key := []byte(RefCountPrefix + hex.EncodeToString(hash[:]))
+
+	data, exists, err := getIfPresent(treeDatabase{smt: smt}, key)
+	if err != nil || !exists { // coverage-ignore
+		return 0, err
+	}
+
+	if len(data) != 8 { // coverage-ignore
+		return 0, fmt.Errorf("invalid reference count length: expected 8, got %d", len(data))
+	}
+
+	return binary.BigEndian.Uint64(data), nil
+}
+
+// setRefCount stores the reference count of a record, removing it at zero
+func (smt *SparseMerkleTree) setRefCount(hash Bytes32, count uint64) error {
+	key := []byte(RefCountPrefix + hex.EncodeToString(hash[:]))
+	if count == 0 {
+		return smt.dbDelete(key)
+	}
+
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, count)
+	return smt.dbSet(key, data)
+}
+
+// retain takes a reference on a node or leaf record
+func (smt *SparseMerkleTree) retain(hash Bytes32) error {
+	if hash.IsZero() {
+		return nil
+	}
+
+	count, err := smt.getRefCount(hash)
+	if err != nil { // coverage-ignore
+		return err
+	}
+	return smt.setRefCount(hash, count+1)
+}
+
+// release drops a reference on a node or leaf record, deleting it and
+// releasing its children once nothing refers to it
+func (smt *SparseMerkleTree) release(hash Bytes32) error {
+	if hash.IsZero() {
+		return nil
+	}
+
+	count, err := smt.getRefCount(hash)
+	if err != nil { // coverage-ignore
+		return err
+	}
+	if count > 1 {
+		return smt.setRefCount(hash, count-1)
+	}
+	if err := smt.setRefCount(hash, 0); err != nil { // coverage-ignore
+		return err
+	}
+
+	isNode, err := smt.hasNode(hash)
+	if err != nil { // coverage-ignore
+		return err
+	}
+	if !isNode {
+		key := []byte(LeafPrefix + hex.EncodeToString(hash[:]))
+		return smt.dbDelete(key)
+	}
+
+	node, err := smt.getNode(hash)
+	if err != nil { // coverage-ignore
+		return err
+	}
+
+	if err := smt.deleteNode(hash); err != nil { // coverage-ignore
+		return err
+	}
+	if err := smt.release(node.Left); err != nil { // coverage-ignore
+		return err
+	}
+	return smt.release(node.Right)
+}
+
+// putNode stores a node built by a mutation. A node that is already stored
+// holds references on its children; a new one takes them here.
+func (smt *SparseMerkleTree) putNode(hash Bytes32, node *Node) error {
+	exists, err := smt.hasNode(hash)
+	if err != nil { // coverage-ignore
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	if err := smt.setNode(hash, node); err != nil {
+		return err
+	}
+	if err := smt.retain(node.Left); err != nil { // coverage-ignore
+		return err
+	}
+	return smt.retain(node.Right)
+}
+
+// hasNode reports whether an internal node record is stored under hash
+func (smt *SparseMerkleTree) hasNode(hash Bytes32) (bool, error) {
+	return smt.dbHas([]byte(NodePrefix + hex.EncodeToString(hash[:])))
+}
+
+// replaceRoot moves the tree's root reference to root and persists it. The new
+// root is retained before the old one is released so shared records survive.
+func (smt *SparseMerkleTree) replaceRoot(root Bytes32) error {
+	old := smt.root
+	if err := smt.retain(root); err != nil { // coverage-ignore
+		return err
+	}
+	if err := smt.release(old); err != nil {
+		return err
+	}
+	return smt.setRoot(root)
+}
+
+// rebuildRefCounts derives the reference counts of every record reachable
+// from the current root. It upgrades trees written before records were
+// reference counted; records unreachable from the root are left untouched.
+func (smt *SparseMerkleTree) rebuildRefCounts() error {
+	counts := make(map[Bytes32]uint64)
+
+	var walk func(hash Bytes32, level uint16) error
+	walk = func(hash Bytes32, level uint16) error {
+		if hash.IsZero() {
+			return nil
+		}
+		counts[hash]++
+		if counts[hash] > 1 || level >= smt.depth {
+			return nil
+		}
+
+		node, err := smt.getNode(hash)
+		if err != nil { // coverage-ignore
+			return err
+		}
+		if err := walk(node.Left, level+1); err != nil { // coverage-ignore
+			return err
+		}
+		return walk(node.Right, level+1)
+	}
+
+	if err := walk(smt.root, 0); err != nil { // coverage-ignore
+		return err
+	}
+
+	for hash, count := range counts {
+		if err := smt.setRefCount(hash, count); err != nil { // coverage-ignore
+			return err
+		}
+	}
+	return smt.setRoot(smt.root)
+}
+
+// CollectGarbage deletes node and leaf records that hold no reference, such as
+// the stale nodes left behind by trees written before reference counting. The
+// database must implement IterableDatabase. It returns the number of records removed.
+func (smt *SparseMerkleTree) CollectGarbage() (int, error) {
+	smt.mu.Lock()
+	defer smt.mu.Unlock()
+
+	// The sweep reads the database directly, so staged changes must be written first
+	if smt.speculative {
+		return 0, ErrUncommittedChanges
+	}
+
+	idb, ok := smt.db.(IterableDatabase)
+	if !ok {
+		return 0, ErrNotIterable
+	}
+
+	unreferenced := make([][]byte, 0)
+	for _, prefix := range []string{NodePrefix, LeafPrefix} {
+		var scanErr error
+		err := idb.IteratePrefix([]byte(prefix), func(key, value []byte) bool {
+			refKey := []byte(RefCountPrefix + string(key[len(prefix):]))
+			exists, err := smt.db.Has(refKey)
+			if err != nil { // coverage-ignore
+				scanErr = err
+				return false
+			}
+			if !exists {
+				unreferenced = append(unreferenced, key)
+			}
+			return true
+		})
+		if err == nil {
+			err = scanErr
+		}
+		if err != nil { // coverage-ignore
+			return 0, err
+		}
+	}
+
+	smt.beginWrite()
+	for _, key := range unreferenced {
+		if err := smt.dbDelete(key); err != nil { // coverage-ignore
+			smt.discardWrite()
+			return 0, err
+		}
+	}
+	if err := smt.commitWrite(); err != nil {
+		return 0, err
+	}
+	return len(unreferenced), nil
+}
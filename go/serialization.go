@@ -19,7 +19,7 @@ func SerializeProof(proof *Proof) *SerializedProof {
 		exists = 1
 	}
 	
-	return &SerializedProof{
+	sp := &SerializedProof{
 		Exists:   exists,
 		Index:    proof.Index,
 		Leaf:     Bytes32ToHex(proof.Leaf),
@@ -27,6 +27,11 @@ func SerializeProof(proof *Proof) *SerializedProof {
 		Enables:  fmt.Sprintf("0x%x", proof.Enables),
 		Siblings: siblings,
 	}
+	if proof.Neighbor != nil {
+		sp.NeighborIndex = proof.Neighbor.Index
+		sp.NeighborValue = Bytes32ToHex(proof.Neighbor.Value)
+	}
+	return sp
 }
 
 // DeserializeProof converts a SerializedProof back to Proof
@@ -58,6 +63,11 @@ func DeserializeProof(sp *SerializedProof) (*Proof, error) {
 		siblings[i] = sibling
 	}
 	
+	neighbor, err := deserializeNeighbor(sp.NeighborIndex, sp.NeighborValue)
+	if err != nil {
+		return nil, err
+	}
+	
 	return &Proof{
 		Exists:   sp.Exists != 0,
 		Index:    sp.Index,
@@ -65,9 +75,22 @@ func DeserializeProof(sp *SerializedProof) (*Proof, error) {
 		Value:    value,
 		Enables:  enables,
 		Siblings: siblings,
+		Neighbor: neighbor,
 	}, nil
 }
 
+// deserializeNeighbor parses the neighbouring leaf of a compact proof, if any
+func deserializeNeighbor(index *big.Int, valueHex string) (*LeafData, error) {
+	if index == nil {
+		return nil, nil
+	}
+	value, err := HexToBytes32(valueHex)
+	if err != nil {
+		return nil, fmt.Errorf("invalid neighbor value hex: %w", err)
+	}
+	return &LeafData{Index: index, Value: value}, nil
+}
+
 // SerializeUpdateProof converts an UpdateProof to its serialized format
 func SerializeUpdateProof(proof *UpdateProof) *SerializedUpdateProof {
 	siblings := make([]string, len(proof.Siblings)) // coverage-ignore
@@ -80,7 +103,7 @@ func SerializeUpdateProof(proof *UpdateProof) *SerializedUpdateProof {
 		exists = 1
 	}
 	
-	return &SerializedUpdateProof{
+	sup := &SerializedUpdateProof{
 		Exists:   exists,
 		Index:    proof.Index,
 		Leaf:     Bytes32ToHex(proof.Leaf),
@@ -88,7 +111,13 @@ func SerializeUpdateProof(proof *UpdateProof) *SerializedUpdateProof {
 		Enables:  fmt.Sprintf("0x%x", proof.Enables),
 		Siblings: siblings,
 		NewLeaf:  Bytes32ToHex(proof.NewLeaf),
+		Op:       string(proof.Op),
+	}
+	if proof.Neighbor != nil {
+		sup.NeighborIndex = proof.Neighbor.Index
+		sup.NeighborValue = Bytes32ToHex(proof.Neighbor.Value)
 	}
+	return sup
 }
 
 // DeserializeUpdateProof converts a SerializedUpdateProof back to UpdateProof
@@ -101,6 +130,8 @@ func DeserializeUpdateProof(sup *SerializedUpdateProof) (*UpdateProof, error) {
 		Value:    sup.Value,
 		Enables:  sup.Enables,
 		Siblings: sup.Siblings,
+		NeighborIndex: sup.NeighborIndex,
+		NeighborValue: sup.NeighborValue,
 	}
 	
 	proof, err := DeserializeProof(baseProof)
@@ -113,6 +144,13 @@ func DeserializeUpdateProof(sup *SerializedUpdateProof) (*UpdateProof, error) {
 	if err != nil {
 		return nil, fmt.Errorf("invalid new leaf hex: %w", err)
 	}
+
+	op := UpdateOp(sup.Op)
+	switch op {
+	case "", OpInsert, OpUpdate, OpDelete:
+	default:
+		return nil, fmt.Errorf("invalid operation: %q", sup.Op)
+	}
 	
 	return &UpdateProof{
 		Exists:   proof.Exists,
@@ -122,9 +160,81 @@ func DeserializeUpdateProof(sup *SerializedUpdateProof) (*UpdateProof, error) {
 		Enables:  proof.Enables,
 		Siblings: proof.Siblings,
 		NewLeaf:  newLeaf,
+		Neighbor: proof.Neighbor,
+		Op:       op,
 	}, nil
 }
 
+// SerializeMultiProof converts a MultiProof to its serialized format
+func SerializeMultiProof(mp *MultiProof) *SerializedMultiProof {
+	exists := new(big.Int)
+	for i, e := range mp.Exists {
+		if e {
+			exists.SetBit(exists, i, 1)
+		}
+	}
+	
+	values := make([]string, len(mp.Values))
+	for i, value := range mp.Values {
+		values[i] = Bytes32ToHex(value)
+	}
+	
+	siblings := make([]string, len(mp.Siblings))
+	for i, sibling := range mp.Siblings {
+		siblings[i] = Bytes32ToHex(sibling)
+	}
+	
+	return &SerializedMultiProof{
+		Indices:  mp.Indices,
+		Exists:   fmt.Sprintf("0x%x", exists),
+		Values:   values,
+		Enables:  fmt.Sprintf("0x%x", mp.Enables),
+		Siblings: siblings,
+	}
+}
+
+// DeserializeMultiProof converts a SerializedMultiProof back to MultiProof
+func DeserializeMultiProof(smp *SerializedMultiProof) (*MultiProof, error) {
+	if len(smp.Values) != len(smp.Indices) {
+		return nil, fmt.Errorf("multi-proof has %d indices and %d values", len(smp.Indices), len(smp.Values))
+	}
+	
+	exists, err := DeserializeBigInt(smp.Exists)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exists hex: %s", smp.Exists)
+	}
+	if exists.BitLen() > len(smp.Indices) {
+		return nil, fmt.Errorf("exists mask %s covers more than %d indices", smp.Exists, len(smp.Indices))
+	}
+	
+	enables, err := DeserializeBigInt(smp.Enables)
+	if err != nil {
+		return nil, fmt.Errorf("invalid enables hex: %s", smp.Enables)
+	}
+	
+	mp := &MultiProof{
+		Indices:  smp.Indices,
+		Exists:   make([]bool, len(smp.Indices)),
+		Values:   make([]Bytes32, len(smp.Values)),
+		Enables:  enables,
+		Siblings: make([]Bytes32, len(smp.Siblings)),
+	}
+	for i := range mp.Exists {
+		mp.Exists[i] = exists.Bit(i) == 1
+	}
+	for i, valueHex := range smp.Values {
+		if mp.Values[i], err = HexToBytes32(valueHex); err != nil {
+			return nil, fmt.Errorf("invalid value hex at index %d: %w", i, err)
+		}
+	}
+	for i, siblingHex := range smp.Siblings {
+		if mp.Siblings[i], err = HexToBytes32(siblingHex); err != nil {
+			return nil, fmt.Errorf("invalid sibling hex at index %d: %w", i, err)
+		}
+	}
+	return mp, nil
+}
+
 // ProofToJSON converts a proof to a JSON-friendly format
 func ProofToJSON(proof *Proof) map[string]interface{} {
 	siblings := make([]string, len(proof.Siblings)) // coverage-ignore
@@ -132,7 +242,7 @@ func ProofToJSON(proof *Proof) map[string]interface{} {
 		siblings[i] = s.String()
 	}
 	
-	return map[string]interface{}{
+	result := map[string]interface{}{
 		"exists":   proof.Exists,
 		"index":    proof.Index.String(),
 		"leaf":     proof.Leaf.String(),
@@ -140,6 +250,11 @@ func ProofToJSON(proof *Proof) map[string]interface{} {
 		"enables":  fmt.Sprintf("0x%x", proof.Enables),
 		"siblings": siblings,
 	}
+	if proof.Neighbor != nil {
+		result["neighborIndex"] = proof.Neighbor.Index.String()
+		result["neighborValue"] = proof.Neighbor.Value.String()
+	}
+	return result
 }
 
 // UpdateProofToJSON converts an update proof to a JSON-friendly format
@@ -151,12 +266,33 @@ func UpdateProofToJSON(proof *UpdateProof) map[string]interface{} {
 		Value:    proof.Value,
 		Enables:  proof.Enables,
 		Siblings: proof.Siblings,
+		Neighbor: proof.Neighbor,
 	})
 	
 	base["newLeaf"] = proof.NewLeaf.String()
+	if proof.Op != "" {
+		base["op"] = string(proof.Op)
+	}
 	return base
 }
 
+// MultiProofToJSON converts a multi-proof to a JSON-friendly format
+func MultiProofToJSON(mp *MultiProof) map[string]interface{} {
+	sp := SerializeMultiProof(mp)
+	indices := make([]string, len(mp.Indices))
+	for i, index := range mp.Indices {
+		indices[i] = index.String()
+	}
+	
+	return map[string]interface{}{
+		"indices":  indices,
+		"exists":   sp.Exists,
+		"values":   sp.Values,
+		"enables":  sp.Enables,
+		"siblings": sp.Siblings,
+	}
+}
+
 // ParseHex parses a hex string with or without 0x prefix
 func ParseHex(s string) ([]byte, error) {
 	s = strings.TrimPrefix(s, "0x")
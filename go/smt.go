@@ -3,8 +3,6 @@ package smt
 import (
 	"math/big"
 	"sync"
-
-	"github.com/ethereum/go-ethereum/crypto"
 )
 
 const (
@@ -21,29 +19,149 @@ var (
 
 // SparseMerkleTree represents a Sparse Merkle Tree implementation
 type SparseMerkleTree struct {
-	db      Database
-	root    Bytes32
-	depth   uint16
-	kvStore *KVStore
-	mu      sync.RWMutex
+	db            Database
+	root          Bytes32
+	depth         uint16
+	options       *TreeOptions
+	hasher        *HashSuite // options.HashSuite with the domain tag applied
+	version       uint64
+	oldestVersion uint64
+	retention     RetentionPolicy
+	kvStore       *KVStore
+	pending       *writeBuffer
+	speculative   bool       // snapshots are open and writes wait for Commit
+	snapshots     []snapshot // open snapshots, oldest first
+	nextSnapshot  SnapshotID
+	mu            sync.RWMutex
 }
 
-// NewSparseMerkleTree creates a new Sparse Merkle Tree
+// NewSparseMerkleTree creates a new Sparse Merkle Tree.
+// If the database already holds a tree of the same depth, its root is restored.
 func NewSparseMerkleTree(db Database, depth uint16) (*SparseMerkleTree, error) {
-	if depth == 0 || depth > SMT_DEPTH {
-		return nil, &InvalidTreeDepthError{Depth: depth}
+	return NewSparseMerkleTreeWithHashSuite(db, depth, nil)
+}
+
+// NewSparseMerkleTreeWithHashSuite creates a tree hashing nodes and leaves with
+// suite; nil selects the default Keccak256 suite. An existing tree must have
+// been built with a suite of the same name.
+func NewSparseMerkleTreeWithHashSuite(db Database, depth uint16, suite *HashSuite) (*SparseMerkleTree, error) {
+	return NewSparseMerkleTreeWithOptions(db, &TreeOptions{Depth: depth, HashSuite: suite})
+}
+
+// NewSparseMerkleTreeWithOptions creates a tree configured by opts. If the
+// database already holds a tree in the namespace, its root is restored; it must
+// have been built with the same depth, hash suite, domain tag and key derivation.
+func NewSparseMerkleTreeWithOptions(db Database, opts *TreeOptions) (*SparseMerkleTree, error) {
+	if opts == nil {
+		return nil, ErrNilOptions
+	}
+	resolved, err := opts.resolve()
+	if err != nil {
+		return nil, err
 	}
 
 	if db == nil {
 		return nil, ErrNilDatabase
 	}
+	if resolved.Namespace != "" {
+		if db, err = NewNamespacedDatabase(db, resolved.Namespace); err != nil { // coverage-ignore
+			return nil, err
+		}
+	}
 
-	return &SparseMerkleTree{
+	meta, err := ReadTreeMetadata(db)
+	if err != nil {
+		return nil, err
+	}
+
+	tree := &SparseMerkleTree{
 		db:      db,
-		root:    Bytes32{},
-		depth:   depth,
-		kvStore: NewKVStore(),
-	}, nil
+		depth:   resolved.Depth,
+		options: resolved,
+		hasher:  resolved.EffectiveHashSuite(),
+	}
+	tree.kvStore = NewPersistentKVStore(treeDatabase{smt: tree})
+
+	if meta != nil {
+		if meta.Version < minTreeFormatVersion || meta.Version > TreeFormatVersion {
+			return nil, &FormatVersionError{Version: meta.Version}
+		}
+		if meta.Depth != resolved.Depth {
+			return nil, &DepthMismatchError{Stored: meta.Depth, Requested: resolved.Depth}
+		}
+		if err := checkOptionRecords(db, resolved); err != nil {
+			return nil, err
+		}
+		tree.root = meta.Root
+		tree.version = meta.LatestVersion
+		tree.oldestVersion = meta.OldestVersion
+	}
+	if tree.retention, err = readRetentionPolicy(db); err != nil {
+		return nil, err
+	}
+
+	if meta != nil && meta.Version < TreeFormatVersion {
+		if err := tree.upgradeFormat(meta.Version); err != nil { // coverage-ignore
+			return nil, err
+		}
+	}
+
+	return tree, nil
+}
+
+// upgradeFormat brings a tree written by an older format version up to date:
+// reference counts are derived once and the current root becomes version 1
+func (smt *SparseMerkleTree) upgradeFormat(from uint8) error {
+	smt.beginWrite()
+
+	var err error
+	if from < 2 {
+		err = smt.rebuildRefCounts()
+	}
+	if err == nil {
+		err = smt.recordVersion()
+	}
+	if err != nil { // coverage-ignore
+		smt.discardWrite()
+		return err
+	}
+
+	return smt.commitWrite()
+}
+
+// OpenSparseMerkleTree reopens a tree previously persisted in the database,
+// restoring its root, depth and options from the metadata records. Trees built
+// with a custom hash suite or key derivation, or stored in a namespace, are
+// reopened with NewSparseMerkleTreeWithOptions.
+func OpenSparseMerkleTree(db Database) (*SparseMerkleTree, error) {
+	meta, err := ReadTreeMetadata(db)
+	if err != nil {
+		return nil, err
+	}
+
+	if meta == nil {
+		return nil, ErrTreeNotFound
+	}
+
+	if meta.Version < minTreeFormatVersion || meta.Version > TreeFormatVersion {
+		return nil, &FormatVersionError{Version: meta.Version}
+	}
+
+	if meta.Depth == 0 || meta.Depth > SMT_DEPTH {
+		return nil, &InvalidTreeDepthError{Depth: meta.Depth}
+	}
+
+	opts, err := ReadTreeOptions(db)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewSparseMerkleTreeWithOptions(db, opts)
+}
+
+// HashSuite returns the hash suite the tree's proofs verify with, including its domain tag
+func (smt *SparseMerkleTree) HashSuite() *HashSuite {
+	return smt.hasher
 }
 
 // Root returns the current root hash
@@ -82,7 +200,7 @@ func (smt *SparseMerkleTree) Exists(index *big.Int) (bool, error) {
 		}
 
 		// Check if we're at a leaf
-		if node.IsEmpty() { // coverage-ignore
+		if node.IsEmpty() {
 			// Check if this is a valid leaf for the requested index
 			leafData, err := smt.getLeaf(current)
 			if err != nil {
@@ -117,104 +235,7 @@ func (smt *SparseMerkleTree) Get(index *big.Int) (*Proof, error) {
 	smt.mu.RLock()
 	defer smt.mu.RUnlock()
 
-	if err := smt.validateIndex(index); err != nil { // coverage-ignore
-		return nil, err
-	}
-
-	enables := big.NewInt(0)
-	siblings := make([]Bytes32, 0, smt.depth)
-	current := smt.root
-
-	// Walk down the tree collecting siblings
-	for i := smt.depth - 1; i >= 0 && i < smt.depth; i-- {
-		if current.IsZero() {
-			break
-		}
-
-		// Get node from database
-		node, err := smt.getNode(current)
-		if err != nil { // coverage-ignore
-			return nil, err
-		}
-
-		// Check if we're at a leaf
-		if node.IsEmpty() { // coverage-ignore
-			// We've reached a leaf
-			leafData, err := smt.getLeaf(current)
-			if err != nil { // coverage-ignore
-				return nil, err
-			}
-
-			if leafData != nil && leafData.Index.Cmp(index) == 0 {
-				// Found the exact leaf we're looking for
-				leafHash := ComputeLeafHash(leafData.Index, leafData.Value)
-				return &Proof{
-					Exists:   true,
-					Leaf:     leafHash,        // Store computed leaf hash
-					Value:    leafData.Value,  // Store raw value
-					Index:    index,
-					Enables:  enables,
-					Siblings: siblings,
-				}, nil
-			}
-
-			return &Proof{ // coverage-ignore
-				Exists:   false,
-				Leaf:     Bytes32{},
-				Value:    Bytes32{},
-				Index:    index,
-				Enables:  enables,
-				Siblings: siblings,
-			}, nil
-		}
-
-		// Collect sibling
-		bit := GetBit(index, uint(i))
-		var sibling Bytes32
-		if bit == 0 {
-			sibling = node.Right
-			current = node.Left
-		} else {
-			sibling = node.Left
-			current = node.Right
-		}
-
-		// Prepend non-zero siblings (to match Go implementation)
-		if !sibling.IsZero() {
-			siblings = append([]Bytes32{sibling}, siblings...)
-			enables = SetBit(enables, uint(i), 1)
-		}
-	}
-
-	// Check final leaf
-	if !current.IsZero() {
-		leafData, err := smt.getLeaf(current)
-		if err != nil { // coverage-ignore
-			return nil, err
-		}
-
-		if leafData != nil && leafData.Index.Cmp(index) == 0 {
-			// Found the exact leaf we're looking for
-			leafHash := ComputeLeafHash(leafData.Index, leafData.Value)
-			return &Proof{
-				Exists:   true,
-				Leaf:     leafHash,        // Store computed leaf hash
-				Value:    leafData.Value,  // Store raw value
-				Index:    index,
-				Enables:  enables,
-				Siblings: siblings,
-			}, nil
-		}
-	}
-
-	return &Proof{
-		Exists:   false,
-		Leaf:     Bytes32{},
-		Value:    Bytes32{},
-		Index:    index,
-		Enables:  enables,
-		Siblings: siblings,
-	}, nil
+	return smt.get(index)
 }
 
 // insertInternal performs insert without locking (for internal use)
@@ -236,7 +257,9 @@ func (smt *SparseMerkleTree) Insert(index *big.Int, leaf Bytes32) (*UpdateProof,
 	smt.mu.Lock()
 	defer smt.mu.Unlock()
 
-	return smt.insertInternal(index, leaf)
+	smt.beginWrite()
+	proof, err := smt.insertInternal(index, leaf)
+	return smt.finishWrite(proof, err)
 }
 
 // updateInternal performs update without locking (for internal use)
@@ -258,7 +281,9 @@ func (smt *SparseMerkleTree) Update(index *big.Int, newLeaf Bytes32) (*UpdatePro
 	smt.mu.Lock()
 	defer smt.mu.Unlock()
 
-	return smt.updateInternal(index, newLeaf)
+	smt.beginWrite()
+	proof, err := smt.updateInternal(index, newLeaf)
+	return smt.finishWrite(proof, err)
 }
 
 // deleteInternal performs delete without locking (for internal use)
@@ -282,15 +307,32 @@ func (smt *SparseMerkleTree) deleteInternal(index *big.Int) (*UpdateProof, error
 		return nil, err
 	}
 
-	// Delete the leaf from database
-	if !oldProof.Leaf.IsZero() {
-		if err := smt.deleteLeaf(oldProof.Leaf); err != nil { // coverage-ignore
+	// In a compact tree a leaf sibling moves up to replace the parent
+	neighbor := oldProof.Neighbor
+	if smt.options.Compact {
+		if neighbor, err = smt.compactCollapsingSibling(oldProof); err != nil { // coverage-ignore
 			return nil, err
 		}
 	}
 
-	// Update the tree to remove the leaf and cleanup empty nodes
-	smt.root = smt.deleteAndRebuild(smt.root, index, 0)
+	// Drop the index mapping; the leaf record goes with its last reference
+	if err := smt.deleteLeafIndex(index); err != nil { // coverage-ignore
+		return nil, err
+	}
+
+	// Rebuild the path without the leaf, then release the old path
+	var newRoot Bytes32
+	if smt.options.Compact {
+		newRoot, err = smt.compactDelete(smt.root, smt.depth, index)
+	} else {
+		newRoot, err = smt.deleteAndRebuild(smt.root, index, 0)
+	}
+	if err != nil { // coverage-ignore
+		return nil, err
+	}
+	if err := smt.replaceRoot(newRoot); err != nil {
+		return nil, err
+	}
 
 	// Return update proof
 	return &UpdateProof{
@@ -301,52 +343,58 @@ func (smt *SparseMerkleTree) deleteInternal(index *big.Int) (*UpdateProof, error
 		Enables:  oldProof.Enables,
 		Siblings: oldProof.Siblings,
 		NewLeaf:  Bytes32{}, // Deleted leaf is zero
+		Neighbor: neighbor,
+		Op:       OpDelete,
 	}, nil
 }
 
-// deleteAndRebuild recursively deletes a node and rebuilds tree structure
-func (smt *SparseMerkleTree) deleteAndRebuild(nodeHash Bytes32, index *big.Int, depth uint16) Bytes32 {
-	if nodeHash.IsZero() || depth >= smt.depth {
-		return Bytes32{} // Already empty or at max depth
+// deleteAndRebuild returns the root of the subtree at nodeHash with the leaf at
+// index removed, storing the rebuilt nodes. Replaced nodes are left in place and
+// reclaimed when the old root is released.
+// level counts down from the root, so the branching bit is depth-1-level.
+func (smt *SparseMerkleTree) deleteAndRebuild(nodeHash Bytes32, index *big.Int, level uint16) (Bytes32, error) {
+	if nodeHash.IsZero() || level >= smt.depth {
+		return Bytes32{}, nil // Already empty or at max depth
 	}
 
 	node, err := smt.getNode(nodeHash)
 	if err != nil { // coverage-ignore
-		return nodeHash // Keep original on error
+		return Bytes32{}, err
 	}
 
 	// If this is a leaf node, check if it's the one to delete
 	if node.IsEmpty() { // coverage-ignore
 		leafData, err := smt.getLeaf(nodeHash)
 		if err != nil { // coverage-ignore
-			return nodeHash
+			return Bytes32{}, err
 		}
 		if leafData != nil && leafData.Index.Cmp(index) == 0 {
 			// This is the leaf to delete
-			smt.deleteNode(nodeHash) // This will now have coverage!
-			return Bytes32{}         // Return zero hash
+			return Bytes32{}, nil // Return zero hash
 		}
-		return nodeHash // Not the target leaf
+		return nodeHash, nil // Not the target leaf
 	}
 
 	// Navigate down the appropriate child
-	bit := GetBit(index, uint(depth))
+	bit := GetBit(index, uint(smt.depth-1-level))
 	var newLeft, newRight Bytes32
 
 	if bit == 0 {
 		// Delete from left subtree
-		newLeft = smt.deleteAndRebuild(node.Left, index, depth+1)
+		newLeft, err = smt.deleteAndRebuild(node.Left, index, level+1)
 		newRight = node.Right
 	} else {
 		// Delete from right subtree
 		newLeft = node.Left
-		newRight = smt.deleteAndRebuild(node.Right, index, depth+1)
+		newRight, err = smt.deleteAndRebuild(node.Right, index, level+1)
+	}
+	if err != nil { // coverage-ignore
+		return Bytes32{}, err
 	}
 
-	// If both children are zero, this node should be deleted
+	// If both children are zero, the subtree is empty
 	if newLeft.IsZero() && newRight.IsZero() {
-		smt.deleteNode(nodeHash) // This will have coverage!
-		return Bytes32{}
+		return Bytes32{}, nil
 	}
 
 	// If only one child remains, we might want to collapse the tree
@@ -354,16 +402,14 @@ func (smt *SparseMerkleTree) deleteAndRebuild(nodeHash Bytes32, index *big.Int,
 	if newLeft != node.Left || newRight != node.Right {
 		// Node changed, create new node
 		newNode := &Node{Left: newLeft, Right: newRight}
-		newNodeHash := HashBytes32(newLeft, newRight)
-		if err := smt.setNode(newNodeHash, newNode); err != nil { // coverage-ignore
-			return nodeHash // Keep original on error
+		newNodeHash := smt.hasher.HashNode(newLeft, newRight)
+		if err := smt.putNode(newNodeHash, newNode); err != nil { // coverage-ignore
+			return Bytes32{}, err
 		}
-		// Delete old node
-		smt.deleteNode(nodeHash) // This will have coverage!
-		return newNodeHash
+		return newNodeHash, nil
 	}
 
-	return nodeHash // No changes
+	return nodeHash, nil // No changes
 }
 
 // Delete removes a leaf from the tree
@@ -371,78 +417,72 @@ func (smt *SparseMerkleTree) Delete(index *big.Int) (*UpdateProof, error) {
 	smt.mu.Lock()
 	defer smt.mu.Unlock()
 
-	return smt.deleteInternal(index)
+	smt.beginWrite()
+	proof, err := smt.deleteInternal(index)
+	return smt.finishWrite(proof, err)
 }
 
 // DeleteKV deletes a key-value pair from the tree
 func (smt *SparseMerkleTree) DeleteKV(key string) (*UpdateProof, error) {
-	// Compute index from key and truncate to tree depth
-	hash := crypto.Keccak256([]byte(key))
-	index := new(big.Int).SetBytes(hash)
-	
-	// Truncate index to fit within tree depth
-	if smt.depth < SMT_DEPTH {
-		maxIndex := new(big.Int).Lsh(ONE, uint(smt.depth))
-		index.Mod(index, maxIndex)
-	}
+	index := smt.indexForKey(key)
 
 	smt.mu.Lock()
 	defer smt.mu.Unlock()
 
 	// Check if key exists in KV store
-	if !smt.kvStore.Has(key) { // coverage-ignore
+	_, exists, err := smt.kvStore.Load(key)
+	if err != nil { // coverage-ignore
+		return nil, err
+	}
+	if !exists { // coverage-ignore
 		return nil, &KeyNotFoundError{Index: index}
 	}
 
+	smt.beginWrite()
+
 	// Delete from tree
 	proof, err := smt.deleteInternal(index)
-	if err != nil { // coverage-ignore
-		return nil, err
+	if err == nil {
+		// Remove from KV store
+		err = smt.kvStore.Remove(key)
 	}
 
-	// Remove from KV store
-	smt.kvStore.Delete(key)
-
-	return proof, nil
+	return smt.finishWrite(proof, err)
 }
 
 // InsertKV inserts a key-value pair into the tree
 func (smt *SparseMerkleTree) InsertKV(key string, value Bytes32) (*UpdateProof, error) {
-	// Compute index from key and truncate to tree depth
-	hash := crypto.Keccak256([]byte(key))
-	index := new(big.Int).SetBytes(hash)
+	index := smt.indexForKey(key)
 
-	// Truncate index to fit within tree depth
-	if smt.depth < SMT_DEPTH {
-		maxIndex := new(big.Int).Lsh(ONE, uint(smt.depth))
-		index.Mod(index, maxIndex)
-	}
+	smt.mu.Lock()
+	defer smt.mu.Unlock()
 
-	// Store in KV store
-	smt.kvStore.Set(key, value)
+	smt.beginWrite()
 
 	// Insert the value directly - ComputeLeafHash will be called inside Insert
-	return smt.Insert(index, value)
+	proof, err := smt.insertInternal(index, value)
+	if err == nil {
+		// Store in KV store
+		err = smt.kvStore.Store(key, value)
+	}
+
+	return smt.finishWrite(proof, err)
 }
 
 // GetKV retrieves a value by key
 func (smt *SparseMerkleTree) GetKV(key string) (Bytes32, bool, error) {
-	value, exists := smt.kvStore.Get(key)
+	smt.mu.RLock()
+	value, exists, err := smt.kvStore.Load(key)
+	smt.mu.RUnlock()
+	if err != nil { // coverage-ignore
+		return Bytes32{}, false, err
+	}
 	if !exists { // coverage-ignore
 		return Bytes32{}, false, nil
 	}
 
 	// Verify it exists in the tree with truncated index
-	hash := crypto.Keccak256([]byte(key))
-	index := new(big.Int).SetBytes(hash)
-
-	// Truncate index to fit within tree depth
-	if smt.depth < SMT_DEPTH {
-		maxIndex := new(big.Int).Lsh(ONE, uint(smt.depth))
-		index.Mod(index, maxIndex)
-	}
-
-	treeExists, err := smt.Exists(index)
+	treeExists, err := smt.Exists(smt.indexForKey(key))
 	if err != nil { // coverage-ignore
 		return Bytes32{}, false, err
 	}
@@ -452,37 +492,46 @@ func (smt *SparseMerkleTree) GetKV(key string) (Bytes32, bool, error) {
 
 // UpdateKV updates a key-value pair in the tree
 func (smt *SparseMerkleTree) UpdateKV(key string, value Bytes32) (*UpdateProof, error) {
-	// Compute index from key and truncate to tree depth
-	hash := crypto.Keccak256([]byte(key))
-	index := new(big.Int).SetBytes(hash)
+	index := smt.indexForKey(key)
 
-	// Truncate index to fit within tree depth
-	if smt.depth < SMT_DEPTH {
-		maxIndex := new(big.Int).Lsh(ONE, uint(smt.depth))
-		index.Mod(index, maxIndex)
-	}
+	smt.mu.Lock()
+	defer smt.mu.Unlock()
 
 	// Check if key exists in KV store
-	_, exists := smt.kvStore.Get(key)
+	_, exists, err := smt.kvStore.Load(key)
+	if err != nil { // coverage-ignore
+		return nil, err
+	}
 	if !exists { // coverage-ignore
 		return nil, &KeyNotFoundError{Index: index}
 	}
 
-	// Update in KV store
-	smt.kvStore.Set(key, value)
+	smt.beginWrite()
 
 	// Update the value directly
-	return smt.Update(index, value)
+	proof, err := smt.updateInternal(index, value)
+	if err == nil {
+		// Update in KV store
+		err = smt.kvStore.Store(key, value)
+	}
+
+	return smt.finishWrite(proof, err)
 }
 
 // VerifyProof verifies a proof against the current root
 func (smt *SparseMerkleTree) VerifyProof(proof *Proof) bool {
-	return VerifyProof(smt.root, smt.depth, proof)
+	if smt.options.Compact {
+		return VerifyCompactProof(smt.root, smt.depth, proof, smt.hasher)
+	}
+	return VerifyProof(smt.root, smt.depth, proof, smt.hasher)
 }
 
 // ComputeRoot computes the root from a proof
 func (smt *SparseMerkleTree) ComputeRoot(proof *Proof) Bytes32 {
-	return ComputeRootFromProof(smt.depth, proof)
+	if smt.options.Compact {
+		return ComputeRootFromCompactProof(smt.depth, proof, smt.hasher)
+	}
+	return ComputeRootFromProof(smt.depth, proof, smt.hasher)
 }
 
 // GetLeafHashByIndex retrieves the hash of a leaf by its index (public method)
@@ -494,6 +543,41 @@ func (smt *SparseMerkleTree) GetLeafHashByIndex(index *big.Int) (Bytes32, error)
 
 // Private helper methods
 
+// finishWrite commits the writes staged for a logical operation, or discards
+// them if the operation failed
+func (smt *SparseMerkleTree) finishWrite(proof *UpdateProof, err error) (*UpdateProof, error) {
+	if err == nil {
+		err = smt.recordVersion()
+	}
+	if err != nil {
+		smt.discardWrite()
+		return nil, err
+	}
+
+	if err := smt.commitWrite(); err != nil {
+		return nil, err
+	}
+
+	return proof, nil
+}
+
+// indexForKey computes the tree index for a KV key, truncated to the tree depth
+func (smt *SparseMerkleTree) indexForKey(key string) *big.Int {
+	return deriveIndex(smt.options.KeyDerivation, smt.depth, key)
+}
+
+// deriveIndex maps a KV key to its index in a tree of the given depth
+func deriveIndex(kd *KeyDerivation, depth uint16, key string) *big.Int {
+	index := new(big.Int).SetBytes(kd.Derive([]byte(key)))
+
+	if depth < SMT_DEPTH {
+		maxIndex := new(big.Int).Lsh(ONE, uint(depth))
+		index.Mod(index, maxIndex)
+	}
+
+	return index
+}
+
 func (smt *SparseMerkleTree) validateIndex(index *big.Int) error {
 	if index.Sign() < 0 {
 		return &OutOfRangeError{Index: index, TreeDepth: smt.depth}
@@ -566,9 +650,9 @@ func (smt *SparseMerkleTree) upsert(index *big.Int, newLeaf Bytes32) (*UpdatePro
 	}
 
 	// Compute new leaf hash
-	leafHash := ComputeLeafHash(index, newLeaf)
+	leafHash := smt.hasher.HashLeaf(index, newLeaf)
 
-	// Store new leaf data
+	// Store new leaf data; the old leaf record is released with the old root
 	leafData := &LeafData{
 		Index: index,
 		Value: newLeaf,
@@ -577,6 +661,23 @@ func (smt *SparseMerkleTree) upsert(index *big.Int, newLeaf Bytes32) (*UpdatePro
 		return nil, err
 	}
 
+	if smt.options.Compact {
+		if err := smt.compactUpsert(index, leafHash); err != nil {
+			return nil, err
+		}
+		return &UpdateProof{
+			Exists:   oldProof.Exists,
+			Leaf:     oldProof.Leaf,
+			Value:    oldProof.Value,
+			Index:    oldProof.Index,
+			Enables:  oldProof.Enables,
+			Siblings: oldProof.Siblings,
+			NewLeaf:  leafHash,
+			Neighbor: oldProof.Neighbor,
+			Op:       upsertOp(oldProof.Exists),
+		}, nil
+	}
+
 	// Special case: if we had an existing leaf and we're inserting a different leaf,
 	// we need to create the tree structure to accommodate both leaves
 	//
@@ -612,8 +713,8 @@ func (smt *SparseMerkleTree) upsert(index *big.Int, newLeaf Bytes32) (*UpdatePro
 			node.Right = leafHash     // New leaf
 		}
 
-		parent = HashBytes32(node.Left, node.Right)
-		if err := smt.setNode(parent, node); err != nil {
+		parent = smt.hasher.HashNode(node.Left, node.Right)
+		if err := smt.putNode(parent, node); err != nil {
 			return nil, err
 		}
 
@@ -633,8 +734,8 @@ func (smt *SparseMerkleTree) upsert(index *big.Int, newLeaf Bytes32) (*UpdatePro
 				node.Right = current
 			}
 
-			parent = HashBytes32(node.Left, node.Right)
-			if err := smt.setNode(parent, node); err != nil { // coverage-ignore
+			parent = smt.hasher.HashNode(node.Left, node.Right)
+			if err := smt.putNode(parent, node); err != nil { // coverage-ignore
 				return nil, err
 			}
 
@@ -642,7 +743,9 @@ func (smt *SparseMerkleTree) upsert(index *big.Int, newLeaf Bytes32) (*UpdatePro
 		}
 
 		// Update root
-		smt.root = current
+		if err := smt.replaceRoot(current); err != nil {
+			return nil, err
+		}
 	} else {
 		// Normal case: rebuild tree from leaf to root using the proof siblings
 		current := leafHash
@@ -671,11 +774,11 @@ func (smt *SparseMerkleTree) upsert(index *big.Int, newLeaf Bytes32) (*UpdatePro
 				node.Right = current
 			}
 
-			parent = HashBytes32(node.Left, node.Right)
+			parent = smt.hasher.HashNode(node.Left, node.Right)
 
 			// Only store non-trivial nodes (not just zero + something)
 			if !node.Left.IsZero() || !node.Right.IsZero() {
-				if err := smt.setNode(parent, node); err != nil { // coverage-ignore
+				if err := smt.putNode(parent, node); err != nil { // coverage-ignore
 					return nil, err
 				}
 			}
@@ -684,12 +787,7 @@ func (smt *SparseMerkleTree) upsert(index *big.Int, newLeaf Bytes32) (*UpdatePro
 		}
 
 		// Update root
-		smt.root = current
-	}
-
-	// Delete old leaf after rebuilding the tree structure
-	if oldProof.Exists && !oldProof.Leaf.IsZero() && oldProof.Leaf != leafHash {
-		if err := smt.deleteLeaf(oldProof.Leaf); err != nil { // coverage-ignore
+		if err := smt.replaceRoot(current); err != nil {
 			return nil, err
 		}
 	}
@@ -702,18 +800,33 @@ func (smt *SparseMerkleTree) upsert(index *big.Int, newLeaf Bytes32) (*UpdatePro
 		Enables:  oldProof.Enables,
 		Siblings: oldProof.Siblings,
 		NewLeaf:  leafHash,         // Use the computed leaf hash, not raw value
+		Op:       upsertOp(oldProof.Exists),
 	}, nil
 }
 
+// upsertOp returns the operation that writes a leaf to an index that did or
+// did not hold one
+func upsertOp(exists bool) UpdateOp {
+	if exists {
+		return OpUpdate
+	}
+	return OpInsert
+}
+
 func (smt *SparseMerkleTree) get(index *big.Int) (*Proof, error) {
 	// Internal get without lock
+	return smt.proofAt(smt.root, index)
+}
+
+// proofAt builds the proof for index against an arbitrary stored root
+func (smt *SparseMerkleTree) proofAt(root Bytes32, index *big.Int) (*Proof, error) {
 	if err := smt.validateIndex(index); err != nil { // coverage-ignore
 		return nil, err
 	}
 
 	enables := big.NewInt(0)
 	siblings := make([]Bytes32, 0, smt.depth)
-	current := smt.root
+	current := root
 
 	// Walk down the tree collecting siblings
 	for i := smt.depth - 1; i >= 0 && i < smt.depth; i-- {
@@ -728,7 +841,7 @@ func (smt *SparseMerkleTree) get(index *big.Int) (*Proof, error) {
 		}
 
 		// Check if we're at a leaf
-		if node.IsEmpty() { // coverage-ignore
+		if node.IsEmpty() {
 			// We've reached a leaf
 			leafData, err := smt.getLeaf(current)
 			if err != nil { // coverage-ignore
@@ -737,7 +850,7 @@ func (smt *SparseMerkleTree) get(index *big.Int) (*Proof, error) {
 
 			if leafData != nil && leafData.Index.Cmp(index) == 0 {
 				// Found the exact leaf we're looking for
-				leafHash := ComputeLeafHash(leafData.Index, leafData.Value)
+				leafHash := smt.hasher.HashLeaf(leafData.Index, leafData.Value)
 				return &Proof{
 					Exists:   true,
 					Leaf:     leafHash,        // Store computed leaf hash
@@ -748,13 +861,15 @@ func (smt *SparseMerkleTree) get(index *big.Int) (*Proof, error) {
 				}, nil
 			}
 
-			return &Proof{ // coverage-ignore
+			// In a compact tree the path can end at a leaf with another index
+			return &Proof{
 				Exists:   false,
 				Leaf:     Bytes32{},
 				Value:    Bytes32{},
 				Index:    index,
 				Enables:  enables,
 				Siblings: siblings,
+				Neighbor: leafData,
 			}, nil
 		}
 
@@ -785,7 +900,7 @@ func (smt *SparseMerkleTree) get(index *big.Int) (*Proof, error) {
 
 		if leafData != nil && leafData.Index.Cmp(index) == 0 {
 			// Found the exact leaf we're looking for
-			leafHash := ComputeLeafHash(leafData.Index, leafData.Value)
+			leafHash := smt.hasher.HashLeaf(leafData.Index, leafData.Value)
 			return &Proof{
 				Exists:   true,
 				Leaf:     leafHash,        // Store computed leaf hash
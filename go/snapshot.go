@@ -0,0 +1,64 @@
+package smt
+
+import "sort"
+
+// SnapshotID identifies a snapshot taken with Snapshot
+type SnapshotID int
+
+// snapshot is an open snapshot and the state it reverts to
+type snapshot struct {
+	id   SnapshotID
+	mark stateMark
+}
+
+// Snapshot marks the current state so it can be restored with RevertToSnapshot.
+// From the first snapshot on, changes are kept in memory instead of being
+// written to the database until Commit is called. Snapshots nest: reverting to
+// one also discards every snapshot taken after it.
+func (smt *SparseMerkleTree) Snapshot() SnapshotID {
+	smt.mu.Lock()
+	defer smt.mu.Unlock()
+
+	smt.startStaging()
+	smt.speculative = true
+
+	id := smt.nextSnapshot
+	smt.nextSnapshot++
+	smt.snapshots = append(smt.snapshots, snapshot{id: id, mark: smt.markState()})
+	return id
+}
+
+// RevertToSnapshot restores the root, the node, leaf and index records and the
+// KV entries to the state when the snapshot was taken. Changes remain uncommitted
+// until Commit.
+func (smt *SparseMerkleTree) RevertToSnapshot(id SnapshotID) error {
+	smt.mu.Lock()
+	defer smt.mu.Unlock()
+
+	i := sort.Search(len(smt.snapshots), func(i int) bool {
+		return smt.snapshots[i].id >= id
+	})
+	if i == len(smt.snapshots) || smt.snapshots[i].id != id {
+		return ErrSnapshotNotFound
+	}
+
+	smt.revertTo(smt.snapshots[i].mark)
+	smt.snapshots = smt.snapshots[:i]
+	return nil
+}
+
+// Commit writes every change made since the first snapshot to the database in
+// a single batch and discards all snapshots. If the write fails, the tree
+// returns to its last committed state.
+func (smt *SparseMerkleTree) Commit() error {
+	smt.mu.Lock()
+	defer smt.mu.Unlock()
+
+	if !smt.speculative {
+		return nil
+	}
+
+	smt.speculative = false
+	smt.snapshots = nil
+	return smt.flushWrite()
+}
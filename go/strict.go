@@ -0,0 +1,158 @@
+package smt
+
+import (
+	"fmt"
+	"math/big"
+	"math/bits"
+)
+
+// Strict verification rejects proofs that the lenient verifiers accept because
+// the extra data does not change the computed root: siblings beyond those the
+// enable bits call for, enable bits at or beyond the depth, zero siblings,
+// leaf hashes that do not match the index and value, and indices out of range.
+// Every failure is a typed error that matches ErrInvalidProof with errors.Is.
+
+// GetNonMembershipProof returns a proof that no leaf is stored at index, or a
+// *KeyExistsError if one is. Proofs from compact trees may carry a Neighbor and
+// are verified with VerifyNonMembershipProofWithOptions.
+func (smt *SparseMerkleTree) GetNonMembershipProof(index *big.Int) (*NonMembershipProof, error) {
+	smt.mu.RLock()
+	defer smt.mu.RUnlock()
+
+	proof, err := smt.get(index)
+	if err != nil {
+		return nil, err
+	}
+	if proof.Exists {
+		return nil, &KeyExistsError{Index: index}
+	}
+
+	return &NonMembershipProof{
+		Index:    proof.Index,
+		Enables:  proof.Enables,
+		Siblings: proof.Siblings,
+		Neighbor: proof.Neighbor,
+	}, nil
+}
+
+// VerifyProofStrict verifies a membership or non-membership proof from a
+// full-depth tree against root, using the optional hash suite, Keccak256 by
+// default. It returns nil if the proof is well formed and computes root.
+func VerifyProofStrict(root Bytes32, depth uint16, proof *Proof, suite ...*HashSuite) error {
+	hasher := resolveHashSuite(suite)
+	if err := checkProof(depth, proof, hasher); err != nil {
+		return err
+	}
+	if proof.Neighbor != nil {
+		return fmt.Errorf("%w: neighbor in a full-depth proof", ErrInvalidProof)
+	}
+
+	if computed := ComputeRootFromProof(depth, proof, hasher); computed != root {
+		return &RootMismatchError{Expected: root, Computed: computed}
+	}
+	return nil
+}
+
+// VerifyNonMembershipProof strictly verifies that a full-depth tree with the
+// given root holds no leaf at the proof's index. It rejects the Neighbor of a
+// compact tree's proof; use VerifyNonMembershipProofWithOptions for those.
+func VerifyNonMembershipProof(root Bytes32, depth uint16, proof *NonMembershipProof, suite ...*HashSuite) error {
+	if proof == nil {
+		return fmt.Errorf("%w: nil proof", ErrInvalidProof)
+	}
+	return VerifyProofStrict(root, depth, proof.Proof(), suite...)
+}
+
+// VerifyNonMembershipProofWithOptions strictly verifies that a tree built with
+// opts, compact or not, holds no leaf at the proof's index
+func VerifyNonMembershipProofWithOptions(root Bytes32, proof *NonMembershipProof, opts *TreeOptions) error {
+	if proof == nil {
+		return fmt.Errorf("%w: nil proof", ErrInvalidProof)
+	}
+	return VerifyProofStrictWithOptions(root, proof.Proof(), opts)
+}
+
+// VerifyProofStrictWithOptions strictly verifies a proof from a tree built with opts
+func VerifyProofStrictWithOptions(root Bytes32, proof *Proof, opts *TreeOptions) error {
+	if !opts.Compact {
+		return VerifyProofStrict(root, opts.Depth, proof, opts.EffectiveHashSuite())
+	}
+
+	hasher := opts.EffectiveHashSuite()
+	if err := checkProof(opts.Depth, proof, hasher); err != nil {
+		return err
+	}
+	if proof.Exists && proof.Neighbor != nil {
+		return fmt.Errorf("%w: neighbor in a membership proof", ErrInvalidProof)
+	}
+
+	// A neighbour off the proof's path computes a zero root
+	if computed := ComputeRootFromCompactProof(opts.Depth, proof, hasher); computed != root || (computed.IsZero() && proof.Neighbor != nil) {
+		return &RootMismatchError{Expected: root, Computed: computed}
+	}
+	return nil
+}
+
+// VerifyProofStrict strictly verifies a proof against the current root
+func (smt *SparseMerkleTree) VerifyProofStrict(proof *Proof) error {
+	smt.mu.RLock()
+	defer smt.mu.RUnlock()
+
+	if smt.options.Compact {
+		return VerifyProofStrictWithOptions(smt.root, proof, smt.options)
+	}
+	return VerifyProofStrict(smt.root, smt.depth, proof, smt.hasher)
+}
+
+// checkProof checks the shape of a proof for a tree of the given depth
+func checkProof(depth uint16, proof *Proof, hasher *HashSuite) error {
+	if proof == nil || proof.Index == nil {
+		return fmt.Errorf("%w: nil proof or index", ErrInvalidProof)
+	}
+	if proof.Index.Sign() < 0 || proof.Index.BitLen() > int(depth) {
+		// OutOfRangeError does not match ErrInvalidProof on its own
+		return fmt.Errorf("%w: %w", ErrInvalidProof, &OutOfRangeError{Index: proof.Index, TreeDepth: depth})
+	}
+
+	enables := proof.Enables
+	if enables == nil {
+		enables = big.NewInt(0)
+	}
+	if enables.Sign() < 0 {
+		return fmt.Errorf("%w: negative enables", ErrInvalidProof)
+	}
+	if enables.BitLen() > int(depth) {
+		return &EnableBitError{Bit: enables.BitLen() - 1, Depth: depth}
+	}
+	if enabled := popCount(enables); enabled != len(proof.Siblings) {
+		return &SiblingCountError{Siblings: len(proof.Siblings), Enabled: enabled}
+	}
+	for i, sibling := range proof.Siblings {
+		if sibling.IsZero() {
+			return fmt.Errorf("%w: sibling %d is zero", ErrInvalidProof, i)
+		}
+	}
+
+	if proof.Exists {
+		if computed := hasher.HashLeaf(proof.Index, proof.Value); proof.Leaf != computed {
+			return &LeafHashMismatchError{Leaf: proof.Leaf, Computed: computed}
+		}
+		return nil
+	}
+	if !proof.Leaf.IsZero() {
+		return &LeafHashMismatchError{Leaf: proof.Leaf}
+	}
+	if !proof.Value.IsZero() {
+		return fmt.Errorf("%w: value in a non-membership proof", ErrInvalidProof)
+	}
+	return nil
+}
+
+// popCount returns the number of bits set in a non-negative x
+func popCount(x *big.Int) int {
+	count := 0
+	for _, word := range x.Bits() {
+		count += bits.OnesCount(uint(word))
+	}
+	return count
+}
@@ -0,0 +1,256 @@
+package tests
+
+import (
+	"errors"
+	"math/big"
+	"math/rand"
+	"testing"
+
+	smt "github.com/0xanonymeow/smt/go"
+)
+
+// randomWrites returns count writes over a small pool of indices, including
+// repeated indices and deletes of leaves present at that point of the batch
+func randomWrites(seed int64, count int, bits uint, present map[string]bool) []smt.LeafWrite {
+	rng := rand.New(rand.NewSource(seed))
+	max := new(big.Int).Lsh(big.NewInt(1), bits)
+	pool := make([]*big.Int, count/2+1)
+	for i := range pool {
+		pool[i] = new(big.Int).Rand(rng, max)
+	}
+
+	writes := make([]smt.LeafWrite, 0, count)
+	for len(writes) < count {
+		index := pool[rng.Intn(len(pool))]
+		if present[index.String()] && rng.Intn(4) == 0 {
+			writes = append(writes, smt.LeafWrite{Index: index, Delete: true})
+			present[index.String()] = false
+			continue
+		}
+		var value smt.Bytes32
+		rng.Read(value[:])
+		writes = append(writes, smt.LeafWrite{Index: index, Value: value})
+		present[index.String()] = true
+	}
+	return writes
+}
+
+// applyOneByOne applies writes with Insert, Update and Delete, returning their proofs
+func applyOneByOne(t *testing.T, tree *smt.SparseMerkleTree, writes []smt.LeafWrite) []*smt.UpdateProof {
+	t.Helper()
+	proofs := make([]*smt.UpdateProof, len(writes))
+	for i, w := range writes {
+		exists, err := tree.Exists(w.Index)
+		if err != nil {
+			t.Fatalf("Exists failed: %v", err)
+		}
+		switch {
+		case w.Delete:
+			proofs[i], err = tree.Delete(w.Index)
+		case exists:
+			proofs[i], err = tree.Update(w.Index, w.Value)
+		default:
+			proofs[i], err = tree.Insert(w.Index, w.Value)
+		}
+		if err != nil {
+			t.Fatalf("Write %d failed: %v", i, err)
+		}
+	}
+	return proofs
+}
+
+func compareUpdateProofs(t *testing.T, i int, got, want *smt.UpdateProof) {
+	t.Helper()
+	if got.Exists != want.Exists || got.Leaf != want.Leaf || got.Value != want.Value ||
+		got.NewLeaf != want.NewLeaf || got.Op != want.Op || got.Index.Cmp(want.Index) != 0 || got.Enables.Cmp(want.Enables) != 0 {
+		t.Fatalf("Proof %d differs: got %+v, expected %+v", i, got, want)
+	}
+	if len(got.Siblings) != len(want.Siblings) {
+		t.Fatalf("Proof %d has %d siblings, expected %d", i, len(got.Siblings), len(want.Siblings))
+	}
+	for j := range got.Siblings {
+		if got.Siblings[j] != want.Siblings[j] {
+			t.Fatalf("Proof %d sibling %d differs", i, j)
+		}
+	}
+}
+
+func TestCommitBatchMatchesOneByOne(t *testing.T) {
+	for _, depth := range []uint16{16, 256} {
+		present := make(map[string]bool)
+		initial := randomWrites(1, 200, uint(depth), present)
+		writes := randomWrites(2, 600, uint(depth), present)
+
+		db := smt.NewInMemoryDatabase()
+		tree, err := smt.NewSparseMerkleTree(db, depth)
+		if err != nil {
+			t.Fatalf("Failed to create tree: %v", err)
+		}
+		refDB := smt.NewInMemoryDatabase()
+		ref, err := smt.NewSparseMerkleTree(refDB, depth)
+		if err != nil {
+			t.Fatalf("Failed to create tree: %v", err)
+		}
+
+		if err := tree.CommitBatch(initial); err != nil {
+			t.Fatalf("CommitBatch failed: %v", err)
+		}
+		applyOneByOne(t, ref, initial)
+		if tree.Root() != ref.Root() {
+			t.Fatalf("Depth %d: root %s, expected %s", depth, tree.Root(), ref.Root())
+		}
+		if tree.Version() != 1 {
+			t.Errorf("Batch commit recorded %d versions, expected 1", tree.Version())
+		}
+
+		proofs, err := tree.CommitBatchWithProofs(writes)
+		if err != nil {
+			t.Fatalf("CommitBatchWithProofs failed: %v", err)
+		}
+		expected := applyOneByOne(t, ref, writes)
+		if tree.Root() != ref.Root() {
+			t.Fatalf("Depth %d: root %s, expected %s", depth, tree.Root(), ref.Root())
+		}
+		for i := range writes {
+			compareUpdateProofs(t, i, proofs[i], expected[i])
+		}
+
+		// The stored records are those the one-by-one writes leave behind
+		compareRecords(t, storedRecords(t, db), storedRecords(t, refDB))
+		compareRecords(t, storedRecords(t, refDB), storedRecords(t, db))
+
+		report, err := tree.Check()
+		if err != nil || !report.OK() {
+			t.Errorf("Check after batch commit failed: %v %v", err, report)
+		}
+	}
+}
+
+func TestCommitBatchProofsChain(t *testing.T) {
+	tree := CreateTestTree(t, 32)
+	writes := randomWrites(3, 300, 32, make(map[string]bool))
+
+	root := tree.Root()
+	proofs, err := tree.CommitBatchWithProofs(writes)
+	if err != nil {
+		t.Fatalf("CommitBatchWithProofs failed: %v", err)
+	}
+
+	// Each proof starts from the root the previous one left
+	for i, proof := range proofs {
+		before := &smt.Proof{Exists: proof.Exists, Leaf: proof.Leaf, Value: proof.Value, Index: proof.Index, Enables: proof.Enables, Siblings: proof.Siblings}
+		if !smt.VerifyProof(root, 32, before) {
+			t.Fatalf("Proof %d does not verify against the previous root", i)
+		}
+		after := &smt.Proof{Exists: !writes[i].Delete, Value: writes[i].Value, Index: proof.Index, Enables: proof.Enables, Siblings: proof.Siblings}
+		root = smt.ComputeRootFromProof(32, after)
+	}
+	if root != tree.Root() {
+		t.Errorf("Chained proofs end at %s, tree root is %s", root, tree.Root())
+	}
+}
+
+func TestCommitBatchFailureRollsBack(t *testing.T) {
+	tree := CreateTestTree(t, 16)
+	if _, err := tree.Insert(big.NewInt(1), smt.Bytes32{1}); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	root, version := tree.Root(), tree.Version()
+
+	writes := make([]smt.LeafWrite, 0, 200)
+	for i := 0; i < 200; i++ {
+		writes = append(writes, smt.LeafWrite{Index: big.NewInt(int64(i * 300)), Value: smt.Bytes32{byte(i)}})
+	}
+	// Index 7 was never written; index 300 is deleted once it has been written
+	writes = append(writes,
+		smt.LeafWrite{Index: big.NewInt(300), Delete: true},
+		smt.LeafWrite{Index: big.NewInt(7), Delete: true},
+		smt.LeafWrite{Index: big.NewInt(300), Delete: true},
+	)
+
+	err := tree.CommitBatch(writes)
+	var batchErr *smt.BatchError
+	if !errors.As(err, &batchErr) || batchErr.Index != 201 || batchErr.Op.Type != "delete" {
+		t.Fatalf("Expected BatchError at write 201, got %v", err)
+	}
+	var notFound *smt.KeyNotFoundError
+	if !errors.As(err, &notFound) {
+		t.Errorf("Expected KeyNotFoundError cause, got %v", err)
+	}
+	if tree.Root() != root || tree.Version() != version {
+		t.Error("Failed batch commit changed the tree")
+	}
+
+	err = tree.CommitBatch([]smt.LeafWrite{{Index: big.NewInt(1 << 16)}})
+	var rangeErr *smt.OutOfRangeError
+	if !errors.As(err, &rangeErr) {
+		t.Errorf("Expected OutOfRangeError, got %v", err)
+	}
+
+	if err := tree.CommitBatch(nil); err != nil || tree.Version() != version {
+		t.Errorf("Empty batch commit: %v, version %d", err, tree.Version())
+	}
+}
+
+func TestCommitBatchCompact(t *testing.T) {
+	present := make(map[string]bool)
+	initial := randomWrites(4, 100, 24, present)
+	writes := randomWrites(5, 200, 24, present)
+
+	tree, db := newCompactTree(t, 24)
+	ref, refDB := newCompactTree(t, 24)
+	if err := tree.CommitBatch(initial); err != nil {
+		t.Fatalf("CommitBatch failed: %v", err)
+	}
+	applyOneByOne(t, ref, initial)
+
+	proofs, err := tree.CommitBatchWithProofs(writes)
+	if err != nil {
+		t.Fatalf("CommitBatchWithProofs failed: %v", err)
+	}
+	expected := applyOneByOne(t, ref, writes)
+	if tree.Root() != ref.Root() {
+		t.Fatalf("Root %s, expected %s", tree.Root(), ref.Root())
+	}
+	for i := range writes {
+		compareUpdateProofs(t, i, proofs[i], expected[i])
+	}
+	compareRecords(t, storedRecords(t, db), storedRecords(t, refDB))
+}
+
+func TestBatchInsertSkipsFailedLeaves(t *testing.T) {
+	tree := CreateTestTree(t, 8)
+	if _, err := tree.Insert(big.NewInt(2), smt.Bytes32{2}); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	version := tree.Version()
+
+	indices := []*big.Int{big.NewInt(1), big.NewInt(2), big.NewInt(1), big.NewInt(300), big.NewInt(3)}
+	values := []smt.Bytes32{{1}, {20}, {10}, {30}, {3}}
+	proofs, err := tree.BatchInsert(indices, values)
+	if err != nil {
+		t.Fatalf("BatchInsert failed: %v", err)
+	}
+	for i, ok := range []bool{true, false, false, false, true} {
+		if (proofs[i] != nil) != ok {
+			t.Errorf("Proof %d: got %v, expected present=%v", i, proofs[i], ok)
+		}
+	}
+	if tree.Version() != version+1 {
+		t.Errorf("BatchInsert recorded %d versions, expected 1", tree.Version()-version)
+	}
+
+	proof, err := tree.Get(big.NewInt(1))
+	if err != nil || proof.Value != (smt.Bytes32{1}) {
+		t.Errorf("Index 1 holds %v, expected the first inserted value", proof.Value)
+	}
+
+	// Updates apply in order, so a repeated index is updated twice
+	proofs, err = tree.BatchUpdate([]*big.Int{big.NewInt(3), big.NewInt(4), big.NewInt(3)}, []smt.Bytes32{{4}, {4}, {5}})
+	if err != nil {
+		t.Fatalf("BatchUpdate failed: %v", err)
+	}
+	if proofs[0] == nil || proofs[1] != nil || proofs[2] == nil || proofs[2].Value != (smt.Bytes32{4}) {
+		t.Errorf("Unexpected BatchUpdate proofs %v", proofs)
+	}
+}
@@ -0,0 +1,349 @@
+package tests
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	smt "github.com/0xanonymeow/smt/go"
+)
+
+// CountingBatchDatabase wraps an InMemoryDatabase and records how writes reach it
+type CountingBatchDatabase struct {
+	*smt.InMemoryDatabase
+	directWrites int
+	batchWrites  int
+	failWrite    bool
+}
+
+func NewCountingBatchDatabase() *CountingBatchDatabase {
+	return &CountingBatchDatabase{InMemoryDatabase: smt.NewInMemoryDatabase()}
+}
+
+func (c *CountingBatchDatabase) Set(key []byte, value []byte) error {
+	c.directWrites++
+	return c.InMemoryDatabase.Set(key, value)
+}
+
+func (c *CountingBatchDatabase) Delete(key []byte) error {
+	c.directWrites++
+	return c.InMemoryDatabase.Delete(key)
+}
+
+func (c *CountingBatchDatabase) NewBatch() smt.Batch {
+	return &countingBatch{Batch: c.InMemoryDatabase.NewBatch(), parent: c}
+}
+
+type countingBatch struct {
+	smt.Batch
+	parent *CountingBatchDatabase
+}
+
+func (b *countingBatch) Write() error {
+	if b.parent.failWrite {
+		return errors.New("simulated batch write error")
+	}
+	b.parent.batchWrites++
+	return b.Batch.Write()
+}
+
+func TestMutationsUseSingleBatch(t *testing.T) {
+	db := NewCountingBatchDatabase()
+	tree, err := smt.NewSparseMerkleTree(db, 16)
+	if err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+
+	if _, err := tree.Insert(big.NewInt(1), smt.Bytes32{1}); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	if _, err := tree.Update(big.NewInt(1), smt.Bytes32{2}); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if _, err := tree.InsertKV("key", smt.Bytes32{3}); err != nil {
+		t.Fatalf("InsertKV failed: %v", err)
+	}
+	if _, err := tree.Delete(big.NewInt(1)); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	if db.directWrites != 0 {
+		t.Errorf("Expected no direct writes, got %d", db.directWrites)
+	}
+	if db.batchWrites != 4 {
+		t.Errorf("Expected one batch per operation (4), got %d", db.batchWrites)
+	}
+
+	_, err = tree.ExecuteBatch([]smt.BatchOperation{
+		{Type: "insert", Index: big.NewInt(10), Leaf: smt.Bytes32{10}},
+		{Type: "insert", Index: big.NewInt(11), Leaf: smt.Bytes32{11}},
+		{Type: "delete", Index: big.NewInt(10)},
+	})
+	if err != nil {
+		t.Fatalf("ExecuteBatch failed: %v", err)
+	}
+	if db.batchWrites != 5 {
+		t.Errorf("ExecuteBatch should commit a single batch, got %d total", db.batchWrites)
+	}
+}
+
+func TestBatchInsertKVUsesSingleBatch(t *testing.T) {
+	db := NewCountingBatchDatabase()
+	tree, err := smt.NewSparseMerkleTree(db, 16)
+	if err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+	if _, err := tree.InsertKV("b", smt.Bytes32{1}); err != nil {
+		t.Fatalf("InsertKV failed: %v", err)
+	}
+	version := tree.Version()
+
+	proofs, err := tree.BatchInsertKV(map[string]smt.Bytes32{"a": {2}, "b": {3}, "c": {4}})
+	if err != nil {
+		t.Fatalf("BatchInsertKV failed: %v", err)
+	}
+	if db.batchWrites != 2 || db.directWrites != 0 {
+		t.Errorf("BatchInsertKV should commit a single batch, got %d batches and %d direct writes", db.batchWrites-1, db.directWrites)
+	}
+	if tree.Version() != version+1 {
+		t.Errorf("BatchInsertKV recorded %d versions, expected 1", tree.Version()-version)
+	}
+
+	// Proofs are in key order, with nil for the key already present
+	if len(proofs) != 3 || proofs[0] == nil || proofs[1] != nil || proofs[2] == nil {
+		t.Fatalf("Unexpected proofs %v", proofs)
+	}
+	if value, _, _ := tree.GetKV("b"); value != (smt.Bytes32{1}) {
+		t.Errorf("Skipped pair overwrote the stored value: %s", value)
+	}
+
+	// A failed commit leaves none of the pairs behind
+	root := tree.Root()
+	db.failWrite = true
+	if _, err := tree.BatchInsertKV(map[string]smt.Bytes32{"d": {5}, "e": {6}}); err == nil {
+		t.Fatal("Expected BatchInsertKV to fail")
+	}
+	db.failWrite = false
+	if tree.Root() != root {
+		t.Error("Failed BatchInsertKV changed the root")
+	}
+	for _, key := range []string{"d", "e"} {
+		if _, exists, _ := tree.GetKV(key); exists {
+			t.Errorf("Failed BatchInsertKV left key %s behind", key)
+		}
+	}
+}
+
+func TestFailedBatchWriteLeavesTreeUnchanged(t *testing.T) {
+	db := NewCountingBatchDatabase()
+	tree, err := smt.NewSparseMerkleTree(db, 16)
+	if err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+	if _, err := tree.Insert(big.NewInt(1), smt.Bytes32{1}); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	rootBefore := tree.Root()
+
+	db.failWrite = true
+	if _, err := tree.Insert(big.NewInt(2), smt.Bytes32{2}); err == nil {
+		t.Fatal("Insert should fail when the batch cannot be written")
+	}
+	db.failWrite = false
+
+	if tree.Root() != rootBefore {
+		t.Fatal("Root should be restored after a failed batch write")
+	}
+	exists, err := tree.Exists(big.NewInt(2))
+	if err != nil {
+		t.Fatalf("Exists failed: %v", err)
+	}
+	if exists {
+		t.Fatal("Failed insert must not leave the leaf behind")
+	}
+	leafHash, _ := tree.GetLeafHashByIndex(big.NewInt(2))
+	if !leafHash.IsZero() {
+		t.Fatal("Failed insert must not leave an index mapping behind")
+	}
+
+	reopened, err := smt.OpenSparseMerkleTree(db)
+	if err != nil {
+		t.Fatalf("Failed to reopen tree: %v", err)
+	}
+	if reopened.Root() != rootBefore {
+		t.Fatal("Persisted root should not reflect the failed insert")
+	}
+}
+
+func TestSequentialFallbackWithoutBatchSupport(t *testing.T) {
+	db := NewMockDatabase()
+	tree, err := smt.NewSparseMerkleTree(db, 8)
+	if err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+	if _, err := tree.Insert(big.NewInt(3), smt.Bytes32{3}); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+
+	db.shouldFailSet = true
+	rootBefore := tree.Root()
+	if _, err := tree.Insert(big.NewInt(4), smt.Bytes32{4}); err == nil {
+		t.Fatal("Insert should fail when the database rejects writes")
+	}
+	if tree.Root() != rootBefore {
+		t.Fatal("Root should be restored after a failed write")
+	}
+}
+
+func TestDeleteRemovesRequestedLeaf(t *testing.T) {
+	tree, err := smt.NewSparseMerkleTree(smt.NewInMemoryDatabase(), 8)
+	if err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+	reference, err := smt.NewSparseMerkleTree(smt.NewInMemoryDatabase(), 8)
+	if err != nil {
+		t.Fatalf("Failed to create reference tree: %v", err)
+	}
+
+	// Indices 1 and 128 differ in the lowest and highest bit
+	tree.Insert(big.NewInt(1), smt.Bytes32{1})
+	tree.Insert(big.NewInt(128), smt.Bytes32{2})
+	if _, err := tree.Delete(big.NewInt(1)); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	reference.Insert(big.NewInt(128), smt.Bytes32{2})
+
+	if tree.Root() != reference.Root() {
+		t.Fatalf("Root after delete %s should match reference %s", tree.Root(), reference.Root())
+	}
+	exists, _ := tree.Exists(big.NewInt(128))
+	if !exists {
+		t.Fatal("Sibling leaf should survive the delete")
+	}
+}
+
+func TestUpdateKeepsIndexMapping(t *testing.T) {
+	tree, err := smt.NewSparseMerkleTree(smt.NewInMemoryDatabase(), 8)
+	if err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+	index := big.NewInt(9)
+	tree.Insert(index, smt.Bytes32{1})
+	proof, err := tree.Update(index, smt.Bytes32{2})
+	if err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+
+	leafHash, err := tree.GetLeafHashByIndex(index)
+	if err != nil {
+		t.Fatalf("GetLeafHashByIndex failed: %v", err)
+	}
+	if leafHash != proof.NewLeaf {
+		t.Fatalf("Index mapping should point to the new leaf %s, got %s", proof.NewLeaf, leafHash)
+	}
+}
+
+// MapDatabase is a minimal map-backed Database whose contents can be snapshotted
+type MapDatabase struct {
+	data map[string]string
+}
+
+func NewMapDatabase() *MapDatabase {
+	return &MapDatabase{data: make(map[string]string)}
+}
+
+func (m *MapDatabase) Get(key []byte) ([]byte, error) {
+	value, exists := m.data[string(key)]
+	if !exists {
+		return nil, nil
+	}
+	return []byte(value), nil
+}
+
+func (m *MapDatabase) Set(key []byte, value []byte) error {
+	m.data[string(key)] = string(value)
+	return nil
+}
+
+func (m *MapDatabase) Delete(key []byte) error {
+	delete(m.data, string(key))
+	return nil
+}
+
+func (m *MapDatabase) Has(key []byte) (bool, error) {
+	_, exists := m.data[string(key)]
+	return exists, nil
+}
+
+// Snapshot returns a copy of the database contents
+func (m *MapDatabase) Snapshot() map[string]string {
+	result := make(map[string]string, len(m.data))
+	for k, v := range m.data {
+		result[k] = v
+	}
+	return result
+}
+
+func TestExecuteBatchRestoresDatabaseAndKV(t *testing.T) {
+	db := NewMapDatabase()
+	tree, err := smt.NewSparseMerkleTree(db, 16)
+	if err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+
+	tree.Insert(big.NewInt(1), smt.Bytes32{1})
+	tree.Insert(big.NewInt(2), smt.Bytes32{2})
+	tree.InsertKV("alice", smt.Bytes32{0xa})
+	tree.InsertKV("bob", smt.Bytes32{0xb})
+
+	before := db.Snapshot()
+	rootBefore := tree.Root()
+
+	ops := []smt.BatchOperation{
+		{Type: "insert", Index: big.NewInt(3), Leaf: smt.Bytes32{3}},
+		{Type: "update", Index: big.NewInt(1), Leaf: smt.Bytes32{0x11}},
+		{Type: "delete", Index: big.NewInt(2)},
+		{Type: "update", Key: "alice", Value: smt.Bytes32{0xaa}},
+		{Type: "delete", Key: "bob"},
+		{Type: "insert", Key: "carol", Value: smt.Bytes32{0xc}},
+		{Type: "update", Index: big.NewInt(999), Leaf: smt.Bytes32{9}}, // fails: not found
+	}
+
+	_, err = tree.ExecuteBatch(ops)
+	var batchErr *smt.BatchError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("Expected BatchError, got %v", err)
+	}
+	if batchErr.Index != 6 || batchErr.Op.Type != "update" {
+		t.Fatalf("Expected failure at op 6 (update), got op %d (%s)", batchErr.Index, batchErr.Op.Type)
+	}
+	var notFound *smt.KeyNotFoundError
+	if !errors.As(err, &notFound) {
+		t.Fatalf("BatchError should wrap the underlying KeyNotFoundError, got %v", batchErr.Err)
+	}
+
+	if tree.Root() != rootBefore {
+		t.Fatal("Root should be restored after a failed batch")
+	}
+
+	after := db.Snapshot()
+	if len(after) != len(before) {
+		t.Fatalf("Database should be restored exactly: %d keys before, %d after", len(before), len(after))
+	}
+	for k, v := range before {
+		if after[k] != v {
+			t.Fatalf("Database key %q changed by a failed batch", k)
+		}
+	}
+
+	// KV values come back exactly as before, including cached entries
+	if value, exists, _ := tree.GetKV("alice"); !exists || value != (smt.Bytes32{0xa}) {
+		t.Fatalf("Expected alice to be restored to 0x0a, got %s (exists=%v)", value, exists)
+	}
+	if value, exists, _ := tree.GetKV("bob"); !exists || value != (smt.Bytes32{0xb}) {
+		t.Fatalf("Expected bob to be restored, got %s (exists=%v)", value, exists)
+	}
+	if _, exists, _ := tree.GetKV("carol"); exists {
+		t.Fatal("carol should not exist after rollback")
+	}
+}
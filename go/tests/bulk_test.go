@@ -0,0 +1,235 @@
+package tests
+
+import (
+	"errors"
+	"io"
+	"math/big"
+	"math/rand"
+	"sort"
+	"testing"
+
+	smt "github.com/0xanonymeow/smt/go"
+)
+
+// sortedLeaves returns count distinct random leaves below 2^bits in index order
+func sortedLeaves(seed int64, count int, bits uint) []smt.LeafData {
+	rng := rand.New(rand.NewSource(seed))
+	max := new(big.Int).Lsh(big.NewInt(1), bits)
+	seen := make(map[string]bool)
+
+	leaves := make([]smt.LeafData, 0, count)
+	for len(leaves) < count {
+		index := new(big.Int).Rand(rng, max)
+		if seen[index.String()] {
+			continue
+		}
+		seen[index.String()] = true
+
+		var value smt.Bytes32
+		rng.Read(value[:])
+		leaves = append(leaves, smt.LeafData{Index: index, Value: value})
+	}
+
+	sort.Slice(leaves, func(i, j int) bool {
+		return leaves[i].Index.Cmp(leaves[j].Index) < 0
+	})
+	return leaves
+}
+
+func TestBulkLoadMatchesSequentialInserts(t *testing.T) {
+	tests := []struct {
+		name  string
+		depth uint16
+		count int
+	}{
+		{"depth 8 dense", 8, 200},
+		{"depth 16", 16, 500},
+		{"depth 256", 256, 300},
+		{"single leaf", 32, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			leaves := sortedLeaves(int64(tt.depth), tt.count, uint(tt.depth))
+
+			seqDB := smt.NewInMemoryDatabase()
+			seq, err := smt.NewSparseMerkleTree(seqDB, tt.depth)
+			if err != nil {
+				t.Fatalf("Failed to create tree: %v", err)
+			}
+			// Inserting out of order must not matter
+			for i := len(leaves) - 1; i >= 0; i-- {
+				if _, err := seq.Insert(leaves[i].Index, leaves[i].Value); err != nil {
+					t.Fatalf("Failed to insert %s: %v", leaves[i].Index, err)
+				}
+			}
+
+			bulkDB := smt.NewInMemoryDatabase()
+			bulk, err := smt.BulkLoad(bulkDB, tt.depth, smt.SliceLeafSource(leaves))
+			if err != nil {
+				t.Fatalf("BulkLoad failed: %v", err)
+			}
+
+			if bulk.Root() != seq.Root() {
+				t.Fatalf("Bulk root %s, sequential root %s", bulk.Root(), seq.Root())
+			}
+			compareRecords(t, storedRecords(t, bulkDB), storedRecords(t, seqDB))
+
+			if bulk.Version() != 1 {
+				t.Errorf("Expected the load to commit version 1, got %d", bulk.Version())
+			}
+
+			// The loaded tree is fully usable and survives a reopen
+			reopened, err := smt.OpenSparseMerkleTree(bulkDB)
+			if err != nil {
+				t.Fatalf("Failed to reopen: %v", err)
+			}
+			proof, err := reopened.Get(leaves[0].Index)
+			if err != nil || !proof.Exists || proof.Value != leaves[0].Value {
+				t.Fatalf("Loaded leaf missing after reopen: %+v %v", proof, err)
+			}
+			if !smt.VerifyProof(reopened.Root(), tt.depth, proof) {
+				t.Error("Proof from loaded tree does not verify")
+			}
+			if _, err := reopened.Delete(leaves[0].Index); err != nil {
+				t.Fatalf("Failed to delete from loaded tree: %v", err)
+			}
+			report, err := reopened.Check()
+			if err != nil {
+				t.Fatalf("Check failed: %v", err)
+			}
+			if !report.OK() {
+				t.Errorf("Loaded tree has issues after a delete: %v", report.Issues)
+			}
+		})
+	}
+}
+
+func TestBulkLoadSpansBatches(t *testing.T) {
+	leaves := sortedLeaves(7, 3000, 20)
+
+	db := smt.NewInMemoryDatabase()
+	tree, err := smt.BulkLoad(db, 20, smt.SliceLeafSource(leaves))
+	if err != nil {
+		t.Fatalf("BulkLoad failed: %v", err)
+	}
+
+	count := 0
+	if err := tree.ForEachLeaf(func(index *big.Int, value smt.Bytes32) bool {
+		if index.Cmp(leaves[count].Index) != 0 || value != leaves[count].Value {
+			t.Fatalf("Leaf %d is %s, expected %s", count, index, leaves[count].Index)
+		}
+		count++
+		return true
+	}); err != nil {
+		t.Fatalf("ForEachLeaf failed: %v", err)
+	}
+	if count != len(leaves) {
+		t.Errorf("Loaded %d leaves, expected %d", count, len(leaves))
+	}
+}
+
+func TestBulkLoadEmptySource(t *testing.T) {
+	tree, err := smt.BulkLoad(smt.NewInMemoryDatabase(), 16, smt.SliceLeafSource(nil))
+	if err != nil {
+		t.Fatalf("BulkLoad failed: %v", err)
+	}
+	if !tree.Root().IsZero() {
+		t.Errorf("Expected an empty root, got %s", tree.Root())
+	}
+}
+
+// failingSource yields its leaves and then fails
+type failingSource struct {
+	leaves []smt.LeafData
+	err    error
+}
+
+func (s *failingSource) Next() (smt.LeafData, error) {
+	if len(s.leaves) == 0 {
+		return smt.LeafData{}, s.err
+	}
+	leaf := s.leaves[0]
+	s.leaves = s.leaves[1:]
+	return leaf, nil
+}
+
+func TestBulkLoadErrors(t *testing.T) {
+	leaf := func(i int64) smt.LeafData {
+		return smt.LeafData{Index: big.NewInt(i), Value: smt.Bytes32{byte(i)}}
+	}
+	sourceErr := errors.New("source failed")
+
+	tests := []struct {
+		name   string
+		source smt.LeafSource
+		check  func(t *testing.T, err error)
+	}{
+		{
+			name:   "descending",
+			source: smt.SliceLeafSource([]smt.LeafData{leaf(5), leaf(3)}),
+			check: func(t *testing.T, err error) {
+				if !errors.Is(err, smt.ErrUnsortedLeaves) {
+					t.Errorf("Expected ErrUnsortedLeaves, got %v", err)
+				}
+			},
+		},
+		{
+			name:   "duplicate",
+			source: smt.SliceLeafSource([]smt.LeafData{leaf(5), leaf(5)}),
+			check: func(t *testing.T, err error) {
+				if !errors.Is(err, smt.ErrUnsortedLeaves) {
+					t.Errorf("Expected ErrUnsortedLeaves, got %v", err)
+				}
+			},
+		},
+		{
+			name:   "out of range",
+			source: smt.SliceLeafSource([]smt.LeafData{leaf(5), leaf(256)}),
+			check: func(t *testing.T, err error) {
+				var rangeErr *smt.OutOfRangeError
+				if !errors.As(err, &rangeErr) {
+					t.Errorf("Expected OutOfRangeError, got %v", err)
+				}
+			},
+		},
+		{
+			name:   "source error",
+			source: &failingSource{leaves: []smt.LeafData{leaf(1), leaf(2)}, err: sourceErr},
+			check: func(t *testing.T, err error) {
+				if !errors.Is(err, sourceErr) {
+					t.Errorf("Expected the source error, got %v", err)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := smt.NewInMemoryDatabase()
+			_, err := smt.BulkLoad(db, 8, tt.source)
+			tt.check(t, err)
+
+			// Nothing is committed by a failed load
+			if meta, _ := smt.ReadTreeMetadata(db); meta != nil {
+				t.Error("Failed load left tree metadata behind")
+			}
+		})
+	}
+
+	t.Run("non-empty tree", func(t *testing.T) {
+		db := smt.NewInMemoryDatabase()
+		tree, _ := smt.NewSparseMerkleTree(db, 8)
+		if _, err := tree.Insert(big.NewInt(1), smt.Bytes32{1}); err != nil {
+			t.Fatalf("Failed to insert: %v", err)
+		}
+		if _, err := smt.BulkLoad(db, 8, smt.SliceLeafSource(nil)); !errors.Is(err, smt.ErrTreeNotEmpty) {
+			t.Errorf("Expected ErrTreeNotEmpty, got %v", err)
+		}
+	})
+
+	// An io.EOF from the source simply ends it
+	if _, err := smt.BulkLoad(smt.NewInMemoryDatabase(), 8, &failingSource{err: io.EOF}); err != nil {
+		t.Errorf("Expected io.EOF to end the source, got %v", err)
+	}
+}
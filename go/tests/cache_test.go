@@ -0,0 +1,247 @@
+package tests
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	smt "github.com/0xanonymeow/smt/go"
+)
+
+func newCachedTree(t *testing.T, inner smt.Database, opts *smt.CacheOptions, depth uint16) (*smt.CachedDatabase, *smt.SparseMerkleTree) {
+	t.Helper()
+	cdb, err := smt.NewCachedDatabase(inner, opts)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	tree, err := smt.NewSparseMerkleTree(cdb, depth)
+	if err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+	return cdb, tree
+}
+
+func TestCachedDatabaseMatchesUncachedTree(t *testing.T) {
+	cdb, cached := newCachedTree(t, smt.NewInMemoryDatabase(), &smt.CacheOptions{Size: 64}, 16)
+	plain, err := smt.NewSparseMerkleTree(smt.NewInMemoryDatabase(), 16)
+	if err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+
+	for i := int64(0); i < 40; i++ {
+		for _, tree := range []*smt.SparseMerkleTree{cached, plain} {
+			if _, err := tree.Insert(big.NewInt(i*31), smt.Bytes32{byte(i + 1)}); err != nil {
+				t.Fatalf("Failed to insert %d: %v", i, err)
+			}
+		}
+	}
+	for i := int64(0); i < 40; i += 3 {
+		for _, tree := range []*smt.SparseMerkleTree{cached, plain} {
+			if _, err := tree.Delete(big.NewInt(i * 31)); err != nil {
+				t.Fatalf("Failed to delete %d: %v", i, err)
+			}
+		}
+	}
+	for i := int64(1); i < 40; i += 3 {
+		for _, tree := range []*smt.SparseMerkleTree{cached, plain} {
+			if _, err := tree.Update(big.NewInt(i*31), smt.Bytes32{0xee, byte(i)}); err != nil {
+				t.Fatalf("Failed to update %d: %v", i, err)
+			}
+		}
+	}
+
+	if cached.Root() != plain.Root() {
+		t.Fatalf("Cached tree root %s differs from %s", cached.Root(), plain.Root())
+	}
+	for i := int64(0); i < 40; i++ {
+		got, _ := cached.Get(big.NewInt(i * 31))
+		want, _ := plain.Get(big.NewInt(i * 31))
+		if got.Exists != want.Exists || got.Value != want.Value {
+			t.Fatalf("Index %d: cached %v/%s, expected %v/%s", i*31, got.Exists, got.Value, want.Exists, want.Value)
+		}
+	}
+
+	stats := cdb.Stats()
+	if stats.Hits == 0 {
+		t.Fatal("Expected cache hits")
+	}
+	if stats.Entries > 64 {
+		t.Fatalf("Cache exceeded its size: %d entries", stats.Entries)
+	}
+}
+
+func TestCachedDatabaseInvalidation(t *testing.T) {
+	inner := smt.NewInMemoryDatabase()
+	cdb, err := smt.NewCachedDatabase(inner, &smt.CacheOptions{Size: 2})
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+
+	key := []byte(smt.NodePrefix + "aa")
+	inner.Set(key, []byte("v1"))
+
+	if value, _ := cdb.Get(key); string(value) != "v1" {
+		t.Fatalf("Expected v1, got %q", value)
+	}
+	if value, _ := cdb.Get(key); string(value) != "v1" {
+		t.Fatalf("Expected v1, got %q", value)
+	}
+	if stats := cdb.Stats(); stats.Hits != 1 || stats.Misses != 1 {
+		t.Fatalf("Expected 1 hit and 1 miss, got %+v", stats)
+	}
+
+	cdb.Set(key, []byte("v2"))
+	if value, _ := cdb.Get(key); string(value) != "v2" {
+		t.Fatalf("Expected v2 after Set, got %q", value)
+	}
+
+	cdb.Delete(key)
+	if value, _ := cdb.Get(key); value != nil {
+		t.Fatalf("Expected nil after Delete, got %q", value)
+	}
+	if has, _ := cdb.Has(key); has {
+		t.Fatal("Deleted key should not exist")
+	}
+
+	// Batches update the cache on Write
+	batch := cdb.NewBatch()
+	batch.Put(key, []byte("v3"))
+	if value, _ := cdb.Get(key); value != nil {
+		t.Fatalf("Unwritten batch should not be visible, got %q", value)
+	}
+	if err := batch.Write(); err != nil {
+		t.Fatalf("Batch write failed: %v", err)
+	}
+	if value, _ := cdb.Get(key); string(value) != "v3" {
+		t.Fatalf("Expected v3 after batch, got %q", value)
+	}
+
+	// The LRU is bounded
+	for i := 0; i < 5; i++ {
+		cdb.Set([]byte(smt.LeafPrefix+string(rune('a'+i))), []byte{byte(i)})
+	}
+	if stats := cdb.Stats(); stats.Entries != 2 {
+		t.Fatalf("Expected 2 entries, got %d", stats.Entries)
+	}
+
+	if _, err := smt.NewCachedDatabase(nil, nil); !errors.Is(err, smt.ErrNilDatabase) {
+		t.Fatalf("Expected ErrNilDatabase, got %v", err)
+	}
+	if _, err := smt.NewCachedDatabase(inner, &smt.CacheOptions{Size: 0}); !errors.Is(err, smt.ErrInvalidCacheSize) {
+		t.Fatalf("Expected ErrInvalidCacheSize, got %v", err)
+	}
+}
+
+func TestCachedDatabaseFailedWriteDropsEntries(t *testing.T) {
+	inner := NewCountingBatchDatabase()
+	_, tree := newCachedTree(t, inner, nil, 8)
+
+	if _, err := tree.Insert(big.NewInt(1), smt.Bytes32{1}); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+	root := tree.Root()
+
+	inner.failWrite = true
+	if _, err := tree.Insert(big.NewInt(2), smt.Bytes32{2}); err == nil {
+		t.Fatal("Expected insert to fail")
+	}
+	inner.failWrite = false
+
+	if tree.Root() != root {
+		t.Fatal("Root should be unchanged after failed write")
+	}
+	if exists, _ := tree.Exists(big.NewInt(2)); exists {
+		t.Fatal("Failed insert should not be visible")
+	}
+	if _, err := tree.Insert(big.NewInt(2), smt.Bytes32{2}); err != nil {
+		t.Fatalf("Retry failed: %v", err)
+	}
+}
+
+func TestCachedDatabasePinsTopLevels(t *testing.T) {
+	inner := smt.NewInMemoryDatabase()
+	cdb, tree := newCachedTree(t, inner, &smt.CacheOptions{Size: 8, PinnedLevels: 4}, 8)
+
+	// Indices spread over every top-level subtree fill the first four levels
+	for i := int64(0); i < 256; i += 8 {
+		if _, err := tree.Insert(big.NewInt(i), smt.Bytes32{byte(i + 1)}); err != nil {
+			t.Fatalf("Failed to insert %d: %v", i, err)
+		}
+	}
+
+	if stats := cdb.Stats(); stats.Pinned != 15 {
+		t.Fatalf("Expected 15 pinned nodes, got %d", stats.Pinned)
+	}
+
+	// A fresh cache over the same database pins from the stored metadata
+	reopened, err := smt.NewCachedDatabase(inner, &smt.CacheOptions{Size: 8, PinnedLevels: 4})
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	if stats := reopened.Stats(); stats.Pinned != 15 {
+		t.Fatalf("Expected 15 pinned nodes after reopen, got %d", stats.Pinned)
+	}
+
+	tree2, err := smt.OpenSparseMerkleTree(reopened)
+	if err != nil {
+		t.Fatalf("Failed to open tree: %v", err)
+	}
+	before := reopened.Stats()
+	proof, err := tree2.Get(big.NewInt(64))
+	if err != nil || !proof.Exists {
+		t.Fatalf("Expected index 64 to exist (err=%v)", err)
+	}
+	after := reopened.Stats()
+	if after.Hits-before.Hits < 4 {
+		t.Fatalf("Expected the top 4 levels to be served from memory, got %d hits", after.Hits-before.Hits)
+	}
+}
+
+func TestCachedDatabaseWithNamespacedTrees(t *testing.T) {
+	cdb, err := smt.NewCachedDatabase(smt.NewInMemoryDatabase(), &smt.CacheOptions{Size: 64, PinnedLevels: 4})
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	trees := make([]*smt.SparseMerkleTree, 2)
+	for i, ns := range []string{"app-a", "app-b"} {
+		if trees[i], err = smt.NewSparseMerkleTreeWithOptions(cdb, &smt.TreeOptions{Depth: 8, Namespace: ns}); err != nil {
+			t.Fatalf("Failed to create tree: %v", err)
+		}
+	}
+
+	// Each tree fills its first four levels, with different values
+	for i := int64(0); i < 256; i += 8 {
+		for j, tree := range trees {
+			if _, err := tree.Insert(big.NewInt(i), smt.Bytes32{byte(i + 1), byte(j)}); err != nil {
+				t.Fatalf("Failed to insert %d: %v", i, err)
+			}
+		}
+	}
+
+	// Pinning one namespace leaves the other's pinned set alone
+	if stats := cdb.Stats(); stats.Pinned != 30 {
+		t.Fatalf("Expected 15 pinned nodes per namespace, got %d", stats.Pinned)
+	}
+
+	for j, tree := range trees {
+		before := cdb.Stats()
+		proof, err := tree.Get(big.NewInt(64))
+		if err != nil || !proof.Exists || proof.Value != (smt.Bytes32{65, byte(j)}) {
+			t.Fatalf("Tree %d: unexpected proof for index 64 (err=%v)", j, err)
+		}
+		if after := cdb.Stats(); after.Hits-before.Hits < 4 {
+			t.Fatalf("Tree %d: expected the top 4 levels to be served from memory, got %d hits", j, after.Hits-before.Hits)
+		}
+	}
+
+	plain, err := smt.NewSparseMerkleTree(smt.NewInMemoryDatabase(), 8)
+	if err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+	for i := int64(0); i < 256; i += 8 {
+		plain.Insert(big.NewInt(i), smt.Bytes32{byte(i + 1), 0})
+	}
+	if trees[0].Root() != plain.Root() {
+		t.Fatalf("Namespaced cached tree root %s differs from %s", trees[0].Root(), plain.Root())
+	}
+}
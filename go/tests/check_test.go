@@ -0,0 +1,256 @@
+package tests
+
+import (
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"testing"
+
+	smt "github.com/0xanonymeow/smt/go"
+)
+
+// checkIndices span both halves of a depth-8 tree
+var checkIndices = []int64{5, 17, 130, 200}
+
+func newCheckTree(t *testing.T) (*smt.SparseMerkleTree, *smt.InMemoryDatabase) {
+	t.Helper()
+	db := smt.NewInMemoryDatabase()
+	tree, err := smt.NewSparseMerkleTree(db, 8)
+	if err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+	for _, i := range checkIndices {
+		if _, err := tree.Insert(big.NewInt(i), smt.Bytes32{byte(i)}); err != nil {
+			t.Fatalf("Failed to insert %d: %v", i, err)
+		}
+	}
+	return tree, db
+}
+
+func hashKey(prefix string, hash smt.Bytes32) string {
+	return prefix + hex.EncodeToString(hash[:])
+}
+
+func findIssue(report *smt.CheckReport, kind smt.IssueKind, key string) *smt.CheckIssue {
+	for i := range report.Issues {
+		if report.Issues[i].Kind == kind && report.Issues[i].Key == key {
+			return &report.Issues[i]
+		}
+	}
+	return nil
+}
+
+func TestCheckHealthyTree(t *testing.T) {
+	db := smt.NewInMemoryDatabase()
+	tree, err := smt.NewSparseMerkleTree(db, 16)
+	if err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+	tree.SetRetentionPolicy(smt.RetentionPolicy{MaxVersions: 3})
+
+	for i := int64(0); i < 40; i++ {
+		if _, err := tree.Insert(big.NewInt(i*1601), smt.Bytes32{byte(i + 1)}); err != nil {
+			t.Fatalf("Failed to insert %d: %v", i, err)
+		}
+	}
+	for i := int64(0); i < 40; i += 3 {
+		if _, err := tree.Update(big.NewInt(i*1601), smt.Bytes32{0xaa, byte(i)}); err != nil {
+			t.Fatalf("Failed to update %d: %v", i, err)
+		}
+	}
+	for i := int64(1); i < 40; i += 4 {
+		if _, err := tree.Delete(big.NewInt(i * 1601)); err != nil {
+			t.Fatalf("Failed to delete %d: %v", i, err)
+		}
+	}
+	if _, err := tree.InsertKV("check", smt.Bytes32{0x02}); err != nil {
+		t.Fatalf("Failed to insert KV: %v", err)
+	}
+
+	report, err := tree.Check()
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if !report.OK() {
+		t.Fatalf("Expected a clean tree, got issues: %v", report.Issues)
+	}
+	if !report.Scanned {
+		t.Error("Expected the in-memory database to be scanned")
+	}
+	if report.Root != tree.Root() || report.Depth != 16 {
+		t.Errorf("Report describes root %s depth %d", report.Root, report.Depth)
+	}
+	if report.Roots != 4 {
+		t.Errorf("Expected the current root and 3 versions to be walked, got %d", report.Roots)
+	}
+	if report.Leaves < 31 {
+		t.Errorf("Expected at least the 31 live leaves to be visited, got %d", report.Leaves)
+	}
+
+	report, err = smt.CheckDatabase(db)
+	if err != nil {
+		t.Fatalf("CheckDatabase failed: %v", err)
+	}
+	if !report.OK() {
+		t.Errorf("Expected CheckDatabase to agree, got issues: %v", report.Issues)
+	}
+}
+
+func TestCheckDetectsCorruption(t *testing.T) {
+	leafValue := smt.Bytes32{5}
+	leafHash := smt.ComputeLeafHash(big.NewInt(5), leafValue)
+	leafKey := hashKey(smt.LeafPrefix, leafHash)
+	indexKey := smt.LeafIndexPrefix + hex.EncodeToString(big.NewInt(5).Bytes())
+
+	tests := []struct {
+		name    string
+		corrupt func(t *testing.T, tree *smt.SparseMerkleTree, db *smt.InMemoryDatabase) string
+		kind    smt.IssueKind
+		path    string
+	}{
+		{
+			name: "swapped node children",
+			corrupt: func(t *testing.T, tree *smt.SparseMerkleTree, db *smt.InMemoryDatabase) string {
+				key := hashKey(smt.NodePrefix, tree.Root())
+				data, _ := db.Get([]byte(key))
+				swapped := append(append([]byte{}, data[32:]...), data[:32]...)
+				db.Set([]byte(key), swapped)
+				return key
+			},
+			kind: smt.IssueNodeHashMismatch,
+			path: "",
+		},
+		{
+			name: "missing child node",
+			corrupt: func(t *testing.T, tree *smt.SparseMerkleTree, db *smt.InMemoryDatabase) string {
+				data, _ := db.Get([]byte(hashKey(smt.NodePrefix, tree.Root())))
+				key := hashKey(smt.NodePrefix, smt.Bytes32(data[32:64]))
+				db.Delete([]byte(key))
+				return key
+			},
+			kind: smt.IssueDanglingReference,
+			path: "1",
+		},
+		{
+			name: "truncated node",
+			corrupt: func(t *testing.T, tree *smt.SparseMerkleTree, db *smt.InMemoryDatabase) string {
+				key := hashKey(smt.NodePrefix, tree.Root())
+				db.Set([]byte(key), []byte{1, 2, 3})
+				return key
+			},
+			kind: smt.IssueCorruptRecord,
+			path: "",
+		},
+		{
+			name: "modified leaf value",
+			corrupt: func(t *testing.T, tree *smt.SparseMerkleTree, db *smt.InMemoryDatabase) string {
+				data, _ := db.Get([]byte(leafKey))
+				data[0] ^= 0xff
+				db.Set([]byte(leafKey), data)
+				return leafKey
+			},
+			kind: smt.IssueLeafHashMismatch,
+			path: "00000101",
+		},
+		{
+			name: "leaf moved to another index",
+			corrupt: func(t *testing.T, tree *smt.SparseMerkleTree, db *smt.InMemoryDatabase) string {
+				db.Set([]byte(leafKey), append(leafValue[:], big.NewInt(6).Bytes()...))
+				return leafKey
+			},
+			kind: smt.IssueMisplacedLeaf,
+			path: "00000101",
+		},
+		{
+			name: "missing leaf",
+			corrupt: func(t *testing.T, tree *smt.SparseMerkleTree, db *smt.InMemoryDatabase) string {
+				db.Delete([]byte(leafKey))
+				return leafKey
+			},
+			kind: smt.IssueDanglingReference,
+			path: "00000101",
+		},
+		{
+			name: "index mapping to wrong leaf",
+			corrupt: func(t *testing.T, tree *smt.SparseMerkleTree, db *smt.InMemoryDatabase) string {
+				other := smt.ComputeLeafHash(big.NewInt(17), smt.Bytes32{17})
+				db.Set([]byte(indexKey), other[:])
+				return indexKey
+			},
+			kind: smt.IssueIndexMismatch,
+		},
+		{
+			name: "missing index mapping",
+			corrupt: func(t *testing.T, tree *smt.SparseMerkleTree, db *smt.InMemoryDatabase) string {
+				db.Delete([]byte(indexKey))
+				return indexKey
+			},
+			kind: smt.IssueIndexMismatch,
+		},
+		{
+			name: "stale index mapping",
+			corrupt: func(t *testing.T, tree *smt.SparseMerkleTree, db *smt.InMemoryDatabase) string {
+				key := smt.LeafIndexPrefix + hex.EncodeToString(big.NewInt(99).Bytes())
+				db.Set([]byte(key), leafHash[:])
+				return key
+			},
+			kind: smt.IssueIndexMismatch,
+		},
+		{
+			name: "orphaned node",
+			corrupt: func(t *testing.T, tree *smt.SparseMerkleTree, db *smt.InMemoryDatabase) string {
+				left, right := smt.Bytes32{1}, smt.Bytes32{2}
+				key := hashKey(smt.NodePrefix, smt.HashBytes32(left, right))
+				db.Set([]byte(key), append(left[:], right[:]...))
+				return key
+			},
+			kind: smt.IssueOrphan,
+		},
+		{
+			name: "wrong reference count",
+			corrupt: func(t *testing.T, tree *smt.SparseMerkleTree, db *smt.InMemoryDatabase) string {
+				key := hashKey(smt.RefCountPrefix, leafHash)
+				db.Set([]byte(key), []byte{0, 0, 0, 0, 0, 0, 0, 7})
+				return key
+			},
+			kind: smt.IssueRefCountMismatch,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree, db := newCheckTree(t)
+			key := tt.corrupt(t, tree, db)
+
+			report, err := tree.Check()
+			if err != nil {
+				t.Fatalf("Check failed: %v", err)
+			}
+			issue := findIssue(report, tt.kind, key)
+			if issue == nil {
+				t.Fatalf("Expected %s at %s, got %v", tt.kind, key, report.Issues)
+			}
+			if issue.Path != tt.path {
+				t.Errorf("Expected path %q, got %q", tt.path, issue.Path)
+			}
+		})
+	}
+}
+
+func TestCheckErrors(t *testing.T) {
+	if _, err := smt.CheckDatabase(smt.NewInMemoryDatabase()); !errors.Is(err, smt.ErrTreeNotFound) {
+		t.Errorf("Expected ErrTreeNotFound, got %v", err)
+	}
+
+	tree, _ := newCheckTree(t)
+	tree.Snapshot()
+	if _, err := tree.Check(); !errors.Is(err, smt.ErrUncommittedChanges) {
+		t.Errorf("Expected ErrUncommittedChanges, got %v", err)
+	}
+	if err := tree.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if _, err := tree.Check(); err != nil {
+		t.Errorf("Check failed after commit: %v", err)
+	}
+}
@@ -0,0 +1,403 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"math/rand"
+	"testing"
+
+	smt "github.com/0xanonymeow/smt/go"
+)
+
+func newCompactTree(t *testing.T, depth uint16) (*smt.SparseMerkleTree, *smt.InMemoryDatabase) {
+	t.Helper()
+	db := smt.NewInMemoryDatabase()
+	tree, err := smt.NewSparseMerkleTreeWithOptions(db, &smt.TreeOptions{Depth: depth, Compact: true})
+	if err != nil {
+		t.Fatalf("Failed to create compact tree: %v", err)
+	}
+	return tree, db
+}
+
+func countRecords(t *testing.T, db *smt.InMemoryDatabase, prefix string) int {
+	t.Helper()
+	count := 0
+	if err := db.IteratePrefix([]byte(prefix), func(key, value []byte) bool {
+		count++
+		return true
+	}); err != nil {
+		t.Fatalf("IteratePrefix failed: %v", err)
+	}
+	return count
+}
+
+func TestCompactTreeProofs(t *testing.T) {
+	tree, db := newCompactTree(t, 256)
+	fullDB := smt.NewInMemoryDatabase()
+	full, err := smt.NewSparseMerkleTree(fullDB, 256)
+	if err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+
+	leaves := sortedLeaves(18, 100, 256)
+	rng := rand.New(rand.NewSource(18))
+	for _, i := range rng.Perm(len(leaves)) {
+		if _, err := tree.Insert(leaves[i].Index, leaves[i].Value); err != nil {
+			t.Fatalf("Failed to insert %s: %v", leaves[i].Index, err)
+		}
+		if _, err := full.Insert(leaves[i].Index, leaves[i].Value); err != nil {
+			t.Fatalf("Failed to insert %s: %v", leaves[i].Index, err)
+		}
+	}
+
+	// A leaf sits where its path leaves the others, not 256 levels down
+	if nodes, fullNodes := countRecords(t, db, smt.NodePrefix), countRecords(t, fullDB, smt.NodePrefix); nodes >= 2*len(leaves) || nodes*20 > fullNodes {
+		t.Errorf("Compact tree stores %d nodes, full tree %d", nodes, fullNodes)
+	}
+	if tree.Root() == full.Root() {
+		t.Error("Compact and full trees share a root")
+	}
+
+	for _, leaf := range leaves {
+		proof, err := tree.Get(leaf.Index)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if !proof.Exists || proof.Value != leaf.Value {
+			t.Fatalf("Leaf %s not found", leaf.Index)
+		}
+		if !smt.VerifyCompactProof(tree.Root(), 256, proof) || !tree.VerifyProof(proof) || tree.ComputeRoot(proof) != tree.Root() {
+			t.Fatalf("Proof for %s does not verify", leaf.Index)
+		}
+		if smt.VerifyProof(tree.Root(), 256, proof) {
+			t.Fatalf("Compact proof for %s verifies as a full-depth proof", leaf.Index)
+		}
+
+		forged := *proof
+		forged.Value = smt.Bytes32{0xff}
+		if smt.VerifyCompactProof(tree.Root(), 256, &forged) {
+			t.Fatalf("Proof with a forged value verifies for %s", leaf.Index)
+		}
+	}
+
+	// Absent indices end at an empty subtree or at a neighbouring leaf
+	neighbors := 0
+	for i := 0; i < 200; i++ {
+		index := new(big.Int).Rand(rng, new(big.Int).Lsh(big.NewInt(1), 256))
+		proof, err := tree.Get(index)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if proof.Exists {
+			continue
+		}
+		if proof.Neighbor != nil {
+			neighbors++
+		}
+		if !smt.VerifyCompactProof(tree.Root(), 256, proof) {
+			t.Fatalf("Non-membership proof for %s does not verify", index)
+		}
+		if proof.Neighbor != nil {
+			claimed := *proof
+			claimed.Exists, claimed.Index, claimed.Value = true, proof.Neighbor.Index, proof.Neighbor.Value
+			if smt.VerifyCompactProof(tree.Root(), 256, &smt.Proof{Index: proof.Neighbor.Index, Enables: proof.Enables, Siblings: proof.Siblings, Neighbor: proof.Neighbor}) {
+				t.Fatal("Non-membership of the neighbour itself verifies")
+			}
+			if !smt.VerifyCompactProof(tree.Root(), 256, &claimed) {
+				t.Fatal("The neighbour of a non-membership proof is not a member")
+			}
+		}
+	}
+	if neighbors == 0 {
+		t.Error("No non-membership proof ended at a neighbouring leaf")
+	}
+
+	report, err := tree.Check()
+	if err != nil || !report.OK() || report.Leaves != len(leaves) {
+		t.Errorf("Check of compact tree failed: %v %v", err, report)
+	}
+}
+
+func TestCompactNeighborMustShareThePath(t *testing.T) {
+	tree, _ := newCompactTree(t, 8)
+	for _, i := range []int64{0x10, 0x90, 0x98} {
+		if _, err := tree.Insert(big.NewInt(i), smt.Bytes32{byte(i)}); err != nil {
+			t.Fatalf("Failed to insert %d: %v", i, err)
+		}
+	}
+
+	// 0x00 shares the left half with 0x10 alone
+	proof, err := tree.Get(big.NewInt(0x00))
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if proof.Exists || proof.Neighbor == nil || proof.Neighbor.Index.Int64() != 0x10 {
+		t.Fatalf("Expected the path to end at leaf 0x10, got %+v", proof)
+	}
+	if !smt.VerifyCompactProof(tree.Root(), 8, proof) {
+		t.Fatal("Non-membership proof does not verify")
+	}
+
+	// A neighbour off the path, or at the queried index, proves nothing
+	for _, index := range []int64{0x90, 0x00} {
+		forged := *proof
+		forged.Neighbor = &smt.LeafData{Index: big.NewInt(index), Value: proof.Neighbor.Value}
+		if smt.VerifyCompactProof(tree.Root(), 8, &forged) || smt.ComputeRootFromCompactProof(8, &forged) != (smt.Bytes32{}) {
+			t.Errorf("Proof with neighbour %d verifies", index)
+		}
+	}
+
+	// A lone leaf is the root
+	single, _ := newCompactTree(t, 8)
+	if _, err := single.Insert(big.NewInt(7), smt.Bytes32{7}); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+	if single.Root() != smt.ComputeLeafHash(big.NewInt(7), smt.Bytes32{7}) {
+		t.Error("The root of a single-leaf compact tree is not the leaf hash")
+	}
+}
+
+func TestCompactTreeIsCanonical(t *testing.T) {
+	leaves := sortedLeaves(7, 120, 16)
+
+	tree, db := newCompactTree(t, 16)
+	for _, leaf := range leaves {
+		if _, err := tree.Insert(leaf.Index, leaf.Value); err != nil {
+			t.Fatalf("Failed to insert %s: %v", leaf.Index, err)
+		}
+	}
+
+	// Deleting leaves moves their neighbours back up: the tree ends up as if
+	// the remaining leaves had been inserted alone
+	for i := 0; i < len(leaves); i += 2 {
+		if _, err := tree.Delete(leaves[i].Index); err != nil {
+			t.Fatalf("Failed to delete %s: %v", leaves[i].Index, err)
+		}
+	}
+
+	fresh, freshDB := newCompactTree(t, 16)
+	for i := len(leaves) - 1; i >= 1; i -= 2 {
+		if _, err := fresh.Insert(leaves[i].Index, leaves[i].Value); err != nil {
+			t.Fatalf("Failed to insert %s: %v", leaves[i].Index, err)
+		}
+	}
+	if tree.Root() != fresh.Root() {
+		t.Fatalf("Root after deletes %s, fresh root %s", tree.Root(), fresh.Root())
+	}
+	compareRecords(t, storedRecords(t, db), storedRecords(t, freshDB))
+
+	report, err := tree.Check()
+	if err != nil || !report.OK() {
+		t.Errorf("Check after deletes failed: %v %v", err, report)
+	}
+
+	for i := 1; i < len(leaves); i += 2 {
+		if _, err := tree.Delete(leaves[i].Index); err != nil {
+			t.Fatalf("Failed to delete %s: %v", leaves[i].Index, err)
+		}
+	}
+	if !tree.Root().IsZero() || countRecords(t, db, smt.NodePrefix) != 0 || countRecords(t, db, smt.LeafPrefix) != 0 {
+		t.Error("Emptied compact tree kept records")
+	}
+}
+
+func TestCompactUpdateProofs(t *testing.T) {
+	tree, _ := newCompactTree(t, 16)
+	rng := rand.New(rand.NewSource(3))
+
+	for i := 0; i < 60; i++ {
+		index := big.NewInt(rng.Int63n(1 << 16))
+		oldRoot := tree.Root()
+		exists, err := tree.Exists(index)
+		if err != nil {
+			t.Fatalf("Exists failed: %v", err)
+		}
+
+		var proof *smt.UpdateProof
+		if exists {
+			proof, err = tree.Update(index, smt.Bytes32{byte(i), 1})
+		} else {
+			proof, err = tree.Insert(index, smt.Bytes32{byte(i)})
+		}
+		if err != nil {
+			t.Fatalf("Write to %s failed: %v", index, err)
+		}
+		if !smt.VerifyCompactUpdateProof(oldRoot, tree.Root(), 16, proof) {
+			t.Fatalf("Update proof for %s does not verify", index)
+		}
+		if smt.VerifyCompactUpdateProof(oldRoot, oldRoot, 16, proof) {
+			t.Fatalf("Update proof for %s verifies against the wrong root", index)
+		}
+	}
+
+	// Deletes verify too, including those whose leaf sibling moves up
+	leaves, err := tree.Leaves(nil, nil)
+	if err != nil {
+		t.Fatalf("Leaves failed: %v", err)
+	}
+	collapsed := 0
+	for _, i := range rng.Perm(len(leaves)) {
+		oldRoot := tree.Root()
+		proof, err := tree.Delete(leaves[i].Index)
+		if err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		if !smt.VerifyCompactUpdateProof(oldRoot, tree.Root(), 16, proof) {
+			t.Fatalf("Delete proof for %s does not verify", leaves[i].Index)
+		}
+		if smt.VerifyCompactUpdateProof(oldRoot, oldRoot, 16, proof) {
+			t.Fatalf("Delete proof for %s verifies against the wrong root", leaves[i].Index)
+		}
+		if proof.Neighbor == nil {
+			continue
+		}
+		collapsed++
+
+		// The verifier relies on the neighbour to move it up
+		tampered := *proof
+		tampered.Neighbor = nil
+		if smt.VerifyCompactUpdateProof(oldRoot, tree.Root(), 16, &tampered) {
+			t.Fatalf("Delete proof for %s verifies without its neighbour", leaves[i].Index)
+		}
+		tampered.Neighbor = &smt.LeafData{Index: proof.Neighbor.Index, Value: smt.Bytes32{0xff}}
+		if smt.VerifyCompactUpdateProof(oldRoot, tree.Root(), 16, &tampered) {
+			t.Fatalf("Delete proof for %s verifies with a forged neighbour", leaves[i].Index)
+		}
+	}
+	if collapsed == 0 {
+		t.Error("Expected deletes that move a neighbouring leaf up")
+	}
+	if !tree.Root().IsZero() {
+		t.Errorf("Expected an empty tree, got root %s", tree.Root())
+	}
+}
+
+func TestCompactTreeOptions(t *testing.T) {
+	tree, db := newCompactTree(t, 32)
+	for i := int64(1); i <= 20; i++ {
+		if _, err := tree.Insert(big.NewInt(i*7919), smt.Bytes32{byte(i)}); err != nil {
+			t.Fatalf("Failed to insert %d: %v", i, err)
+		}
+	}
+
+	reopened, err := smt.OpenSparseMerkleTree(db)
+	if err != nil {
+		t.Fatalf("Failed to reopen: %v", err)
+	}
+	if !reopened.Options().Compact || reopened.Root() != tree.Root() {
+		t.Fatalf("Reopened tree lost its layout")
+	}
+	if _, err := reopened.Insert(big.NewInt(5), smt.Bytes32{5}); err != nil {
+		t.Fatalf("Insert after reopen failed: %v", err)
+	}
+	proof, err := reopened.Get(big.NewInt(5))
+	if err != nil || !smt.VerifyProofWithOptions(reopened.Root(), proof, &smt.TreeOptions{Depth: 32, Compact: true}) {
+		t.Errorf("Proof after reopen does not verify: %v", err)
+	}
+	if smt.VerifyProofWithOptions(reopened.Root(), proof, &smt.TreeOptions{Depth: 32}) {
+		t.Error("Compact proof verifies with full-depth options")
+	}
+
+	var mismatch *smt.OptionMismatchError
+	if _, err := smt.NewSparseMerkleTree(db, 32); !errors.As(err, &mismatch) || mismatch.Option != "layout" {
+		t.Errorf("Expected layout mismatch, got %v", err)
+	}
+	report, err := smt.CheckDatabase(db)
+	if err != nil || !report.OK() {
+		t.Errorf("CheckDatabase of compact tree failed: %v %v", err, report)
+	}
+
+	// Full-depth trees do not record their layout
+	plainDB := smt.NewInMemoryDatabase()
+	plain, _ := smt.NewSparseMerkleTree(plainDB, 32)
+	plain.Insert(big.NewInt(1), smt.Bytes32{1})
+	if has, _ := plainDB.Has([]byte(smt.LayoutKey)); has {
+		t.Error("Full-depth tree wrote a layout record")
+	}
+	if _, err := smt.NewSparseMerkleTreeWithOptions(plainDB, &smt.TreeOptions{Depth: 32, Compact: true}); !errors.As(err, &mismatch) {
+		t.Errorf("Expected layout mismatch, got %v", err)
+	}
+}
+
+func TestCompactBulkLoadAndDump(t *testing.T) {
+	leaves := sortedLeaves(11, 300, 64)
+	opts := &smt.TreeOptions{Depth: 64, Compact: true}
+
+	seq, seqDB := newCompactTree(t, 64)
+	for i := len(leaves) - 1; i >= 0; i-- {
+		if _, err := seq.Insert(leaves[i].Index, leaves[i].Value); err != nil {
+			t.Fatalf("Failed to insert %s: %v", leaves[i].Index, err)
+		}
+	}
+
+	bulkDB := smt.NewInMemoryDatabase()
+	bulk, err := smt.BulkLoadWithOptions(bulkDB, opts, smt.SliceLeafSource(leaves))
+	if err != nil {
+		t.Fatalf("BulkLoadWithOptions failed: %v", err)
+	}
+	if bulk.Root() != seq.Root() {
+		t.Fatalf("Bulk root %s, sequential root %s", bulk.Root(), seq.Root())
+	}
+	compareRecords(t, storedRecords(t, bulkDB), storedRecords(t, seqDB))
+
+	var dump bytes.Buffer
+	if err := bulk.Export(&dump); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	imported, _ := newCompactTree(t, 64)
+	if err := imported.Import(&dump); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if imported.Root() != bulk.Root() {
+		t.Error("Imported compact tree has a different root")
+	}
+}
+
+func TestCompactProofSerialization(t *testing.T) {
+	tree, _ := newCompactTree(t, 8)
+	tree.Insert(big.NewInt(0x10), smt.Bytes32{1})
+	tree.Insert(big.NewInt(0x90), smt.Bytes32{2})
+
+	proof, err := tree.Get(big.NewInt(0x00))
+	if err != nil || proof.Neighbor == nil {
+		t.Fatalf("Expected a proof with a neighbour: %v", err)
+	}
+
+	data, err := json.Marshal(smt.SerializeProof(proof))
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	var sp smt.SerializedProof
+	if err := json.Unmarshal(data, &sp); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	decoded, err := smt.DeserializeProof(&sp)
+	if err != nil {
+		t.Fatalf("DeserializeProof failed: %v", err)
+	}
+	if decoded.Neighbor == nil || decoded.Neighbor.Index.Cmp(proof.Neighbor.Index) != 0 || decoded.Neighbor.Value != proof.Neighbor.Value {
+		t.Fatalf("Neighbour lost in serialization: %+v", decoded.Neighbor)
+	}
+	if !smt.VerifyCompactProof(tree.Root(), 8, decoded) {
+		t.Error("Deserialized proof does not verify")
+	}
+	if fields := smt.ProofToJSON(proof); fields["neighborIndex"] != "16" {
+		t.Errorf("ProofToJSON neighbour index %v", fields["neighborIndex"])
+	}
+
+	sp.NeighborValue = "0xzz"
+	if _, err := smt.DeserializeProof(&sp); err == nil {
+		t.Error("Expected an error for an invalid neighbour value")
+	}
+
+	// Proofs without a neighbour serialize without one
+	member, _ := tree.Get(big.NewInt(0x10))
+	data, _ = json.Marshal(smt.SerializeProof(member))
+	var fields map[string]interface{}
+	json.Unmarshal(data, &fields)
+	if _, ok := fields["neighborIndex"]; ok {
+		t.Error("Membership proof serialized a neighbour")
+	}
+}
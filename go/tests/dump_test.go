@@ -0,0 +1,233 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+
+	smt "github.com/0xanonymeow/smt/go"
+)
+
+func newDumpSource(t *testing.T) *smt.SparseMerkleTree {
+	t.Helper()
+	tree, err := smt.NewSparseMerkleTree(smt.NewInMemoryDatabase(), 16)
+	if err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+	for i := int64(0); i < 30; i++ {
+		if _, err := tree.Insert(big.NewInt(i*2003+1), smt.Bytes32{byte(i + 1), 0x11}); err != nil {
+			t.Fatalf("Failed to insert %d: %v", i, err)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		if _, err := tree.InsertKV(fmt.Sprintf("account-%d", i), smt.Bytes32{0xcc, byte(i)}); err != nil {
+			t.Fatalf("Failed to insert KV %d: %v", i, err)
+		}
+	}
+	return tree
+}
+
+func exportTree(t *testing.T, tree *smt.SparseMerkleTree) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := tree.Export(&buf); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func newImportTarget(t *testing.T, depth uint16) *smt.SparseMerkleTree {
+	t.Helper()
+	tree, err := smt.NewSparseMerkleTree(smt.NewInMemoryDatabase(), depth)
+	if err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+	return tree
+}
+
+// reseal recomputes the trailing checksum of an edited dump
+func reseal(dump []byte) []byte {
+	body := dump[:len(dump)-32]
+	return append(append([]byte{}, body...), crypto.Keccak256(body)...)
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	source := newDumpSource(t)
+	dump := exportTree(t, source)
+
+	target := newImportTarget(t, 16)
+	if err := target.Import(bytes.NewReader(dump)); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+
+	if target.Root() != source.Root() {
+		t.Fatalf("Imported root %s, expected %s", target.Root(), source.Root())
+	}
+	if target.Version() != 1 {
+		t.Errorf("Expected the import to commit one version, got %d", target.Version())
+	}
+
+	want, _ := source.Leaves(nil, nil)
+	got, err := target.Leaves(nil, nil)
+	if err != nil {
+		t.Fatalf("Leaves failed: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Imported %d leaves, expected %d", len(got), len(want))
+	}
+
+	for i := 0; i < 5; i++ {
+		value, exists, err := target.GetKV(fmt.Sprintf("account-%d", i))
+		if err != nil || !exists || value != (smt.Bytes32{0xcc, byte(i)}) {
+			t.Errorf("KV key account-%d not restored: %s %v %v", i, value, exists, err)
+		}
+	}
+
+	report, err := target.Check()
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if !report.OK() {
+		t.Errorf("Imported tree has issues: %v", report.Issues)
+	}
+
+	// Exporting the imported tree reproduces the dump byte for byte
+	if !bytes.Equal(exportTree(t, target), dump) {
+		t.Error("Re-exported dump differs from the original")
+	}
+}
+
+func TestExportEmptyTree(t *testing.T) {
+	dump := exportTree(t, newImportTarget(t, 8))
+
+	target := newImportTarget(t, 8)
+	if err := target.Import(bytes.NewReader(dump)); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if !target.Root().IsZero() {
+		t.Errorf("Expected an empty root, got %s", target.Root())
+	}
+}
+
+func TestImportRejectsBadDumps(t *testing.T) {
+	dump := exportTree(t, newDumpSource(t))
+
+	tests := []struct {
+		name  string
+		dump  func() []byte
+		check func(t *testing.T, err error)
+	}{
+		{
+			name: "flipped value byte",
+			dump: func() []byte {
+				d := append([]byte{}, dump...)
+				d[39+40] ^= 0x01
+				return d
+			},
+			check: func(t *testing.T, err error) {
+				if !errors.Is(err, smt.ErrDumpChecksum) {
+					t.Errorf("Expected ErrDumpChecksum, got %v", err)
+				}
+			},
+		},
+		{
+			name: "header root altered",
+			dump: func() []byte {
+				d := append([]byte{}, dump...)
+				d[10] ^= 0x01
+				return reseal(d)
+			},
+			check: func(t *testing.T, err error) {
+				var rootErr *smt.DumpRootMismatchError
+				if !errors.As(err, &rootErr) {
+					t.Errorf("Expected DumpRootMismatchError, got %v", err)
+				}
+			},
+		},
+		{
+			name: "truncated",
+			dump: func() []byte { return dump[:len(dump)/2] },
+			check: func(t *testing.T, err error) {
+				if !errors.Is(err, smt.ErrInvalidDump) {
+					t.Errorf("Expected ErrInvalidDump, got %v", err)
+				}
+			},
+		},
+		{
+			name: "bad magic",
+			dump: func() []byte {
+				d := append([]byte{}, dump...)
+				d[0] = 'X'
+				return d
+			},
+			check: func(t *testing.T, err error) {
+				if !errors.Is(err, smt.ErrInvalidDump) {
+					t.Errorf("Expected ErrInvalidDump, got %v", err)
+				}
+			},
+		},
+		{
+			name: "unsupported version",
+			dump: func() []byte {
+				d := append([]byte{}, dump...)
+				d[4] = 9
+				return d
+			},
+			check: func(t *testing.T, err error) {
+				var versionErr *smt.DumpVersionError
+				if !errors.As(err, &versionErr) || versionErr.Version != 9 {
+					t.Errorf("Expected DumpVersionError, got %v", err)
+				}
+			},
+		},
+		{
+			name: "wrong record count",
+			dump: func() []byte {
+				d := append([]byte{}, dump...)
+				d[len(d)-33]++
+				return reseal(d)
+			},
+			check: func(t *testing.T, err error) {
+				if !errors.Is(err, smt.ErrInvalidDump) {
+					t.Errorf("Expected ErrInvalidDump, got %v", err)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := newImportTarget(t, 16)
+			tt.check(t, target.Import(bytes.NewReader(tt.dump())))
+
+			// A rejected import leaves nothing behind
+			if !target.Root().IsZero() || target.Version() != 0 {
+				t.Errorf("Rejected import changed the tree: root %s version %d", target.Root(), target.Version())
+			}
+			if exists, _ := target.Exists(big.NewInt(1)); exists {
+				t.Error("Rejected import left a leaf behind")
+			}
+		})
+	}
+}
+
+func TestImportTargetErrors(t *testing.T) {
+	dump := exportTree(t, newDumpSource(t))
+
+	var depthErr *smt.DepthMismatchError
+	if err := newImportTarget(t, 32).Import(bytes.NewReader(dump)); !errors.As(err, &depthErr) {
+		t.Errorf("Expected DepthMismatchError, got %v", err)
+	}
+
+	target := newImportTarget(t, 16)
+	if _, err := target.Insert(big.NewInt(3), smt.Bytes32{3}); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+	if err := target.Import(bytes.NewReader(dump)); !errors.Is(err, smt.ErrTreeNotEmpty) {
+		t.Errorf("Expected ErrTreeNotEmpty, got %v", err)
+	}
+}
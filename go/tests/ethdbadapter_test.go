@@ -0,0 +1,103 @@
+package tests
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+
+	smt "github.com/0xanonymeow/smt/go"
+	"github.com/0xanonymeow/smt/go/ethdbadapter"
+)
+
+func TestEthdbAdapterBasicOperations(t *testing.T) {
+	db := ethdbadapter.NewDatabase(memorydb.New())
+
+	value, err := db.Get([]byte("missing"))
+	if err != nil || value != nil {
+		t.Fatalf("Missing key should return nil without error, got %q (err=%v)", value, err)
+	}
+
+	if err := db.Set([]byte("key"), []byte("value")); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	value, err = db.Get([]byte("key"))
+	if err != nil || string(value) != "value" {
+		t.Fatalf("Expected key=value, got %q (err=%v)", value, err)
+	}
+	if has, _ := db.Has([]byte("key")); !has {
+		t.Fatal("Key should exist")
+	}
+
+	if err := db.Delete([]byte("key")); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if has, _ := db.Has([]byte("key")); has {
+		t.Fatal("Key should be deleted")
+	}
+}
+
+func TestEthdbAdapterBatch(t *testing.T) {
+	kv := memorydb.New()
+	db := ethdbadapter.NewDatabase(kv)
+
+	batch := db.NewBatch()
+	batch.Put([]byte("a"), []byte("1"))
+	batch.Put([]byte("b"), []byte("2"))
+	batch.Delete([]byte("a"))
+
+	if has, _ := kv.Has([]byte("b")); has {
+		t.Fatal("Batch writes must not be visible before Write")
+	}
+	if err := batch.Write(); err != nil {
+		t.Fatalf("Batch write failed: %v", err)
+	}
+	if has, _ := kv.Has([]byte("a")); has {
+		t.Fatal("Deleted key should not exist after Write")
+	}
+	if value, _ := kv.Get([]byte("b")); string(value) != "2" {
+		t.Fatalf("Expected b=2 after Write, got %q", value)
+	}
+}
+
+func TestEthdbAdapterTree(t *testing.T) {
+	kv := memorydb.New()
+	db := ethdbadapter.NewDatabase(kv)
+
+	tree, err := smt.NewSparseMerkleTree(db, 32)
+	if err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+	reference, err := smt.NewSparseMerkleTree(smt.NewInMemoryDatabase(), 32)
+	if err != nil {
+		t.Fatalf("Failed to create reference tree: %v", err)
+	}
+
+	for i := int64(1); i <= 20; i++ {
+		tree.Insert(big.NewInt(i*31), smt.Bytes32{byte(i)})
+		reference.Insert(big.NewInt(i*31), smt.Bytes32{byte(i)})
+	}
+	tree.Delete(big.NewInt(62))
+	reference.Delete(big.NewInt(62))
+	tree.InsertKV("account", smt.Bytes32{0x99})
+	reference.InsertKV("account", smt.Bytes32{0x99})
+
+	if tree.Root() != reference.Root() {
+		t.Fatalf("Root over ethdb %s should match in-memory root %s", tree.Root(), reference.Root())
+	}
+
+	reopened, err := smt.OpenSparseMerkleTree(ethdbadapter.NewDatabase(kv))
+	if err != nil {
+		t.Fatalf("Failed to reopen tree: %v", err)
+	}
+	if reopened.Root() != tree.Root() {
+		t.Fatal("Reopened tree should restore the root")
+	}
+	if value, exists, _ := reopened.GetKV("account"); !exists || value != (smt.Bytes32{0x99}) {
+		t.Fatal("KV entry should be readable through the adapter")
+	}
+
+	if has, _ := kv.Has([]byte(smt.MetadataKey)); !has {
+		t.Fatal("Tree metadata should be stored in the wrapped store")
+	}
+}
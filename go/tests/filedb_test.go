@@ -0,0 +1,459 @@
+package tests
+
+import (
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	smt "github.com/0xanonymeow/smt/go"
+	"github.com/0xanonymeow/smt/go/filedb"
+)
+
+func openFileDB(t *testing.T, dir string, opts *filedb.Options) *filedb.FileDatabase {
+	t.Helper()
+	db, err := filedb.Open(dir, opts)
+	if err != nil {
+		t.Fatalf("Failed to open file database: %v", err)
+	}
+	return db
+}
+
+func TestFileDatabaseBasicOperations(t *testing.T) {
+	dir := t.TempDir()
+	db := openFileDB(t, dir, nil)
+
+	if err := db.Set([]byte("a"), []byte("1")); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := db.Set([]byte("b"), []byte("2")); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := db.Set([]byte("a"), []byte("3")); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := db.Delete([]byte("b")); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	value, err := db.Get([]byte("a"))
+	if err != nil || string(value) != "3" {
+		t.Fatalf("Expected a=3, got %q (err=%v)", value, err)
+	}
+	if value, _ := db.Get([]byte("b")); value != nil {
+		t.Fatalf("Deleted key should return nil, got %q", value)
+	}
+	if has, _ := db.Has([]byte("b")); has {
+		t.Fatal("Deleted key should not exist")
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if _, err := db.Get([]byte("a")); err != filedb.ErrClosed {
+		t.Fatalf("Expected ErrClosed after Close, got %v", err)
+	}
+
+	// Reopen and replay the log
+	db = openFileDB(t, dir, nil)
+	defer db.Close()
+
+	value, _ = db.Get([]byte("a"))
+	if string(value) != "3" {
+		t.Fatalf("Expected a=3 after reopen, got %q", value)
+	}
+	if has, _ := db.Has([]byte("b")); has {
+		t.Fatal("Deleted key should stay deleted after reopen")
+	}
+}
+
+func TestFileDatabaseBatchIsAtomic(t *testing.T) {
+	dir := t.TempDir()
+	db := openFileDB(t, dir, nil)
+
+	batch := db.NewBatch()
+	batch.Put([]byte("x"), []byte("1"))
+	batch.Put([]byte("y"), []byte("2"))
+	batch.Delete([]byte("x"))
+	if err := batch.Write(); err != nil {
+		t.Fatalf("Batch write failed: %v", err)
+	}
+	db.Close()
+
+	// Chop the last byte off the log: the whole batch record must be discarded
+	segment := filepath.Join(dir, "00000001.log")
+	info, err := os.Stat(segment)
+	if err != nil {
+		t.Fatalf("Failed to stat segment: %v", err)
+	}
+	if err := os.Truncate(segment, info.Size()-1); err != nil {
+		t.Fatalf("Failed to truncate segment: %v", err)
+	}
+
+	db = openFileDB(t, dir, nil)
+	defer db.Close()
+
+	if has, _ := db.Has([]byte("y")); has {
+		t.Fatal("A torn batch must not be partially applied")
+	}
+	if stats := db.Stats(); stats.Keys != 0 {
+		t.Fatalf("Expected empty database after discarding torn batch, got %d keys", stats.Keys)
+	}
+}
+
+func TestFileDatabaseRecoversTornTail(t *testing.T) {
+	dir := t.TempDir()
+	db := openFileDB(t, dir, nil)
+	for i := 0; i < 10; i++ {
+		db.Set([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i)))
+	}
+	db.Close()
+
+	// Append garbage that looks like the start of a record
+	segment := filepath.Join(dir, "00000001.log")
+	f, err := os.OpenFile(segment, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatalf("Failed to open segment: %v", err)
+	}
+	f.Write([]byte{0xde, 0xad, 0xbe, 0xef, 0xff, 0x00, 0x00, 0x00, 0x01})
+	f.Close()
+	sizeWithGarbage, _ := os.Stat(segment)
+
+	db = openFileDB(t, dir, nil)
+	for i := 0; i < 10; i++ {
+		value, _ := db.Get([]byte(fmt.Sprintf("key%d", i)))
+		if string(value) != fmt.Sprintf("value%d", i) {
+			t.Fatalf("Expected key%d to survive recovery, got %q", i, value)
+		}
+	}
+
+	truncated, _ := os.Stat(segment)
+	if truncated.Size() >= sizeWithGarbage.Size() {
+		t.Fatal("Torn tail should be truncated during recovery")
+	}
+
+	// New writes after recovery are readable after another restart
+	db.Set([]byte("after"), []byte("recovery"))
+	db.Close()
+
+	db = openFileDB(t, dir, nil)
+	defer db.Close()
+	if value, _ := db.Get([]byte("after")); string(value) != "recovery" {
+		t.Fatalf("Expected write after recovery to persist, got %q", value)
+	}
+}
+
+func TestFileDatabaseCorruptedSealedSegment(t *testing.T) {
+	dir := t.TempDir()
+	db := openFileDB(t, dir, &filedb.Options{MaxSegmentSize: 64})
+	for i := 0; i < 20; i++ {
+		db.Set([]byte(fmt.Sprintf("key%02d", i)), []byte("some value bytes"))
+	}
+	db.Close()
+
+	// Flip a byte inside the first (sealed) segment
+	segment := filepath.Join(dir, "00000001.log")
+	data, _ := os.ReadFile(segment)
+	data[len(data)-1] ^= 0xff
+	os.WriteFile(segment, data, 0o644)
+
+	if _, err := filedb.Open(dir, nil); err == nil {
+		t.Fatal("Corruption in a sealed segment should be reported")
+	}
+}
+
+func TestFileDatabaseCompaction(t *testing.T) {
+	dir := t.TempDir()
+	db := openFileDB(t, dir, &filedb.Options{SyncPolicy: filedb.SyncNever, MaxSegmentSize: 4096})
+
+	for round := 0; round < 20; round++ {
+		for i := 0; i < 50; i++ {
+			db.Set([]byte(fmt.Sprintf("key%02d", i)), []byte(fmt.Sprintf("round%02d-value%02d", round, i)))
+		}
+	}
+	for i := 40; i < 50; i++ {
+		db.Delete([]byte(fmt.Sprintf("key%02d", i)))
+	}
+
+	before := db.Stats()
+	if db.GarbageRatio() < 0.5 {
+		t.Fatalf("Expected mostly garbage before compaction, got ratio %f", db.GarbageRatio())
+	}
+
+	if err := db.Compact(); err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+
+	after := db.Stats()
+	if after.TotalBytes >= before.TotalBytes/2 {
+		t.Fatalf("Compaction should reclaim space: %d bytes before, %d after", before.TotalBytes, after.TotalBytes)
+	}
+	if after.Keys != 40 {
+		t.Fatalf("Expected 40 live keys, got %d", after.Keys)
+	}
+
+	// Writes after compaction land in the active segment
+	db.Set([]byte("key00"), []byte("final"))
+	db.Close()
+
+	db = openFileDB(t, dir, nil)
+	defer db.Close()
+
+	if value, _ := db.Get([]byte("key00")); string(value) != "final" {
+		t.Fatalf("Expected key00=final after reopen, got %q", value)
+	}
+	if value, _ := db.Get([]byte("key39")); string(value) != "round19-value39" {
+		t.Fatalf("Expected key39 to survive compaction, got %q", value)
+	}
+	if has, _ := db.Has([]byte("key45")); has {
+		t.Fatal("Deleted key must not be resurrected by compaction")
+	}
+}
+
+func TestFileDatabaseInterruptedCompaction(t *testing.T) {
+	dir := t.TempDir()
+	db := openFileDB(t, dir, &filedb.Options{MaxSegmentSize: 256})
+	for i := 0; i < 30; i++ {
+		db.Set([]byte(fmt.Sprintf("key%02d", i)), []byte("value"))
+	}
+	db.Delete([]byte("key00"))
+
+	// Keep a copy of a segment that compaction is about to remove
+	oldSegment := filepath.Join(dir, "00000001.log")
+	oldData, err := os.ReadFile(oldSegment)
+	if err != nil {
+		t.Fatalf("Failed to read segment: %v", err)
+	}
+
+	if err := db.Compact(); err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	db.Close()
+
+	// Simulate a crash after the rename but before old segments were removed
+	os.WriteFile(oldSegment, oldData, 0o644)
+	os.WriteFile(filepath.Join(dir, "compact.tmp"), []byte("partial"), 0o644)
+
+	db = openFileDB(t, dir, nil)
+	defer db.Close()
+
+	if has, _ := db.Has([]byte("key00")); has {
+		t.Fatal("Stale segment left by an interrupted compaction must be ignored")
+	}
+	if stats := db.Stats(); stats.Keys != 29 {
+		t.Fatalf("Expected 29 keys, got %d", stats.Keys)
+	}
+	if _, err := os.Stat(oldSegment); !os.IsNotExist(err) {
+		t.Fatal("Superseded segment should be removed on open")
+	}
+}
+
+// readDir returns the name and contents of every file in dir
+func readDir(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Failed to read directory: %v", err)
+	}
+	files := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", entry.Name(), err)
+		}
+		files[entry.Name()] = string(data)
+	}
+	return files
+}
+
+func TestFileDatabaseReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	db := openFileDB(t, dir, &filedb.Options{MaxSegmentSize: 64})
+	for i := 0; i < 10; i++ {
+		db.Set([]byte(fmt.Sprintf("key%02d", i)), []byte("some value bytes"))
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	for i := 10; i < 20; i++ {
+		db.Set([]byte(fmt.Sprintf("key%02d", i)), []byte("some value bytes"))
+	}
+	db.Close()
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) < 3 {
+		t.Fatalf("Expected a base segment and at least two more, got %d files", len(entries))
+	}
+	sealed := entries[len(entries)-2].Name()
+	last := entries[len(entries)-1].Name()
+
+	// Damage a sealed segment and the tail of the newest one, and leave behind
+	// what a read-write open would clean up
+	data, _ := os.ReadFile(filepath.Join(dir, sealed))
+	data[len(data)-1] ^= 0xff
+	os.WriteFile(filepath.Join(dir, sealed), data, 0o644)
+	f, err := os.OpenFile(filepath.Join(dir, last), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatalf("Failed to open segment: %v", err)
+	}
+	f.Write([]byte{0xde, 0xad, 0xbe, 0xef, 0xff})
+	f.Close()
+	os.WriteFile(filepath.Join(dir, "00000000.log"), []byte("SMTLOG01\x00"), 0o644)
+	os.WriteFile(filepath.Join(dir, "compact.tmp"), []byte("partial"), 0o644)
+	before := readDir(t, dir)
+
+	db = openFileDB(t, dir, &filedb.Options{ReadOnly: true})
+	damage := db.Damage()
+	if len(damage) != 2 {
+		t.Fatalf("Expected 2 damaged records, got %v", damage)
+	}
+	if damage[0].File != sealed || !strings.Contains(damage[0].Reason, "checksum mismatch") {
+		t.Errorf("Expected a checksum mismatch in %s, got %v", sealed, damage[0])
+	}
+	if damage[1].File != last || !strings.Contains(damage[1].Reason, "past the end") {
+		t.Errorf("Expected a torn tail in %s, got %v", last, damage[1])
+	}
+
+	if value, _ := db.Get([]byte("key00")); string(value) != "some value bytes" {
+		t.Errorf("Expected records of the base segment to be readable, got %q", value)
+	}
+	if err := db.Set([]byte("key"), []byte("value")); err != filedb.ErrReadOnly {
+		t.Errorf("Expected ErrReadOnly from Set, got %v", err)
+	}
+	if err := db.Delete([]byte("key00")); err != filedb.ErrReadOnly {
+		t.Errorf("Expected ErrReadOnly from Delete, got %v", err)
+	}
+	if err := db.Compact(); err != filedb.ErrReadOnly {
+		t.Errorf("Expected ErrReadOnly from Compact, got %v", err)
+	}
+	db.Close()
+
+	after := readDir(t, dir)
+	if len(after) != len(before) {
+		t.Fatalf("Read-only open changed the directory: %d files before, %d after", len(before), len(after))
+	}
+	for name, data := range before {
+		if after[name] != data {
+			t.Errorf("Read-only open modified %s", name)
+		}
+	}
+
+	// A read-write open still repairs the tail and fails on the sealed segment
+	if _, err := filedb.Open(dir, nil); err == nil {
+		t.Fatal("Corruption in a sealed segment should be reported")
+	}
+}
+
+func TestFileDatabaseReadOnlyCreatesNothing(t *testing.T) {
+	dir := t.TempDir()
+
+	db := openFileDB(t, dir, &filedb.Options{ReadOnly: true})
+	if stats := db.Stats(); stats.Keys != 0 || stats.Segments != 0 {
+		t.Errorf("Expected an empty database without segments, got %+v", stats)
+	}
+	if len(db.Damage()) != 0 {
+		t.Errorf("Expected no damage, got %v", db.Damage())
+	}
+	db.Close()
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("Read-only open created %d files", len(entries))
+	}
+
+	missing := filepath.Join(dir, "missing")
+	if _, err := filedb.Open(missing, &filedb.Options{ReadOnly: true}); err == nil {
+		t.Fatal("Read-only open of a missing directory should fail")
+	}
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Fatal("Read-only open should not create the directory")
+	}
+}
+
+func TestFileDatabaseBackgroundWork(t *testing.T) {
+	dir := t.TempDir()
+	db := openFileDB(t, dir, &filedb.Options{
+		SyncPolicy:         filedb.SyncInterval,
+		SyncInterval:       5 * time.Millisecond,
+		MaxSegmentSize:     512,
+		CompactionInterval: 5 * time.Millisecond,
+		CompactionRatio:    0.3,
+	})
+
+	for round := 0; round < 20; round++ {
+		for i := 0; i < 10; i++ {
+			db.Set([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", round)))
+		}
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for db.GarbageRatio() >= 0.3 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if ratio := db.GarbageRatio(); ratio >= 0.3 {
+		t.Fatalf("Background compaction should reduce garbage, ratio still %f", ratio)
+	}
+
+	if err := db.Sync(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	db = openFileDB(t, dir, nil)
+	defer db.Close()
+	if value, _ := db.Get([]byte("key3")); string(value) != "value19" {
+		t.Fatalf("Expected key3=value19, got %q", value)
+	}
+}
+
+func TestFileDatabaseTreeSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	db := openFileDB(t, dir, &filedb.Options{SyncPolicy: filedb.SyncNever, MaxSegmentSize: 16 << 10})
+
+	tree, err := smt.NewSparseMerkleTree(db, 64)
+	if err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+	for i := int64(0); i < 100; i++ {
+		if _, err := tree.Insert(big.NewInt(i*977), smt.Bytes32{byte(i), 1}); err != nil {
+			t.Fatalf("Insert %d failed: %v", i, err)
+		}
+	}
+	for i := int64(0); i < 100; i += 3 {
+		if _, err := tree.Delete(big.NewInt(i * 977)); err != nil {
+			t.Fatalf("Delete %d failed: %v", i, err)
+		}
+	}
+	tree.InsertKV("account", smt.Bytes32{0x42})
+	root := tree.Root()
+
+	if err := db.Compact(); err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	db.Close()
+
+	db = openFileDB(t, dir, nil)
+	defer db.Close()
+
+	reopened, err := smt.OpenSparseMerkleTree(db)
+	if err != nil {
+		t.Fatalf("Failed to reopen tree: %v", err)
+	}
+	if reopened.Root() != root {
+		t.Fatalf("Expected root %s after restart, got %s", root, reopened.Root())
+	}
+
+	proof, err := reopened.Get(big.NewInt(977))
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if !proof.Exists || !reopened.VerifyProof(proof) {
+		t.Fatal("Proof from the reopened tree should verify")
+	}
+	if value, exists, _ := reopened.GetKV("account"); !exists || value != (smt.Bytes32{0x42}) {
+		t.Fatal("KV entry should survive the restart")
+	}
+}
@@ -0,0 +1,175 @@
+package tests
+
+import (
+	"crypto/sha256"
+	"errors"
+	"math/big"
+	"testing"
+
+	smt "github.com/0xanonymeow/smt/go"
+)
+
+func TestSHA256HashSuite(t *testing.T) {
+	suite := smt.SHA256HashSuite()
+
+	// Leaves hash index(32) || value(32) || 1, nodes hash left || right
+	index := big.NewInt(0x1234)
+	value := smt.Bytes32{0xab}
+	preimage := make([]byte, 65)
+	preimage[30], preimage[31] = 0x12, 0x34
+	copy(preimage[32:64], value[:])
+	preimage[64] = 1
+	if got := suite.HashLeaf(index, value); got != smt.Bytes32(sha256.Sum256(preimage)) {
+		t.Errorf("Unexpected SHA-256 leaf hash %s", got)
+	}
+
+	left, right := smt.Bytes32{1}, smt.Bytes32{2}
+	if got := suite.HashNode(left, right); got != smt.Bytes32(sha256.Sum256(append(left[:], right[:]...))) {
+		t.Errorf("Unexpected SHA-256 node hash %s", got)
+	}
+
+	keccak := smt.Keccak256HashSuite()
+	if keccak.HashNode(left, right) != smt.HashBytes32(left, right) || keccak.HashLeaf(index, value) != smt.ComputeLeafHash(index, value) {
+		t.Error("Keccak256 suite differs from the package hash functions")
+	}
+}
+
+func TestTreeWithSHA256Suite(t *testing.T) {
+	suite := smt.SHA256HashSuite()
+	db := smt.NewInMemoryDatabase()
+	tree, err := smt.NewSparseMerkleTreeWithHashSuite(db, 16, suite)
+	if err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+	keccakTree := CreateTestTree(t, 16)
+
+	for i := int64(1); i <= 20; i++ {
+		if _, err := tree.Insert(big.NewInt(i*331), smt.Bytes32{byte(i)}); err != nil {
+			t.Fatalf("Failed to insert %d: %v", i, err)
+		}
+		if _, err := keccakTree.Insert(big.NewInt(i*331), smt.Bytes32{byte(i)}); err != nil {
+			t.Fatalf("Failed to insert %d: %v", i, err)
+		}
+	}
+	if tree.Root() == keccakTree.Root() {
+		t.Fatal("SHA-256 and Keccak256 trees share a root")
+	}
+
+	proof, err := tree.Get(big.NewInt(331))
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if !smt.VerifyProof(tree.Root(), 16, proof, suite) {
+		t.Error("Proof does not verify with the SHA-256 suite")
+	}
+	if smt.VerifyProof(tree.Root(), 16, proof) {
+		t.Error("Proof verifies with the default Keccak256 suite")
+	}
+	if !tree.VerifyProof(proof) || tree.ComputeRoot(proof) != tree.Root() {
+		t.Error("Tree methods do not use the tree's suite")
+	}
+	if smt.ComputeRootFromProof(16, proof, suite) != tree.Root() {
+		t.Error("ComputeRootFromProof does not rebuild the root with the suite")
+	}
+
+	oldRoot := tree.Root()
+	update, err := tree.Update(big.NewInt(662), smt.Bytes32{0xff})
+	if err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if !smt.VerifyProof(oldRoot, 16, &smt.Proof{
+		Exists: update.Exists, Leaf: update.Leaf, Value: update.Value,
+		Index: update.Index, Enables: update.Enables, Siblings: update.Siblings,
+	}, suite) {
+		t.Error("Update proof does not verify against the old root")
+	}
+
+	absent, err := tree.Get(big.NewInt(7))
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if absent.Exists || !smt.VerifyProof(tree.Root(), 16, absent, suite) {
+		t.Error("Non-membership proof does not verify with the SHA-256 suite")
+	}
+
+	report, err := tree.Check()
+	if err != nil || !report.OK() {
+		t.Errorf("Check of SHA-256 tree failed: %v %v", err, report)
+	}
+	report, err = smt.CheckDatabase(db)
+	if err != nil || !report.OK() {
+		t.Errorf("CheckDatabase of SHA-256 tree failed: %v %v", err, report)
+	}
+}
+
+func TestHashSuitePersistence(t *testing.T) {
+	db := smt.NewInMemoryDatabase()
+	tree, err := smt.NewSparseMerkleTreeWithHashSuite(db, 16, smt.SHA256HashSuite())
+	if err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+	if _, err := tree.Insert(big.NewInt(5), smt.Bytes32{5}); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+
+	reopened, err := smt.OpenSparseMerkleTree(db)
+	if err != nil {
+		t.Fatalf("Failed to reopen: %v", err)
+	}
+	if reopened.HashSuite().Name != smt.SHA256SuiteName || reopened.Root() != tree.Root() {
+		t.Errorf("Reopened with suite %q root %s", reopened.HashSuite().Name, reopened.Root())
+	}
+
+	var mismatch *smt.HashSuiteMismatchError
+	if _, err := smt.NewSparseMerkleTree(db, 16); !errors.As(err, &mismatch) {
+		t.Errorf("Expected HashSuiteMismatchError, got %v", err)
+	} else if mismatch.Stored != smt.SHA256SuiteName || mismatch.Requested != smt.Keccak256SuiteName {
+		t.Errorf("Unexpected mismatch %+v", mismatch)
+	}
+
+	// Default trees do not record their suite
+	defaultDB := smt.NewInMemoryDatabase()
+	defaultTree, _ := smt.NewSparseMerkleTree(defaultDB, 16)
+	if _, err := defaultTree.Insert(big.NewInt(5), smt.Bytes32{5}); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+	if has, _ := defaultDB.Has([]byte(smt.HashSuiteKey)); has {
+		t.Error("Default tree wrote a hash suite record")
+	}
+	if name, err := smt.ReadHashSuiteName(defaultDB); err != nil || name != smt.Keccak256SuiteName {
+		t.Errorf("Expected keccak256, got %q %v", name, err)
+	}
+}
+
+func TestCustomHashSuite(t *testing.T) {
+	custom := smt.SHA256HashSuite()
+	custom.Name = "sha256-custom"
+
+	db := smt.NewInMemoryDatabase()
+	tree, err := smt.NewSparseMerkleTreeWithHashSuite(db, 8, custom)
+	if err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+	if _, err := tree.Insert(big.NewInt(9), smt.Bytes32{9}); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+
+	var mismatch *smt.HashSuiteMismatchError
+	if _, err := smt.OpenSparseMerkleTree(db); !errors.As(err, &mismatch) {
+		t.Errorf("Expected HashSuiteMismatchError for a custom suite, got %v", err)
+	}
+	if _, err := smt.NewSparseMerkleTreeWithHashSuite(db, 8, custom); err != nil {
+		t.Errorf("Failed to reopen with the custom suite: %v", err)
+	}
+
+	invalid := []*smt.HashSuite{
+		{Node: smt.Hash, Leaf: smt.ComputeLeafHash},
+		{Name: "no-node", Leaf: smt.ComputeLeafHash},
+		{Name: "no-leaf", Node: smt.Hash},
+	}
+	for _, suite := range invalid {
+		if _, err := smt.NewSparseMerkleTreeWithHashSuite(smt.NewInMemoryDatabase(), 8, suite); !errors.Is(err, smt.ErrInvalidHashSuite) {
+			t.Errorf("Expected ErrInvalidHashSuite for %q, got %v", suite.Name, err)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package tests
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+
+	smt "github.com/0xanonymeow/smt/go"
+	"github.com/0xanonymeow/smt/go/ethdbadapter"
+)
+
+func TestForEachLeafVisitsLeavesInIndexOrder(t *testing.T) {
+	tree, err := smt.NewSparseMerkleTree(smt.NewInMemoryDatabase(), 8)
+	if err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+
+	indices := []int64{200, 3, 128, 0, 255, 64, 127}
+	for _, idx := range indices {
+		if _, err := tree.Insert(big.NewInt(idx), smt.Bytes32{byte(idx), 1}); err != nil {
+			t.Fatalf("Failed to insert %d: %v", idx, err)
+		}
+	}
+	if _, err := tree.Delete(big.NewInt(64)); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	if _, err := tree.Update(big.NewInt(3), smt.Bytes32{0xaa}); err != nil {
+		t.Fatalf("Failed to update: %v", err)
+	}
+
+	expected := []int64{0, 3, 127, 128, 200, 255}
+	visited := make([]int64, 0)
+	err = tree.ForEachLeaf(func(index *big.Int, value smt.Bytes32) bool {
+		visited = append(visited, index.Int64())
+		want := smt.Bytes32{byte(index.Int64()), 1}
+		if index.Int64() == 3 {
+			want = smt.Bytes32{0xaa}
+		}
+		if value != want {
+			t.Errorf("Unexpected value at %d: %s", index, value)
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatalf("ForEachLeaf failed: %v", err)
+	}
+
+	if len(visited) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, visited)
+	}
+	for i := range expected {
+		if visited[i] != expected[i] {
+			t.Fatalf("Expected %v, got %v", expected, visited)
+		}
+	}
+
+	count := 0
+	tree.ForEachLeaf(func(*big.Int, smt.Bytes32) bool {
+		count++
+		return count < 2
+	})
+	if count != 2 {
+		t.Fatalf("Expected iteration to stop after 2 leaves, got %d", count)
+	}
+}
+
+func TestLeavesRange(t *testing.T) {
+	tree, err := smt.NewSparseMerkleTree(smt.NewInMemoryDatabase(), 16)
+	if err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+	for i := int64(0); i < 50; i++ {
+		if _, err := tree.Insert(big.NewInt(i*1000), smt.Bytes32{byte(i)}); err != nil {
+			t.Fatalf("Failed to insert %d: %v", i, err)
+		}
+	}
+
+	leaves, err := tree.Leaves(big.NewInt(5000), big.NewInt(9000))
+	if err != nil {
+		t.Fatalf("Leaves failed: %v", err)
+	}
+	if len(leaves) != 4 {
+		t.Fatalf("Expected 4 leaves, got %d", len(leaves))
+	}
+	for i, leaf := range leaves {
+		if leaf.Index.Int64() != int64(5+i)*1000 || leaf.Value != (smt.Bytes32{byte(5 + i)}) {
+			t.Fatalf("Unexpected leaf %d: index %s value %s", i, leaf.Index, leaf.Value)
+		}
+	}
+
+	// End is exclusive, start is inclusive
+	leaves, _ = tree.Leaves(big.NewInt(1000), big.NewInt(2000))
+	if len(leaves) != 1 || leaves[0].Index.Int64() != 1000 {
+		t.Fatalf("Expected only index 1000, got %v", leaves)
+	}
+
+	all, err := tree.Leaves(nil, nil)
+	if err != nil || len(all) != 50 {
+		t.Fatalf("Expected 50 leaves, got %d (err=%v)", len(all), err)
+	}
+	tail, _ := tree.Leaves(big.NewInt(45000), nil)
+	if len(tail) != 5 {
+		t.Fatalf("Expected 5 leaves, got %d", len(tail))
+	}
+
+	empty, err := smt.NewSparseMerkleTree(smt.NewInMemoryDatabase(), 16)
+	if err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+	if leaves, err := empty.Leaves(nil, nil); err != nil || len(leaves) != 0 {
+		t.Fatalf("Empty tree should have no leaves, got %d (err=%v)", len(leaves), err)
+	}
+}
+
+func TestIterableDatabases(t *testing.T) {
+	backends := map[string]smt.IterableDatabase{
+		"memory": smt.NewInMemoryDatabase(),
+		"file":   openFileDB(t, t.TempDir(), nil),
+		"ethdb":  ethdbadapter.NewDatabase(memorydb.New()),
+	}
+
+	for name, db := range backends {
+		t.Run(name, func(t *testing.T) {
+			for _, key := range []string{"b:2", "a:1", "b:1", "c:1", "b:3"} {
+				if err := db.Set([]byte(key), []byte("v"+key)); err != nil {
+					t.Fatalf("Set failed: %v", err)
+				}
+			}
+			db.Delete([]byte("b:2"))
+
+			keys := make([]string, 0)
+			err := db.IteratePrefix([]byte("b:"), func(key, value []byte) bool {
+				if string(value) != "v"+string(key) {
+					t.Errorf("Unexpected value for %s: %s", key, value)
+				}
+				keys = append(keys, string(key))
+				return true
+			})
+			if err != nil {
+				t.Fatalf("IteratePrefix failed: %v", err)
+			}
+			if len(keys) != 2 || keys[0] != "b:1" || keys[1] != "b:3" {
+				t.Fatalf("Expected [b:1 b:3], got %v", keys)
+			}
+
+			count := 0
+			db.IteratePrefix(nil, func(key, value []byte) bool {
+				count++
+				return count < 3
+			})
+			if count != 3 {
+				t.Fatalf("Expected iteration to stop after 3 keys, got %d", count)
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"math/rand"
+	"testing"
+
+	smt "github.com/0xanonymeow/smt/go"
+)
+
+// multiProofTree returns a tree holding count random leaves and indices mixing
+// some of them with indices that hold no leaf
+func multiProofTree(t *testing.T, depth uint16, count, proven int) (*smt.SparseMerkleTree, []*big.Int) {
+	t.Helper()
+	tree := CreateTestTree(t, depth)
+	leaves := sortedLeaves(11, count+proven, uint(depth))
+	rng := rand.New(rand.NewSource(12))
+	rng.Shuffle(len(leaves), func(i, j int) { leaves[i], leaves[j] = leaves[j], leaves[i] })
+
+	writes := make([]smt.LeafWrite, count)
+	for i := range writes {
+		writes[i] = smt.LeafWrite{Index: leaves[i].Index, Value: leaves[i].Value}
+	}
+	if err := tree.CommitBatch(writes); err != nil {
+		t.Fatalf("CommitBatch failed: %v", err)
+	}
+
+	indices := make([]*big.Int, 0, proven)
+	for i := 0; i < proven; i++ {
+		if i%5 == 0 {
+			indices = append(indices, leaves[count+i].Index)
+		} else {
+			indices = append(indices, leaves[rng.Intn(count)].Index)
+		}
+	}
+	return tree, indices
+}
+
+func TestMultiProof(t *testing.T) {
+	tree, indices := multiProofTree(t, 256, 1000, 500)
+
+	mp, err := tree.GetMultiProof(indices)
+	if err != nil {
+		t.Fatalf("GetMultiProof failed: %v", err)
+	}
+	if !smt.VerifyMultiProof(tree.Root(), 256, mp) || !tree.VerifyMultiProof(mp) {
+		t.Fatal("Multi-proof does not verify")
+	}
+	if smt.ComputeRootFromMultiProof(256, mp) != tree.Root() {
+		t.Error("ComputeRootFromMultiProof does not match the root")
+	}
+
+	// Every index is proven once, in order, with what Get reports
+	separate := 0
+	for i, index := range mp.Indices {
+		if i > 0 && index.Cmp(mp.Indices[i-1]) <= 0 {
+			t.Fatalf("Indices are not strictly ascending at %d", i)
+		}
+		proof, err := tree.Get(index)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if proof.Exists != mp.Exists[i] || proof.Value != mp.Values[i] {
+			t.Errorf("Index %s: multi-proof holds %v %s, Get %v %s", index, mp.Exists[i], mp.Values[i], proof.Exists, proof.Value)
+		}
+		separate += len(proof.Siblings)
+	}
+	if len(mp.Siblings)*2 > separate {
+		t.Errorf("Multi-proof holds %d siblings, separate proofs %d", len(mp.Siblings), separate)
+	}
+}
+
+func TestMultiProofSingleIndexMatchesProof(t *testing.T) {
+	tree, indices := multiProofTree(t, 32, 200, 10)
+	for _, index := range indices {
+		mp, err := tree.GetMultiProof([]*big.Int{index})
+		if err != nil {
+			t.Fatalf("GetMultiProof failed: %v", err)
+		}
+		proof, err := tree.Get(index)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if mp.Enables.Cmp(proof.Enables) != 0 || len(mp.Siblings) != len(proof.Siblings) {
+			t.Fatalf("Index %s: enables %x, proof enables %x", index, mp.Enables, proof.Enables)
+		}
+		for i := range mp.Siblings {
+			if mp.Siblings[i] != proof.Siblings[i] {
+				t.Errorf("Index %s: sibling %d differs", index, i)
+			}
+		}
+	}
+}
+
+func TestMultiProofRejectsTampering(t *testing.T) {
+	tree, indices := multiProofTree(t, 64, 300, 40)
+	root := tree.Root()
+
+	fresh := func() *smt.MultiProof {
+		mp, err := tree.GetMultiProof(indices)
+		if err != nil {
+			t.Fatalf("GetMultiProof failed: %v", err)
+		}
+		return mp
+	}
+
+	cases := map[string]func(mp *smt.MultiProof){
+		"value":           func(mp *smt.MultiProof) { mp.Values[1][0] ^= 1 },
+		"exists":          func(mp *smt.MultiProof) { mp.Exists[0] = !mp.Exists[0] },
+		"sibling":         func(mp *smt.MultiProof) { mp.Siblings[2][0] ^= 1 },
+		"extra sibling":   func(mp *smt.MultiProof) { mp.Siblings = append(mp.Siblings, smt.Bytes32{1}) },
+		"missing sibling": func(mp *smt.MultiProof) { mp.Siblings = mp.Siblings[:len(mp.Siblings)-1] },
+		"extra enable":    func(mp *smt.MultiProof) { mp.Enables.SetBit(mp.Enables, 1<<16, 1) },
+		"unordered":       func(mp *smt.MultiProof) { mp.Indices[0], mp.Indices[1] = mp.Indices[1], mp.Indices[0] },
+		"out of range":    func(mp *smt.MultiProof) { mp.Indices[len(mp.Indices)-1] = new(big.Int).Lsh(big.NewInt(1), 64) },
+		"short values":    func(mp *smt.MultiProof) { mp.Values = mp.Values[1:] },
+	}
+	for name, tamper := range cases {
+		mp := fresh()
+		tamper(mp)
+		if smt.VerifyMultiProof(root, 64, mp) {
+			t.Errorf("Multi-proof with tampered %s verifies", name)
+		}
+	}
+
+	if smt.VerifyMultiProof(root, 64, nil) || smt.VerifyMultiProof(root, 64, &smt.MultiProof{}) {
+		t.Error("Empty multi-proof verifies")
+	}
+}
+
+func TestMultiProofErrors(t *testing.T) {
+	tree := CreateTestTree(t, 8)
+
+	if _, err := tree.GetMultiProof(nil); !errors.Is(err, smt.ErrNoIndices) {
+		t.Errorf("Expected ErrNoIndices, got %v", err)
+	}
+	var rangeErr *smt.OutOfRangeError
+	if _, err := tree.GetMultiProof([]*big.Int{big.NewInt(256)}); !errors.As(err, &rangeErr) {
+		t.Errorf("Expected OutOfRangeError, got %v", err)
+	}
+
+	// An empty tree proves absence, and duplicates are proven once
+	mp, err := tree.GetMultiProof([]*big.Int{big.NewInt(3), big.NewInt(3), big.NewInt(1)})
+	if err != nil {
+		t.Fatalf("GetMultiProof failed: %v", err)
+	}
+	if len(mp.Indices) != 2 || mp.Exists[0] || mp.Exists[1] || !tree.VerifyMultiProof(mp) {
+		t.Errorf("Unexpected multi-proof for an empty tree: %+v", mp)
+	}
+
+	compact, _ := newCompactTree(t, 8)
+	if _, err := compact.GetMultiProof([]*big.Int{big.NewInt(1)}); !errors.Is(err, smt.ErrCompactLayout) {
+		t.Errorf("Expected ErrCompactLayout, got %v", err)
+	}
+}
+
+func TestMultiProofWithOptions(t *testing.T) {
+	opts := &smt.TreeOptions{Depth: 32, HashSuite: smt.SHA256HashSuite(), DomainTag: "multi"}
+	tree, err := smt.NewSparseMerkleTreeWithOptions(smt.NewInMemoryDatabase(), opts)
+	if err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+	indices := []*big.Int{big.NewInt(5), big.NewInt(6), big.NewInt(1 << 20)}
+	for i, index := range indices[:2] {
+		if _, err := tree.Insert(index, smt.Bytes32{byte(i + 1)}); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+
+	mp, err := tree.GetMultiProof(indices)
+	if err != nil {
+		t.Fatalf("GetMultiProof failed: %v", err)
+	}
+	if !tree.VerifyMultiProof(mp) || !smt.VerifyMultiProof(tree.Root(), 32, mp, opts.EffectiveHashSuite()) {
+		t.Error("Multi-proof does not verify with the tree's options")
+	}
+	if smt.VerifyMultiProof(tree.Root(), 32, mp) {
+		t.Error("Multi-proof verifies with the default suite")
+	}
+}
+
+func TestMultiProofSerialization(t *testing.T) {
+	tree, indices := multiProofTree(t, 256, 100, 30)
+	mp, err := tree.GetMultiProof(indices)
+	if err != nil {
+		t.Fatalf("GetMultiProof failed: %v", err)
+	}
+
+	data, err := json.Marshal(smt.SerializeMultiProof(mp))
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	var decoded smt.SerializedMultiProof
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	restored, err := smt.DeserializeMultiProof(&decoded)
+	if err != nil {
+		t.Fatalf("DeserializeMultiProof failed: %v", err)
+	}
+	if !tree.VerifyMultiProof(restored) {
+		t.Error("Deserialized multi-proof does not verify")
+	}
+	for i := range mp.Exists {
+		if restored.Exists[i] != mp.Exists[i] || restored.Values[i] != mp.Values[i] {
+			t.Fatalf("Leaf %d changed in serialization", i)
+		}
+	}
+
+	if _, err := json.Marshal(smt.MultiProofToJSON(mp)); err != nil {
+		t.Errorf("MultiProofToJSON does not marshal: %v", err)
+	}
+
+	bad := []*smt.SerializedMultiProof{
+		{Indices: decoded.Indices, Exists: "0x0", Values: decoded.Values[1:], Enables: "0x0"},
+		{Indices: decoded.Indices, Exists: "zz", Values: decoded.Values, Enables: "0x0"},
+		{Indices: decoded.Indices[:1], Exists: "0x3", Values: decoded.Values[:1], Enables: "0x0"},
+		{Indices: decoded.Indices, Exists: "0x0", Values: decoded.Values, Enables: "zz"},
+		{Indices: decoded.Indices[:1], Exists: "0x0", Values: []string{"0x12"}, Enables: "0x0"},
+		{Indices: decoded.Indices[:1], Exists: "0x0", Values: decoded.Values[:1], Enables: "0x1", Siblings: []string{"0x12"}},
+	}
+	for i, smp := range bad {
+		if _, err := smt.DeserializeMultiProof(smp); err == nil {
+			t.Errorf("Malformed serialized multi-proof %d deserialized", i)
+		}
+	}
+}
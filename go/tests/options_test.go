@@ -0,0 +1,353 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+	"sort"
+	"strings"
+	"testing"
+
+	smt "github.com/0xanonymeow/smt/go"
+)
+
+func newOptionsTree(t *testing.T, db smt.Database, opts *smt.TreeOptions) *smt.SparseMerkleTree {
+	t.Helper()
+	tree, err := smt.NewSparseMerkleTreeWithOptions(db, opts)
+	if err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+	for i := int64(1); i <= 10; i++ {
+		if _, err := tree.Insert(big.NewInt(i*23), smt.Bytes32{byte(i)}); err != nil {
+			t.Fatalf("Failed to insert %d: %v", i, err)
+		}
+	}
+	return tree
+}
+
+func TestDomainTagSeparatesProofs(t *testing.T) {
+	optsA := &smt.TreeOptions{Depth: 16, DomainTag: "app-a"}
+	optsB := &smt.TreeOptions{Depth: 16, DomainTag: "app-b"}
+	plain := &smt.TreeOptions{Depth: 16}
+
+	treeA := newOptionsTree(t, smt.NewInMemoryDatabase(), optsA)
+	treeB := newOptionsTree(t, smt.NewInMemoryDatabase(), optsB)
+	treePlain := newOptionsTree(t, smt.NewInMemoryDatabase(), plain)
+	if treeA.Root() == treeB.Root() || treeA.Root() == treePlain.Root() {
+		t.Fatal("Domain tags do not change the root")
+	}
+
+	proof, err := treeA.Get(big.NewInt(46))
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if !smt.VerifyProofWithOptions(treeA.Root(), proof, optsA) || !treeA.VerifyProof(proof) {
+		t.Error("Proof does not verify with its own options")
+	}
+	if smt.VerifyProofWithOptions(treeA.Root(), proof, optsB) || smt.VerifyProofWithOptions(treeA.Root(), proof, plain) {
+		t.Error("Proof verifies with another domain tag")
+	}
+	if smt.VerifyProof(treeA.Root(), 16, proof) {
+		t.Error("Proof verifies without the domain tag")
+	}
+	if smt.ComputeRootWithOptions(proof, optsA) != treeA.Root() {
+		t.Error("ComputeRootWithOptions does not rebuild the root")
+	}
+
+	// The tag is mixed into the leaf hash only
+	suite := optsA.EffectiveHashSuite()
+	keccak := smt.Keccak256HashSuite()
+	left, right := smt.Bytes32{1}, smt.Bytes32{2}
+	if suite.HashNode(left, right) != keccak.HashNode(left, right) {
+		t.Error("Domain tag changed the node hash")
+	}
+	if suite.HashLeaf(big.NewInt(1), left) == keccak.HashLeaf(big.NewInt(1), left) {
+		t.Error("Domain tag did not change the leaf hash")
+	}
+	if plain.EffectiveHashSuite().HashLeaf(big.NewInt(1), left) != keccak.HashLeaf(big.NewInt(1), left) {
+		t.Error("Options without a tag changed the leaf hash")
+	}
+}
+
+func TestDomainTagWithHashSuite(t *testing.T) {
+	opts := &smt.TreeOptions{Depth: 16, HashSuite: smt.SHA256HashSuite(), DomainTag: "app"}
+	tree := newOptionsTree(t, smt.NewInMemoryDatabase(), opts)
+
+	proof, err := tree.Get(big.NewInt(23))
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if !smt.VerifyProofWithOptions(tree.Root(), proof, opts) {
+		t.Error("Proof does not verify with its options")
+	}
+	if smt.VerifyProof(tree.Root(), 16, proof, smt.SHA256HashSuite()) {
+		t.Error("Proof verifies with the suite but without the tag")
+	}
+	if tree.HashSuite().Name != smt.SHA256SuiteName {
+		t.Errorf("Domain separated suite is named %q", tree.HashSuite().Name)
+	}
+}
+
+func TestTreeOptionsPersistence(t *testing.T) {
+	db := smt.NewInMemoryDatabase()
+	opts := &smt.TreeOptions{Depth: 16, DomainTag: "app", KeyDerivation: smt.SHA256KeyDerivation()}
+	tree := newOptionsTree(t, db, opts)
+	if _, err := tree.InsertKV("alice", smt.Bytes32{0xa1}); err != nil {
+		t.Fatalf("InsertKV failed: %v", err)
+	}
+
+	reopened, err := smt.OpenSparseMerkleTree(db)
+	if err != nil {
+		t.Fatalf("Failed to reopen: %v", err)
+	}
+	restored := reopened.Options()
+	if restored.DomainTag != "app" || restored.KeyDerivation.Name != smt.SHA256KeyDerivationName || restored.Depth != 16 {
+		t.Errorf("Unexpected restored options %+v", restored)
+	}
+	if reopened.Root() != tree.Root() {
+		t.Error("Reopened tree has a different root")
+	}
+	if value, exists, err := reopened.GetKV("alice"); err != nil || !exists || value != (smt.Bytes32{0xa1}) {
+		t.Errorf("GetKV after reopen returned %s %v %v", value, exists, err)
+	}
+
+	read, err := smt.ReadTreeOptions(db)
+	if err != nil || read.DomainTag != "app" || read.KeyDerivation.Name != smt.SHA256KeyDerivationName {
+		t.Errorf("ReadTreeOptions returned %+v %v", read, err)
+	}
+	if read, err := smt.ReadTreeOptions(smt.NewInMemoryDatabase()); read != nil || err != nil {
+		t.Errorf("Expected no options for an empty database, got %+v %v", read, err)
+	}
+
+	var mismatch *smt.OptionMismatchError
+	_, err = smt.NewSparseMerkleTreeWithOptions(db, &smt.TreeOptions{Depth: 16, DomainTag: "other", KeyDerivation: smt.SHA256KeyDerivation()})
+	if !errors.As(err, &mismatch) || mismatch.Option != "domain tag" || mismatch.Stored != "app" || mismatch.Requested != "other" {
+		t.Errorf("Expected domain tag mismatch, got %v", err)
+	}
+	_, err = smt.NewSparseMerkleTreeWithOptions(db, &smt.TreeOptions{Depth: 16, DomainTag: "app"})
+	if !errors.As(err, &mismatch) || mismatch.Option != "key derivation" || mismatch.Stored != smt.SHA256KeyDerivationName {
+		t.Errorf("Expected key derivation mismatch, got %v", err)
+	}
+	if _, err := smt.NewSparseMerkleTree(db, 16); !errors.As(err, &mismatch) {
+		t.Errorf("Expected mismatch when opening without options, got %v", err)
+	}
+	if _, err := smt.NewSparseMerkleTreeWithOptions(db, opts); err != nil {
+		t.Errorf("Failed to reopen with the same options: %v", err)
+	}
+
+	// Trees with default options record none
+	plainDB := smt.NewInMemoryDatabase()
+	newOptionsTree(t, plainDB, &smt.TreeOptions{Depth: 16})
+	for _, key := range []string{smt.HashSuiteKey, smt.DomainTagKey, smt.KeyDerivationKey} {
+		if has, _ := plainDB.Has([]byte(key)); has {
+			t.Errorf("Default tree wrote %s", key)
+		}
+	}
+}
+
+func TestUnknownKeyDerivation(t *testing.T) {
+	custom := &smt.KeyDerivation{Name: "identity", Derive: func(key []byte) []byte { return key }}
+	db := smt.NewInMemoryDatabase()
+	tree := newOptionsTree(t, db, &smt.TreeOptions{Depth: 16, KeyDerivation: custom})
+	if _, err := tree.InsertKV("k", smt.Bytes32{1}); err != nil {
+		t.Fatalf("InsertKV failed: %v", err)
+	}
+
+	var mismatch *smt.OptionMismatchError
+	if _, err := smt.OpenSparseMerkleTree(db); !errors.As(err, &mismatch) || mismatch.Stored != "identity" {
+		t.Errorf("Expected OptionMismatchError for a custom key derivation, got %v", err)
+	}
+	if _, err := smt.NewSparseMerkleTreeWithOptions(db, &smt.TreeOptions{Depth: 16, KeyDerivation: custom}); err != nil {
+		t.Errorf("Failed to reopen with the custom key derivation: %v", err)
+	}
+}
+
+func TestSHA256KeyDerivation(t *testing.T) {
+	sha, err := smt.NewSparseMerkleTreeWithOptions(smt.NewInMemoryDatabase(), &smt.TreeOptions{Depth: 256, KeyDerivation: smt.SHA256KeyDerivation()})
+	if err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+	keccak := CreateTestTree(t, 256)
+
+	shaProof, err := sha.InsertKV("alice", smt.Bytes32{1})
+	if err != nil {
+		t.Fatalf("InsertKV failed: %v", err)
+	}
+	keccakProof, err := keccak.InsertKV("alice", smt.Bytes32{1})
+	if err != nil {
+		t.Fatalf("InsertKV failed: %v", err)
+	}
+
+	sum := sha256.Sum256([]byte("alice"))
+	if shaProof.Index.Cmp(new(big.Int).SetBytes(sum[:])) != 0 {
+		t.Errorf("SHA-256 derivation placed the key at %s", shaProof.Index)
+	}
+	if shaProof.Index.Cmp(keccakProof.Index) == 0 {
+		t.Error("Key derivations agree on the index")
+	}
+}
+
+func TestTreeOptionsErrors(t *testing.T) {
+	db := smt.NewInMemoryDatabase()
+	if _, err := smt.NewSparseMerkleTreeWithOptions(db, nil); !errors.Is(err, smt.ErrNilOptions) {
+		t.Errorf("Expected ErrNilOptions, got %v", err)
+	}
+
+	var depthErr *smt.InvalidTreeDepthError
+	if _, err := smt.NewSparseMerkleTreeWithOptions(db, &smt.TreeOptions{}); !errors.As(err, &depthErr) {
+		t.Errorf("Expected InvalidTreeDepthError, got %v", err)
+	}
+
+	invalid := []*smt.KeyDerivation{
+		{Derive: func(key []byte) []byte { return key }},
+		{Name: "no-derive"},
+	}
+	for _, kd := range invalid {
+		if _, err := smt.NewSparseMerkleTreeWithOptions(db, &smt.TreeOptions{Depth: 8, KeyDerivation: kd}); !errors.Is(err, smt.ErrInvalidKeyDerivation) {
+			t.Errorf("Expected ErrInvalidKeyDerivation for %q, got %v", kd.Name, err)
+		}
+	}
+
+	if _, err := smt.NewNamespacedDatabase(db, ""); !errors.Is(err, smt.ErrInvalidNamespace) {
+		t.Errorf("Expected ErrInvalidNamespace, got %v", err)
+	}
+	if _, err := smt.NewNamespacedDatabase(nil, "ns"); !errors.Is(err, smt.ErrNilDatabase) {
+		t.Errorf("Expected ErrNilDatabase, got %v", err)
+	}
+
+	if smt.KeyDerivationByName("unknown") != nil {
+		t.Error("KeyDerivationByName returned a scheme for an unknown name")
+	}
+}
+
+func TestNamespacesShareDatabase(t *testing.T) {
+	db := smt.NewInMemoryDatabase()
+	optsA := &smt.TreeOptions{Depth: 16, Namespace: "a"}
+	optsB := &smt.TreeOptions{Depth: 16, Namespace: "b", DomainTag: "b"}
+
+	// Identical contents produce identical records; namespaces keep their
+	// reference counts apart
+	treeA := newOptionsTree(t, db, optsA)
+	twin := newOptionsTree(t, db, &smt.TreeOptions{Depth: 16, Namespace: "a2"})
+	treeB := newOptionsTree(t, db, optsB)
+	if treeA.Root() != twin.Root() || treeA.Root() == treeB.Root() {
+		t.Fatal("Unexpected roots for namespaced trees")
+	}
+
+	for i := int64(1); i <= 10; i++ {
+		if _, err := twin.Delete(big.NewInt(i * 23)); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+	}
+	for i := int64(1); i <= 10; i++ {
+		proof, err := treeA.Get(big.NewInt(i * 23))
+		if err != nil || !proof.Exists || !smt.VerifyProofWithOptions(treeA.Root(), proof, optsA) {
+			t.Fatalf("Tree a lost leaf %d after deletes in its twin: %v", i*23, err)
+		}
+	}
+
+	// Nothing is stored outside the namespaces
+	err := db.IteratePrefix(nil, func(key, value []byte) bool {
+		if !bytes.HasPrefix(key, []byte("a/")) && !bytes.HasPrefix(key, []byte("a2/")) && !bytes.HasPrefix(key, []byte("b/")) {
+			t.Errorf("Key %q stored outside the namespaces", key)
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatalf("IteratePrefix failed: %v", err)
+	}
+
+	reopened, err := smt.NewSparseMerkleTreeWithOptions(db, optsB)
+	if err != nil || reopened.Root() != treeB.Root() {
+		t.Errorf("Failed to reopen namespace b: %v", err)
+	}
+	ndb, err := smt.NewNamespacedDatabase(db, "b")
+	if err != nil {
+		t.Fatalf("Failed to wrap database: %v", err)
+	}
+	if opened, err := smt.OpenSparseMerkleTree(ndb); err != nil || opened.Options().DomainTag != "b" {
+		t.Errorf("OpenSparseMerkleTree on the namespace failed: %v", err)
+	}
+
+	for name, tree := range map[string]*smt.SparseMerkleTree{"a": treeA, "a2": twin, "b": treeB} {
+		report, err := tree.Check()
+		if err != nil || !report.OK() || !report.Scanned {
+			t.Errorf("Check of namespace %s failed: %v %v", name, err, report)
+		}
+	}
+	report, err := smt.CheckDatabase(ndb)
+	if err != nil || !report.OK() || report.Leaves != 10 {
+		t.Errorf("CheckDatabase of namespace b failed: %v %v", err, report)
+	}
+}
+
+func TestNamespacedDatabase(t *testing.T) {
+	// A shared database without batches or iteration
+	shared := NewMapDatabase()
+	ndb, err := smt.NewNamespacedDatabase(shared, "ns")
+	if err != nil {
+		t.Fatalf("Failed to wrap database: %v", err)
+	}
+	if ndb.Unwrap() != smt.Database(shared) {
+		t.Error("Unwrap does not return the shared database")
+	}
+
+	batch := ndb.NewBatch()
+	batch.Put([]byte("x"), []byte("1"))
+	batch.Put([]byte("y"), []byte("2"))
+	batch.Delete([]byte("x"))
+	if has, _ := ndb.Has([]byte("y")); has {
+		t.Error("Batch write visible before Write")
+	}
+	if err := batch.Write(); err != nil {
+		t.Fatalf("Batch write failed: %v", err)
+	}
+	if has, _ := ndb.Has([]byte("x")); has {
+		t.Error("Batched delete not applied")
+	}
+	if value, err := ndb.Get([]byte("y")); err != nil || string(value) != "2" {
+		t.Errorf("Get returned %q %v", value, err)
+	}
+	if has, _ := shared.Has([]byte("ns/y")); !has {
+		t.Error("Key not stored under the namespace")
+	}
+
+	if err := ndb.IteratePrefix(nil, func(key, value []byte) bool { return true }); !errors.Is(err, smt.ErrNotIterable) {
+		t.Errorf("Expected ErrNotIterable, got %v", err)
+	}
+
+	// Trees fall back to reference count checks and the KV cache
+	tree := newOptionsTree(t, shared, &smt.TreeOptions{Depth: 16, Namespace: "tree"})
+	if _, err := tree.InsertKV("k", smt.Bytes32{7}); err != nil {
+		t.Fatalf("InsertKV failed: %v", err)
+	}
+	report, err := tree.Check()
+	if err != nil || !report.OK() || report.Scanned {
+		t.Errorf("Check over a non-iterable namespace failed: %v %v", err, report)
+	}
+	var dump bytes.Buffer
+	if err := tree.Export(&dump); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if !strings.Contains(dump.String(), "k") {
+		t.Error("Export lost the KV key")
+	}
+
+	// Iteration strips the namespace and stays inside it
+	mem := smt.NewInMemoryDatabase()
+	mem.Set([]byte("nsx"), []byte("outside"))
+	imdb, _ := smt.NewNamespacedDatabase(mem, "ns")
+	imdb.Set([]byte("p:1"), []byte("a"))
+	imdb.Set([]byte("p:2"), []byte("b"))
+	imdb.Set([]byte("q:1"), []byte("c"))
+	var keys []string
+	imdb.IteratePrefix([]byte("p:"), func(key, value []byte) bool {
+		keys = append(keys, string(key))
+		return true
+	})
+	sort.Strings(keys)
+	if strings.Join(keys, ",") != "p:1,p:2" {
+		t.Errorf("IteratePrefix returned %v", keys)
+	}
+}
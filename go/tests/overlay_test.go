@@ -0,0 +1,169 @@
+package tests
+
+import (
+	"errors"
+	"math/big"
+	"reflect"
+	"testing"
+
+	smt "github.com/0xanonymeow/smt/go"
+)
+
+func TestOverlayDatabaseBasicOperations(t *testing.T) {
+	parent := smt.NewInMemoryDatabase()
+	parent.Set([]byte("a"), []byte("1"))
+	parent.Set([]byte("b"), []byte("2"))
+
+	overlay, err := smt.NewOverlayDatabase(parent)
+	if err != nil {
+		t.Fatalf("Failed to create overlay: %v", err)
+	}
+
+	overlay.Set([]byte("a"), []byte("10"))
+	overlay.Delete([]byte("b"))
+	overlay.Set([]byte("c"), []byte("3"))
+
+	if value, _ := overlay.Get([]byte("a")); string(value) != "10" {
+		t.Fatalf("Expected overlay value 10, got %q", value)
+	}
+	if value, _ := overlay.Get([]byte("b")); value != nil {
+		t.Fatalf("Deleted key should be hidden, got %q", value)
+	}
+	if has, _ := overlay.Has([]byte("b")); has {
+		t.Fatal("Deleted key should not exist")
+	}
+	if value, _ := parent.Get([]byte("a")); string(value) != "1" {
+		t.Fatalf("Parent should be untouched, got %q", value)
+	}
+
+	keys := make([]string, 0)
+	overlay.IteratePrefix(nil, func(key, value []byte) bool {
+		keys = append(keys, string(key)+"="+string(value))
+		return true
+	})
+	if !reflect.DeepEqual(keys, []string{"a=10", "c=3"}) {
+		t.Fatalf("Unexpected merged iteration: %v", keys)
+	}
+
+	overlay.Discard()
+	if value, _ := overlay.Get([]byte("b")); string(value) != "2" {
+		t.Fatalf("Discard should expose the parent again, got %q", value)
+	}
+
+	batch := overlay.NewBatch()
+	batch.Put([]byte("d"), []byte("4"))
+	batch.Delete([]byte("a"))
+	if overlay.Len() != 0 {
+		t.Fatal("Unwritten batch should not reach the overlay")
+	}
+	batch.Write()
+
+	if err := overlay.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if overlay.Len() != 0 {
+		t.Fatal("Flush should empty the overlay")
+	}
+	if has, _ := parent.Has([]byte("a")); has {
+		t.Fatal("Tombstone should delete from the parent on flush")
+	}
+	if value, _ := parent.Get([]byte("d")); string(value) != "4" {
+		t.Fatalf("Expected d=4 in parent, got %q", value)
+	}
+
+	if _, err := smt.NewOverlayDatabase(nil); !errors.Is(err, smt.ErrNilDatabase) {
+		t.Fatalf("Expected ErrNilDatabase, got %v", err)
+	}
+	mapOverlay, _ := smt.NewOverlayDatabase(NewMapDatabase())
+	if err := mapOverlay.IteratePrefix(nil, func(key, value []byte) bool { return true }); !errors.Is(err, smt.ErrNotIterable) {
+		t.Fatalf("Expected ErrNotIterable, got %v", err)
+	}
+}
+
+func TestOverlayForkedTree(t *testing.T) {
+	shared := NewMapDatabase()
+	base, err := smt.NewSparseMerkleTree(shared, 16)
+	if err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+	for i := int64(0); i < 20; i++ {
+		if _, err := base.Insert(big.NewInt(i*13), smt.Bytes32{byte(i + 1)}); err != nil {
+			t.Fatalf("Failed to insert %d: %v", i, err)
+		}
+	}
+	if _, err := base.InsertKV("alice", smt.Bytes32{0xaa}); err != nil {
+		t.Fatalf("Failed to insert KV: %v", err)
+	}
+	baseRoot := base.Root()
+	before := shared.Snapshot()
+
+	overlay, _ := smt.NewOverlayDatabase(shared)
+	fork, err := smt.OpenSparseMerkleTree(overlay)
+	if err != nil {
+		t.Fatalf("Failed to fork tree: %v", err)
+	}
+	if fork.Root() != baseRoot {
+		t.Fatal("Fork should start at the parent's root")
+	}
+	if value, exists, _ := fork.GetKV("alice"); !exists || value != (smt.Bytes32{0xaa}) {
+		t.Fatal("Fork should see the parent's KV entries")
+	}
+
+	// Apply a candidate block on the fork
+	for i := int64(0); i < 20; i += 2 {
+		if _, err := fork.Update(big.NewInt(i*13), smt.Bytes32{0xee}); err != nil {
+			t.Fatalf("Failed to update %d: %v", i, err)
+		}
+	}
+	if _, err := fork.Delete(big.NewInt(13)); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	if _, err := fork.UpdateKV("alice", smt.Bytes32{0xbb}); err != nil {
+		t.Fatalf("Failed to update KV: %v", err)
+	}
+	candidateRoot := fork.Root()
+
+	if !reflect.DeepEqual(shared.Snapshot(), before) {
+		t.Fatal("Fork must not touch the shared store")
+	}
+	if proof, _ := base.Get(big.NewInt(0)); proof.Value != (smt.Bytes32{1}) {
+		t.Fatal("Base tree should be unaffected by the fork")
+	}
+
+	// Throw the candidate away
+	overlay.Discard()
+	if !reflect.DeepEqual(shared.Snapshot(), before) {
+		t.Fatal("Discard must not touch the shared store")
+	}
+	retry, err := smt.OpenSparseMerkleTree(overlay)
+	if err != nil {
+		t.Fatalf("Failed to reopen fork: %v", err)
+	}
+	if retry.Root() != baseRoot {
+		t.Fatal("Discarded fork should be back at the parent's root")
+	}
+
+	// Apply it again and merge it into the shared store
+	for i := int64(0); i < 20; i += 2 {
+		retry.Update(big.NewInt(i*13), smt.Bytes32{0xee})
+	}
+	retry.Delete(big.NewInt(13))
+	retry.UpdateKV("alice", smt.Bytes32{0xbb})
+	if retry.Root() != candidateRoot {
+		t.Fatal("Re-applied block should produce the same root")
+	}
+	if err := overlay.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	merged, err := smt.OpenSparseMerkleTree(shared)
+	if err != nil {
+		t.Fatalf("Failed to reopen shared tree: %v", err)
+	}
+	if merged.Root() != candidateRoot {
+		t.Fatal("Flushed root should be persisted in the shared store")
+	}
+	if value, _, _ := merged.GetKV("alice"); value != (smt.Bytes32{0xbb}) {
+		t.Fatal("Flushed KV entry should be persisted")
+	}
+}
@@ -0,0 +1,267 @@
+package tests
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	smt "github.com/0xanonymeow/smt/go"
+)
+
+func TestOpenSparseMerkleTreeRestoresRoot(t *testing.T) {
+	db := smt.NewInMemoryDatabase()
+	tree, err := smt.NewSparseMerkleTree(db, 16)
+	if err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+
+	for i := int64(0); i < 10; i++ {
+		if _, err := tree.Insert(big.NewInt(i*7), smt.Bytes32{byte(i + 1)}); err != nil {
+			t.Fatalf("Failed to insert %d: %v", i, err)
+		}
+	}
+	if _, err := tree.Update(big.NewInt(14), smt.Bytes32{0xff}); err != nil {
+		t.Fatalf("Failed to update: %v", err)
+	}
+
+	reopened, err := smt.OpenSparseMerkleTree(db)
+	if err != nil {
+		t.Fatalf("Failed to reopen tree: %v", err)
+	}
+
+	if reopened.Root() != tree.Root() {
+		t.Fatalf("Expected root %s, got %s", tree.Root(), reopened.Root())
+	}
+	if reopened.Depth() != 16 {
+		t.Fatalf("Expected depth 16, got %d", reopened.Depth())
+	}
+
+	proof, err := reopened.Get(big.NewInt(14))
+	if err != nil {
+		t.Fatalf("Failed to get proof: %v", err)
+	}
+	if !proof.Exists || proof.Value != (smt.Bytes32{0xff}) {
+		t.Fatal("Reopened tree should contain the updated value")
+	}
+	if !reopened.VerifyProof(proof) {
+		t.Fatal("Proof from reopened tree should verify")
+	}
+
+	// Mutations on the reopened tree continue from the persisted state
+	if _, err := reopened.Insert(big.NewInt(100), smt.Bytes32{0xaa}); err != nil {
+		t.Fatalf("Failed to insert into reopened tree: %v", err)
+	}
+	meta, err := smt.ReadTreeMetadata(db)
+	if err != nil {
+		t.Fatalf("Failed to read metadata: %v", err)
+	}
+	if meta.Root != reopened.Root() {
+		t.Fatal("Metadata root should track the latest mutation")
+	}
+}
+
+func TestNewSparseMerkleTreeResumesMatchingDepth(t *testing.T) {
+	db := smt.NewInMemoryDatabase()
+	tree, err := smt.NewSparseMerkleTree(db, 8)
+	if err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+	if _, err := tree.Insert(big.NewInt(3), smt.Bytes32{3}); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+
+	resumed, err := smt.NewSparseMerkleTree(db, 8)
+	if err != nil {
+		t.Fatalf("Failed to resume tree: %v", err)
+	}
+	if resumed.Root() != tree.Root() {
+		t.Fatal("NewSparseMerkleTree should resume the persisted root")
+	}
+
+	_, err = smt.NewSparseMerkleTree(db, 16)
+	var depthErr *smt.DepthMismatchError
+	if !errors.As(err, &depthErr) {
+		t.Fatalf("Expected DepthMismatchError, got %v", err)
+	}
+	if depthErr.Stored != 8 || depthErr.Requested != 16 {
+		t.Fatalf("Unexpected mismatch details: %v", depthErr)
+	}
+}
+
+func TestOpenSparseMerkleTreeErrors(t *testing.T) {
+	if _, err := smt.OpenSparseMerkleTree(nil); err != smt.ErrNilDatabase {
+		t.Fatalf("Expected ErrNilDatabase, got %v", err)
+	}
+
+	db := smt.NewInMemoryDatabase()
+	if _, err := smt.OpenSparseMerkleTree(db); err != smt.ErrTreeNotFound {
+		t.Fatalf("Expected ErrTreeNotFound, got %v", err)
+	}
+
+	// Unsupported format version
+	record := make([]byte, 35)
+	record[0] = smt.TreeFormatVersion + 1
+	record[2] = 8
+	db.Set([]byte(smt.MetadataKey), record)
+
+	_, err := smt.OpenSparseMerkleTree(db)
+	var versionErr *smt.FormatVersionError
+	if !errors.As(err, &versionErr) {
+		t.Fatalf("Expected FormatVersionError, got %v", err)
+	}
+	if _, err := smt.NewSparseMerkleTree(db, 8); !errors.As(err, &versionErr) {
+		t.Fatalf("Expected FormatVersionError from NewSparseMerkleTree, got %v", err)
+	}
+
+	// Invalid stored depth
+	record[0] = smt.TreeFormatVersion
+	record[2] = 0
+	db.Set([]byte(smt.MetadataKey), record)
+
+	_, err = smt.OpenSparseMerkleTree(db)
+	var depthErr *smt.InvalidTreeDepthError
+	if !errors.As(err, &depthErr) {
+		t.Fatalf("Expected InvalidTreeDepthError, got %v", err)
+	}
+
+	// Corrupted record length
+	db.Set([]byte(smt.MetadataKey), []byte{1, 2, 3})
+	if _, err := smt.OpenSparseMerkleTree(db); err == nil {
+		t.Fatal("Expected error for truncated metadata")
+	}
+}
+
+func TestKVSurvivesReopen(t *testing.T) {
+	db := smt.NewInMemoryDatabase()
+	tree, err := smt.NewSparseMerkleTree(db, 32)
+	if err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+
+	if _, err := tree.InsertKV("alice", smt.Bytes32{1}); err != nil {
+		t.Fatalf("Failed to insert alice: %v", err)
+	}
+	if _, err := tree.InsertKV("bob", smt.Bytes32{2}); err != nil {
+		t.Fatalf("Failed to insert bob: %v", err)
+	}
+	if _, err := tree.InsertKV("carol", smt.Bytes32{3}); err != nil {
+		t.Fatalf("Failed to insert carol: %v", err)
+	}
+
+	// Simulate a process restart
+	reopened, err := smt.OpenSparseMerkleTree(db)
+	if err != nil {
+		t.Fatalf("Failed to reopen tree: %v", err)
+	}
+
+	value, exists, err := reopened.GetKV("alice")
+	if err != nil {
+		t.Fatalf("GetKV failed: %v", err)
+	}
+	if !exists || value != (smt.Bytes32{1}) {
+		t.Fatalf("Expected alice=1 after reopen, got %s (exists=%v)", value, exists)
+	}
+
+	if _, err := reopened.UpdateKV("bob", smt.Bytes32{20}); err != nil {
+		t.Fatalf("UpdateKV after reopen failed: %v", err)
+	}
+	if _, err := reopened.DeleteKV("carol"); err != nil {
+		t.Fatalf("DeleteKV after reopen failed: %v", err)
+	}
+
+	// A second instance over the same storage sees the changes
+	shared, err := smt.OpenSparseMerkleTree(db)
+	if err != nil {
+		t.Fatalf("Failed to open shared tree: %v", err)
+	}
+	value, exists, _ = shared.GetKV("bob")
+	if !exists || value != (smt.Bytes32{20}) {
+		t.Fatalf("Expected bob=20 in shared tree, got %s (exists=%v)", value, exists)
+	}
+	if _, exists, _ = shared.GetKV("carol"); exists {
+		t.Fatal("Deleted key should not be visible in shared tree")
+	}
+}
+
+func TestInsertKVFailureDoesNotStoreValue(t *testing.T) {
+	tree, err := smt.NewSparseMerkleTree(smt.NewInMemoryDatabase(), 16)
+	if err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+
+	if _, err := tree.InsertKV("key", smt.Bytes32{1}); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+	if _, err := tree.InsertKV("key", smt.Bytes32{2}); err == nil {
+		t.Fatal("Duplicate InsertKV should fail")
+	}
+
+	value, _, err := tree.GetKV("key")
+	if err != nil {
+		t.Fatalf("GetKV failed: %v", err)
+	}
+	if value != (smt.Bytes32{1}) {
+		t.Fatalf("Failed insert must not overwrite the stored value, got %s", value)
+	}
+}
+
+func TestPersistentKVStore(t *testing.T) {
+	db := smt.NewInMemoryDatabase()
+	store := smt.NewPersistentKVStore(db)
+
+	if err := store.Store("key", smt.Bytes32{7}); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+
+	// A fresh store over the same database loads the value lazily, and
+	// lists it without loading it first
+	fresh := smt.NewPersistentKVStore(db)
+	if all := fresh.All(); len(all) != 1 || all["key"] != (smt.Bytes32{7}) {
+		t.Fatalf("Fresh store should list the stored entry, got %v", all)
+	}
+	value, exists, err := fresh.Load("key")
+	if err != nil || !exists || value != (smt.Bytes32{7}) {
+		t.Fatalf("Expected lazy load of key=7, got %s exists=%v err=%v", value, exists, err)
+	}
+	if len(fresh.All()) != 1 {
+		t.Fatal("Loaded entry should be listed once")
+	}
+
+	if err := fresh.Remove("key"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if store := smt.NewPersistentKVStore(db); store.Has("key") {
+		t.Fatal("Removed key should be gone from the database")
+	}
+
+	// Corrupted records surface as errors from Load and as a miss from Get
+	db.Set([]byte(smt.KVPrefix+"6b6579"), []byte{1, 2, 3})
+	if _, _, err := smt.NewPersistentKVStore(db).Load("key"); err == nil {
+		t.Fatal("Expected error for corrupted kv record")
+	}
+	if _, err := smt.NewPersistentKVStore(db).LoadAll(); err == nil {
+		t.Fatal("Expected error listing a corrupted kv record")
+	}
+	if _, exists := smt.NewPersistentKVStore(db).Get("key"); exists {
+		t.Fatal("Corrupted record should be reported as a miss by Get")
+	}
+}
+
+func TestPersistentKVStoreAllWithoutIteration(t *testing.T) {
+	db := NewMapDatabase()
+	if err := smt.NewPersistentKVStore(db).Store("key", smt.Bytes32{7}); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+
+	// Without prefix scans only the loaded entries can be listed
+	fresh := smt.NewPersistentKVStore(db)
+	if all, err := fresh.LoadAll(); err != nil || len(all) != 0 {
+		t.Fatalf("Expected no loaded entries, got %v %v", all, err)
+	}
+	if _, _, err := fresh.Load("key"); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if all := fresh.All(); len(all) != 1 || all["key"] != (smt.Bytes32{7}) {
+		t.Fatalf("Expected the loaded entry, got %v", all)
+	}
+}
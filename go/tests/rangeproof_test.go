@@ -0,0 +1,248 @@
+package tests
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	smt "github.com/0xanonymeow/smt/go"
+)
+
+// rangeProofTree returns a tree holding count random leaves
+func rangeProofTree(t *testing.T, depth uint16, count int) *smt.SparseMerkleTree {
+	t.Helper()
+	tree := CreateTestTree(t, depth)
+	leaves := sortedLeaves(21, count, uint(depth))
+	writes := make([]smt.LeafWrite, len(leaves))
+	for i, leaf := range leaves {
+		writes[i] = smt.LeafWrite{Index: leaf.Index, Value: leaf.Value}
+	}
+	if err := tree.CommitBatch(writes); err != nil {
+		t.Fatalf("CommitBatch failed: %v", err)
+	}
+	return tree
+}
+
+func sameLeaves(got, want []smt.LeafData) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i].Index.Cmp(want[i].Index) != 0 || got[i].Value != want[i].Value {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSubtreeProof(t *testing.T) {
+	tree := rangeProofTree(t, 16, 300)
+	root := tree.Root()
+
+	cases := []struct {
+		prefix    int64
+		prefixLen uint16
+	}{
+		{0, 0}, {0, 1}, {1, 1}, {0x5a, 8}, {0xff, 8}, {0x3, 4}, {0x1234, 14},
+	}
+	for _, c := range cases {
+		prefix := big.NewInt(c.prefix)
+		sp, err := tree.GetSubtreeProof(prefix, c.prefixLen)
+		if err != nil {
+			t.Fatalf("GetSubtreeProof(%x, %d) failed: %v", c.prefix, c.prefixLen, err)
+		}
+		if !smt.VerifySubtreeProof(root, 16, sp) {
+			t.Errorf("Subtree proof %x/%d does not verify", c.prefix, c.prefixLen)
+		}
+
+		height := uint(16 - c.prefixLen)
+		first := new(big.Int).Lsh(prefix, height)
+		end := new(big.Int).Lsh(big.NewInt(c.prefix+1), height)
+		leaves, err := tree.Leaves(first, end)
+		if err != nil {
+			t.Fatalf("Leaves failed: %v", err)
+		}
+		if !sameLeaves(sp.Leaves, leaves) {
+			t.Errorf("Subtree %x/%d holds %d leaves, Leaves returns %d", c.prefix, c.prefixLen, len(sp.Leaves), len(leaves))
+		}
+	}
+
+	// Every leaf of the tree is in exactly one bucket
+	total := 0
+	for prefix := int64(0); prefix < 256; prefix++ {
+		sp, err := tree.GetSubtreeProof(big.NewInt(prefix), 8)
+		if err != nil {
+			t.Fatalf("GetSubtreeProof failed: %v", err)
+		}
+		if !smt.VerifySubtreeProof(root, 16, sp) {
+			t.Fatalf("Bucket %x does not verify", prefix)
+		}
+		total += len(sp.Leaves)
+	}
+	if total != 300 {
+		t.Errorf("Buckets hold %d leaves, expected 300", total)
+	}
+}
+
+func TestSubtreeProofSingleLeafAndEmpty(t *testing.T) {
+	tree := CreateTestTree(t, 256)
+	index := new(big.Int).Lsh(big.NewInt(0xab), 248)
+	if _, err := tree.Insert(index, smt.Bytes32{1}); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+
+	sp, err := tree.GetSubtreeProof(index, 256)
+	if err != nil {
+		t.Fatalf("GetSubtreeProof failed: %v", err)
+	}
+	if len(sp.Leaves) != 1 || !smt.VerifySubtreeProof(tree.Root(), 256, sp) {
+		t.Errorf("Single-leaf subtree proof: %d leaves", len(sp.Leaves))
+	}
+
+	// An empty 8-bit bucket proves it holds nothing
+	sp, err = tree.GetSubtreeProof(big.NewInt(0xac), 8)
+	if err != nil {
+		t.Fatalf("GetSubtreeProof failed: %v", err)
+	}
+	if len(sp.Leaves) != 0 || !sp.Root.IsZero() || !smt.VerifySubtreeProof(tree.Root(), 256, sp) {
+		t.Error("Empty bucket proof does not verify")
+	}
+
+	// Claiming a leaf in the empty bucket fails
+	sp.Leaves = append(sp.Leaves, smt.LeafData{Index: new(big.Int).Lsh(big.NewInt(0xac), 248), Value: smt.Bytes32{1}})
+	sp.Root = smt.ComputeLeafHash(sp.Leaves[0].Index, sp.Leaves[0].Value)
+	if smt.VerifySubtreeProof(tree.Root(), 256, sp) {
+		t.Error("Subtree proof with an invented leaf verifies")
+	}
+}
+
+func TestSubtreeProofRejectsTampering(t *testing.T) {
+	tree := rangeProofTree(t, 16, 300)
+	root := tree.Root()
+
+	fresh := func() *smt.SubtreeProof {
+		sp, err := tree.GetSubtreeProof(big.NewInt(0x2), 4)
+		if err != nil {
+			t.Fatalf("GetSubtreeProof failed: %v", err)
+		}
+		if len(sp.Leaves) < 3 || len(sp.Siblings) == 0 {
+			t.Fatalf("Subtree proof too small to tamper with")
+		}
+		return sp
+	}
+
+	cases := map[string]func(sp *smt.SubtreeProof){
+		"dropped leaf":    func(sp *smt.SubtreeProof) { sp.Leaves = sp.Leaves[1:] },
+		"value":           func(sp *smt.SubtreeProof) { sp.Leaves[1].Value[0] ^= 1 },
+		"outside leaf":    func(sp *smt.SubtreeProof) { sp.Leaves[0].Index = big.NewInt(0) },
+		"unordered":       func(sp *smt.SubtreeProof) { sp.Leaves[0], sp.Leaves[1] = sp.Leaves[1], sp.Leaves[0] },
+		"root":            func(sp *smt.SubtreeProof) { sp.Root[0] ^= 1 },
+		"sibling":         func(sp *smt.SubtreeProof) { sp.Siblings[0][0] ^= 1 },
+		"extra sibling":   func(sp *smt.SubtreeProof) { sp.Siblings = append(sp.Siblings, smt.Bytes32{1}) },
+		"enable below":    func(sp *smt.SubtreeProof) { sp.Enables.SetBit(sp.Enables, 0, 1) },
+		"prefix":          func(sp *smt.SubtreeProof) { sp.Prefix = big.NewInt(0x3) },
+		"prefix too long": func(sp *smt.SubtreeProof) { sp.Prefix = big.NewInt(0x12) },
+	}
+	for name, tamper := range cases {
+		sp := fresh()
+		tamper(sp)
+		if smt.VerifySubtreeProof(root, 16, sp) {
+			t.Errorf("Subtree proof with tampered %s verifies", name)
+		}
+	}
+	if smt.VerifySubtreeProof(root, 16, nil) {
+		t.Error("Nil subtree proof verifies")
+	}
+}
+
+func TestRangeProof(t *testing.T) {
+	tree := rangeProofTree(t, 16, 300)
+	root := tree.Root()
+
+	ranges := [][2]int64{
+		{0, 1 << 16}, {0, 1}, {100, 101}, {1000, 9000}, {4096, 8192}, {65000, 65536}, {12345, 54321},
+	}
+	for _, r := range ranges {
+		start, end := big.NewInt(r[0]), big.NewInt(r[1])
+		rp, err := tree.GetRangeProof(start, end)
+		if err != nil {
+			t.Fatalf("GetRangeProof(%d, %d) failed: %v", r[0], r[1], err)
+		}
+		if !smt.VerifyRangeProof(root, 16, rp) {
+			t.Errorf("Range proof [%d, %d) does not verify", r[0], r[1])
+		}
+		leaves, err := tree.Leaves(start, end)
+		if err != nil {
+			t.Fatalf("Leaves failed: %v", err)
+		}
+		if !sameLeaves(rp.Leaves, leaves) {
+			t.Errorf("Range [%d, %d) holds %d leaves, Leaves returns %d", r[0], r[1], len(rp.Leaves), len(leaves))
+		}
+		if len(rp.Siblings) > 2*16 {
+			t.Errorf("Range [%d, %d) needs %d siblings", r[0], r[1], len(rp.Siblings))
+		}
+	}
+}
+
+func TestRangeProofRejectsTampering(t *testing.T) {
+	tree := rangeProofTree(t, 16, 300)
+	root := tree.Root()
+	outside, err := tree.Leaves(big.NewInt(0), big.NewInt(1000))
+	if err != nil || len(outside) == 0 {
+		t.Fatalf("No leaf below the range: %v", err)
+	}
+
+	fresh := func() *smt.RangeProof {
+		rp, err := tree.GetRangeProof(big.NewInt(1000), big.NewInt(30000))
+		if err != nil {
+			t.Fatalf("GetRangeProof failed: %v", err)
+		}
+		return rp
+	}
+
+	cases := map[string]func(rp *smt.RangeProof){
+		"dropped leaf":  func(rp *smt.RangeProof) { rp.Leaves = rp.Leaves[:len(rp.Leaves)-1] },
+		"value":         func(rp *smt.RangeProof) { rp.Leaves[0].Value[0] ^= 1 },
+		"outside leaf":  func(rp *smt.RangeProof) { rp.Leaves = append([]smt.LeafData{outside[0]}, rp.Leaves...) },
+		"sibling":       func(rp *smt.RangeProof) { rp.Siblings[0][0] ^= 1 },
+		"extra sibling": func(rp *smt.RangeProof) { rp.Siblings = append(rp.Siblings, smt.Bytes32{1}) },
+		"extra enable":  func(rp *smt.RangeProof) { rp.Enables.SetBit(rp.Enables, 200, 1) },
+		"wider range":   func(rp *smt.RangeProof) { rp.Start = big.NewInt(0) },
+		"narrower":      func(rp *smt.RangeProof) { rp.End = big.NewInt(20000) },
+	}
+	for name, tamper := range cases {
+		rp := fresh()
+		tamper(rp)
+		if smt.VerifyRangeProof(root, 16, rp) {
+			t.Errorf("Range proof with tampered %s verifies", name)
+		}
+	}
+	if smt.VerifyRangeProof(root, 16, nil) {
+		t.Error("Nil range proof verifies")
+	}
+}
+
+func TestRangeAndSubtreeProofErrors(t *testing.T) {
+	tree := CreateTestTree(t, 8)
+
+	invalidRanges := [][2]int64{{5, 5}, {6, 5}, {-1, 5}, {0, 257}}
+	for _, r := range invalidRanges {
+		if _, err := tree.GetRangeProof(big.NewInt(r[0]), big.NewInt(r[1])); !errors.Is(err, smt.ErrInvalidRange) {
+			t.Errorf("Range [%d, %d): expected ErrInvalidRange, got %v", r[0], r[1], err)
+		}
+	}
+	if _, err := tree.GetSubtreeProof(big.NewInt(4), 2); !errors.Is(err, smt.ErrInvalidPrefix) {
+		t.Errorf("Expected ErrInvalidPrefix, got %v", err)
+	}
+	if _, err := tree.GetSubtreeProof(big.NewInt(0), 9); !errors.Is(err, smt.ErrInvalidPrefix) {
+		t.Errorf("Expected ErrInvalidPrefix, got %v", err)
+	}
+
+	compact, _ := newCompactTree(t, 8)
+	if _, err := compact.GetSubtreeProof(big.NewInt(0), 1); !errors.Is(err, smt.ErrCompactLayout) {
+		t.Errorf("Expected ErrCompactLayout, got %v", err)
+	}
+	if _, err := compact.GetRangeProof(big.NewInt(0), big.NewInt(1)); !errors.Is(err, smt.ErrCompactLayout) {
+		t.Errorf("Expected ErrCompactLayout, got %v", err)
+	}
+}
@@ -0,0 +1,178 @@
+package tests
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+
+	smt "github.com/0xanonymeow/smt/go"
+)
+
+// storedRecords returns the node, leaf, index and reference count records in db
+func storedRecords(t *testing.T, db *smt.InMemoryDatabase) map[string]string {
+	t.Helper()
+	records := make(map[string]string)
+	for _, prefix := range []string{smt.NodePrefix, smt.LeafPrefix, smt.LeafIndexPrefix, smt.RefCountPrefix} {
+		err := db.IteratePrefix([]byte(prefix), func(key, value []byte) bool {
+			records[string(key)] = string(value)
+			return true
+		})
+		if err != nil {
+			t.Fatalf("IteratePrefix failed: %v", err)
+		}
+	}
+	return records
+}
+
+func compareRecords(t *testing.T, got, want map[string]string) {
+	t.Helper()
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("Record %s: got %x, expected %x", key, got[key], value)
+		}
+	}
+	for key := range got {
+		if _, ok := want[key]; !ok {
+			t.Errorf("Unexpected stale record %s", key)
+		}
+	}
+}
+
+func TestStaleRecordsAreReclaimed(t *testing.T) {
+	db := smt.NewInMemoryDatabase()
+	tree, err := smt.NewSparseMerkleTree(db, 16)
+	if err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+
+	for i := int64(0); i < 50; i++ {
+		if _, err := tree.Insert(big.NewInt(i*997), smt.Bytes32{byte(i + 1)}); err != nil {
+			t.Fatalf("Failed to insert %d: %v", i, err)
+		}
+	}
+	for i := int64(0); i < 50; i++ {
+		if _, err := tree.Update(big.NewInt(i*997), smt.Bytes32{0xee, byte(i)}); err != nil {
+			t.Fatalf("Failed to update %d: %v", i, err)
+		}
+	}
+	for i := int64(0); i < 50; i += 2 {
+		if _, err := tree.Delete(big.NewInt(i * 997)); err != nil {
+			t.Fatalf("Failed to delete %d: %v", i, err)
+		}
+	}
+
+	// A tree built directly in its final state stores exactly the same records
+	freshDB := smt.NewInMemoryDatabase()
+	fresh, err := smt.NewSparseMerkleTree(freshDB, 16)
+	if err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+	for i := int64(1); i < 50; i += 2 {
+		if _, err := fresh.Insert(big.NewInt(i*997), smt.Bytes32{0xee, byte(i)}); err != nil {
+			t.Fatalf("Failed to insert %d: %v", i, err)
+		}
+	}
+	if tree.Root() != fresh.Root() {
+		t.Fatalf("Roots differ: %s vs %s", tree.Root(), fresh.Root())
+	}
+	compareRecords(t, storedRecords(t, db), storedRecords(t, freshDB))
+
+	for i := int64(1); i < 50; i += 2 {
+		if _, err := tree.Delete(big.NewInt(i * 997)); err != nil {
+			t.Fatalf("Failed to delete %d: %v", i, err)
+		}
+	}
+	if records := storedRecords(t, db); len(records) != 0 {
+		t.Fatalf("Empty tree should store no records, found %d", len(records))
+	}
+}
+
+func TestRepeatedUpdatesKeepStorageBounded(t *testing.T) {
+	db := smt.NewInMemoryDatabase()
+	tree, err := smt.NewSparseMerkleTree(db, 32)
+	if err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+	if _, err := tree.Insert(big.NewInt(7), smt.Bytes32{1}); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+	before := len(storedRecords(t, db))
+
+	for i := 0; i < 100; i++ {
+		if _, err := tree.Update(big.NewInt(7), smt.Bytes32{byte(i), 2}); err != nil {
+			t.Fatalf("Failed to update: %v", err)
+		}
+	}
+	if after := len(storedRecords(t, db)); after != before {
+		t.Fatalf("Expected %d records after updates, found %d", before, after)
+	}
+
+	proof, err := tree.Get(big.NewInt(7))
+	if err != nil || !proof.Exists || proof.Value != (smt.Bytes32{99, 2}) {
+		t.Fatalf("Unexpected proof after updates (err=%v)", err)
+	}
+}
+
+func TestLegacyTreeUpgradeAndGarbageCollection(t *testing.T) {
+	db := smt.NewInMemoryDatabase()
+	tree, err := smt.NewSparseMerkleTree(db, 16)
+	if err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+	for i := int64(0); i < 20; i++ {
+		if _, err := tree.Insert(big.NewInt(i*331), smt.Bytes32{byte(i + 1)}); err != nil {
+			t.Fatalf("Failed to insert %d: %v", i, err)
+		}
+	}
+	expected := storedRecords(t, db)
+
+	// Rewrite the database as a version 1 tree: no reference counts or version
+	// records, a 35-byte metadata record, and a stale node left behind by an old update
+	for key := range expected {
+		if strings.HasPrefix(key, smt.RefCountPrefix) {
+			db.Delete([]byte(key))
+		}
+	}
+	db.IteratePrefix([]byte(smt.VersionPrefix), func(key, value []byte) bool {
+		db.Delete(key)
+		return true
+	})
+	record, _ := db.Get([]byte(smt.MetadataKey))
+	record[0] = 1
+	db.Set([]byte(smt.MetadataKey), record[:35])
+	stale := smt.NodePrefix + strings.Repeat("ab", 32)
+	db.Set([]byte(stale), make([]byte, 64))
+
+	upgraded, err := smt.OpenSparseMerkleTree(db)
+	if err != nil {
+		t.Fatalf("Failed to open version 1 tree: %v", err)
+	}
+	if upgraded.Root() != tree.Root() {
+		t.Fatal("Upgrade should keep the root")
+	}
+	if meta, _ := smt.ReadTreeMetadata(db); meta.Version != smt.TreeFormatVersion {
+		t.Fatalf("Expected metadata version %d, got %d", smt.TreeFormatVersion, meta.Version)
+	}
+
+	removed, err := upgraded.CollectGarbage()
+	if err != nil {
+		t.Fatalf("CollectGarbage failed: %v", err)
+	}
+	if removed != 1 {
+		t.Fatalf("Expected 1 stale record removed, got %d", removed)
+	}
+	compareRecords(t, storedRecords(t, db), expected)
+
+	if removed, _ := upgraded.CollectGarbage(); removed != 0 {
+		t.Fatalf("Second collection should remove nothing, removed %d", removed)
+	}
+
+	mapTree, err := smt.NewSparseMerkleTree(NewMapDatabase(), 8)
+	if err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+	if _, err := mapTree.CollectGarbage(); !errors.Is(err, smt.ErrNotIterable) {
+		t.Fatalf("Expected ErrNotIterable, got %v", err)
+	}
+}
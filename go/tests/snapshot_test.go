@@ -0,0 +1,171 @@
+package tests
+
+import (
+	"errors"
+	"math/big"
+	"reflect"
+	"testing"
+
+	smt "github.com/0xanonymeow/smt/go"
+)
+
+func TestNestedSnapshotRevert(t *testing.T) {
+	db := NewMapDatabase()
+	tree, err := smt.NewSparseMerkleTree(db, 16)
+	if err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+	if _, err := tree.Insert(big.NewInt(1), smt.Bytes32{1}); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+	committedRoot := tree.Root()
+	committed := db.Snapshot()
+
+	s1 := tree.Snapshot()
+	if _, err := tree.Insert(big.NewInt(2), smt.Bytes32{2}); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+	if _, err := tree.InsertKV("alice", smt.Bytes32{0xaa}); err != nil {
+		t.Fatalf("Failed to insert KV: %v", err)
+	}
+	middleRoot := tree.Root()
+
+	s2 := tree.Snapshot()
+	if _, err := tree.Update(big.NewInt(1), smt.Bytes32{9}); err != nil {
+		t.Fatalf("Failed to update: %v", err)
+	}
+	if _, err := tree.Delete(big.NewInt(2)); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	if _, err := tree.UpdateKV("alice", smt.Bytes32{0xbb}); err != nil {
+		t.Fatalf("Failed to update KV: %v", err)
+	}
+
+	// Nothing reaches the database before Commit
+	if !reflect.DeepEqual(db.Snapshot(), committed) {
+		t.Fatal("Speculative changes should not be written to the database")
+	}
+
+	if err := tree.RevertToSnapshot(s2); err != nil {
+		t.Fatalf("RevertToSnapshot failed: %v", err)
+	}
+	if tree.Root() != middleRoot {
+		t.Fatal("Root should match the state at the inner snapshot")
+	}
+	if proof, _ := tree.Get(big.NewInt(1)); proof.Value != (smt.Bytes32{1}) {
+		t.Fatalf("Expected original value at index 1, got %s", proof.Value)
+	}
+	if exists, _ := tree.Exists(big.NewInt(2)); !exists {
+		t.Fatal("Index 2 should exist again")
+	}
+	if value, exists, _ := tree.GetKV("alice"); !exists || value != (smt.Bytes32{0xaa}) {
+		t.Fatalf("Expected alice=aa, got %s (exists=%v)", value, exists)
+	}
+
+	// Reverting to s1 discards s2 as well
+	if err := tree.RevertToSnapshot(s1); err != nil {
+		t.Fatalf("RevertToSnapshot failed: %v", err)
+	}
+	if tree.Root() != committedRoot {
+		t.Fatal("Root should match the committed state")
+	}
+	if _, exists, _ := tree.GetKV("alice"); exists {
+		t.Fatal("KV entry should be reverted")
+	}
+	if err := tree.RevertToSnapshot(s2); !errors.Is(err, smt.ErrSnapshotNotFound) {
+		t.Fatalf("Expected ErrSnapshotNotFound, got %v", err)
+	}
+
+	// Changes stay staged until Commit, which writes them all together
+	if _, err := tree.Insert(big.NewInt(3), smt.Bytes32{3}); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+	if !reflect.DeepEqual(db.Snapshot(), committed) {
+		t.Fatal("Changes after a revert should wait for Commit")
+	}
+	if err := tree.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	reopened, err := smt.OpenSparseMerkleTree(db)
+	if err != nil {
+		t.Fatalf("Failed to reopen tree: %v", err)
+	}
+	if reopened.Root() != tree.Root() {
+		t.Fatal("Committed root should be persisted")
+	}
+
+	// The committed database matches one built without snapshots
+	expected := NewMapDatabase()
+	plain, _ := smt.NewSparseMerkleTree(expected, 16)
+	plain.Insert(big.NewInt(1), smt.Bytes32{1})
+	plain.Insert(big.NewInt(3), smt.Bytes32{3})
+	if plain.Root() != tree.Root() {
+		t.Fatal("Roots should match")
+	}
+	got, want := db.Snapshot(), expected.Snapshot()
+	for key, value := range want {
+		if got[key] != value && key != smt.MetadataKey && key[:2] != smt.VersionPrefix {
+			t.Errorf("Record %s differs", key)
+		}
+	}
+	for key := range got {
+		if _, ok := want[key]; !ok && key[:2] != smt.VersionPrefix {
+			t.Errorf("Unexpected record %s", key)
+		}
+	}
+}
+
+func TestSnapshotFailedOperationAndCommitErrors(t *testing.T) {
+	db := NewCountingBatchDatabase()
+	tree, err := smt.NewSparseMerkleTree(db, 8)
+	if err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+	if _, err := tree.Insert(big.NewInt(1), smt.Bytes32{1}); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+	committedRoot := tree.Root()
+
+	tree.Snapshot()
+	if _, err := tree.Insert(big.NewInt(2), smt.Bytes32{2}); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+	stagedRoot := tree.Root()
+
+	// A failing operation only rolls back its own changes
+	if _, err := tree.Insert(big.NewInt(2), smt.Bytes32{3}); err == nil {
+		t.Fatal("Expected duplicate insert to fail")
+	}
+	if tree.Root() != stagedRoot {
+		t.Fatal("Failed operation should not disturb earlier staged changes")
+	}
+
+	if _, err := tree.CollectGarbage(); !errors.Is(err, smt.ErrUncommittedChanges) {
+		t.Fatalf("Expected ErrUncommittedChanges, got %v", err)
+	}
+
+	db.failWrite = true
+	if err := tree.Commit(); err == nil {
+		t.Fatal("Expected commit to fail")
+	}
+	db.failWrite = false
+
+	if tree.Root() != committedRoot {
+		t.Fatal("Failed commit should return to the last committed root")
+	}
+	if exists, _ := tree.Exists(big.NewInt(2)); exists {
+		t.Fatal("Uncommitted insert should be gone after a failed commit")
+	}
+	if _, err := tree.Insert(big.NewInt(2), smt.Bytes32{2}); err != nil {
+		t.Fatalf("Insert after failed commit failed: %v", err)
+	}
+	if tree.Root() != stagedRoot {
+		t.Fatal("Tree should be usable after a failed commit")
+	}
+
+	// Commit without snapshots is a no-op
+	if err := tree.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+}
@@ -0,0 +1,232 @@
+package tests
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	smt "github.com/0xanonymeow/smt/go"
+)
+
+func strictTree(t *testing.T) *smt.SparseMerkleTree {
+	t.Helper()
+	tree := CreateTestTree(t, 16)
+	for i := int64(0); i < 50; i++ {
+		if _, err := tree.Insert(big.NewInt(i*997%65536), smt.Bytes32{byte(i + 1)}); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+	return tree
+}
+
+func TestVerifyProofStrict(t *testing.T) {
+	tree := strictTree(t)
+	root := tree.Root()
+
+	for _, index := range []int64{997, 1994, 5, 40000} {
+		proof, err := tree.Get(big.NewInt(index))
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if err := smt.VerifyProofStrict(root, 16, proof); err != nil {
+			t.Errorf("Index %d: strict verification failed: %v", index, err)
+		}
+		if err := tree.VerifyProofStrict(proof); err != nil {
+			t.Errorf("Index %d: tree strict verification failed: %v", index, err)
+		}
+	}
+
+	proof, err := tree.Get(big.NewInt(5))
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if err := smt.VerifyProofStrict(smt.Bytes32{1}, 16, proof); err == nil {
+		t.Error("Proof verifies against another root")
+	} else {
+		var mismatch *smt.RootMismatchError
+		if !errors.As(err, &mismatch) || mismatch.Computed != root {
+			t.Errorf("Expected RootMismatchError, got %v", err)
+		}
+	}
+}
+
+func TestVerifyProofStrictRejectsMalformedProofs(t *testing.T) {
+	tree := strictTree(t)
+	root := tree.Root()
+
+	member := func() *smt.Proof {
+		proof, err := tree.Get(big.NewInt(997))
+		if err != nil || !proof.Exists || len(proof.Siblings) == 0 {
+			t.Fatalf("Get failed: %v", err)
+		}
+		return proof
+	}
+	absent := func() *smt.Proof {
+		proof, err := tree.Get(big.NewInt(5))
+		if err != nil || proof.Exists {
+			t.Fatalf("Get failed: %v", err)
+		}
+		return proof
+	}
+
+	cases := []struct {
+		name   string
+		proof  func() *smt.Proof
+		tamper func(p *smt.Proof)
+		target interface{}
+	}{
+		{"extra sibling", member, func(p *smt.Proof) { p.Siblings = append(p.Siblings, smt.Bytes32{1}) }, new(*smt.SiblingCountError)},
+		{"missing sibling", absent, func(p *smt.Proof) { p.Siblings = p.Siblings[1:] }, new(*smt.SiblingCountError)},
+		{"enable beyond depth", member, func(p *smt.Proof) { p.Enables.SetBit(p.Enables, 16, 1) }, new(*smt.EnableBitError)},
+		{"index out of range", absent, func(p *smt.Proof) { p.Index = big.NewInt(1 << 16) }, new(*smt.OutOfRangeError)},
+		{"negative index", absent, func(p *smt.Proof) { p.Index = big.NewInt(-1) }, new(*smt.OutOfRangeError)},
+		{"leaf hash", member, func(p *smt.Proof) { p.Leaf[0] ^= 1 }, new(*smt.LeafHashMismatchError)},
+		{"leaf in non-membership", absent, func(p *smt.Proof) { p.Leaf = smt.Bytes32{1} }, new(*smt.LeafHashMismatchError)},
+		{"value", member, func(p *smt.Proof) { p.Value[0] ^= 1 }, new(*smt.LeafHashMismatchError)},
+		{"sibling", member, func(p *smt.Proof) { p.Siblings[0][0] ^= 1 }, new(*smt.RootMismatchError)},
+	}
+	for _, c := range cases {
+		proof := c.proof()
+		c.tamper(proof)
+		err := smt.VerifyProofStrict(root, 16, proof)
+		if !errors.Is(err, smt.ErrInvalidProof) {
+			t.Errorf("%s: expected an invalid proof error, got %v", c.name, err)
+		}
+		if !errors.As(err, c.target) {
+			t.Errorf("%s: expected %T, got %v", c.name, c.target, err)
+		}
+	}
+
+	// The lenient verifier ignores the extra data
+	proof := member()
+	proof.Siblings = append(proof.Siblings, smt.Bytes32{1})
+	proof.Enables.SetBit(proof.Enables, 300, 1)
+	if !smt.VerifyProof(root, 16, proof) {
+		t.Error("Lenient verification rejects a proof with extra data")
+	}
+
+	for name, proof := range map[string]*smt.Proof{"nil": nil, "nil index": {}} {
+		if err := smt.VerifyProofStrict(root, 16, proof); !errors.Is(err, smt.ErrInvalidProof) {
+			t.Errorf("%s proof: expected ErrInvalidProof, got %v", name, err)
+		}
+	}
+	zeroSibling := member()
+	zeroSibling.Siblings[0] = smt.Bytes32{}
+	if err := smt.VerifyProofStrict(root, 16, zeroSibling); !errors.Is(err, smt.ErrInvalidProof) {
+		t.Errorf("Zero sibling: expected ErrInvalidProof, got %v", err)
+	}
+}
+
+func TestNonMembershipProof(t *testing.T) {
+	tree := strictTree(t)
+
+	proof, err := tree.GetNonMembershipProof(big.NewInt(5))
+	if err != nil {
+		t.Fatalf("GetNonMembershipProof failed: %v", err)
+	}
+	if err := smt.VerifyNonMembershipProof(tree.Root(), 16, proof); err != nil {
+		t.Errorf("Non-membership proof does not verify: %v", err)
+	}
+
+	// The proof no longer holds once a leaf is inserted at the index
+	if _, err := tree.Insert(big.NewInt(5), smt.Bytes32{5}); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	var mismatch *smt.RootMismatchError
+	if err := smt.VerifyNonMembershipProof(tree.Root(), 16, proof); !errors.As(err, &mismatch) {
+		t.Errorf("Expected RootMismatchError, got %v", err)
+	}
+
+	var exists *smt.KeyExistsError
+	if _, err := tree.GetNonMembershipProof(big.NewInt(5)); !errors.As(err, &exists) {
+		t.Errorf("Expected KeyExistsError, got %v", err)
+	}
+	if err := smt.VerifyNonMembershipProof(tree.Root(), 16, nil); !errors.Is(err, smt.ErrInvalidProof) {
+		t.Errorf("Expected ErrInvalidProof, got %v", err)
+	}
+}
+
+func TestVerifyProofStrictWithOptions(t *testing.T) {
+	opts := &smt.TreeOptions{Depth: 64, DomainTag: "strict", Compact: true}
+	tree, err := smt.NewSparseMerkleTreeWithOptions(smt.NewInMemoryDatabase(), opts)
+	if err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+	for i := int64(1); i <= 20; i++ {
+		if _, err := tree.Insert(big.NewInt(i*i*7919), smt.Bytes32{byte(i)}); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+
+	for _, index := range []int64{7919, 4 * 7919, 7920} {
+		proof, err := tree.Get(big.NewInt(index))
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if err := smt.VerifyProofStrictWithOptions(tree.Root(), proof, opts); err != nil {
+			t.Errorf("Index %d: strict verification failed: %v", index, err)
+		}
+		if err := tree.VerifyProofStrict(proof); err != nil {
+			t.Errorf("Index %d: tree strict verification failed: %v", index, err)
+		}
+		if err := smt.VerifyProofStrict(tree.Root(), 64, proof); err == nil {
+			t.Errorf("Index %d: compact proof verifies as a full-depth proof", index)
+		}
+	}
+
+	// A neighbour that does not share the path is rejected
+	proof, err := tree.Get(big.NewInt(7920))
+	if err != nil || proof.Neighbor == nil {
+		t.Fatalf("Expected a proof ending at a neighbour: %v", err)
+	}
+	proof.Neighbor = &smt.LeafData{Index: big.NewInt(1 << 40), Value: proof.Neighbor.Value}
+	if err := smt.VerifyProofStrictWithOptions(tree.Root(), proof, opts); !errors.Is(err, smt.ErrInvalidProof) {
+		t.Errorf("Expected ErrInvalidProof, got %v", err)
+	}
+}
+
+func TestNonMembershipProofWithOptions(t *testing.T) {
+	opts := &smt.TreeOptions{Depth: 64, Compact: true}
+	tree, err := smt.NewSparseMerkleTreeWithOptions(smt.NewInMemoryDatabase(), opts)
+	if err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+	for i := int64(1); i <= 20; i++ {
+		if _, err := tree.Insert(big.NewInt(i*i*7919), smt.Bytes32{byte(i)}); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+
+	// The path of 7920 ends at the leaf stored at 7919
+	proof, err := tree.GetNonMembershipProof(big.NewInt(7920))
+	if err != nil || proof.Neighbor == nil {
+		t.Fatalf("Expected a proof ending at a neighbour: %v", err)
+	}
+	if err := smt.VerifyNonMembershipProofWithOptions(tree.Root(), proof, opts); err != nil {
+		t.Errorf("Compact non-membership proof does not verify: %v", err)
+	}
+	if err := smt.VerifyNonMembershipProof(tree.Root(), 64, proof); !errors.Is(err, smt.ErrInvalidProof) {
+		t.Errorf("Expected ErrInvalidProof for a neighbour in a full-depth proof, got %v", err)
+	}
+
+	if _, err := tree.Insert(big.NewInt(7920), smt.Bytes32{0xff}); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	var mismatch *smt.RootMismatchError
+	if err := smt.VerifyNonMembershipProofWithOptions(tree.Root(), proof, opts); !errors.As(err, &mismatch) {
+		t.Errorf("Expected RootMismatchError, got %v", err)
+	}
+	if err := smt.VerifyNonMembershipProofWithOptions(tree.Root(), nil, opts); !errors.Is(err, smt.ErrInvalidProof) {
+		t.Errorf("Expected ErrInvalidProof, got %v", err)
+	}
+
+	// Full-depth trees are verified as by VerifyNonMembershipProof
+	plain := strictTree(t)
+	full, err := plain.GetNonMembershipProof(big.NewInt(5))
+	if err != nil {
+		t.Fatalf("GetNonMembershipProof failed: %v", err)
+	}
+	if err := smt.VerifyNonMembershipProofWithOptions(plain.Root(), full, &smt.TreeOptions{Depth: 16}); err != nil {
+		t.Errorf("Full-depth non-membership proof does not verify: %v", err)
+	}
+}
@@ -0,0 +1,225 @@
+package tests
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	smt "github.com/0xanonymeow/smt/go"
+)
+
+// transitionOps returns a batch mixing direct and KV inserts, updates and
+// deletes over a tree holding leaves 0..9 and the keys "a" and "b"
+func transitionOps() []smt.BatchOperation {
+	return []smt.BatchOperation{
+		{Type: "insert", Index: big.NewInt(100), Leaf: smt.Bytes32{1}},
+		{Type: "update", Index: big.NewInt(3), Leaf: smt.Bytes32{2}},
+		{Type: "delete", Index: big.NewInt(5)},
+		{Type: "update", Index: big.NewInt(100), Leaf: smt.Bytes32{3}},
+		{Type: "insert", Key: "c", Value: smt.Bytes32{4}},
+		{Type: "update", Key: "a", Value: smt.Bytes32{5}},
+		{Type: "delete", Key: "b"},
+		{Type: "delete", Index: big.NewInt(100)},
+		{Type: "insert", Index: big.NewInt(5), Leaf: smt.Bytes32{6}},
+	}
+}
+
+func transitionTree(t *testing.T, opts *smt.TreeOptions) *smt.SparseMerkleTree {
+	t.Helper()
+	tree, err := smt.NewSparseMerkleTreeWithOptions(smt.NewInMemoryDatabase(), opts)
+	if err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+	for i := int64(0); i < 10; i++ {
+		if _, err := tree.Insert(big.NewInt(i), smt.Bytes32{byte(i + 10)}); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+	for _, key := range []string{"a", "b"} {
+		if _, err := tree.InsertKV(key, smt.Bytes32{key[0]}); err != nil {
+			t.Fatalf("InsertKV failed: %v", err)
+		}
+	}
+	return tree
+}
+
+func TestVerifyTransition(t *testing.T) {
+	tree := transitionTree(t, &smt.TreeOptions{Depth: 256})
+	oldRoot := tree.Root()
+	ops := transitionOps()
+
+	proof, err := tree.ExecuteBatchTransition(ops)
+	if err != nil {
+		t.Fatalf("ExecuteBatchTransition failed: %v", err)
+	}
+	newRoot := tree.Root()
+	if proof.OldRoot != oldRoot || proof.NewRoot != newRoot || len(proof.Steps) != len(ops) {
+		t.Fatalf("Transition proof does not match the batch")
+	}
+	if !smt.VerifyTransition(oldRoot, newRoot, 256, ops, proof) {
+		t.Fatal("Transition does not verify")
+	}
+
+	// KV operations in a batch store the leaf hash of the value, as
+	// ExecuteBatch always has, and the verifier expects the same leaf
+	batched := transitionTree(t, &smt.TreeOptions{Depth: 256})
+	batchProofs, err := batched.ExecuteBatch([]smt.BatchOperation{{Type: "insert", Key: "c", Value: smt.Bytes32{4}}})
+	if err != nil {
+		t.Fatalf("ExecuteBatch failed: %v", err)
+	}
+	index := batchProofs[0].Index
+	leaf, err := batched.Get(index)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if expected := batched.HashSuite().HashLeaf(index, smt.Bytes32{4}); leaf.Value != expected {
+		t.Errorf("KV insert in a batch stored %s, expected %s", leaf.Value, expected)
+	}
+	if proof.Steps[4].Index.Cmp(index) != 0 {
+		t.Fatal("Transition step does not use the key's index")
+	}
+
+	// An empty batch proves the root is unchanged
+	empty, err := tree.ExecuteBatchTransition(nil)
+	if err != nil {
+		t.Fatalf("ExecuteBatchTransition failed: %v", err)
+	}
+	if !smt.VerifyTransition(newRoot, newRoot, 256, nil, empty) {
+		t.Error("Empty transition does not verify")
+	}
+}
+
+func TestVerifyTransitionRejectsTampering(t *testing.T) {
+	tree := transitionTree(t, &smt.TreeOptions{Depth: 256})
+	oldRoot := tree.Root()
+	ops := transitionOps()
+	proof, err := tree.ExecuteBatchTransition(ops)
+	if err != nil {
+		t.Fatalf("ExecuteBatchTransition failed: %v", err)
+	}
+	newRoot := tree.Root()
+
+	tamperedOps := map[string]func(ops []smt.BatchOperation){
+		"leaf":     func(ops []smt.BatchOperation) { ops[0].Leaf = smt.Bytes32{9} },
+		"kv value": func(ops []smt.BatchOperation) { ops[5].Value = smt.Bytes32{9} },
+		"index":    func(ops []smt.BatchOperation) { ops[2].Index = big.NewInt(6) },
+		"key":      func(ops []smt.BatchOperation) { ops[6].Key = "a" },
+		"type":     func(ops []smt.BatchOperation) { ops[1].Type = "insert" },
+		"unknown":  func(ops []smt.BatchOperation) { ops[1].Type = "upsert" },
+		"order":    func(ops []smt.BatchOperation) { ops[1], ops[2] = ops[2], ops[1] },
+	}
+	for name, tamper := range tamperedOps {
+		changed := transitionOps()
+		tamper(changed)
+		if smt.VerifyTransition(oldRoot, newRoot, 256, changed, proof) {
+			t.Errorf("Transition with tampered %s operation verifies", name)
+		}
+	}
+	if smt.VerifyTransition(oldRoot, newRoot, 256, ops[:len(ops)-1], proof) {
+		t.Error("Transition with a missing operation verifies")
+	}
+
+	tamperedProofs := map[string]func(p *smt.TransitionProof){
+		"intermediate root": func(p *smt.TransitionProof) { p.Roots[3][0] ^= 1 },
+		"new leaf":          func(p *smt.TransitionProof) { p.Steps[0].NewLeaf[0] ^= 1 },
+		"sibling":           func(p *smt.TransitionProof) { p.Steps[2].Siblings[0][0] ^= 1 },
+		"operation":         func(p *smt.TransitionProof) { p.Steps[1].Op = smt.OpInsert },
+		"dropped step":      func(p *smt.TransitionProof) { p.Steps = p.Steps[1:] },
+		"nil step":          func(p *smt.TransitionProof) { p.Steps[4] = nil },
+		"old root":          func(p *smt.TransitionProof) { p.OldRoot = smt.Bytes32{1} },
+	}
+	for name, tamper := range tamperedProofs {
+		changed := copyTransition(proof)
+		tamper(changed)
+		if smt.VerifyTransition(oldRoot, newRoot, 256, ops, changed) {
+			t.Errorf("Transition with tampered %s verifies", name)
+		}
+	}
+
+	if smt.VerifyTransition(oldRoot, oldRoot, 256, ops, proof) {
+		t.Error("Transition verifies against the old root")
+	}
+	if smt.VerifyTransition(oldRoot, newRoot, 256, ops, nil) {
+		t.Error("Nil transition verifies")
+	}
+}
+
+// copyTransition deep-copies a transition proof so it can be tampered with
+func copyTransition(p *smt.TransitionProof) *smt.TransitionProof {
+	c := &smt.TransitionProof{OldRoot: p.OldRoot, NewRoot: p.NewRoot, Roots: append([]smt.Bytes32{}, p.Roots...)}
+	for _, step := range p.Steps {
+		s := *step
+		s.Siblings = append([]smt.Bytes32{}, step.Siblings...)
+		c.Steps = append(c.Steps, &s)
+	}
+	return c
+}
+
+func TestVerifyTransitionWithOptions(t *testing.T) {
+	opts := &smt.TreeOptions{Depth: 64, DomainTag: "transition", KeyDerivation: smt.SHA256KeyDerivation()}
+	tree := transitionTree(t, opts)
+	oldRoot := tree.Root()
+	ops := transitionOps()
+
+	proof, err := tree.ExecuteBatchTransition(ops)
+	if err != nil {
+		t.Fatalf("ExecuteBatchTransition failed: %v", err)
+	}
+	if !smt.VerifyTransitionWithOptions(oldRoot, tree.Root(), ops, proof, opts) {
+		t.Error("Transition does not verify with the tree's options")
+	}
+	if smt.VerifyTransition(oldRoot, tree.Root(), 64, ops, proof, opts.EffectiveHashSuite()) {
+		t.Error("Transition verifies with the default key derivation")
+	}
+
+	// A failed batch leaves the tree unchanged and yields no proof
+	root := tree.Root()
+	failing := []smt.BatchOperation{{Type: "insert", Index: big.NewInt(200), Leaf: smt.Bytes32{1}}, {Type: "delete", Index: big.NewInt(201)}}
+	var batchErr *smt.BatchError
+	if _, err := tree.ExecuteBatchTransition(failing); !errors.As(err, &batchErr) || batchErr.Index != 1 {
+		t.Errorf("Expected a BatchError for operation 1, got %v", err)
+	}
+	if tree.Root() != root {
+		t.Error("Failed batch changed the root")
+	}
+
+}
+
+func TestVerifyCompactTransition(t *testing.T) {
+	opts := &smt.TreeOptions{Depth: 64, Compact: true}
+	tree := transitionTree(t, opts)
+	oldRoot := tree.Root()
+	ops := transitionOps()
+
+	proof, err := tree.ExecuteBatchTransition(ops)
+	if err != nil {
+		t.Fatalf("ExecuteBatchTransition failed: %v", err)
+	}
+	if !smt.VerifyTransitionWithOptions(oldRoot, tree.Root(), ops, proof, opts) {
+		t.Fatal("Compact transition does not verify")
+	}
+	if smt.VerifyTransition(oldRoot, tree.Root(), 64, ops, proof) {
+		t.Error("Compact transition verifies as a full-depth one")
+	}
+	if smt.VerifyTransitionWithOptions(oldRoot, oldRoot, ops, proof, opts) {
+		t.Error("Compact transition verifies against the old root")
+	}
+
+	// Deleting one of the two leaves under a node moves the other one up
+	collapse := []smt.BatchOperation{
+		{Type: "insert", Index: big.NewInt(1 << 40), Leaf: smt.Bytes32{7}},
+		{Type: "insert", Index: big.NewInt(1<<40 + 1), Leaf: smt.Bytes32{8}},
+		{Type: "delete", Index: big.NewInt(1 << 40)},
+	}
+	before := tree.Root()
+	proof, err = tree.ExecuteBatchTransition(collapse)
+	if err != nil {
+		t.Fatalf("ExecuteBatchTransition failed: %v", err)
+	}
+	if proof.Steps[2].Neighbor == nil {
+		t.Fatal("Expected the delete to move its sibling up")
+	}
+	if !smt.VerifyTransitionWithOptions(before, tree.Root(), collapse, proof, opts) {
+		t.Error("Compact transition with a collapsing delete does not verify")
+	}
+}
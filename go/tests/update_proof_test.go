@@ -0,0 +1,190 @@
+package tests
+
+import (
+	"math/big"
+	"math/rand"
+	"testing"
+
+	smt "github.com/0xanonymeow/smt/go"
+)
+
+func TestVerifyUpdateProofOperations(t *testing.T) {
+	suites := map[string]*smt.HashSuite{
+		"keccak": smt.Keccak256HashSuite(),
+		"tagged": smt.DomainSeparatedHashSuite(smt.SHA256HashSuite(), "update-proof"),
+	}
+	for name, suite := range suites {
+		tree, err := smt.NewSparseMerkleTreeWithHashSuite(smt.NewInMemoryDatabase(), 16, suite)
+		if err != nil {
+			t.Fatalf("Failed to create tree: %v", err)
+		}
+
+		rng := rand.New(rand.NewSource(23))
+		present := make(map[int64]bool)
+		for i := 0; i < 400; i++ {
+			index := rng.Int63n(64)
+			var value smt.Bytes32
+			rng.Read(value[:])
+
+			oldRoot := tree.Root()
+			var proof *smt.UpdateProof
+			var want smt.UpdateOp
+			switch {
+			case present[index] && rng.Intn(2) == 0:
+				proof, err = tree.Delete(big.NewInt(index))
+				want = smt.OpDelete
+				present[index] = false
+			case present[index]:
+				proof, err = tree.Update(big.NewInt(index), value)
+				want = smt.OpUpdate
+			default:
+				proof, err = tree.Insert(big.NewInt(index), value)
+				want = smt.OpInsert
+				present[index] = true
+			}
+			if err != nil {
+				t.Fatalf("%s: write %d failed: %v", name, i, err)
+			}
+
+			if proof.Op != want {
+				t.Fatalf("%s: write %d recorded %q, expected %q", name, i, proof.Op, want)
+			}
+			if !smt.VerifyUpdateProof(oldRoot, tree.Root(), 16, proof, suite) {
+				t.Fatalf("%s: %s proof %d does not verify", name, proof.Op, i)
+			}
+			if tree.Root() != oldRoot && smt.VerifyUpdateProof(oldRoot, oldRoot, 16, proof, suite) {
+				t.Fatalf("%s: %s proof %d verifies without changing the root", name, proof.Op, i)
+			}
+		}
+	}
+}
+
+func TestVerifyUpdateProofDeleteLastLeaf(t *testing.T) {
+	tree := CreateTestTree(t, 256)
+	if _, err := tree.Insert(big.NewInt(7), smt.Bytes32{7}); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	oldRoot := tree.Root()
+
+	proof, err := tree.Delete(big.NewInt(7))
+	if err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if !tree.Root().IsZero() || !smt.VerifyUpdateProof(oldRoot, smt.Bytes32{}, 256, proof) {
+		t.Error("Deleting the only leaf does not verify against the empty root")
+	}
+}
+
+func TestVerifyUpdateProofRejectsWrongOperation(t *testing.T) {
+	tree := CreateTestTree(t, 16)
+	for i := int64(1); i <= 10; i++ {
+		if _, err := tree.Insert(big.NewInt(i*131), smt.Bytes32{byte(i)}); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+	oldRoot := tree.Root()
+	deletion, err := tree.Delete(big.NewInt(131))
+	if err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	newRoot := tree.Root()
+
+	// Proofs without an operation infer it
+	legacy := *deletion
+	legacy.Op = ""
+	if !smt.VerifyUpdateProof(oldRoot, newRoot, 16, &legacy) {
+		t.Error("Delete proof without an operation does not verify")
+	}
+
+	for _, op := range []smt.UpdateOp{smt.OpInsert, smt.OpUpdate, "replace"} {
+		wrong := *deletion
+		wrong.Op = op
+		if smt.VerifyUpdateProof(oldRoot, newRoot, 16, &wrong) {
+			t.Errorf("Delete proof labelled %q verifies", op)
+		}
+	}
+
+	// A delete cannot claim a new leaf, nor remove an absent one
+	withLeaf := *deletion
+	withLeaf.NewLeaf = smt.Bytes32{1}
+	if smt.VerifyUpdateProof(oldRoot, newRoot, 16, &withLeaf) {
+		t.Error("Delete proof with a new leaf verifies")
+	}
+	absent, err := tree.Get(big.NewInt(131))
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	phantom := &smt.UpdateProof{Index: absent.Index, Enables: absent.Enables, Siblings: absent.Siblings, Op: smt.OpDelete}
+	if smt.VerifyUpdateProof(newRoot, newRoot, 16, phantom) {
+		t.Error("Delete proof for an absent leaf verifies")
+	}
+	if smt.VerifyUpdateProof(oldRoot, newRoot, 16, nil) {
+		t.Error("Nil update proof verifies")
+	}
+}
+
+func TestUpdateProofOperationSerialization(t *testing.T) {
+	tree := CreateTestTree(t, 16)
+	insert, err := tree.Insert(big.NewInt(3), smt.Bytes32{3})
+	if err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	oldRoot := tree.Root()
+	deletion, err := tree.Delete(big.NewInt(3))
+	if err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	for _, proof := range []*smt.UpdateProof{insert, deletion} {
+		sup := smt.SerializeUpdateProof(proof)
+		if sup.Op != string(proof.Op) {
+			t.Errorf("Serialized operation %q, expected %q", sup.Op, proof.Op)
+		}
+		back, err := smt.DeserializeUpdateProof(sup)
+		if err != nil {
+			t.Fatalf("DeserializeUpdateProof failed: %v", err)
+		}
+		if back.Op != proof.Op {
+			t.Errorf("Deserialized operation %q, expected %q", back.Op, proof.Op)
+		}
+		if smt.UpdateProofToJSON(proof)["op"] != string(proof.Op) {
+			t.Errorf("JSON operation differs for %q", proof.Op)
+		}
+	}
+
+	back, err := smt.DeserializeUpdateProof(smt.SerializeUpdateProof(deletion))
+	if err != nil || !smt.VerifyUpdateProof(oldRoot, tree.Root(), 16, back) {
+		t.Errorf("Deserialized delete proof does not verify: %v", err)
+	}
+
+	sup := smt.SerializeUpdateProof(deletion)
+	sup.Op = "replace"
+	if _, err := smt.DeserializeUpdateProof(sup); err == nil {
+		t.Error("Unknown operation deserializes")
+	}
+}
+
+func TestBatchUpdateProofOperations(t *testing.T) {
+	tree := CreateTestTree(t, 32)
+	writes := randomWrites(4, 300, 32, make(map[string]bool))
+	proofs, err := tree.CommitBatchWithProofs(writes)
+	if err != nil {
+		t.Fatalf("CommitBatchWithProofs failed: %v", err)
+	}
+
+	// Each proof moves the root the previous one left to the next
+	for i, proof := range proofs {
+		if writes[i].Delete != (proof.Op == smt.OpDelete) {
+			t.Fatalf("Write %d recorded %q", i, proof.Op)
+		}
+		oldRoot := smt.ComputeRootFromProof(32, &smt.Proof{Exists: proof.Exists, Value: proof.Value, Index: proof.Index, Enables: proof.Enables, Siblings: proof.Siblings})
+		newRoot := tree.Root()
+		if i+1 < len(proofs) {
+			next := proofs[i+1]
+			newRoot = smt.ComputeRootFromProof(32, &smt.Proof{Exists: next.Exists, Value: next.Value, Index: next.Index, Enables: next.Enables, Siblings: next.Siblings})
+		}
+		if !smt.VerifyUpdateProof(oldRoot, newRoot, 32, proof) {
+			t.Fatalf("%s proof %d does not verify", proof.Op, i)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package tests
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	smt "github.com/0xanonymeow/smt/go"
+)
+
+func TestHistoricalProofs(t *testing.T) {
+	db := smt.NewInMemoryDatabase()
+	tree, err := smt.NewSparseMerkleTree(db, 16)
+	if err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+	tree.SetRetentionPolicy(smt.RetentionPolicy{})
+
+	if tree.Version() != 0 {
+		t.Fatalf("New tree should be at version 0, got %d", tree.Version())
+	}
+
+	roots := make(map[uint64]smt.Bytes32)
+	for i := int64(1); i <= 5; i++ {
+		if _, err := tree.Insert(big.NewInt(i*100), smt.Bytes32{byte(i)}); err != nil {
+			t.Fatalf("Failed to insert %d: %v", i, err)
+		}
+		roots[tree.Version()] = tree.Root()
+	}
+	if _, err := tree.Update(big.NewInt(100), smt.Bytes32{0xaa}); err != nil {
+		t.Fatalf("Failed to update: %v", err)
+	}
+	if _, err := tree.Delete(big.NewInt(300)); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+
+	if tree.Version() != 7 || tree.OldestVersion() != 1 {
+		t.Fatalf("Expected versions 1..7, got %d..%d", tree.OldestVersion(), tree.Version())
+	}
+
+	for version, root := range roots {
+		got, err := tree.RootAt(version)
+		if err != nil || got != root {
+			t.Fatalf("RootAt(%d) = %s (err=%v), expected %s", version, got, err, root)
+		}
+	}
+
+	// Index 300 was inserted at version 3 and deleted at version 7
+	for version, want := range map[uint64]bool{2: false, 3: true, 6: true, 7: false} {
+		exists, err := tree.ExistsAt(version, big.NewInt(300))
+		if err != nil {
+			t.Fatalf("ExistsAt(%d) failed: %v", version, err)
+		}
+		if exists != want {
+			t.Fatalf("ExistsAt(%d) = %v, expected %v", version, exists, want)
+		}
+	}
+
+	proof, err := tree.GetAt(1, big.NewInt(100))
+	if err != nil {
+		t.Fatalf("GetAt failed: %v", err)
+	}
+	if !proof.Exists || proof.Value != (smt.Bytes32{1}) {
+		t.Fatalf("Expected original value at version 1, got %s", proof.Value)
+	}
+	if !smt.VerifyProof(roots[1], 16, proof) {
+		t.Fatal("Historical proof should verify against the historical root")
+	}
+
+	absent, err := tree.GetAt(4, big.NewInt(500))
+	if err != nil {
+		t.Fatalf("GetAt failed: %v", err)
+	}
+	if absent.Exists || !smt.VerifyProof(roots[4], 16, absent) {
+		t.Fatal("Expected a valid non-membership proof at version 4")
+	}
+
+	var notFound *smt.VersionNotFoundError
+	for _, version := range []uint64{0, 8} {
+		if _, err := tree.GetAt(version, big.NewInt(100)); !errors.As(err, &notFound) {
+			t.Fatalf("Expected VersionNotFoundError for version %d, got %v", version, err)
+		}
+	}
+
+	// Failed operations do not create versions
+	if _, err := tree.Insert(big.NewInt(100), smt.Bytes32{1}); err == nil {
+		t.Fatal("Expected duplicate insert to fail")
+	}
+	if tree.Version() != 7 {
+		t.Fatalf("Failed insert should not commit a version, got %d", tree.Version())
+	}
+
+	// History survives a restart
+	reopened, err := smt.OpenSparseMerkleTree(db)
+	if err != nil {
+		t.Fatalf("Failed to reopen tree: %v", err)
+	}
+	if reopened.Version() != 7 || reopened.OldestVersion() != 1 {
+		t.Fatalf("Expected versions 1..7 after reopen, got %d..%d", reopened.OldestVersion(), reopened.Version())
+	}
+	if exists, _ := reopened.ExistsAt(5, big.NewInt(300)); !exists {
+		t.Fatal("Historical state should survive a restart")
+	}
+}
+
+func TestVersionRetentionByCount(t *testing.T) {
+	db := smt.NewInMemoryDatabase()
+	tree, err := smt.NewSparseMerkleTree(db, 16)
+	if err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+	tree.SetRetentionPolicy(smt.RetentionPolicy{MaxVersions: 3})
+
+	for i := int64(0); i < 10; i++ {
+		if _, err := tree.Insert(big.NewInt(i), smt.Bytes32{byte(i + 1)}); err != nil {
+			t.Fatalf("Failed to insert %d: %v", i, err)
+		}
+	}
+	// ExecuteBatch commits a single version
+	_, err = tree.ExecuteBatch([]smt.BatchOperation{
+		{Type: "update", Index: big.NewInt(0), Leaf: smt.Bytes32{0xaa}},
+		{Type: "delete", Index: big.NewInt(1)},
+	})
+	if err != nil {
+		t.Fatalf("ExecuteBatch failed: %v", err)
+	}
+
+	if tree.Version() != 11 || tree.OldestVersion() != 9 {
+		t.Fatalf("Expected versions 9..11, got %d..%d", tree.OldestVersion(), tree.Version())
+	}
+	var notFound *smt.VersionNotFoundError
+	if _, err := tree.ExistsAt(8, big.NewInt(0)); !errors.As(err, &notFound) {
+		t.Fatalf("Expected pruned version 8 to be gone, got %v", err)
+	}
+	if exists, _ := tree.ExistsAt(10, big.NewInt(1)); !exists {
+		t.Fatal("Index 1 should exist at version 10")
+	}
+
+	// Tightening the policy reclaims every node only reachable from old versions
+	tree.SetRetentionPolicy(smt.DefaultRetentionPolicy())
+	pruned, err := tree.PruneVersions()
+	if err != nil || pruned != 2 {
+		t.Fatalf("Expected 2 versions pruned, got %d (err=%v)", pruned, err)
+	}
+
+	freshDB := smt.NewInMemoryDatabase()
+	fresh, err := smt.NewSparseMerkleTree(freshDB, 16)
+	if err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+	fresh.Insert(big.NewInt(0), smt.Bytes32{0xaa})
+	for i := int64(2); i < 10; i++ {
+		fresh.Insert(big.NewInt(i), smt.Bytes32{byte(i + 1)})
+	}
+	if fresh.Root() != tree.Root() {
+		t.Fatal("Roots should match")
+	}
+	compareRecords(t, storedRecords(t, db), storedRecords(t, freshDB))
+}
+
+func TestRetentionPolicySurvivesReopen(t *testing.T) {
+	db := smt.NewInMemoryDatabase()
+	tree, err := smt.NewSparseMerkleTree(db, 16)
+	if err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+	policy := smt.RetentionPolicy{MaxVersions: 10, MaxAge: time.Hour}
+	if err := tree.SetRetentionPolicy(policy); err != nil {
+		t.Fatalf("SetRetentionPolicy failed: %v", err)
+	}
+	for i := int64(1); i <= 3; i++ {
+		if _, err := tree.Insert(big.NewInt(i), smt.Bytes32{byte(i)}); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+	previous := tree.Root()
+
+	reopened, err := smt.OpenSparseMerkleTree(db)
+	if err != nil {
+		t.Fatalf("OpenSparseMerkleTree failed: %v", err)
+	}
+	if reopened.RetentionPolicy() != policy {
+		t.Fatalf("Reopened tree has policy %+v, expected %+v", reopened.RetentionPolicy(), policy)
+	}
+
+	// The first write after reopening keeps the earlier versions
+	if _, err := reopened.Insert(big.NewInt(4), smt.Bytes32{4}); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	v := reopened.Version()
+	if root, err := reopened.RootAt(v - 1); err != nil || root != previous {
+		t.Fatalf("RootAt(%d) = %s (err=%v), expected %s", v-1, root, err, previous)
+	}
+	if exists, err := reopened.ExistsAt(1, big.NewInt(1)); err != nil || !exists {
+		t.Fatalf("ExistsAt(1) = %v (err=%v), expected true", exists, err)
+	}
+
+	// Trees without a stored policy keep the default
+	fresh, err := smt.NewSparseMerkleTree(smt.NewInMemoryDatabase(), 16)
+	if err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+	if fresh.RetentionPolicy() != smt.DefaultRetentionPolicy() {
+		t.Errorf("New tree has policy %+v, expected the default", fresh.RetentionPolicy())
+	}
+}
+
+func TestVersionRetentionByAge(t *testing.T) {
+	tree, err := smt.NewSparseMerkleTree(smt.NewInMemoryDatabase(), 16)
+	if err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+	tree.SetRetentionPolicy(smt.RetentionPolicy{MaxAge: 50 * time.Millisecond})
+
+	for i := int64(0); i < 3; i++ {
+		if _, err := tree.Insert(big.NewInt(i), smt.Bytes32{byte(i + 1)}); err != nil {
+			t.Fatalf("Failed to insert %d: %v", i, err)
+		}
+	}
+	if tree.OldestVersion() != 1 {
+		t.Fatalf("Recent versions should be kept, oldest is %d", tree.OldestVersion())
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	pruned, err := tree.PruneVersions()
+	if err != nil {
+		t.Fatalf("PruneVersions failed: %v", err)
+	}
+	if pruned != 2 || tree.OldestVersion() != 3 {
+		t.Fatalf("Expected only version 3 to remain, pruned %d, oldest %d", pruned, tree.OldestVersion())
+	}
+	if exists, err := tree.ExistsAt(3, big.NewInt(2)); err != nil || !exists {
+		t.Fatalf("Latest version must always be kept (err=%v)", err)
+	}
+}
@@ -0,0 +1,218 @@
+package tests
+
+import (
+	"errors"
+	"math/big"
+	"math/rand"
+	"testing"
+
+	smt "github.com/0xanonymeow/smt/go"
+)
+
+// sameProof reports whether two proofs carry the same path
+func sameProof(a, b *smt.Proof) bool {
+	if a.Exists != b.Exists || a.Value != b.Value || a.Index.Cmp(b.Index) != 0 || a.Enables.Cmp(b.Enables) != 0 {
+		return false
+	}
+	if len(a.Siblings) != len(b.Siblings) {
+		return false
+	}
+	for i := range a.Siblings {
+		if a.Siblings[i] != b.Siblings[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestUpdateWitness(t *testing.T) {
+	for _, depth := range []uint16{8, 256} {
+		tree := CreateTestTree(t, depth)
+		rng := rand.New(rand.NewSource(25))
+		max := new(big.Int).Lsh(big.NewInt(1), uint(depth))
+
+		// A light client follows a few present and absent indices
+		watched := make([]*big.Int, 6)
+		for i := range watched {
+			watched[i] = new(big.Int).Rand(rng, max)
+			if i%2 == 0 {
+				if _, err := tree.Insert(watched[i], smt.Bytes32{byte(i + 1)}); err != nil {
+					t.Fatalf("Insert failed: %v", err)
+				}
+			}
+		}
+		pool := make([]*big.Int, 20)
+		for i := range pool {
+			pool[i] = new(big.Int).Rand(rng, max)
+		}
+		isWatched := func(index *big.Int) bool {
+			for _, w := range watched {
+				if w.Cmp(index) == 0 {
+					return true
+				}
+			}
+			return false
+		}
+
+		witnesses := make([]*smt.Proof, len(watched))
+		for i, index := range watched {
+			proof, err := tree.Get(index)
+			if err != nil {
+				t.Fatalf("Get failed: %v", err)
+			}
+			witnesses[i] = proof
+		}
+
+		for step := 0; step < 200; step++ {
+			index := pool[rng.Intn(len(pool))]
+			if isWatched(index) {
+				continue
+			}
+			var value smt.Bytes32
+			rng.Read(value[:])
+
+			exists, err := tree.Exists(index)
+			if err != nil {
+				t.Fatalf("Exists failed: %v", err)
+			}
+			var update *smt.UpdateProof
+			switch {
+			case exists && rng.Intn(2) == 0:
+				update, err = tree.Delete(index)
+			case exists:
+				update, err = tree.Update(index, value)
+			default:
+				update, err = tree.Insert(index, value)
+			}
+			if err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+
+			for i, witness := range witnesses {
+				refreshed, err := smt.UpdateWitness(witness, update)
+				if err != nil {
+					t.Fatalf("Depth %d step %d: UpdateWitness failed: %v", depth, step, err)
+				}
+				if !smt.VerifyProof(tree.Root(), depth, refreshed) {
+					t.Fatalf("Depth %d step %d: refreshed witness %d does not verify", depth, step, i)
+				}
+				fresh, err := tree.Get(watched[i])
+				if err != nil {
+					t.Fatalf("Get failed: %v", err)
+				}
+				if !sameProof(refreshed, fresh) {
+					t.Fatalf("Depth %d step %d: refreshed witness %d differs from a fresh proof", depth, step, i)
+				}
+				witnesses[i] = refreshed
+			}
+		}
+	}
+}
+
+func TestUpdateWitnessOwnIndex(t *testing.T) {
+	tree := CreateTestTree(t, 16)
+	for i := int64(1); i <= 8; i++ {
+		if _, err := tree.Insert(big.NewInt(i*1000), smt.Bytes32{byte(i)}); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+	witness, err := tree.Get(big.NewInt(3000))
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+
+	update, err := tree.Update(big.NewInt(3000), smt.Bytes32{9})
+	if err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if _, err := smt.UpdateWitness(witness, update); !errors.Is(err, smt.ErrWitnessIndex) {
+		t.Errorf("Expected ErrWitnessIndex, got %v", err)
+	}
+
+	// Deleting the leaf turns the witness into a non-membership proof
+	witness, err = tree.Get(big.NewInt(3000))
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	deletion, err := tree.Delete(big.NewInt(3000))
+	if err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	refreshed, err := smt.UpdateWitness(witness, deletion)
+	if err != nil {
+		t.Fatalf("UpdateWitness failed: %v", err)
+	}
+	if refreshed.Exists || !smt.VerifyProof(tree.Root(), 16, refreshed) {
+		t.Error("Witness of a deleted leaf does not prove non-membership")
+	}
+}
+
+func TestUpdateWitnessRejectsMismatchedProofs(t *testing.T) {
+	suite := smt.DomainSeparatedHashSuite(smt.Keccak256HashSuite(), "witness")
+	tree, err := smt.NewSparseMerkleTreeWithHashSuite(smt.NewInMemoryDatabase(), 16, suite)
+	if err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+	for i := int64(1); i <= 8; i++ {
+		if _, err := tree.Insert(big.NewInt(i*1000), smt.Bytes32{byte(i)}); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+	witness, err := tree.Get(big.NewInt(1000))
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+
+	// Missing an update leaves the witness behind the next one
+	if _, err := tree.Update(big.NewInt(5000), smt.Bytes32{10}); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	next, err := tree.Update(big.NewInt(5000), smt.Bytes32{11})
+	if err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if _, err := smt.UpdateWitness(witness, next, suite); !errors.Is(err, smt.ErrWitnessMismatch) {
+		t.Errorf("Expected ErrWitnessMismatch, got %v", err)
+	}
+
+	// The suite must be the tree's
+	witness, err = tree.Get(big.NewInt(1000))
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	update, err := tree.Update(big.NewInt(5000), smt.Bytes32{12})
+	if err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if _, err := smt.UpdateWitness(witness, update); !errors.Is(err, smt.ErrWitnessMismatch) {
+		t.Errorf("Expected ErrWitnessMismatch with another suite, got %v", err)
+	}
+	refreshed, err := smt.UpdateWitness(witness, update, suite)
+	if err != nil || !smt.VerifyProof(tree.Root(), 16, refreshed, suite) {
+		t.Errorf("Refreshed witness does not verify: %v", err)
+	}
+
+	extra := *witness
+	extra.Siblings = append(append([]smt.Bytes32{}, witness.Siblings...), smt.Bytes32{1})
+	var count *smt.SiblingCountError
+	if _, err := smt.UpdateWitness(&extra, update, suite); !errors.As(err, &count) {
+		t.Errorf("Expected SiblingCountError, got %v", err)
+	}
+	if _, err := smt.UpdateWitness(nil, update, suite); !errors.Is(err, smt.ErrInvalidProof) {
+		t.Errorf("Expected ErrInvalidProof, got %v", err)
+	}
+
+	compact, _ := newCompactTree(t, 16)
+	for i := int64(1); i <= 4; i++ {
+		if _, err := compact.Insert(big.NewInt(i), smt.Bytes32{byte(i)}); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+	absent, err := compact.Get(big.NewInt(5))
+	if err != nil || absent.Neighbor == nil {
+		t.Fatalf("Expected a proof ending at a neighbour: %v", err)
+	}
+	if _, err := smt.UpdateWitness(absent, update, suite); !errors.Is(err, smt.ErrCompactLayout) {
+		t.Errorf("Expected ErrCompactLayout, got %v", err)
+	}
+}
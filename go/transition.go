@@ -0,0 +1,89 @@
+package smt
+
+// ExecuteBatchTransition executes operations like ExecuteBatch and returns a
+// TransitionProof that the batch moved the tree from its previous root to the
+// new one. Its steps are the proofs ExecuteBatch returns.
+func (smt *SparseMerkleTree) ExecuteBatchTransition(operations []BatchOperation) (*TransitionProof, error) {
+	smt.mu.Lock()
+	defer smt.mu.Unlock()
+
+	oldRoot := smt.root
+	proofs, roots, err := smt.executeBatch(operations)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TransitionProof{
+		OldRoot: oldRoot,
+		NewRoot: smt.root,
+		Steps:   proofs,
+		Roots:   roots,
+	}, nil
+}
+
+// VerifyTransition verifies that applying ops in order moves a full-depth tree
+// from oldRoot to newRoot, using the optional hash suite, Keccak256 by
+// default. KV operations are indexed with the default Keccak256 key
+// derivation; use VerifyTransitionWithOptions for other schemes.
+func VerifyTransition(oldRoot, newRoot Bytes32, depth uint16, ops []BatchOperation, proof *TransitionProof, suite ...*HashSuite) bool {
+	return verifyTransition(oldRoot, newRoot, depth, ops, proof, resolveHashSuite(suite), Keccak256KeyDerivation(), false)
+}
+
+// VerifyTransitionWithOptions verifies a transition of a tree built with opts,
+// compact or not
+func VerifyTransitionWithOptions(oldRoot, newRoot Bytes32, ops []BatchOperation, proof *TransitionProof, opts *TreeOptions) bool {
+	kd := opts.KeyDerivation
+	if kd == nil {
+		kd = Keccak256KeyDerivation()
+	}
+	return verifyTransition(oldRoot, newRoot, opts.Depth, ops, proof, opts.EffectiveHashSuite(), kd, opts.Compact)
+}
+
+// verifyTransition checks that each step applies its operation to the root
+// the step before it left
+func verifyTransition(oldRoot, newRoot Bytes32, depth uint16, ops []BatchOperation, proof *TransitionProof, hasher *HashSuite, kd *KeyDerivation, compact bool) bool {
+	if proof == nil || len(proof.Steps) != len(ops) || len(proof.Roots) != len(ops) {
+		return false
+	}
+	if proof.OldRoot != oldRoot || proof.NewRoot != newRoot {
+		return false
+	}
+
+	current := oldRoot
+	for i, op := range ops {
+		step := proof.Steps[i]
+		if step == nil || step.Index == nil {
+			return false
+		}
+
+		// ExecuteBatch stores the leaf hash of a KV operation's value as the leaf value
+		index, value := op.Index, op.Leaf
+		if op.Key != "" {
+			index = deriveIndex(kd, depth, op.Key)
+			value = hasher.HashLeaf(index, op.Value)
+		}
+		if index == nil || index.Cmp(step.Index) != 0 {
+			return false
+		}
+
+		// The step must record the operation's kind and, unless it deletes,
+		// the leaf it writes
+		stepOp, ok := step.operation()
+		if !ok || string(stepOp) != op.Type {
+			return false
+		}
+		if stepOp != OpDelete && step.NewLeaf != hasher.HashLeaf(index, value) {
+			return false
+		}
+
+		verify := VerifyUpdateProof
+		if compact {
+			verify = VerifyCompactUpdateProof
+		}
+		if !verify(current, proof.Roots[i], depth, step, hasher) {
+			return false
+		}
+		current = proof.Roots[i]
+	}
+	return current == newRoot
+}
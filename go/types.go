@@ -2,8 +2,11 @@ package smt
 
 import (
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"math/big"
+	"strings"
+	"sync"
 )
 
 // HashFunction defines the interface for hash functions used in SMT
@@ -17,6 +20,29 @@ type Database interface {
 	Has(key []byte) (bool, error)
 }
 
+// Batch accumulates writes that are applied atomically by Write
+type Batch interface {
+	Put(key []byte, value []byte) error
+	Delete(key []byte) error
+	Write() error
+}
+
+// IterableDatabase is an optional Database extension for ordered prefix iteration
+type IterableDatabase interface {
+	Database
+	// IteratePrefix calls fn for every key starting with prefix in ascending
+	// key order, stopping early when fn returns false
+	IteratePrefix(prefix []byte, fn func(key, value []byte) bool) error
+}
+
+// BatchDatabase is an optional Database extension for atomic write batches.
+// When the tree's database implements it, each logical operation is committed
+// with a single batch.
+type BatchDatabase interface {
+	Database
+	NewBatch() Batch
+}
+
 // Bytes32 represents a 32-byte hash value
 type Bytes32 [32]byte
 
@@ -71,6 +97,7 @@ func NewBytes32FromHex(s string) (Bytes32, error) {
 //   - Exists:   Whether the leaf exists in the tree
 //   - Enables:  Bitmask indicating which siblings are non-zero
 //   - Siblings: Array of non-zero sibling hashes for proof verification
+//   - Neighbor: Compact trees only: the leaf a non-membership path ends at
 type Proof struct {
 	Exists   bool     `json:"exists"`   // Whether the leaf exists
 	Leaf     Bytes32  `json:"leaf"`     // Computed leaf hash (Keccak256(index || value || 1))
@@ -78,9 +105,43 @@ type Proof struct {
 	Index    *big.Int `json:"index"`    // Tree index
 	Enables  *big.Int `json:"enables"`  // Sibling enable bitmask
 	Siblings []Bytes32 `json:"siblings"` // Non-zero sibling hashes
+	Neighbor *LeafData `json:"neighbor,omitempty"` // Leaf with another index ending the path
+}
+
+// NonMembershipProof proves that no leaf is stored at Index. It carries the
+// fields of a Proof with Exists false; Neighbor is set only by compact trees.
+type NonMembershipProof struct {
+	Index    *big.Int  `json:"index"`
+	Enables  *big.Int  `json:"enables"`
+	Siblings []Bytes32 `json:"siblings"`
+	Neighbor *LeafData `json:"neighbor,omitempty"`
+}
+
+// Proof returns the non-membership proof as a Proof
+func (p *NonMembershipProof) Proof() *Proof {
+	return &Proof{
+		Exists:   false,
+		Index:    p.Index,
+		Enables:  p.Enables,
+		Siblings: p.Siblings,
+		Neighbor: p.Neighbor,
+	}
 }
 
-// UpdateProof represents the proof data for an update operation
+// UpdateOp is the kind of change an UpdateProof records
+type UpdateOp string
+
+// Update operations
+const (
+	OpInsert UpdateOp = "insert" // A leaf was added at an empty index
+	OpUpdate UpdateOp = "update" // An existing leaf's value was replaced
+	OpDelete UpdateOp = "delete" // An existing leaf was removed
+)
+
+// UpdateProof represents the proof data for an update operation. Op is empty
+// in proofs made before it was recorded; verifiers then infer it from Exists
+// and NewLeaf. Neighbor is set only by compact trees: for an insert it is the
+// leaf the path ended at, for a delete the leaf sibling that moves up.
 type UpdateProof struct {
 	Exists   bool      `json:"exists"`
 	Leaf     Bytes32   `json:"leaf"`
@@ -89,6 +150,75 @@ type UpdateProof struct {
 	Enables  *big.Int  `json:"enables"`
 	Siblings []Bytes32 `json:"siblings"`
 	NewLeaf  Bytes32   `json:"newLeaf"`
+	Neighbor *LeafData `json:"neighbor,omitempty"`
+	Op       UpdateOp  `json:"op,omitempty"`
+}
+
+// MultiProof proves the leaves at several indices against one root. Siblings
+// shared by their paths appear once.
+//
+// Field semantics:
+//   - Indices:  The proven indices in strictly ascending order
+//   - Exists:   Whether a leaf is stored at each index
+//   - Values:   The raw value stored at each index, zero where none is
+//   - Enables:  Bit i is set when the i-th sibling the verifier reads is non-zero
+//   - Siblings: Non-zero sibling hashes in the order the verifier reads them
+//
+// The verifier walks the paths depth first, left before right, and reads the
+// sibling of a node after the subtree below it. For a single index Enables and
+// Siblings are those of its Proof.
+type MultiProof struct {
+	Indices  []*big.Int `json:"indices"`
+	Exists   []bool     `json:"exists"`
+	Values   []Bytes32  `json:"values"`
+	Enables  *big.Int   `json:"enables"`
+	Siblings []Bytes32  `json:"siblings"`
+}
+
+// SubtreeProof proves the complete contents of the subtree holding the indices
+// whose top PrefixLen bits spell Prefix
+//
+// Field semantics:
+//   - Root:     The hash of the subtree
+//   - Leaves:   Every leaf of the subtree in ascending index order
+//   - Enables:  Bit i is set when the sibling at height i above the subtree is non-zero
+//   - Siblings: Non-zero siblings on the path from the subtree to the root, bottom up
+type SubtreeProof struct {
+	Prefix    *big.Int   `json:"prefix"`
+	PrefixLen uint16     `json:"prefixLen"`
+	Root      Bytes32    `json:"root"`
+	Leaves    []LeafData `json:"leaves"`
+	Enables   *big.Int   `json:"enables"`
+	Siblings  []Bytes32  `json:"siblings"`
+}
+
+// RangeProof proves the complete contents of the index range [Start, End)
+//
+// Field semantics:
+//   - Leaves:   Every leaf in the range in ascending index order
+//   - Enables:  Bit i is set when the i-th subtree outside the range is non-empty
+//   - Siblings: Hashes of the non-empty subtrees outside the range, left to right
+//
+// The subtrees outside the range are the largest ones beside the paths to the
+// range's two ends; their slots are ordered as the verifier visits them, depth
+// first and left before right.
+type RangeProof struct {
+	Start    *big.Int   `json:"start"`
+	End      *big.Int   `json:"end"`
+	Leaves   []LeafData `json:"leaves"`
+	Enables  *big.Int   `json:"enables"`
+	Siblings []Bytes32  `json:"siblings"`
+}
+
+// TransitionProof proves that applying a list of batch operations in order
+// moves a tree from OldRoot to NewRoot. Steps[i] is the UpdateProof of the i-th
+// operation and Roots[i] the root it leaves, so each step starts from the root
+// before it.
+type TransitionProof struct {
+	OldRoot Bytes32        `json:"oldRoot"`
+	NewRoot Bytes32        `json:"newRoot"`
+	Steps   []*UpdateProof `json:"steps"`
+	Roots   []Bytes32      `json:"roots"`
 }
 
 // Node represents an internal node in the tree
@@ -110,6 +240,8 @@ type SerializedProof struct {
 	Value    string   `json:"value"`
 	Enables  string   `json:"enables"`
 	Siblings []string `json:"siblings"`
+	NeighborIndex *big.Int `json:"neighborIndex,omitempty"`
+	NeighborValue string   `json:"neighborValue,omitempty"`
 }
 
 // SerializedUpdateProof represents an update proof in serialized format
@@ -121,47 +253,256 @@ type SerializedUpdateProof struct {
 	Enables  string   `json:"enables"`
 	Siblings []string `json:"siblings"`
 	NewLeaf  string   `json:"newLeaf"`
+	NeighborIndex *big.Int `json:"neighborIndex,omitempty"`
+	NeighborValue string   `json:"neighborValue,omitempty"`
+	Op            string   `json:"op,omitempty"`
 }
 
-// KVStore represents a key-value mapping for the tree
+// SerializedMultiProof represents a multi-proof in serialized format. Exists is
+// a bitmask with bit i set when Indices[i] holds a leaf.
+type SerializedMultiProof struct {
+	Indices  []*big.Int `json:"indices"`
+	Exists   string     `json:"exists"`
+	Values   []string   `json:"values"`
+	Enables  string     `json:"enables"`
+	Siblings []string   `json:"siblings"`
+}
+
+// KVStore represents a key-value mapping for the tree.
+// When backed by a Database, key preimages and values are persisted under
+// KVPrefix and loaded lazily on first access; the map acts as a cache.
 type KVStore struct {
-	kv map[string]Bytes32
+	kv      map[string]Bytes32
+	db      Database
+	journal []kvJournalEntry // nil when no journal is active
+	mu      sync.RWMutex
+}
+
+// kvJournalEntry records the cached state of a key before it was modified
+type kvJournalEntry struct {
+	key     string
+	value   Bytes32
+	present bool
 }
 
-// NewKVStore creates a new key-value store
+// NewKVStore creates a new in-memory key-value store
 func NewKVStore() *KVStore {
 	return &KVStore{
 		kv: make(map[string]Bytes32),
 	}
 }
 
-// Get retrieves a value by key
-func (kv *KVStore) Get(key string) (Bytes32, bool) {
+// NewPersistentKVStore creates a key-value store backed by the given database
+func NewPersistentKVStore(db Database) *KVStore {
+	return &KVStore{
+		kv: make(map[string]Bytes32),
+		db: db,
+	}
+}
+
+// kvKey returns the database key holding the value for a KV key
+func kvKey(key string) []byte {
+	return []byte(KVPrefix + hex.EncodeToString([]byte(key)))
+}
+
+// Load retrieves a value by key, reading through to the database on a cache miss
+func (kv *KVStore) Load(key string) (Bytes32, bool, error) {
+	kv.mu.RLock()
 	val, exists := kv.kv[key]
+	kv.mu.RUnlock()
+
+	if exists || kv.db == nil {
+		return val, exists, nil
+	}
+
+	data, found, err := getIfPresent(kv.db, kvKey(key))
+	if err != nil || !found {
+		return Bytes32{}, false, err
+	}
+	if len(data) != 32 {
+		return Bytes32{}, false, fmt.Errorf("invalid kv data length: expected 32, got %d", len(data))
+	}
+
+	copy(val[:], data)
+
+	kv.mu.Lock()
+	kv.record(key)
+	kv.kv[key] = val
+	kv.mu.Unlock()
+
+	return val, true, nil
+}
+
+// Store persists a key-value pair and caches it
+func (kv *KVStore) Store(key string, value Bytes32) error {
+	if kv.db != nil {
+		if err := kv.db.Set(kvKey(key), value[:]); err != nil {
+			return err
+		}
+	}
+
+	kv.mu.Lock()
+	kv.record(key)
+	kv.kv[key] = value
+	kv.mu.Unlock()
+	return nil
+}
+
+// Remove deletes a key-value pair from the cache and the database
+func (kv *KVStore) Remove(key string) error {
+	if kv.db != nil {
+		if err := kv.db.Delete(kvKey(key)); err != nil {
+			return err
+		}
+	}
+
+	kv.mu.Lock()
+	kv.record(key)
+	delete(kv.kv, key)
+	kv.mu.Unlock()
+	return nil
+}
+
+// record journals the cached state of key; the caller must hold kv.mu
+func (kv *KVStore) record(key string) {
+	if kv.journal == nil {
+		return
+	}
+	value, present := kv.kv[key]
+	kv.journal = append(kv.journal, kvJournalEntry{key: key, value: value, present: present})
+}
+
+// beginJournal starts recording cache changes so they can be reverted
+func (kv *KVStore) beginJournal() {
+	kv.mu.Lock()
+	kv.journal = make([]kvJournalEntry, 0)
+	kv.mu.Unlock()
+}
+
+// commitJournal keeps the recorded changes and stops journaling
+func (kv *KVStore) commitJournal() {
+	kv.mu.Lock()
+	kv.journal = nil
+	kv.mu.Unlock()
+}
+
+// revertJournal undoes the recorded changes in reverse order and stops journaling
+func (kv *KVStore) revertJournal() {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	kv.revertLocked(0)
+	kv.journal = nil
+}
+
+// journalLen returns the number of changes recorded so far
+func (kv *KVStore) journalLen() int {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+	return len(kv.journal)
+}
+
+// revertJournalTo undoes the changes recorded after the first n entries and
+// keeps journaling
+func (kv *KVStore) revertJournalTo(n int) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	kv.revertLocked(n)
+}
+
+// revertLocked undoes journal entries from the end down to position n; the caller must hold kv.mu
+func (kv *KVStore) revertLocked(n int) {
+	for i := len(kv.journal) - 1; i >= n; i-- {
+		entry := kv.journal[i]
+		if entry.present {
+			kv.kv[entry.key] = entry.value
+		} else {
+			delete(kv.kv, entry.key)
+		}
+	}
+	if kv.journal != nil {
+		kv.journal = kv.journal[:n]
+	}
+}
+
+// Get retrieves a value by key. Database errors are reported as a miss;
+// use Load to observe them.
+func (kv *KVStore) Get(key string) (Bytes32, bool) {
+	val, exists, err := kv.Load(key)
+	if err != nil {
+		return Bytes32{}, false
+	}
 	return val, exists
 }
 
 // Set stores a key-value pair
 func (kv *KVStore) Set(key string, value Bytes32) {
-	kv.kv[key] = value
+	_ = kv.Store(key, value)
 }
 
 // Delete removes a key-value pair
 func (kv *KVStore) Delete(key string) {
-	delete(kv.kv, key)
+	_ = kv.Remove(key)
 }
 
 // Has checks if a key exists
 func (kv *KVStore) Has(key string) bool {
-	_, exists := kv.kv[key]
+	_, exists := kv.Get(key)
 	return exists
 }
 
-// All returns all key-value pairs
+// All returns all key-value pairs. Database errors are reported by returning
+// only the pairs loaded in memory; use LoadAll to observe them.
 func (kv *KVStore) All() map[string]Bytes32 {
+	result, err := kv.LoadAll()
+	if err != nil {
+		return kv.loaded()
+	}
+	return result
+}
+
+// LoadAll returns all key-value pairs. When the store is backed by an
+// IterableDatabase the stored pairs are scanned; otherwise only the pairs
+// loaded in memory are returned.
+func (kv *KVStore) LoadAll() (map[string]Bytes32, error) {
+	idb, ok := kv.db.(IterableDatabase)
+	if !ok {
+		return kv.loaded(), nil
+	}
+
+	result := make(map[string]Bytes32)
+	var invalid error
+	err := idb.IteratePrefix([]byte(KVPrefix), func(k, v []byte) bool {
+		raw, err := hex.DecodeString(strings.TrimPrefix(string(k), KVPrefix))
+		if err != nil || len(v) != 32 {
+			invalid = fmt.Errorf("invalid kv record %q", k)
+			return false
+		}
+		result[string(raw)] = Bytes32(v)
+		return true
+	})
+	// Wrappers implement IterableDatabase even when the database they wrap does not
+	if errors.Is(err, ErrNotIterable) {
+		return kv.loaded(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if invalid != nil {
+		return nil, invalid
+	}
+	return result, nil
+}
+
+// loaded returns the key-value pairs loaded in memory
+func (kv *KVStore) loaded() map[string]Bytes32 {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+
 	result := make(map[string]Bytes32)
 	for k, v := range kv.kv {
 		result[k] = v
 	}
 	return result
-}
\ No newline at end of file
+}
@@ -0,0 +1,242 @@
+package smt
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// RetentionPolicy decides which historical versions are kept. A version is
+// pruned once it falls outside either limit; zero disables a limit. The
+// latest version is always kept.
+type RetentionPolicy struct {
+	// MaxVersions is the number of most recent versions to keep
+	MaxVersions uint64
+	// MaxAge keeps a version while it was the latest state at some point
+	// within this duration
+	MaxAge time.Duration
+}
+
+// DefaultRetentionPolicy keeps only the latest version, so replaced nodes are
+// reclaimed immediately
+func DefaultRetentionPolicy() RetentionPolicy {
+	return RetentionPolicy{MaxVersions: 1}
+}
+
+// encodeRetentionPolicy serializes a policy as maxVersions(8) || maxAge(8)
+func encodeRetentionPolicy(policy RetentionPolicy) []byte {
+	data := make([]byte, 16)
+	binary.BigEndian.PutUint64(data[0:8], policy.MaxVersions)
+	binary.BigEndian.PutUint64(data[8:16], uint64(policy.MaxAge))
+	return data
+}
+
+// readRetentionPolicy loads the policy stored with a tree, returning the
+// default policy if none was set
+func readRetentionPolicy(db Database) (RetentionPolicy, error) {
+	data, exists, err := getIfPresent(db, []byte(RetentionKey))
+	if err != nil || !exists {
+		return DefaultRetentionPolicy(), err
+	}
+	if len(data) != 16 {
+		return RetentionPolicy{}, fmt.Errorf("invalid retention policy length: expected 16, got %d", len(data))
+	}
+	return RetentionPolicy{
+		MaxVersions: binary.BigEndian.Uint64(data[0:8]),
+		MaxAge:      time.Duration(binary.BigEndian.Uint64(data[8:16])),
+	}, nil
+}
+
+// versionRecord is the root committed as a version and when it was committed
+type versionRecord struct {
+	root      Bytes32
+	timestamp time.Time
+}
+
+// versionKey returns the storage key of a version; the fixed-width hex keeps
+// versions in numeric order under prefix iteration
+func versionKey(version uint64) []byte {
+	return []byte(fmt.Sprintf("%s%016x", VersionPrefix, version))
+}
+
+// getVersion loads a version record, returning nil if it does not exist
+func (smt *SparseMerkleTree) getVersion(version uint64) (*versionRecord, error) {
+	key := versionKey(version)
+
+	data, exists, err := getIfPresent(treeDatabase{smt: smt}, key)
+	if err != nil || !exists {
+		return nil, err
+	}
+	if len(data) != 40 { // coverage-ignore
+		return nil, fmt.Errorf("invalid version record length: expected 40, got %d", len(data))
+	}
+
+	record := &versionRecord{timestamp: time.Unix(0, int64(binary.BigEndian.Uint64(data[32:40])))}
+	copy(record.root[:], data[0:32])
+	return record, nil
+}
+
+// recordVersion commits the current root as a new version, which holds a
+// reference on it, and prunes the versions the retention policy no longer keeps
+func (smt *SparseMerkleTree) recordVersion() error {
+	smt.version++
+	if smt.oldestVersion == 0 {
+		smt.oldestVersion = smt.version
+	}
+
+	data := make([]byte, 40)
+	copy(data[0:32], smt.root[:])
+	binary.BigEndian.PutUint64(data[32:40], uint64(time.Now().UnixNano()))
+	if err := smt.dbSet(versionKey(smt.version), data); err != nil { // coverage-ignore
+		return err
+	}
+	if err := smt.retain(smt.root); err != nil { // coverage-ignore
+		return err
+	}
+
+	if _, err := smt.pruneVersions(time.Now()); err != nil { // coverage-ignore
+		return err
+	}
+	return smt.setRoot(smt.root)
+}
+
+// pruneVersions drops the oldest versions outside the retention policy and
+// returns how many were removed. The caller persists the metadata.
+func (smt *SparseMerkleTree) pruneVersions(now time.Time) (int, error) {
+	pruned := 0
+	for smt.oldestVersion != 0 && smt.oldestVersion < smt.version {
+		expired := smt.retention.MaxVersions > 0 && smt.version-smt.oldestVersion+1 > smt.retention.MaxVersions
+
+		if !expired && smt.retention.MaxAge > 0 {
+			// A version stopped being the latest when its successor was committed
+			next, err := smt.getVersion(smt.oldestVersion + 1)
+			if err != nil { // coverage-ignore
+				return pruned, err
+			}
+			expired = next != nil && now.Sub(next.timestamp) > smt.retention.MaxAge
+		}
+
+		if !expired {
+			break
+		}
+
+		record, err := smt.getVersion(smt.oldestVersion)
+		if err != nil { // coverage-ignore
+			return pruned, err
+		}
+		if record != nil {
+			if err := smt.release(record.root); err != nil {
+				return pruned, err
+			}
+			if err := smt.dbDelete(versionKey(smt.oldestVersion)); err != nil { // coverage-ignore
+				return pruned, err
+			}
+		}
+		smt.oldestVersion++
+		pruned++
+	}
+	return pruned, nil
+}
+
+// Version returns the latest committed version, or 0 before the first commit
+func (smt *SparseMerkleTree) Version() uint64 {
+	smt.mu.RLock()
+	defer smt.mu.RUnlock()
+	return smt.version
+}
+
+// OldestVersion returns the oldest version still retained, or 0 before the first commit
+func (smt *SparseMerkleTree) OldestVersion() uint64 {
+	smt.mu.RLock()
+	defer smt.mu.RUnlock()
+	return smt.oldestVersion
+}
+
+// SetRetentionPolicy changes which historical versions are kept and stores
+// the policy with the tree, so it is restored when the tree is reopened. It
+// takes effect at the next commit or PruneVersions call.
+func (smt *SparseMerkleTree) SetRetentionPolicy(policy RetentionPolicy) error {
+	smt.mu.Lock()
+	defer smt.mu.Unlock()
+
+	smt.beginWrite()
+	smt.retention = policy
+	if err := smt.dbSet([]byte(RetentionKey), encodeRetentionPolicy(policy)); err != nil { // coverage-ignore
+		smt.discardWrite()
+		return err
+	}
+	return smt.commitWrite()
+}
+
+// RetentionPolicy returns the policy deciding which historical versions are kept
+func (smt *SparseMerkleTree) RetentionPolicy() RetentionPolicy {
+	smt.mu.RLock()
+	defer smt.mu.RUnlock()
+	return smt.retention
+}
+
+// PruneVersions applies the retention policy now, which matters for age-based
+// retention on a tree that is not being written. It returns the number of versions removed.
+func (smt *SparseMerkleTree) PruneVersions() (int, error) {
+	smt.mu.Lock()
+	defer smt.mu.Unlock()
+
+	smt.beginWrite()
+	pruned, err := smt.pruneVersions(time.Now())
+	if err == nil && pruned > 0 {
+		err = smt.setRoot(smt.root)
+	}
+	if err != nil { // coverage-ignore
+		smt.discardWrite()
+		return 0, err
+	}
+	if err := smt.commitWrite(); err != nil {
+		return 0, err
+	}
+	return pruned, nil
+}
+
+// RootAt returns the root committed as version
+func (smt *SparseMerkleTree) RootAt(version uint64) (Bytes32, error) {
+	smt.mu.RLock()
+	defer smt.mu.RUnlock()
+	return smt.rootAt(version)
+}
+
+// rootAt returns the root of a retained version
+func (smt *SparseMerkleTree) rootAt(version uint64) (Bytes32, error) {
+	if version == 0 || version < smt.oldestVersion || version > smt.version {
+		return Bytes32{}, &VersionNotFoundError{Version: version}
+	}
+
+	record, err := smt.getVersion(version)
+	if err != nil { // coverage-ignore
+		return Bytes32{}, err
+	}
+	if record == nil { // coverage-ignore
+		return Bytes32{}, &VersionNotFoundError{Version: version}
+	}
+	return record.root, nil
+}
+
+// GetAt retrieves a proof for index against the root committed as version
+func (smt *SparseMerkleTree) GetAt(version uint64, index *big.Int) (*Proof, error) {
+	smt.mu.RLock()
+	defer smt.mu.RUnlock()
+
+	root, err := smt.rootAt(version)
+	if err != nil {
+		return nil, err
+	}
+	return smt.proofAt(root, index)
+}
+
+// ExistsAt checks if a key existed in the tree at version
+func (smt *SparseMerkleTree) ExistsAt(version uint64, index *big.Int) (bool, error) {
+	proof, err := smt.GetAt(version, index)
+	if err != nil {
+		return false, err
+	}
+	return proof.Exists, nil
+}
@@ -0,0 +1,140 @@
+package smt
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// UpdateWitness returns myProof brought up to date with other, an update made
+// against the root myProof verifies against, using the optional hash suite,
+// Keccak256 by default. The returned proof verifies against the root other
+// leaves. Only the sibling where the two paths part changes: it is rebuilt
+// from the new leaf and the siblings below it in other.
+//
+// Both proofs must come from a full-depth tree; proofs with a neighbour
+// return ErrCompactLayout. Proofs that do not share a root return
+// ErrWitnessMismatch. If other deletes myProof's leaf the result proves
+// non-membership; inserts and updates of myProof's index return
+// ErrWitnessIndex, since the proof does not carry the new value.
+func UpdateWitness(myProof *Proof, other *UpdateProof, suite ...*HashSuite) (*Proof, error) {
+	if myProof == nil || myProof.Index == nil || other == nil || other.Index == nil {
+		return nil, fmt.Errorf("%w: nil proof or index", ErrInvalidProof)
+	}
+	if myProof.Neighbor != nil || other.Neighbor != nil {
+		return nil, ErrCompactLayout
+	}
+	op, ok := other.operation()
+	if !ok {
+		return nil, fmt.Errorf("%w: operation %q contradicts the update", ErrInvalidProof, other.Op)
+	}
+	myEnables, err := witnessEnables(myProof.Enables, myProof.Siblings)
+	if err != nil {
+		return nil, err
+	}
+	otherEnables, err := witnessEnables(other.Enables, other.Siblings)
+	if err != nil {
+		return nil, err
+	}
+	hasher := resolveHashSuite(suite)
+
+	var myLeaf, oldLeaf Bytes32
+	if myProof.Exists {
+		myLeaf = hasher.HashLeaf(myProof.Index, myProof.Value)
+	}
+	if other.Exists {
+		oldLeaf = hasher.HashLeaf(other.Index, other.Value)
+	}
+
+	if myProof.Index.Cmp(other.Index) == 0 {
+		if myLeaf != oldLeaf || myEnables.Cmp(otherEnables) != 0 || !equalSiblings(myProof.Siblings, other.Siblings) {
+			return nil, ErrWitnessMismatch
+		}
+		if op != OpDelete {
+			return nil, ErrWitnessIndex
+		}
+		return &Proof{
+			Exists:   false,
+			Index:    new(big.Int).Set(myProof.Index),
+			Enables:  new(big.Int).Set(myEnables),
+			Siblings: append([]Bytes32{}, myProof.Siblings...),
+		}, nil
+	}
+
+	// The paths part below the node at height split+1, which branches on bit
+	// split; each proof's sibling there is the subtree holding the other's path
+	split := uint(new(big.Int).Xor(myProof.Index, other.Index).BitLen() - 1)
+	myBelow, otherBelow := enabledBelow(myEnables, split), enabledBelow(otherEnables, split)
+	myAbove := myProof.Siblings[myBelow+int(myEnables.Bit(int(split))):]
+	otherAbove := other.Siblings[otherBelow+int(otherEnables.Bit(int(split))):]
+
+	// Above the split both paths run through the same nodes
+	if new(big.Int).Rsh(myEnables, split+1).Cmp(new(big.Int).Rsh(otherEnables, split+1)) != 0 || !equalSiblings(myAbove, otherAbove) {
+		return nil, ErrWitnessMismatch
+	}
+	var mySibling, otherSibling Bytes32
+	if myEnables.Bit(int(split)) == 1 {
+		mySibling = myProof.Siblings[myBelow]
+	}
+	if otherEnables.Bit(int(split)) == 1 {
+		otherSibling = other.Siblings[otherBelow]
+	}
+	if pathRoot(uint16(split), oldLeaf, other.Index, otherEnables, other.Siblings, hasher) != mySibling ||
+		pathRoot(uint16(split), myLeaf, myProof.Index, myEnables, myProof.Siblings, hasher) != otherSibling {
+		return nil, ErrWitnessMismatch
+	}
+
+	updated := pathRoot(uint16(split), other.NewLeaf, other.Index, otherEnables, other.Siblings, hasher)
+	enables := new(big.Int).Set(myEnables)
+	siblings := make([]Bytes32, 0, len(myProof.Siblings)+1)
+	siblings = append(siblings, myProof.Siblings[:myBelow]...)
+	if updated.IsZero() {
+		enables.SetBit(enables, int(split), 0)
+	} else {
+		enables.SetBit(enables, int(split), 1)
+		siblings = append(siblings, updated)
+	}
+	siblings = append(siblings, myAbove...)
+
+	return &Proof{
+		Exists:   myProof.Exists,
+		Leaf:     myProof.Leaf,
+		Value:    myProof.Value,
+		Index:    new(big.Int).Set(myProof.Index),
+		Enables:  enables,
+		Siblings: siblings,
+	}, nil
+}
+
+// witnessEnables returns a proof's enable bits, checking they call for exactly
+// its siblings
+func witnessEnables(enables *big.Int, siblings []Bytes32) (*big.Int, error) {
+	if enables == nil {
+		enables = big.NewInt(0)
+	}
+	if enables.Sign() < 0 {
+		return nil, fmt.Errorf("%w: negative enables", ErrInvalidProof)
+	}
+	if enabled := popCount(enables); enabled != len(siblings) {
+		return nil, &SiblingCountError{Siblings: len(siblings), Enabled: enabled}
+	}
+	return enables, nil
+}
+
+// enabledBelow returns the number of enable bits set below bit
+func enabledBelow(enables *big.Int, bit uint) int {
+	mask := new(big.Int).Sub(new(big.Int).Lsh(ONE, bit), ONE)
+	return popCount(mask.And(mask, enables))
+}
+
+// equalSiblings reports whether two sibling lists are identical
+func equalSiblings(a, b []Bytes32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}